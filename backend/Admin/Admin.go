@@ -0,0 +1,246 @@
+// Package admin — консольные команды администратора. Запускаются тем же
+// бинарником, что и сервер:
+//
+//	revforum admin user create -username alice -email alice@example.com [-role admin]
+//	revforum admin user list [-role moderator] [-banned]
+//	revforum admin user promote <id|username> [-role moderator]
+//	revforum admin user ban <id|username> [-reason "спам"]
+//	revforum admin user unban <id|username>
+//	revforum admin user reset-password <id|username>
+//	revforum admin user reset-2fa <id|username>
+//	revforum admin 2fa policy [-require moderator,admin]
+//	revforum admin theme create -title "Новости" [-status open]
+//	revforum admin theme list
+//	revforum admin theme archive <id>
+//	revforum admin topic move <id> -to <id подтемы>
+//	revforum admin topic lock <id> [-unlock]
+//	revforum admin stats
+//	revforum admin seed [-profile small|load-test] [-seed 1] [-users N] [-topics N] ...
+//	revforum admin export [-o forum.jsonl] [-password-hashes]
+//	revforum admin import <forum.jsonl|-> [-dry-run] [-on-conflict fail|skip|overwrite]
+//
+// Пароль не передается флагом, чтобы не попасть в историю оболочки:
+// он читается из первой строки стандартного ввода. Формат архива export/import
+// описан в database/Archive.go.
+//
+// Команды вызывают те же операции пакета database, что и обработчики HTTP,
+// поэтому проверки (уникальность, правила полей, роли) совпадают.
+package admin
+
+import (
+	apperr "REVFORUM/apperr"
+	cache "REVFORUM/cache"
+	database "REVFORUM/database"
+	realtime "REVFORUM/realtime"
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Env — зависимости команд.
+type Env struct {
+	DB     *gorm.DB
+	Cache  cache.Store   // Сбрасывается после изменений, как и в обработчиках HTTP
+	Events *realtime.Hub // Изменения топиков доходят до подписчиков (при брокере postgres)
+	In     io.Reader     // Откуда читается пароль
+	Out    io.Writer
+	Err    io.Writer
+	Lang   string // Язык сообщений об ошибках: ru или en
+
+	Passwords database.PasswordPolicy // Требования к паролям, как у сервера
+}
+
+// NewEnv собирает окружение команд для запуска из консоли: кэш и брокер
+// событий — по тем же переменным окружения, что и у сервера.
+func NewEnv(db *gorm.DB) Env {
+	lang := "ru"
+	if strings.HasPrefix(os.Getenv("LANG"), "en") {
+		lang = "en"
+	}
+	return Env{
+		DB:     db,
+		Cache:  cache.Init(),
+		Events: realtime.Init(database.DSN()),
+		In:     os.Stdin,
+		Out:    os.Stdout,
+		Err:    os.Stderr,
+		Lang:   lang,
+
+		Passwords: database.PasswordPolicyFromEnv(),
+	}
+}
+
+// errUsage — неверные аргументы; справка уже выведена.
+var errUsage = errors.New("usage")
+
+// command — подкоманда: разбирает свои аргументы и выполняется.
+type command struct {
+	usage string
+	run   func(ctx context.Context, env Env, args []string) error
+}
+
+var commands = map[string]map[string]command{
+	"user": {
+		"create":         {"-username <имя> -email <email> [-role user|moderator|admin]", userCreate},
+		"list":           {"[-role <роль>] [-banned]", userList},
+		"promote":        {"<id|имя> [-role moderator|admin|user]", userPromote},
+		"ban":            {"<id|имя> [-reason <причина>]", userBan},
+		"unban":          {"<id|имя>", userUnban},
+		"reset-password": {"<id|имя>", userResetPassword},
+		"reset-2fa":      {"<id|имя>", userReset2FA},
+	},
+	"2fa": {
+		"policy": {"[-require <роли через запятую>]", twoFactorPolicy},
+	},
+	"theme": {
+		"create":  {"-title <название> [-status <статус>]", themeCreate},
+		"list":    {"", themeList},
+		"archive": {"<id>", themeArchive},
+	},
+	"topic": {
+		"move": {"<id> -to <id подтемы>", topicMove},
+		"lock": {"<id> [-unlock]", topicLock},
+	},
+	"stats": {
+		"": {"", stats},
+	},
+	"export": {
+		"": {"[-o <файл>] [-password-hashes]", export},
+	},
+	"import": {
+		"": {"<файл|-> [-dry-run] [-on-conflict fail|skip|overwrite]", importArchive},
+	},
+	"seed": {
+		"": {"[-profile small|load-test] [-seed <число>] [-users|-themes|-subthemes|-topics|-posts <число>]", seedRun},
+	},
+}
+
+// Main выполняет команду и возвращает код выхода процесса:
+// 0 — успех, 1 — ошибка выполнения, 2 — неверные аргументы.
+func Main(ctx context.Context, env Env, args []string) int {
+	err := Run(ctx, env, args)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	}
+	var e *apperr.Error
+	if errors.As(err, &e) {
+		fmt.Fprintf(env.Err, "ошибка: %s (%s)\n", e.Text(env.Lang), e.Code)
+		if e.Cause != nil {
+			fmt.Fprintln(env.Err, e.Cause)
+		}
+		return 1
+	}
+	fmt.Fprintln(env.Err, "ошибка:", err)
+	return 1
+}
+
+// Run выполняет команду args (без слова admin).
+func Run(ctx context.Context, env Env, args []string) error {
+	if len(args) == 0 {
+		return usage(env, "")
+	}
+	group, ok := commands[args[0]]
+	if !ok {
+		return usage(env, "")
+	}
+	if cmd, ok := group[""]; ok {
+		return cmd.run(ctx, env, args[1:])
+	}
+	if len(args) < 2 {
+		return usage(env, args[0])
+	}
+	cmd, ok := group[args[1]]
+	if !ok {
+		return usage(env, args[0])
+	}
+	return cmd.run(ctx, env, args[2:])
+}
+
+// usage печатает список команд (всех или группы group).
+func usage(env Env, group string) error {
+	fmt.Fprintln(env.Err, "использование: revforum admin <команда>")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		if group == "" || name == group {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		subs := make([]string, 0, len(commands[name]))
+		for sub := range commands[name] {
+			subs = append(subs, sub)
+		}
+		sort.Strings(subs)
+		for _, sub := range subs {
+			line := strings.Join(strings.Fields(name+" "+sub+" "+commands[name][sub].usage), " ")
+			fmt.Fprintln(env.Err, "  "+line)
+		}
+	}
+	return errUsage
+}
+
+// parse разбирает флаги fs, разрешая им стоять и до, и после позиционных
+// аргументов (promote alice -role admin), и проверяет число позиционных.
+func parse(env Env, fs *flag.FlagSet, args []string, positional ...string) ([]string, error) {
+	fs.SetOutput(env.Err)
+	fs.Usage = func() {
+		fmt.Fprintf(env.Err, "использование: revforum admin %s", fs.Name())
+		for _, p := range positional {
+			fmt.Fprintf(env.Err, " <%s>", p)
+		}
+		fmt.Fprintln(env.Err)
+		fs.PrintDefaults()
+	}
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, errUsage
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(rest) != len(positional) {
+		fs.Usage()
+		return nil, errUsage
+	}
+	return rest, nil
+}
+
+// parseID разбирает положительный ID из аргумента name.
+func parseID(name, value string) (uint, error) {
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || id == 0 {
+		return 0, apperr.New(apperr.InvalidParameter).Param("name", name)
+	}
+	return uint(id), nil
+}
+
+// readPassword читает пароль из первой строки env.In.
+func readPassword(env Env) (string, error) {
+	if f, ok := env.In.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprint(env.Err, "пароль: ") // Ввод с терминала — подсказка; из канала — молча
+		}
+	}
+	line, err := bufio.NewReader(env.In).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
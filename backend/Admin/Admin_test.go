@@ -0,0 +1,350 @@
+package admin
+
+import (
+	cache "REVFORUM/cache"
+	database "REVFORUM/database"
+	dbtest "REVFORUM/dbtest"
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+	os.Exit(dbtest.Run(m))
+}
+
+// run выполняет команду с вводом stdin и возвращает код выхода, stdout и stderr.
+func run(t *testing.T, db *gorm.DB, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	env := Env{
+		DB:    db,
+		Cache: cache.NewLRU(10),
+		In:    strings.NewReader(stdin),
+		Out:   &out,
+		Err:   &errOut,
+		Lang:  "ru",
+	}
+	code := Main(context.Background(), env, args)
+	return code, out.String(), errOut.String()
+}
+
+// expectCode проверяет код выхода команды.
+func expectCode(t *testing.T, code, want int, stdout, stderr string) {
+	t.Helper()
+	if code != want {
+		t.Fatalf("код выхода %d, ожидался %d\nstdout: %s\nstderr: %s", code, want, stdout, stderr)
+	}
+}
+
+func TestUserCreate(t *testing.T) {
+	db := dbtest.Open(t)
+
+	code, out, errOut := run(t, db, "secret12\n", "user", "create", "-username", "root", "-email", "root@example.com", "-role", "admin")
+	expectCode(t, code, 0, out, errOut)
+	var user database.User
+	if err := db.Where("username = ?", "root").First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != database.RoleAdmin || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("secret12")) != nil {
+		t.Fatalf("пользователь создан неверно: %+v", user)
+	}
+
+	// Проверки те же, что у POST /api/v1/auth/register
+	code, _, errOut = run(t, db, "secret12\n", "user", "create", "-username", "root", "-email", "other@example.com")
+	expectCode(t, code, 1, "", errOut)
+	if !strings.Contains(errOut, "USER_ALREADY_EXISTS") {
+		t.Fatalf("ожидалась ошибка USER_ALREADY_EXISTS: %s", errOut)
+	}
+	code, _, errOut = run(t, db, "secret12\n", "user", "create", "-username", "new", "-email", "not-an-email")
+	expectCode(t, code, 1, "", errOut)
+	if !strings.Contains(errOut, "VALIDATION_FAILED") || !strings.Contains(errOut, "email") {
+		t.Fatalf("ожидалась ошибка поля email: %s", errOut)
+	}
+	code, _, errOut = run(t, db, "123\n", "user", "create", "-username", "new", "-email", "new@example.com")
+	expectCode(t, code, 1, "", errOut)
+	if !strings.Contains(errOut, "VALIDATION_FAILED") || !strings.Contains(errOut, "password") {
+		t.Fatalf("ожидалась ошибка поля password: %s", errOut)
+	}
+	code, _, errOut = run(t, db, "secret12\n", "user", "create", "-username", "new", "-email", "new@example.com", "-role", "root")
+	expectCode(t, code, 1, "", errOut)
+	if !strings.Contains(errOut, "INVALID_ROLE") {
+		t.Fatalf("ожидалась ошибка INVALID_ROLE: %s", errOut)
+	}
+}
+
+func TestUserPromoteBanAndList(t *testing.T) {
+	db := dbtest.Open(t)
+	f := dbtest.NewFixtures(t, db)
+	alice := f.User(func(u *database.User) { u.Username = "alice" })
+	admin := f.User(dbtest.Admin)
+
+	code, out, errOut := run(t, db, "", "user", "promote", "alice")
+	expectCode(t, code, 0, out, errOut)
+	code, out, errOut = run(t, db, "", "user", "ban", itoa(alice.ID), "-reason", "спам")
+	expectCode(t, code, 0, out, errOut)
+
+	var stored database.User
+	db.First(&stored, alice.ID)
+	if stored.Role != database.RoleModerator || stored.BannedAt == nil || stored.BanReason != "спам" {
+		t.Fatalf("роль или блокировка не сохранены: %+v", stored)
+	}
+
+	code, out, errOut = run(t, db, "", "user", "list", "-banned")
+	expectCode(t, code, 0, out, errOut)
+	if !strings.Contains(out, "alice") || strings.Contains(out, admin.Username) {
+		t.Fatalf("в списке заблокированных должна быть только alice:\n%s", out)
+	}
+
+	code, _, errOut = run(t, db, "", "user", "ban", admin.Username)
+	expectCode(t, code, 1, "", errOut)
+	if !strings.Contains(errOut, "CANNOT_BAN_ADMIN") {
+		t.Fatalf("ожидалась ошибка CANNOT_BAN_ADMIN: %s", errOut)
+	}
+
+	code, out, errOut = run(t, db, "", "user", "unban", "alice")
+	expectCode(t, code, 0, out, errOut)
+	var unbanned database.User
+	db.First(&unbanned, alice.ID)
+	if unbanned.BannedAt != nil {
+		t.Fatal("блокировка не снята")
+	}
+
+	code, _, errOut = run(t, db, "", "user", "promote", "nobody")
+	expectCode(t, code, 1, "", errOut)
+	if !strings.Contains(errOut, "USER_NOT_FOUND") {
+		t.Fatalf("ожидалась ошибка USER_NOT_FOUND: %s", errOut)
+	}
+}
+
+func TestUserResetPassword(t *testing.T) {
+	db := dbtest.Open(t)
+	user := dbtest.NewFixtures(t, db).User()
+
+	code, out, errOut := run(t, db, "new-secret\n", "user", "reset-password", user.Username)
+	expectCode(t, code, 0, out, errOut)
+	var stored database.User
+	db.First(&stored, user.ID)
+	if bcrypt.CompareHashAndPassword([]byte(stored.PasswordHash), []byte("new-secret")) != nil {
+		t.Fatal("пароль не изменен")
+	}
+
+	code, _, errOut = run(t, db, "123\n", "user", "reset-password", user.Username)
+	expectCode(t, code, 1, "", errOut)
+	if !strings.Contains(errOut, "VALIDATION_FAILED") {
+		t.Fatalf("короткий пароль должен отклоняться: %s", errOut)
+	}
+}
+
+func TestTwoFactorCommands(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	user := dbtest.NewFixtures(t, db).User(dbtest.Moderator)
+
+	code, out, errOut := run(t, db, "", "2fa", "policy")
+	expectCode(t, code, 0, out, errOut)
+	if !strings.Contains(out, "необязательна") {
+		t.Fatalf("по умолчанию 2FA необязательна: %s", out)
+	}
+	code, out, errOut = run(t, db, "", "2fa", "policy", "-require", "admin, moderator")
+	expectCode(t, code, 0, out, errOut)
+	if roles, _ := database.TwoFactorRequiredRoles(ctx, db); strings.Join(roles, ",") != "moderator,admin" {
+		t.Fatalf("политика не сохранена: %v", roles)
+	}
+	code, _, errOut = run(t, db, "", "2fa", "policy", "-require", "user")
+	expectCode(t, code, 1, "", errOut)
+	if !strings.Contains(errOut, "INVALID_ROLE") {
+		t.Fatalf("ожидалась ошибка INVALID_ROLE: %s", errOut)
+	}
+	code, out, errOut = run(t, db, "", "2fa", "policy", "-require", "")
+	expectCode(t, code, 0, out, errOut)
+	if roles, _ := database.TwoFactorRequiredRoles(ctx, db); len(roles) != 0 {
+		t.Fatalf("политика не сброшена: %v", roles)
+	}
+
+	// Сброс 2FA пользователю, потерявшему телефон
+	setup, err := database.SetupTwoFactor(ctx, db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totp, _ := database.TOTPCode(setup.Secret, time.Now())
+	if _, err := database.EnableTwoFactor(ctx, db, user.ID, totp); err != nil {
+		t.Fatal(err)
+	}
+	code, out, errOut = run(t, db, "", "user", "reset-2fa", user.Username)
+	expectCode(t, code, 0, out, errOut)
+	var stored database.User
+	db.First(&stored, user.ID)
+	if stored.TOTPEnabledAt != nil || stored.TOTPSecret != "" {
+		t.Fatalf("2FA не выключена: %+v", stored)
+	}
+	if n := db.Where("user_id = ?", user.ID).Find(&[]database.RecoveryCode{}).RowsAffected; n != 0 {
+		t.Fatalf("осталось резервных кодов: %d", n)
+	}
+}
+
+func TestThemeCommands(t *testing.T) {
+	db := dbtest.Open(t)
+
+	code, out, errOut := run(t, db, "", "theme", "create", "-title", "Новости")
+	expectCode(t, code, 0, out, errOut)
+	code, _, errOut = run(t, db, "", "theme", "create", "-title", "Новости")
+	expectCode(t, code, 1, "", errOut)
+	if !strings.Contains(errOut, "THEME_ALREADY_EXISTS") {
+		t.Fatalf("ожидалась ошибка THEME_ALREADY_EXISTS: %s", errOut)
+	}
+
+	var theme database.Themes_Collection
+	if err := db.Where("title = ?", "Новости").First(&theme).Error; err != nil {
+		t.Fatal(err)
+	}
+	code, out, errOut = run(t, db, "", "theme", "archive", itoa(theme.ID))
+	expectCode(t, code, 0, out, errOut)
+	code, out, errOut = run(t, db, "", "theme", "list")
+	expectCode(t, code, 0, out, errOut)
+	if !strings.Contains(out, "Новости") || !strings.Contains(out, database.ThemeArchived) {
+		t.Fatalf("в списке нет архивной темы:\n%s", out)
+	}
+}
+
+func TestTopicCommands(t *testing.T) {
+	db := dbtest.Open(t)
+	f := dbtest.NewFixtures(t, db)
+	me := f.User()
+	theme := f.Theme()
+	from, to := f.SubTheme(theme), f.SubTheme(theme)
+	topic := f.Topic(from, me)
+
+	code, out, errOut := run(t, db, "", "topic", "move", itoa(topic.ID), "-to", itoa(to.ID))
+	expectCode(t, code, 0, out, errOut)
+	code, out, errOut = run(t, db, "", "topic", "lock", itoa(topic.ID))
+	expectCode(t, code, 0, out, errOut)
+
+	var stored database.Topic
+	db.First(&stored, topic.ID)
+	if stored.SubThemeID != to.ID || !stored.Locked {
+		t.Fatalf("топик не перенесен или не закрыт: %+v", stored)
+	}
+
+	code, _, errOut = run(t, db, "", "topic", "move", itoa(topic.ID), "-to", "999")
+	expectCode(t, code, 1, "", errOut)
+	if !strings.Contains(errOut, "SUBTHEME_NOT_FOUND") {
+		t.Fatalf("ожидалась ошибка SUBTHEME_NOT_FOUND: %s", errOut)
+	}
+	code, out, errOut = run(t, db, "", "topic", "lock", itoa(topic.ID), "-unlock")
+	expectCode(t, code, 0, out, errOut)
+	db.First(&stored, topic.ID)
+	if stored.Locked {
+		t.Fatal("топик не открыт")
+	}
+}
+
+func TestStats(t *testing.T) {
+	db := dbtest.Open(t)
+	f := dbtest.NewFixtures(t, db)
+	me := f.User()
+	f.User(dbtest.Moderator)
+	topic := f.Topic(f.SubTheme(f.Theme()), me)
+	f.Post(topic, me)
+	f.Post(topic, me)
+
+	code, out, errOut := run(t, db, "", "stats")
+	expectCode(t, code, 0, out, errOut)
+	rows := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 {
+			rows[fields[0]] = strings.Join(fields[1:], " ")
+		}
+	}
+	for label, want := range map[string]string{"пользователи": "2", "moderator": "1", "сообщения": "2 (за сутки 2)"} {
+		if rows[label] != want {
+			t.Fatalf("%s: %q, ожидалось %q\n%s", label, rows[label], want, out)
+		}
+	}
+}
+
+func TestSeed(t *testing.T) {
+	db := dbtest.Open(t)
+
+	code, out, errOut := run(t, db, "", "seed", "-seed", "7", "-users", "3", "-themes", "2", "-subthemes", "1", "-topics", "2", "-posts", "0")
+	expectCode(t, code, 0, out, errOut)
+	var users, topics, posts int64
+	db.Model(&database.User{}).Count(&users)
+	db.Model(&database.Topic{}).Count(&topics)
+	db.Model(&database.Post{}).Count(&posts)
+	if users != 3 || topics != 4 || posts != 4 { // Только первые сообщения топиков
+		t.Fatalf("создано пользователей %d, топиков %d, сообщений %d\n%s", users, topics, posts, out)
+	}
+
+	code, _, errOut = run(t, db, "", "seed")
+	expectCode(t, code, 1, "", errOut)
+	if !strings.Contains(errOut, "база не пуста") {
+		t.Fatalf("повторное заполнение должно отклоняться: %s", errOut)
+	}
+	code, _, errOut = run(t, db, "", "seed", "-profile", "huge")
+	expectCode(t, code, 2, "", errOut)
+}
+
+func TestExportImport(t *testing.T) {
+	db := dbtest.Open(t)
+	f := dbtest.NewFixtures(t, db)
+	me := f.User()
+	f.Post(f.Topic(f.SubTheme(f.Theme()), me), me)
+	path := filepath.Join(t.TempDir(), "forum.jsonl")
+
+	code, out, errOut := run(t, db, "", "export", "-o", path, "-password-hashes")
+	expectCode(t, code, 0, out, errOut)
+	if !strings.Contains(errOut, "сообщений 1") {
+		t.Fatalf("нет итога выгрузки: %s", errOut)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), me.PasswordHash) {
+		t.Fatal("хэш пароля не выгружен")
+	}
+
+	// Пробный импорт из stdin в ту же базу: пользователь и тема совпадают
+	code, out, errOut = run(t, db, string(data), "import", "-", "-dry-run", "-on-conflict", "skip")
+	expectCode(t, code, 0, out, errOut)
+	if !strings.Contains(out, "пробный прогон") || !strings.Contains(out, "пропущено: пользователей 1, тем 1") {
+		t.Fatalf("неверный итог пробного импорта:\n%s", out)
+	}
+	code, _, errOut = run(t, db, "", "import", path)
+	expectCode(t, code, 1, "", errOut)
+	if !strings.Contains(errOut, "ARCHIVE_CONFLICT") {
+		t.Fatalf("ожидалась ошибка ARCHIVE_CONFLICT: %s", errOut)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var db *gorm.DB // До базы дело не доходит
+
+	code, _, errOut := run(t, db, "")
+	expectCode(t, code, 2, "", errOut)
+	if !strings.Contains(errOut, "user reset-password") || !strings.Contains(errOut, "topic move") {
+		t.Fatalf("справка неполная:\n%s", errOut)
+	}
+	code, _, errOut = run(t, db, "", "topic", "lock")
+	expectCode(t, code, 2, "", errOut)
+	code, _, errOut = run(t, db, "", "theme", "drop")
+	expectCode(t, code, 2, "", errOut)
+}
+
+func itoa(id uint) string { return strconv.FormatUint(uint64(id), 10) }
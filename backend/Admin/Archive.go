@@ -0,0 +1,79 @@
+package admin
+
+import (
+	database "REVFORUM/database"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// export пишет архив форума в файл или в стандартный вывод.
+func export(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := fs.String("o", "-", "файл архива; - — стандартный вывод")
+	hashes := fs.Bool("password-hashes", false, "включить хэши паролей")
+	if _, err := parse(env, fs, args); err != nil {
+		return err
+	}
+
+	opts := database.ExportOptions{PasswordHashes: *hashes}
+	var counts database.ArchiveCounts
+	var err error
+	if *out == "-" {
+		counts, err = database.ExportArchive(ctx, env.DB, env.Out, opts)
+	} else {
+		f, createErr := os.Create(*out)
+		if createErr != nil {
+			return createErr
+		}
+		counts, err = database.ExportArchive(ctx, env.DB, f, opts)
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// Итог — в stderr, чтобы не смешаться с архивом в stdout
+	fmt.Fprintf(env.Err, "выгружено: пользователей %d, тем %d, подтем %d, топиков %d, сообщений %d\n",
+		counts.Users, counts.Themes, counts.SubThemes, counts.Topics, counts.Posts)
+	return nil
+}
+
+// importArchive загружает архив из файла или стандартного ввода (-).
+func importArchive(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "только проверить архив и посчитать изменения")
+	onConflict := fs.String("on-conflict", database.ConflictFail, "при совпадении пользователя или темы: fail, skip или overwrite")
+	rest, err := parse(env, fs, args, "файл|-")
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = env.In
+	if rest[0] != "-" {
+		f, err := os.Open(rest[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	report, err := database.ImportArchive(ctx, env.DB, env.Cache, r, database.ImportOptions{DryRun: *dryRun, OnConflict: *onConflict})
+	if err != nil {
+		return err
+	}
+	if report.DryRun {
+		fmt.Fprintln(env.Out, "пробный прогон, база не изменена")
+	}
+	for _, row := range []struct {
+		label string
+		c     database.ArchiveCounts
+	}{{"создано", report.Created}, {"пропущено", report.Skipped}, {"перезаписано", report.Overwritten}} {
+		fmt.Fprintf(env.Out, "%s: пользователей %d, тем %d, подтем %d, топиков %d, сообщений %d\n",
+			row.label, row.c.Users, row.c.Themes, row.c.SubThemes, row.c.Topics, row.c.Posts)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package admin
+
+import (
+	database "REVFORUM/database"
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+)
+
+// themeCreate создает тему с той же проверкой уникальности, что и POST /api/v1/themes.
+func themeCreate(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("theme create", flag.ContinueOnError)
+	title := fs.String("title", "", "название темы")
+	status := fs.String("status", "open", "статус темы")
+	if _, err := parse(env, fs, args); err != nil {
+		return err
+	}
+	theme, err := database.CreateTheme(ctx, env.DB.WithContext(ctx), env.Cache,
+		database.CreateThemeRequest{Title: *title, Status: *status})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Out, "создана тема %d %q\n", theme.ID, theme.Title)
+	return nil
+}
+
+// themeList выводит темы с числом подтем.
+func themeList(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("theme list", flag.ContinueOnError)
+	if _, err := parse(env, fs, args); err != nil {
+		return err
+	}
+	db := env.DB.WithContext(ctx)
+	var themes []database.Themes_Collection
+	if err := db.Order("id ASC").Find(&themes).Error; err != nil {
+		return err
+	}
+	var counts []struct {
+		ParentID uint
+		Count    int64
+	}
+	if err := db.Model(&database.Sub_Themes{}).Select("parent_id, COUNT(*) AS count").Group("parent_id").Scan(&counts).Error; err != nil {
+		return err
+	}
+	subThemes := make(map[uint]int64, len(counts))
+	for _, c := range counts {
+		subThemes[c.ParentID] = c.Count
+	}
+
+	w := tabwriter.NewWriter(env.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tНАЗВАНИЕ\tСТАТУС\tПОДТЕМ\tСОЗДАНА")
+	for _, t := range themes {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", t.ID, t.Title, t.Status, subThemes[t.ID], t.CreatedAt.Format("2006-01-02"))
+	}
+	return w.Flush()
+}
+
+// themeArchive переводит тему в архив.
+func themeArchive(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("theme archive", flag.ContinueOnError)
+	rest, err := parse(env, fs, args, "id")
+	if err != nil {
+		return err
+	}
+	id, err := parseID("id", rest[0])
+	if err != nil {
+		return err
+	}
+	theme, err := database.ArchiveTheme(ctx, env.DB.WithContext(ctx), env.Cache, id)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Out, "тема %d %q перенесена в архив\n", theme.ID, theme.Title)
+	return nil
+}
+
+// topicMove переносит топик в другую подтему.
+func topicMove(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("topic move", flag.ContinueOnError)
+	to := fs.String("to", "", "ID подтемы, куда перенести топик")
+	rest, err := parse(env, fs, args, "id")
+	if err != nil {
+		return err
+	}
+	id, err := parseID("id", rest[0])
+	if err != nil {
+		return err
+	}
+	subThemeID, err := parseID("to", *to)
+	if err != nil {
+		return err
+	}
+	topic, err := database.MoveTopic(ctx, env.DB.WithContext(ctx), env.Cache, env.Events, id, subThemeID)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Out, "топик %d %q теперь в подтеме %d\n", topic.ID, topic.Title, topic.SubThemeID)
+	return nil
+}
+
+// topicLock закрывает топик для новых сообщений или открывает его (-unlock).
+func topicLock(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("topic lock", flag.ContinueOnError)
+	unlock := fs.Bool("unlock", false, "открыть топик")
+	rest, err := parse(env, fs, args, "id")
+	if err != nil {
+		return err
+	}
+	id, err := parseID("id", rest[0])
+	if err != nil {
+		return err
+	}
+	topic, err := database.SetTopicLocked(ctx, env.DB.WithContext(ctx), env.Cache, env.Events, id, !*unlock)
+	if err != nil {
+		return err
+	}
+	state := "закрыт"
+	if !topic.Locked {
+		state = "открыт"
+	}
+	fmt.Fprintf(env.Out, "топик %d %q %s\n", topic.ID, topic.Title, state)
+	return nil
+}
+
+// stats выводит сводные показатели форума.
+func stats(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	if _, err := parse(env, fs, args); err != nil {
+		return err
+	}
+	s, err := database.Stats(ctx, env.DB.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(env.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "пользователи\t%d\n", s.Users)
+	roles := make([]string, 0, len(s.UsersByRole))
+	for role := range s.UsersByRole {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		fmt.Fprintf(w, "  %s\t%d\n", role, s.UsersByRole[role])
+	}
+	fmt.Fprintf(w, "  заблокированы\t%d\n", s.BannedUsers)
+	fmt.Fprintf(w, "темы\t%d (в архиве %d)\n", s.Themes, s.ArchivedThemes)
+	fmt.Fprintf(w, "подтемы\t%d\n", s.SubThemes)
+	fmt.Fprintf(w, "топики\t%d (закрыто %d)\n", s.Topics, s.LockedTopics)
+	fmt.Fprintf(w, "сообщения\t%d (за сутки %d)\n", s.Posts, s.PostsLastDay)
+	fmt.Fprintf(w, "вложения\t%d (%d байт)\n", s.Attachments, s.AttachmentBytes)
+	return w.Flush()
+}
@@ -0,0 +1,67 @@
+package admin
+
+import (
+	seed "REVFORUM/seed"
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// seedRun заполняет пустую базу тестовыми данными по профилю; отдельные
+// количества можно переопределить флагами.
+func seedRun(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	name := fs.String("profile", "small", "профиль: "+strings.Join(profileNames(), ", "))
+	random := fs.Int64("seed", 1, "зерно генератора: одно зерно — одни и те же данные")
+	users := fs.Int("users", 0, "пользователей")
+	themes := fs.Int("themes", 0, "тем")
+	subThemes := fs.Int("subthemes", 0, "подтем в каждой теме")
+	topics := fs.Int("topics", 0, "топиков в каждой подтеме")
+	posts := fs.Int("posts", 0, "ответов в топике (в среднем), не считая первого сообщения")
+	if _, err := parse(env, fs, args); err != nil {
+		return err
+	}
+	profile, ok := seed.Profiles[*name]
+	if !ok {
+		fmt.Fprintf(env.Err, "неизвестный профиль %q\n", *name)
+		fs.Usage()
+		return errUsage
+	}
+	// Флаги, заданные явно, заменяют значения профиля (в том числе нулем)
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "users":
+			profile.Users = *users
+		case "themes":
+			profile.Themes = *themes
+		case "subthemes":
+			profile.SubThemesPerTheme = *subThemes
+		case "topics":
+			profile.TopicsPerSubTheme = *topics
+		case "posts":
+			profile.PostsPerTopic = *posts
+		}
+	})
+
+	started := time.Now()
+	res, err := seed.Generate(ctx, env.DB, env.Cache, seed.Options{Profile: profile, Seed: *random})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Out, "создано за %s: пользователей %d, тем %d, подтем %d, топиков %d, сообщений %d\n",
+		time.Since(started).Round(time.Millisecond), res.Users, res.Themes, res.SubThemes, res.Topics, res.Posts)
+	fmt.Fprintf(env.Out, "пароль всех пользователей: %s (администратор — admin)\n", seed.Password)
+	return nil
+}
+
+func profileNames() []string {
+	names := make([]string, 0, len(seed.Profiles))
+	for name := range seed.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,208 @@
+package admin
+
+import (
+	database "REVFORUM/database"
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+// userCreate создает пользователя; пароль читается из стандартного ввода.
+func userCreate(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	username := fs.String("username", "", "имя пользователя")
+	email := fs.String("email", "", "email")
+	role := fs.String("role", database.RoleUser, "роль: user, moderator или admin")
+	if _, err := parse(env, fs, args); err != nil {
+		return err
+	}
+	password, err := readPassword(env)
+	if err != nil {
+		return err
+	}
+
+	user, err := database.RegisterUser(ctx, env.DB.WithContext(ctx), database.RegisterRequest{
+		Username: *username,
+		Email:    *email,
+		Password: password,
+	}, *role, env.Passwords)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Out, "создан пользователь %d %s (%s)\n", user.ID, user.Username, user.Role)
+	return nil
+}
+
+// userList выводит пользователей таблицей.
+func userList(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("user list", flag.ContinueOnError)
+	role := fs.String("role", "", "только с этой ролью")
+	banned := fs.Bool("banned", false, "только заблокированные")
+	if _, err := parse(env, fs, args); err != nil {
+		return err
+	}
+
+	q := env.DB.WithContext(ctx).Order("id ASC")
+	if *role != "" {
+		q = q.Where("role = ?", *role)
+	}
+	if *banned {
+		q = q.Where("banned_at IS NOT NULL")
+	}
+	var users []database.User
+	if err := q.Find(&users).Error; err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(env.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tИМЯ\tEMAIL\tРОЛЬ\tЗАРЕГИСТРИРОВАН\tБЛОКИРОВКА")
+	for _, u := range users {
+		ban := "-"
+		if u.BannedAt != nil {
+			ban = u.BannedAt.Format("2006-01-02")
+			if u.BanReason != "" {
+				ban += " (" + u.BanReason + ")"
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", u.ID, u.Username, u.Email, u.Role, u.CreatedAt.Format("2006-01-02"), ban)
+	}
+	return w.Flush()
+}
+
+// userPromote меняет роль пользователя (по умолчанию — на модератора).
+func userPromote(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("user promote", flag.ContinueOnError)
+	role := fs.String("role", database.RoleModerator, "новая роль: user, moderator или admin")
+	rest, err := parse(env, fs, args, "id|имя")
+	if err != nil {
+		return err
+	}
+	db := env.DB.WithContext(ctx)
+	user, err := database.LookupUser(ctx, db, rest[0])
+	if err != nil {
+		return err
+	}
+	if user, err = database.SetUserRole(ctx, db, env.Cache, user.ID, *role); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Out, "пользователь %d %s: роль %s\n", user.ID, user.Username, user.Role)
+	return nil
+}
+
+// userBan блокирует пользователя.
+func userBan(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("user ban", flag.ContinueOnError)
+	reason := fs.String("reason", "", "причина блокировки")
+	rest, err := parse(env, fs, args, "id|имя")
+	if err != nil {
+		return err
+	}
+	db := env.DB.WithContext(ctx)
+	user, err := database.LookupUser(ctx, db, rest[0])
+	if err != nil {
+		return err
+	}
+	if user, err = database.BanUser(ctx, db, user.ID, *reason); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Out, "пользователь %d %s заблокирован\n", user.ID, user.Username)
+	return nil
+}
+
+// userUnban снимает блокировку.
+func userUnban(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("user unban", flag.ContinueOnError)
+	rest, err := parse(env, fs, args, "id|имя")
+	if err != nil {
+		return err
+	}
+	db := env.DB.WithContext(ctx)
+	user, err := database.LookupUser(ctx, db, rest[0])
+	if err != nil {
+		return err
+	}
+	if user, err = database.UnbanUser(ctx, db, user.ID); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Out, "пользователь %d %s разблокирован\n", user.ID, user.Username)
+	return nil
+}
+
+// userResetPassword задает новый пароль из стандартного ввода.
+func userResetPassword(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("user reset-password", flag.ContinueOnError)
+	rest, err := parse(env, fs, args, "id|имя")
+	if err != nil {
+		return err
+	}
+	db := env.DB.WithContext(ctx)
+	user, err := database.LookupUser(ctx, db, rest[0])
+	if err != nil {
+		return err
+	}
+	password, err := readPassword(env)
+	if err != nil {
+		return err
+	}
+	if err := database.ResetPassword(ctx, db, user.ID, database.ResetPasswordRequest{Password: password}, env.Passwords); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Out, "пароль пользователя %d %s изменен\n", user.ID, user.Username)
+	return nil
+}
+
+// userReset2FA выключает пользователю 2FA, например если он потерял телефон.
+func userReset2FA(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("user reset-2fa", flag.ContinueOnError)
+	rest, err := parse(env, fs, args, "id|имя")
+	if err != nil {
+		return err
+	}
+	db := env.DB.WithContext(ctx)
+	user, err := database.LookupUser(ctx, db, rest[0])
+	if err != nil {
+		return err
+	}
+	if err := database.ResetTwoFactor(ctx, db, user.ID); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Out, "двухфакторная аутентификация пользователя %d %s выключена\n", user.ID, user.Username)
+	return nil
+}
+
+// twoFactorPolicy показывает или, с -require, меняет роли, которым 2FA обязательна.
+func twoFactorPolicy(ctx context.Context, env Env, args []string) error {
+	fs := flag.NewFlagSet("2fa policy", flag.ContinueOnError)
+	require := fs.String("require", "", "роли через запятую (moderator,admin); пустая строка — 2FA необязательна")
+	if _, err := parse(env, fs, args); err != nil {
+		return err
+	}
+	db := env.DB.WithContext(ctx)
+	set := false
+	fs.Visit(func(f *flag.Flag) { set = set || f.Name == "require" })
+
+	var roles []string
+	var err error
+	if set {
+		var list []string
+		for _, role := range strings.Split(*require, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				list = append(list, role)
+			}
+		}
+		roles, err = database.SetTwoFactorRequiredRoles(ctx, db, list)
+	} else {
+		roles, err = database.TwoFactorRequiredRoles(ctx, db)
+	}
+	if err != nil {
+		return err
+	}
+	if len(roles) == 0 {
+		fmt.Fprintln(env.Out, "2FA необязательна")
+		return nil
+	}
+	fmt.Fprintln(env.Out, "2FA обязательна для ролей:", strings.Join(roles, ", "))
+	return nil
+}
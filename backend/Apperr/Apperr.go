@@ -0,0 +1,294 @@
+package apperr
+
+import (
+	logging "REVFORUM/logging"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Code — стабильный машиночитаемый код ошибки API. Клиенты ветвятся по коду,
+// а не по тексту сообщения: текст переводится и может меняться.
+type Code string
+
+const (
+	InvalidRequestBody Code = "INVALID_REQUEST_BODY" // Тело запроса не разбирается как JSON/форма
+	ValidationFailed   Code = "VALIDATION_FAILED"    // Поля не прошли проверку, подробности в fields
+	InvalidParameter   Code = "INVALID_PARAMETER"    // Некорректный параметр пути или запроса (params.name)
+	InvalidInclude     Code = "INVALID_INCLUDE"      // Неизвестное значение ?include=
+	NoFieldsToUpdate   Code = "NO_FIELDS_TO_UPDATE"  // PATCH без единого поля
+	Internal           Code = "INTERNAL_ERROR"       // Любая внутренняя ошибка; подробности только в журнале
+
+	RouteNotFound        Code = "ROUTE_NOT_FOUND"
+	UserNotFound         Code = "USER_NOT_FOUND"
+	ThemeNotFound        Code = "THEME_NOT_FOUND"
+	SubThemeNotFound     Code = "SUBTHEME_NOT_FOUND"
+	TopicNotFound        Code = "TOPIC_NOT_FOUND"
+	PostNotFound         Code = "POST_NOT_FOUND"
+	AttachmentNotFound   Code = "ATTACHMENT_NOT_FOUND"
+	AvatarNotFound       Code = "AVATAR_NOT_FOUND"
+	ThumbnailNotFound    Code = "THUMBNAIL_NOT_FOUND"
+	SessionNotFound      Code = "SESSION_NOT_FOUND"
+	IdentityNotFound     Code = "IDENTITY_NOT_FOUND"      // params.provider — провайдер не привязан
+	OIDCProviderNotFound Code = "OIDC_PROVIDER_NOT_FOUND" // params.provider — провайдер не настроен
+	FileNotFound         Code = "FILE_NOT_FOUND"          // Запись в БД есть, а файла в хранилище нет
+
+	UserAlreadyExists     Code = "USER_ALREADY_EXISTS"
+	UsernameReserved      Code = "USERNAME_RESERVED"        // Служебное имя (admin, system…) при регистрации или смене имени
+	UsernameChangeTooSoon Code = "USERNAME_CHANGE_TOO_SOON" // params.available_at — когда имя можно сменить снова
+	ThemeAlreadyExists    Code = "THEME_ALREADY_EXISTS"
+	ThemeNotEmpty         Code = "THEME_NOT_EMPTY"          // Удаление темы, в которой есть подтемы
+	SubThemeNotEmpty      Code = "SUBTHEME_NOT_EMPTY"       // Удаление подтемы, в которой есть топики
+	FirstPostNotDeletable Code = "FIRST_POST_NOT_DELETABLE" // Первое сообщение удаляется только вместе с топиком
+
+	TopicLocked    Code = "TOPIC_LOCKED"     // Новые сообщения в закрытый топик не принимаются
+	InvalidRole    Code = "INVALID_ROLE"     // params.allowed — допустимые роли
+	CannotBanAdmin Code = "CANNOT_BAN_ADMIN" // Администратора сначала нужно понизить
+
+	InvalidArchive            Code = "INVALID_ARCHIVE"             // params.line, params.reason
+	UnsupportedArchiveVersion Code = "UNSUPPORTED_ARCHIVE_VERSION" // params.version, params.max
+	ArchiveConflict           Code = "ARCHIVE_CONFLICT"            // params.kind, params.key — запись уже есть в базе
+
+	InvalidCredentials     Code = "INVALID_CREDENTIALS"
+	InvalidCurrentPassword Code = "INVALID_CURRENT_PASSWORD" // Смена пароля или удаление учетной записи: текущий пароль не подошел
+	UserBanned             Code = "USER_BANNED"
+	Unauthenticated        Code = "UNAUTHENTICATED"
+	Forbidden              Code = "FORBIDDEN"
+
+	InvalidTwoFactorCode      Code = "INVALID_TWO_FACTOR_CODE"
+	TwoFactorChallengeExpired Code = "TWO_FACTOR_CHALLENGE_EXPIRED" // challenge_token неизвестен, истек или исчерпал попытки
+	TwoFactorAlreadyEnabled   Code = "TWO_FACTOR_ALREADY_ENABLED"
+	TwoFactorNotEnabled       Code = "TWO_FACTOR_NOT_ENABLED"
+	TwoFactorRequired         Code = "TWO_FACTOR_REQUIRED" // Роль пользователя требует 2FA
+	TwoFactorLocked           Code = "TWO_FACTOR_LOCKED"   // params.retry_at — когда можно ввести код снова
+
+	OIDCStateInvalid      Code = "OIDC_STATE_INVALID"      // state неизвестен, истек, уже использован или выдан другому
+	OIDCLoginFailed       Code = "OIDC_LOGIN_FAILED"       // Провайдер не подтвердил вход; подробности только в журнале
+	OIDCEmailUnverified   Code = "OIDC_EMAIL_UNVERIFIED"   // Для новой учетной записи нужен подтвержденный email
+	OIDCEmailInUse        Code = "OIDC_EMAIL_IN_USE"       // Email уже занят: войти паролем и привязать провайдера
+	IdentityAlreadyLinked Code = "IDENTITY_ALREADY_LINKED" // params.provider
+	LastLoginMethod       Code = "LAST_LOGIN_METHOD"       // Отвязка оставила бы учетную запись без способа входа
+
+	AttachmentsUnavailable Code = "ATTACHMENTS_UNAVAILABLE" // Вложения чужие, не существуют или уже привязаны
+	FileRequired           Code = "FILE_REQUIRED"           // params.field — имя поля формы
+	FileTooLarge           Code = "FILE_TOO_LARGE"          // params.max_size — лимит в байтах
+	UnsupportedMediaType   Code = "UNSUPPORTED_MEDIA_TYPE"  // params.content_type, params.allowed
+	InvalidImage           Code = "INVALID_IMAGE"
+
+	InvalidChannel  Code = "INVALID_CHANNEL"
+	TooManyChannels Code = "TOO_MANY_CHANNELS"
+	TypingTopicOnly Code = "TYPING_TOPIC_ONLY"
+	UnknownAction   Code = "UNKNOWN_ACTION"
+
+	IdempotencyKeyReused     Code = "IDEMPOTENCY_KEY_REUSED"      // Idempotency-Key уже использован с другим запросом
+	IdempotencyKeyInProgress Code = "IDEMPOTENCY_KEY_IN_PROGRESS" // Запрос с этим Idempotency-Key еще выполняется
+)
+
+// statuses — HTTP-статус по умолчанию для каждого кода.
+var statuses = map[Code]int{
+	InvalidRequestBody: http.StatusBadRequest,
+	ValidationFailed:   http.StatusBadRequest,
+	InvalidParameter:   http.StatusBadRequest,
+	InvalidInclude:     http.StatusBadRequest,
+	NoFieldsToUpdate:   http.StatusBadRequest,
+	Internal:           http.StatusInternalServerError,
+
+	RouteNotFound:        http.StatusNotFound,
+	UserNotFound:         http.StatusNotFound,
+	ThemeNotFound:        http.StatusNotFound,
+	SubThemeNotFound:     http.StatusNotFound,
+	TopicNotFound:        http.StatusNotFound,
+	PostNotFound:         http.StatusNotFound,
+	AttachmentNotFound:   http.StatusNotFound,
+	AvatarNotFound:       http.StatusNotFound,
+	ThumbnailNotFound:    http.StatusNotFound,
+	SessionNotFound:      http.StatusNotFound,
+	IdentityNotFound:     http.StatusNotFound,
+	OIDCProviderNotFound: http.StatusNotFound,
+	FileNotFound:         http.StatusNotFound,
+
+	UserAlreadyExists:     http.StatusConflict,
+	UsernameReserved:      http.StatusConflict,
+	UsernameChangeTooSoon: http.StatusTooManyRequests,
+	ThemeAlreadyExists:    http.StatusConflict,
+	ThemeNotEmpty:         http.StatusConflict,
+	SubThemeNotEmpty:      http.StatusConflict,
+	FirstPostNotDeletable: http.StatusConflict,
+	TopicLocked:           http.StatusConflict,
+	InvalidRole:           http.StatusBadRequest,
+	CannotBanAdmin:        http.StatusConflict,
+
+	InvalidArchive:            http.StatusBadRequest,
+	UnsupportedArchiveVersion: http.StatusBadRequest,
+	ArchiveConflict:           http.StatusConflict,
+
+	InvalidCredentials:     http.StatusUnauthorized,
+	InvalidCurrentPassword: http.StatusBadRequest,
+	UserBanned:             http.StatusForbidden,
+	Unauthenticated:        http.StatusUnauthorized,
+	Forbidden:              http.StatusForbidden,
+
+	InvalidTwoFactorCode:      http.StatusBadRequest,
+	TwoFactorChallengeExpired: http.StatusUnauthorized,
+	TwoFactorAlreadyEnabled:   http.StatusConflict,
+	TwoFactorNotEnabled:       http.StatusConflict,
+	TwoFactorRequired:         http.StatusForbidden,
+	TwoFactorLocked:           http.StatusTooManyRequests,
+
+	OIDCStateInvalid:      http.StatusBadRequest,
+	OIDCLoginFailed:       http.StatusUnauthorized,
+	OIDCEmailUnverified:   http.StatusBadRequest,
+	OIDCEmailInUse:        http.StatusConflict,
+	IdentityAlreadyLinked: http.StatusConflict,
+	LastLoginMethod:       http.StatusConflict,
+
+	AttachmentsUnavailable: http.StatusBadRequest,
+	FileRequired:           http.StatusBadRequest,
+	FileTooLarge:           http.StatusRequestEntityTooLarge,
+	UnsupportedMediaType:   http.StatusUnsupportedMediaType,
+	InvalidImage:           http.StatusBadRequest,
+
+	InvalidChannel:  http.StatusBadRequest,
+	TooManyChannels: http.StatusBadRequest,
+	TypingTopicOnly: http.StatusBadRequest,
+	UnknownAction:   http.StatusBadRequest,
+
+	IdempotencyKeyReused:     http.StatusUnprocessableEntity,
+	IdempotencyKeyInProgress: http.StatusConflict,
+}
+
+// Error — ошибка API. Клиент видит код, переведенное сообщение, параметры
+// и ошибки полей; Cause остается только в журнале.
+type Error struct {
+	Status int
+	Code   Code
+	Params map[string]string // Подставляются в сообщение вместо {name} и отдаются клиенту
+	Fields []FieldError
+	Cause  error
+}
+
+// FieldError — ошибка отдельного поля запроса.
+type FieldError struct {
+	Field   string `json:"field"`           // Имя поля как в JSON, например attachment_ids[2]
+	Rule    string `json:"rule"`            // Нарушенное правило: required, email, max...
+	Param   string `json:"param,omitempty"` // Параметр правила: 6 для min=6
+	Message string `json:"message"`
+}
+
+// New создает ошибку с HTTP-статусом по умолчанию для кода.
+func New(code Code) *Error {
+	status, ok := statuses[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	return &Error{Status: status, Code: code}
+}
+
+// Wrap создает внутреннюю ошибку; cause попадет в журнал, но не в ответ.
+func Wrap(cause error) *Error {
+	e := New(Internal)
+	e.Cause = cause
+	return e
+}
+
+// Param добавляет параметр сообщения.
+func (e *Error) Param(name, value string) *Error {
+	if e.Params == nil {
+		e.Params = map[string]string{}
+	}
+	e.Params[name] = value
+	return e
+}
+
+// Field добавляет ошибку поля с правилом rule (и его параметром param).
+func (e *Error) Field(field, rule, param string) *Error {
+	e.Fields = append(e.Fields, FieldError{Field: field, Rule: rule, Param: param})
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return string(e.Code) + ": " + e.Cause.Error()
+	}
+	return string(e.Code)
+}
+
+func (e *Error) Unwrap() error { return e.Cause }
+
+// Text — сообщение об ошибке на языке lang вместе с ошибками полей,
+// для вывода вне HTTP (например, в консольных командах).
+func (e *Error) Text(lang string) string {
+	text := message(lang, e.Code, e.Params)
+	for _, f := range e.Fields {
+		text += "; " + f.Field + ": " + fieldMessage(lang, f.Rule, f.Param)
+	}
+	return text
+}
+
+// Render пишет ошибку в ответ и прерывает цепочку обработчиков.
+// Ошибка не типа *Error считается внутренней: она пишется в журнал,
+// а клиент получает только INTERNAL_ERROR.
+func Render(c *gin.Context, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		logging.L(c).Error("unhandled error", "error", err)
+		e = Wrap(err)
+	}
+	c.AbortWithStatusJSON(e.Status, Body(c, e))
+}
+
+// Body — тело ответа с ошибкой на языке клиента. Используется и там, где
+// ошибка уходит не HTTP-ответом (сообщения WebSocket).
+func Body(c *gin.Context, e *Error) gin.H {
+	lang := Lang(c)
+	body := gin.H{
+		"error": message(lang, e.Code, e.Params), // Для клиентов, которые показывают только текст
+		"code":  e.Code,
+	}
+	if len(e.Params) > 0 {
+		body["params"] = e.Params
+	}
+	if len(e.Fields) > 0 {
+		fields := make([]FieldError, len(e.Fields))
+		for i, f := range e.Fields {
+			f.Message = fieldMessage(lang, f.Rule, f.Param)
+			fields[i] = f
+		}
+		body["fields"] = fields
+	}
+	if id := c.GetString(logging.RequestIDKey); id != "" {
+		body["request_id"] = id
+	}
+	return body
+}
+
+// Lang выбирает язык ответа по Accept-Language: ru или en.
+// Учитываются веса q; без заголовка — русский.
+func Lang(c *gin.Context) string {
+	best, bestQ := defaultLang, -1.0
+	for _, part := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		tag, q := parseLangPart(part)
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if _, ok := messages[base]; ok && q > bestQ {
+			best, bestQ = base, q
+		}
+	}
+	return best
+}
+
+// NoRoute отвечает на запросы к несуществующим маршрутам.
+func NoRoute(c *gin.Context) {
+	Render(c, New(RouteNotFound))
+}
+
+// Recovery перехватывает панику обработчика, пишет ее со стеком в журнал
+// запроса и отвечает INTERNAL_ERROR.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		logging.L(c).Error("panic recovered", "panic", err, "stack", string(debug.Stack()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Body(c, New(Internal)))
+	})
+}
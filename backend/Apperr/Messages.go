@@ -0,0 +1,245 @@
+package apperr
+
+import (
+	"strconv"
+	"strings"
+)
+
+// defaultLang — язык ответа, если клиент не просит ни ru, ни en.
+const defaultLang = "ru"
+
+// messages — тексты ошибок по языку и коду. {name} заменяется значением из Params.
+var messages = map[string]map[Code]string{
+	"ru": {
+		InvalidRequestBody: "Некорректные данные",
+		ValidationFailed:   "Некорректные данные",
+		InvalidParameter:   "Некорректный параметр {name}",
+		InvalidInclude:     "Неизвестное значение include: {value} (допустимо: {allowed})",
+		NoFieldsToUpdate:   "Нет полей для изменения",
+		Internal:           "Ошибка сервера",
+
+		RouteNotFound:        "Маршрут не найден",
+		UserNotFound:         "Пользователь не найден",
+		ThemeNotFound:        "Тема не найдена",
+		SubThemeNotFound:     "Подтема не найдена",
+		TopicNotFound:        "Топик не найден",
+		PostNotFound:         "Сообщение не найдено",
+		AttachmentNotFound:   "Вложение не найдено",
+		AvatarNotFound:       "У пользователя нет аватара",
+		ThumbnailNotFound:    "У вложения нет миниатюры",
+		SessionNotFound:      "Сессия не найдена",
+		IdentityNotFound:     "Провайдер {provider} не привязан",
+		OIDCProviderNotFound: "Вход через {provider} не настроен",
+		FileNotFound:         "Файл не найден",
+
+		UserAlreadyExists:     "Пользователь с таким именем или email уже существует",
+		UsernameReserved:      "Это имя зарезервировано",
+		UsernameChangeTooSoon: "Имя можно будет сменить снова после {available_at}",
+		ThemeAlreadyExists:    "Тема с данным названием уже существует",
+		ThemeNotEmpty:         "В теме есть подтемы; сначала удалите или перенесите их",
+		SubThemeNotEmpty:      "В подтеме есть топики; сначала удалите или перенесите их",
+		FirstPostNotDeletable: "Первое сообщение топика удаляется только вместе с топиком",
+		TopicLocked:           "Топик закрыт для новых сообщений",
+		InvalidRole:           "Неизвестная роль (допустимо: {allowed})",
+		CannotBanAdmin:        "Нельзя заблокировать администратора; сначала смените ему роль",
+
+		InvalidArchive:            "Некорректный архив, строка {line}: {reason}",
+		UnsupportedArchiveVersion: "Версия архива {version} не поддерживается (не выше {max})",
+		ArchiveConflict:           "Запись {kind} {key} уже есть в базе; выберите другую политику конфликтов",
+
+		InvalidCredentials:     "Неверное имя пользователя или пароль",
+		InvalidCurrentPassword: "Текущий пароль указан неверно",
+		UserBanned:             "Учетная запись заблокирована",
+		Unauthenticated:        "Пользователь не аутентифицирован",
+		Forbidden:              "Недостаточно прав",
+
+		InvalidTwoFactorCode:      "Неверный код подтверждения",
+		TwoFactorChallengeExpired: "Время на ввод кода истекло; войдите заново",
+		TwoFactorAlreadyEnabled:   "Двухфакторная аутентификация уже включена",
+		TwoFactorNotEnabled:       "Двухфакторная аутентификация не включена",
+		TwoFactorRequired:         "Для вашей роли нужна двухфакторная аутентификация",
+		TwoFactorLocked:           "Слишком много неверных кодов; попробуйте снова после {retry_at}",
+
+		OIDCStateInvalid:      "Ссылка для входа устарела; начните вход заново",
+		OIDCLoginFailed:       "Провайдер не подтвердил вход",
+		OIDCEmailUnverified:   "Провайдер не подтвердил ваш email",
+		OIDCEmailInUse:        "Учетная запись с этим email уже есть: войдите в нее и привяжите провайдера в профиле",
+		IdentityAlreadyLinked: "Эта учетная запись {provider} уже привязана",
+		LastLoginMethod:       "Это последний способ входа; сначала задайте пароль или привяжите другого провайдера",
+
+		AttachmentsUnavailable: "Вложения не найдены или уже прикреплены к другому посту",
+		FileRequired:           "Файл не передан (ожидается поле {field})",
+		FileTooLarge:           "Файл слишком большой (не больше {max_size} байт)",
+		UnsupportedMediaType:   "Недопустимый тип файла {content_type} (допустимо: {allowed})",
+		InvalidImage:           "Не удалось прочитать изображение",
+
+		InvalidChannel:  "Некорректный канал",
+		TooManyChannels: "Укажите от 1 до {max} каналов (topic, subtheme)",
+		TypingTopicOnly: "typing поддерживается только для топиков",
+		UnknownAction:   "Неизвестное действие {action}",
+
+		IdempotencyKeyReused:     "Ключ Idempotency-Key уже использован с другим запросом",
+		IdempotencyKeyInProgress: "Запрос с этим ключом Idempotency-Key еще выполняется",
+	},
+	"en": {
+		InvalidRequestBody: "Malformed request body",
+		ValidationFailed:   "Validation failed",
+		InvalidParameter:   "Invalid parameter {name}",
+		InvalidInclude:     "Unknown include value: {value} (allowed: {allowed})",
+		NoFieldsToUpdate:   "No fields to update",
+		Internal:           "Internal server error",
+
+		RouteNotFound:        "Route not found",
+		UserNotFound:         "User not found",
+		ThemeNotFound:        "Theme not found",
+		SubThemeNotFound:     "Subtheme not found",
+		TopicNotFound:        "Topic not found",
+		PostNotFound:         "Post not found",
+		AttachmentNotFound:   "Attachment not found",
+		AvatarNotFound:       "User has no avatar",
+		ThumbnailNotFound:    "Attachment has no thumbnail",
+		SessionNotFound:      "Session not found",
+		IdentityNotFound:     "Provider {provider} is not linked",
+		OIDCProviderNotFound: "Sign-in with {provider} is not configured",
+		FileNotFound:         "File not found",
+
+		UserAlreadyExists:     "A user with this username or email already exists",
+		UsernameReserved:      "This username is reserved",
+		UsernameChangeTooSoon: "You can change your username again after {available_at}",
+		ThemeAlreadyExists:    "A theme with this title already exists",
+		ThemeNotEmpty:         "The theme still has subthemes; delete or move them first",
+		SubThemeNotEmpty:      "The subtheme still has topics; delete or move them first",
+		FirstPostNotDeletable: "The first post of a topic can only be deleted together with the topic",
+		TopicLocked:           "The topic is locked for new posts",
+		InvalidRole:           "Unknown role (allowed: {allowed})",
+		CannotBanAdmin:        "An administrator cannot be banned; change their role first",
+
+		InvalidArchive:            "Invalid archive, line {line}: {reason}",
+		UnsupportedArchiveVersion: "Archive version {version} is not supported (at most {max})",
+		ArchiveConflict:           "Record {kind} {key} already exists; choose another conflict policy",
+
+		InvalidCredentials:     "Invalid username or password",
+		InvalidCurrentPassword: "The current password is incorrect",
+		UserBanned:             "The account is banned",
+		Unauthenticated:        "Authentication required",
+		Forbidden:              "Insufficient permissions",
+
+		InvalidTwoFactorCode:      "Invalid verification code",
+		TwoFactorChallengeExpired: "The verification step has expired; please log in again",
+		TwoFactorAlreadyEnabled:   "Two-factor authentication is already enabled",
+		TwoFactorNotEnabled:       "Two-factor authentication is not enabled",
+		TwoFactorRequired:         "Your role requires two-factor authentication",
+		TwoFactorLocked:           "Too many invalid codes; try again after {retry_at}",
+
+		OIDCStateInvalid:      "The sign-in link has expired; please start again",
+		OIDCLoginFailed:       "The provider did not confirm the sign-in",
+		OIDCEmailUnverified:   "The provider has not verified your email",
+		OIDCEmailInUse:        "An account with this email already exists: log in to it and link the provider in your profile",
+		IdentityAlreadyLinked: "This {provider} account is already linked",
+		LastLoginMethod:       "This is your last way to log in; set a password or link another provider first",
+
+		AttachmentsUnavailable: "Attachments not found or already attached to another post",
+		FileRequired:           "No file uploaded (expected field {field})",
+		FileTooLarge:           "File is too large (at most {max_size} bytes)",
+		UnsupportedMediaType:   "Unsupported file type {content_type} (allowed: {allowed})",
+		InvalidImage:           "Could not decode image",
+
+		InvalidChannel:  "Invalid channel",
+		TooManyChannels: "Specify from 1 to {max} channels (topic, subtheme)",
+		TypingTopicOnly: "typing is supported for topics only",
+		UnknownAction:   "Unknown action {action}",
+
+		IdempotencyKeyReused:     "This Idempotency-Key was already used with a different request",
+		IdempotencyKeyInProgress: "A request with this Idempotency-Key is still in progress",
+	},
+}
+
+// fieldMessages — тексты ошибок полей по правилу валидации. {param} — параметр правила.
+var fieldMessages = map[string]map[string]string{
+	"ru": {
+		"required": "обязательное поле",
+		"email":    "некорректный email",
+		"url":      "должно быть ссылкой http(s)://",
+		"min":      "не меньше {param}",
+		"max":      "не больше {param}",
+		"len":      "длина должна быть {param}",
+		"gt":       "должно быть больше {param}",
+		"gte":      "должно быть не меньше {param}",
+		"lt":       "должно быть меньше {param}",
+		"lte":      "должно быть не больше {param}",
+		"oneof":    "допустимые значения: {param}",
+		"unique":   "значения не должны повторяться",
+		"type":     "неверный тип значения, ожидается {param}",
+		"invalid":  "некорректное значение",
+
+		"max_bytes":         "не длиннее {param} байт",
+		"password_classes":  "нужны символы хотя бы {param} видов из четырех: строчные и прописные буквы, цифры, прочие",
+		"password_personal": "не должен содержать имя пользователя или email",
+		"password_common":   "слишком распространенный пароль или найден в утечках",
+		"username_chars":    "допустимы буквы, цифры, «_», «-» и «.»",
+		"username_digits":   "не может состоять из одних цифр",
+	},
+	"en": {
+		"required": "is required",
+		"email":    "must be a valid email",
+		"url":      "must be an http(s):// link",
+		"min":      "must be at least {param}",
+		"max":      "must be at most {param}",
+		"len":      "must have length {param}",
+		"gt":       "must be greater than {param}",
+		"gte":      "must be at least {param}",
+		"lt":       "must be less than {param}",
+		"lte":      "must be at most {param}",
+		"oneof":    "must be one of: {param}",
+		"unique":   "must not contain duplicates",
+		"type":     "has wrong type, expected {param}",
+		"invalid":  "is invalid",
+
+		"max_bytes":         "must be at most {param} bytes",
+		"password_classes":  "must contain at least {param} of: lowercase letters, uppercase letters, digits, other characters",
+		"password_personal": "must not contain your username or email",
+		"password_common":   "is too common or has appeared in a data breach",
+		"username_chars":    "may contain only letters, digits, \"_\", \"-\" and \".\"",
+		"username_digits":   "must not consist of digits only",
+	},
+}
+
+func message(lang string, code Code, params map[string]string) string {
+	text, ok := messages[lang][code]
+	if !ok {
+		text = string(code)
+	}
+	return fill(text, params)
+}
+
+func fieldMessage(lang, rule, param string) string {
+	text, ok := fieldMessages[lang][rule]
+	if !ok {
+		text = fieldMessages[lang]["invalid"]
+	}
+	return fill(text, map[string]string{"param": param})
+}
+
+// fill подставляет значения params вместо {name}.
+func fill(text string, params map[string]string) string {
+	if len(params) == 0 || !strings.Contains(text, "{") {
+		return text
+	}
+	pairs := make([]string, 0, len(params)*2)
+	for name, value := range params {
+		pairs = append(pairs, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// parseLangPart разбирает элемент Accept-Language вида "en-US;q=0.8".
+func parseLangPart(part string) (string, float64) {
+	tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+	q := 1.0
+	if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+		if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+			q = parsed
+		}
+	}
+	return strings.TrimSpace(tag), q
+}
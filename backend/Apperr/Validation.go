@@ -0,0 +1,61 @@
+package apperr
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// В ошибках полей — имена из тегов json/form, а не имена полей Go
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(fieldName)
+	}
+}
+
+func fieldName(f reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+// Binding превращает ошибку c.ShouldBind* в ошибку API: ошибки валидатора —
+// в VALIDATION_FAILED со списком полей, неверный тип значения — в ошибку
+// поля, прочие ошибки разбора — в INVALID_REQUEST_BODY.
+func Binding(err error) *Error {
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		e := New(ValidationFailed)
+		for _, fe := range verrs {
+			e.Field(fieldPath(fe), fe.Tag(), fe.Param())
+		}
+		return e
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return New(ValidationFailed).Field(typeErr.Field, "type", typeErr.Type.Kind().String())
+	}
+	e := New(InvalidRequestBody)
+	e.Cause = err
+	return e
+}
+
+// fieldPath — путь к полю без имени корневой структуры: attachment_ids[2].
+func fieldPath(fe validator.FieldError) string {
+	_, path, found := strings.Cut(fe.Namespace(), ".")
+	if !found {
+		return fe.Field()
+	}
+	return path
+}
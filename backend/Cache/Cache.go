@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry — закэшированный ответ обработчика вместе с валидаторами для условных GET.
+type Entry struct {
+	Body         []byte    `json:"body"`          // Тело ответа как есть (JSON)
+	ContentType  string    `json:"content_type"`  // Content-Type исходного ответа
+	ETag         string    `json:"etag"`          // Сильный ETag, вычисленный по телу
+	LastModified time.Time `json:"last_modified"` // Момент, когда ответ был сформирован
+}
+
+// Store — хранилище кэша. Реализации: in-process LRU и Redis.
+type Store interface {
+	// Get возвращает запись по ключу; ok == false, если записи нет или она устарела.
+	Get(ctx context.Context, key string) (entry Entry, ok bool, err error)
+	// Set сохраняет запись на время ttl (0 — без ограничения по времени).
+	// gen — поколение группы ключа, прочитанное до построения ответа (см. Generation):
+	// если группу с тех пор сбросили, ответ мог устареть и не сохраняется.
+	Set(ctx context.Context, key string, entry Entry, ttl time.Duration, gen uint64) error
+	// DeletePrefix удаляет все записи, ключ которых начинается с prefix,
+	// и увеличивает поколение группы префикса.
+	DeletePrefix(ctx context.Context, prefix string) error
+	// Generation возвращает поколение группы — число ее сбросов.
+	Generation(ctx context.Context, group string) (uint64, error)
+}
+
+// Init создает хранилище кэша по переменным окружения:
+//
+//	CACHE_BACKEND — memory (по умолчанию), redis или off
+//	CACHE_SIZE    — максимальное число записей для memory (по умолчанию 1024)
+//	REDIS_ADDR, REDIS_PASSWORD, REDIS_DB — параметры подключения для redis
+//
+// Возвращает nil, если кэш выключен; middleware и инвалидация это учитывают.
+// Время жизни записей задается отдельно (см. TTLFromEnv).
+func Init() Store {
+	switch backend := os.Getenv("CACHE_BACKEND"); backend {
+	case "", "memory":
+		size := 1024
+		if v := os.Getenv("CACHE_SIZE"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				log.Fatal("Invalid CACHE_SIZE: ", v)
+			}
+			size = n
+		}
+		return NewLRU(size)
+	case "redis":
+		db := 0
+		if v := os.Getenv("REDIS_DB"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				log.Fatal("Invalid REDIS_DB: ", v)
+			}
+			db = n
+		}
+		store, err := NewRedis(os.Getenv("REDIS_ADDR"), os.Getenv("REDIS_PASSWORD"), db)
+		if err != nil {
+			log.Fatal("Failed to connect to redis: ", err)
+		}
+		return store
+	case "off":
+		return nil
+	default:
+		log.Fatal("Unknown CACHE_BACKEND: ", backend)
+		return nil
+	}
+}
+
+// TTLFromEnv читает время жизни записи из CACHE_TTL, например 5m (по умолчанию 5m).
+func TTLFromEnv() time.Duration {
+	ttl := 5 * time.Minute
+	if v := os.Getenv("CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatal("Invalid CACHE_TTL: ", err)
+		}
+		ttl = d
+	}
+	return ttl
+}
+
+// GroupKey возвращает префикс ключей для группы ответов, относящихся к одному ресурсу,
+// например GroupKey("topics", "5") — все варианты списка топиков подтемы 5.
+// Разделитель в конце не дает префиксу "topics:5" совпасть с "topics:50".
+func GroupKey(group, id string) string {
+	return group + ":" + id + "|"
+}
+
+// Group возвращает префикс всех ключей группы, например для сброса
+// всех списков постов сразу.
+func Group(group string) string {
+	return group + ":"
+}
+
+// groupOf возвращает имя группы ключа или префикса (см. GroupKey, Group).
+func groupOf(key string) string {
+	group, _, _ := strings.Cut(key, ":")
+	return group
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LRU — in-process кэш с вытеснением давно не использованных записей.
+// Подходит для одного экземпляра бэкенда; для нескольких нужен Redis.
+type LRU struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List               // Начало списка — самые свежие записи
+	items    map[string]*list.Element // key -> элемент списка с *lruItem
+	gens     map[string]uint64        // Поколения групп (см. Store.Generation)
+}
+
+type lruItem struct {
+	key       string
+	entry     Entry
+	expiresAt time.Time // Нулевое значение — запись не устаревает
+}
+
+// NewLRU создает LRU-кэш, вмещающий не более capacity записей.
+func NewLRU(capacity int) *LRU {
+	return &LRU{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+		gens:     make(map[string]uint64),
+	}
+}
+
+func (l *LRU) Get(_ context.Context, key string) (Entry, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.items[key]
+	if !ok {
+		return Entry{}, false, nil
+	}
+	item := el.Value.(*lruItem)
+	if !item.expiresAt.IsZero() && time.Now().After(item.expiresAt) {
+		l.remove(el)
+		return Entry{}, false, nil
+	}
+	l.order.MoveToFront(el)
+	return item.entry, true, nil
+}
+
+func (l *LRU) Set(_ context.Context, key string, entry Entry, ttl time.Duration, gen uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.gens[groupOf(key)] != gen {
+		return nil
+	}
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	if el, ok := l.items[key]; ok {
+		item := el.Value.(*lruItem)
+		item.entry = entry
+		item.expiresAt = expiresAt
+		l.order.MoveToFront(el)
+		return nil
+	}
+
+	l.items[key] = l.order.PushFront(&lruItem{key: key, entry: entry, expiresAt: expiresAt})
+	for l.order.Len() > l.capacity {
+		l.remove(l.order.Back())
+	}
+	return nil
+}
+
+func (l *LRU) DeletePrefix(_ context.Context, prefix string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.gens[groupOf(prefix)]++
+	for key, el := range l.items {
+		if strings.HasPrefix(key, prefix) {
+			l.remove(el)
+		}
+	}
+	return nil
+}
+
+func (l *LRU) Generation(_ context.Context, group string) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.gens[group], nil
+}
+
+// remove удаляет элемент; вызывается под l.mu.
+func (l *LRU) remove(el *list.Element) {
+	l.order.Remove(el)
+	delete(l.items, el.Value.(*lruItem).key)
+}
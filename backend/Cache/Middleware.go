@@ -0,0 +1,191 @@
+package cache
+
+import (
+	logging "REVFORUM/logging"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeyFunc строит ключ кэша для запроса. Пустой ключ — запрос не кэшируется.
+type KeyFunc func(c *gin.Context) string
+
+// ByParam строит ключ из группы, параметра маршрута и ?include=,
+// например ByParam("topics", "id") для GET /api/themes/subthemes/:id/topics.
+// Пустое имя параметра — ресурс без ID (список тем).
+//
+// ID записывается в каноническом виде, как его строит сброс кэша:
+// /topics/05/posts и /topics/+5/posts иначе не сбрасывались бы вместе с /topics/5/posts.
+// Запрос с нечисловым ID идет мимо кэша — обработчик сам ответит ошибкой.
+// От остальных параметров ответ не зависит: в ключ они не входят, иначе
+// ?x=1, ?x=2… плодили бы копии одного ответа и вытесняли нужные.
+func ByParam(group, param string) KeyFunc {
+	return func(c *gin.Context) string {
+		id := ""
+		if param != "" {
+			n, err := strconv.ParseUint(c.Param(param), 10, 32)
+			if err != nil {
+				return ""
+			}
+			id = strconv.FormatUint(n, 10)
+		}
+		return GroupKey(group, id) + includeKey(c.Query("include"))
+	}
+}
+
+// includeKey приводит ?include= к одному виду: author,topic и topic, author — один ответ.
+func includeKey(raw string) string {
+	var names []string
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	slices.Sort(names)
+	return "include=" + strings.Join(slices.Compact(names), ",")
+}
+
+// Middleware кэширует успешные (200) ответы GET-обработчика и отвечает на условные
+// запросы (If-None-Match / If-Modified-Since) статусом 304 Not Modified.
+// Если store == nil, запрос просто передается дальше.
+func Middleware(store Store, ttl time.Duration, key KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if store == nil || c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		k := key(c)
+		if k == "" {
+			c.Next()
+			return
+		}
+		entry, ok, err := store.Get(c.Request.Context(), k)
+		if err != nil {
+			// Недоступный кэш не должен ронять чтение — идем в БД.
+			logging.L(c).Warn("cache read failed", "key", k, "error", err)
+		}
+		if ok {
+			serve(c, entry)
+			c.Abort()
+			return
+		}
+
+		// Поколение группы — до чтения БД: если запись в БД и сброс группы
+		// случатся, пока обработчик строит ответ, устаревший ответ не сохранится
+		gen, err := store.Generation(c.Request.Context(), groupOf(k))
+		if err != nil {
+			logging.L(c).Warn("cache read failed", "key", k, "error", err)
+			c.Next()
+			return
+		}
+
+		// Перехватываем ответ обработчика, чтобы посчитать ETag до отправки заголовков.
+		w := &bufferedWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		if w.status != http.StatusOK {
+			c.Writer.WriteHeader(w.status)
+			c.Writer.Write(w.body.Bytes())
+			return
+		}
+
+		entry = Entry{
+			Body:         w.body.Bytes(),
+			ContentType:  c.Writer.Header().Get("Content-Type"),
+			ETag:         etag(w.body.Bytes()),
+			LastModified: time.Now().UTC().Truncate(time.Second), // HTTP-даты с точностью до секунды
+		}
+		if err := store.Set(c.Request.Context(), k, entry, ttl, gen); err != nil {
+			logging.L(c).Warn("cache write failed", "key", k, "error", err)
+		}
+		serve(c, entry)
+	}
+}
+
+// Invalidate удаляет из store все ответы перечисленных групп (см. GroupKey).
+// Ошибки только логируются: запись в БД уже произошла, а запись в кэше истечет по TTL.
+func Invalidate(c *gin.Context, store Store, prefixes ...string) {
+	InvalidateContext(c.Request.Context(), store, prefixes...)
+}
+
+// InvalidateContext — Invalidate для кода вне обработчиков HTTP
+// (общие операции database, консольные команды).
+func InvalidateContext(ctx context.Context, store Store, prefixes ...string) {
+	if store == nil {
+		return
+	}
+	for _, prefix := range prefixes {
+		if err := store.DeletePrefix(ctx, prefix); err != nil {
+			logging.FromContext(ctx).Warn("cache invalidation failed", "prefix", prefix, "error", err)
+		}
+	}
+}
+
+// serve отправляет запись из кэша с валидаторами или 304, если у клиента актуальная копия.
+func serve(c *gin.Context, entry Entry) {
+	h := c.Writer.Header()
+	h.Set("ETag", entry.ETag)
+	h.Set("Last-Modified", entry.LastModified.Format(http.TimeFormat))
+	h.Set("Cache-Control", "no-cache") // Клиент может хранить ответ, но обязан его перепроверять
+
+	if notModified(c.Request, entry) {
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	c.Data(http.StatusOK, entry.ContentType, entry.Body)
+}
+
+// notModified реализует проверку условного GET по RFC 9110:
+// If-None-Match имеет приоритет, If-Modified-Since проверяется только без него.
+func notModified(r *http.Request, entry Entry) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == entry.ETag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !entry.LastModified.After(t)
+	}
+	return false
+}
+
+// etag возвращает сильный ETag по содержимому тела.
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// bufferedWriter копит тело и статус ответа вместо немедленной отправки клиенту.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *bufferedWriter) WriteHeader(code int)              { w.status = code }
+func (w *bufferedWriter) WriteHeaderNow()                   {}
+func (w *bufferedWriter) Status() int                       { return w.status }
+func (w *bufferedWriter) Written() bool                     { return w.body.Len() > 0 }
+func (w *bufferedWriter) Size() int                         { return w.body.Len() }
+func (w *bufferedWriter) Write(data []byte) (int, error)    { return w.body.Write(data) }
+func (w *bufferedWriter) WriteString(s string) (int, error) { return w.body.WriteString(s) }
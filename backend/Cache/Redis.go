@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keyPrefix отделяет ключи форума от прочих данных в общем Redis.
+const keyPrefix = "revforum:cache:"
+
+// genPrefix — ключи поколений групп; вне keyPrefix, чтобы сброс группы их не удалял.
+const genPrefix = "revforum:cache-gen:"
+
+// setIfGeneration сохраняет запись, только если поколение группы не изменилось:
+// проверка и запись атомарны. ARGV: запись, TTL в мс (0 — без срока), поколение.
+var setIfGeneration = redis.NewScript(`
+if (redis.call("GET", KEYS[2]) or "0") ~= ARGV[3] then
+	return 0
+end
+if ARGV[2] == "0" then
+	redis.call("SET", KEYS[1], ARGV[1])
+else
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+end
+return 1
+`)
+
+// Redis — кэш в Redis, общий для всех экземпляров бэкенда.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis подключается к Redis и проверяет соединение.
+func NewRedis(addr, password string, db int) (*Redis, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, err
+	}
+	return &Redis{client: client}, nil
+}
+
+func (r *Redis) Get(ctx context.Context, key string) (Entry, bool, error) {
+	data, err := r.client.Get(ctx, keyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, err
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return Entry{}, false, err
+	}
+	return entry, true, nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, entry Entry, ttl time.Duration, gen uint64) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	keys := []string{keyPrefix + key, genPrefix + groupOf(key)}
+	return setIfGeneration.Run(ctx, r.client, keys, data, ttl.Milliseconds(), gen).Err()
+}
+
+// DeletePrefix проходит по ключам через SCAN, чтобы не блокировать Redis командой KEYS.
+// Поколение растет до удаления: ответ, построенный до записи в БД, уже не сохранится.
+func (r *Redis) DeletePrefix(ctx context.Context, prefix string) error {
+	if err := r.client.Incr(ctx, genPrefix+groupOf(prefix)).Err(); err != nil {
+		return err
+	}
+	iter := r.client.Scan(ctx, 0, keyPrefix+escapePattern(prefix)+"*", 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return r.client.Del(ctx, keys...).Err()
+}
+
+func (r *Redis) Generation(ctx context.Context, group string) (uint64, error) {
+	gen, err := r.client.Get(ctx, genPrefix+group).Uint64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return gen, err
+}
+
+// escapePattern экранирует спецсимволы glob-шаблона Redis в части ключа.
+func escapePattern(s string) string {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			out = append(out, '\\')
+		}
+		out = append(out, s[i])
+	}
+	return string(out)
+}
@@ -0,0 +1,326 @@
+package database
+
+import (
+	apperr "REVFORUM/apperr"
+	cache "REVFORUM/cache"
+	logging "REVFORUM/logging"
+	storage "REVFORUM/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Личные данные и удаление учетной записи.
+//
+// DELETE /api/v1/me не удаляет учетную запись сразу, а назначает удаление
+// через срок ACCOUNT_DELETION_GRACE: до него пользователь может передумать
+// (DELETE /api/v1/me/deletion). Назначенные удаления выполняет фоновая задача
+// сервера (PurgeDeletedAccounts). Топики, сообщения и вложения удаленного
+// пользователя передаются заглушке DeletedUsername, поэтому ссылки AuthorID
+// остаются верными, а обсуждения — читаемыми; история имен тоже переходит
+// к ней, чтобы прежние имена не освобождались раньше срока. Сама запись
+// пользователя с именем, email и профилем удаляется.
+
+// DeletedUsername — имя заглушки «удаленный пользователь». Зарегистрировать
+// такое имя нельзя, войти под заглушкой тоже. Саму заглушку отличает
+// User.Placeholder, а не имя: имя могло достаться пользователю раньше,
+// чем его зарезервировали.
+const DeletedUsername = "deleted"
+
+// deletedEmail — email заглушки; домен .invalid не существует.
+const deletedEmail = DeletedUsername + "@revforum.invalid"
+
+// AccountDeletionGraceFromEnv читает ACCOUNT_DELETION_GRACE — срок между
+// запросом на удаление и самим удалением, например 720h (по умолчанию 30 суток).
+func AccountDeletionGraceFromEnv() time.Duration {
+	grace := 30 * 24 * time.Hour
+	if v := os.Getenv("ACCOUNT_DELETION_GRACE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatal("Invalid ACCOUNT_DELETION_GRACE: ", v)
+		}
+		grace = d
+	}
+	return grace
+}
+
+// PersonalProfile — все поля учетной записи, которые видит ее владелец.
+type PersonalProfile struct {
+	ID                  uint       `json:"id"`
+	Username            string     `json:"username"`
+	Email               string     `json:"email"`
+	CreatedAt           time.Time  `json:"created_at"`
+	DisplayName         string     `json:"display_name"`
+	Bio                 string     `json:"bio"`
+	Signature           string     `json:"signature"`
+	Location            string     `json:"location"`
+	Website             string     `json:"website"`
+	AvatarURL           string     `json:"avatar_url,omitempty"`
+	Reputation          int        `json:"reputation"`
+	Role                string     `json:"role"`
+	BannedAt            *time.Time `json:"banned_at,omitempty"`
+	BanReason           string     `json:"ban_reason,omitempty"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+// PersonalData — выгрузка GET /api/v1/me/export. Личных сообщений и настроек
+// в форуме пока нет; когда появятся, их нужно добавить сюда.
+type PersonalData struct {
+	ExportedAt  time.Time       `json:"exported_at"`
+	Profile     PersonalProfile `json:"profile"`
+	Topics      []Topic         `json:"topics"`
+	Posts       []Post          `json:"posts"`       // С вложениями
+	Attachments []Attachment    `json:"attachments"` // Все загруженные файлы, в том числе не прикрепленные
+	Identities  []Identity      `json:"identities"`  // Привязанные провайдеры входа
+
+	UsernameChanges []UsernameChange `json:"username_changes"` // Смены имени пользователя
+}
+
+// ExportPersonalData собирает все данные пользователя.
+func ExportPersonalData(ctx context.Context, db *gorm.DB, userID uint) (PersonalData, error) {
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return PersonalData{}, err
+	}
+	data := PersonalData{
+		ExportedAt: time.Now().UTC(),
+		Profile: PersonalProfile{
+			ID: user.ID, Username: user.Username, Email: user.Email, CreatedAt: user.CreatedAt,
+			DisplayName: user.DisplayName, Bio: user.Bio, Signature: user.Signature, Location: user.Location,
+			Website: user.Website, AvatarURL: avatarURL(user), Reputation: user.Reputation, Role: user.Role,
+			BannedAt: user.BannedAt, BanReason: user.BanReason, DeletionScheduledAt: user.DeletionScheduledAt,
+		},
+		Topics:      []Topic{},
+		Posts:       []Post{},
+		Attachments: []Attachment{},
+		Identities:  []Identity{},
+
+		UsernameChanges: []UsernameChange{},
+	}
+	for _, err := range []error{
+		db.Where("author_id = ?", userID).Order("id ASC").Find(&data.Topics).Error,
+		db.Where("author_id = ?", userID).Order("id ASC").Preload("Attachments").Find(&data.Posts).Error,
+		db.Where("author_id = ?", userID).Order("id ASC").Find(&data.Attachments).Error,
+		db.Where("user_id = ?", userID).Order("provider ASC").Find(&data.Identities).Error,
+		db.Where("user_id = ?", userID).Order("created_at ASC").Find(&data.UsernameChanges).Error,
+	} {
+		if err != nil {
+			logging.FromContext(ctx).Error("failed to export personal data", "user_id", userID, "error", err)
+			return PersonalData{}, apperr.Wrap(err)
+		}
+	}
+	return data, nil
+}
+
+// ExportPersonalDataHandler отдает текущему пользователю его данные файлом JSON.
+// GET /api/v1/me/export
+func ExportPersonalDataHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+
+		data, err := ExportPersonalData(c.Request.Context(), db, userID)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		name := "revforum-" + data.Profile.Username + "-" + data.ExportedAt.Format("20060102") + ".json"
+		c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+		c.JSON(http.StatusOK, data)
+	}
+}
+
+// DeleteAccountRequest — подтверждение удаления паролем. Пароль не нужен
+// только учетной записи без пароля (созданной входом через провайдера OIDC).
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// ScheduleAccountDeletion назначает удаление учетной записи через grace.
+// Повторный запрос не переносит уже назначенный срок.
+func ScheduleAccountDeletion(ctx context.Context, db *gorm.DB, userID uint, req DeleteAccountRequest, grace time.Duration) (User, error) {
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return user, err
+	}
+	if user.PasswordHash != noPassword && !verifyPassword(user.PasswordHash, req.Password) {
+		return user, apperr.New(apperr.InvalidCurrentPassword)
+	}
+	if user.DeletionScheduledAt != nil {
+		return user, nil
+	}
+	at := time.Now().Add(grace)
+	if err := db.Model(&user).Update("deletion_scheduled_at", at).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to schedule account deletion", "user_id", userID, "error", err)
+		return user, apperr.Wrap(err)
+	}
+	user.DeletionScheduledAt = &at
+	return user, nil
+}
+
+// CancelAccountDeletion отменяет назначенное удаление.
+func CancelAccountDeletion(ctx context.Context, db *gorm.DB, userID uint) (User, error) {
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return user, err
+	}
+	if err := db.Model(&user).Update("deletion_scheduled_at", nil).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to cancel account deletion", "user_id", userID, "error", err)
+		return user, apperr.Wrap(err)
+	}
+	return user, nil
+}
+
+// DeleteAccountHandler назначает удаление учетной записи текущего пользователя.
+// Украденной сессии для этого мало: нужен пароль.
+// DELETE /api/v1/me
+func DeleteAccountHandler(db *gorm.DB, grace time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		var req DeleteAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperr.Render(c, apperr.Binding(err))
+			return
+		}
+
+		user, err := ScheduleAccountDeletion(c.Request.Context(), db, userID, req, grace)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":               "Учетная запись будет удалена; до этого срока удаление можно отменить",
+			"deletion_scheduled_at": user.DeletionScheduledAt,
+		})
+	}
+}
+
+// CancelAccountDeletionHandler отменяет назначенное удаление.
+// DELETE /api/v1/me/deletion
+func CancelAccountDeletionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+
+		if _, err := CancelAccountDeletion(c.Request.Context(), db, userID); err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Удаление учетной записи отменено"})
+	}
+}
+
+// deletedUser возвращает заглушку «удаленный пользователь», создавая ее при первом удалении.
+// Если имя DeletedUsername занято обычным пользователем, материалы передавать
+// некому: удаление не выполняется, пока администратор не сменит ему имя.
+func deletedUser(tx *gorm.DB) (User, error) {
+	var placeholder User
+	err := tx.Where("placeholder = ?", true).First(&placeholder).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return placeholder, err
+	}
+	var taken int64
+	if err := tx.Model(&User{}).Scopes(byUsername(DeletedUsername)).Count(&taken).Error; err != nil {
+		return placeholder, err
+	}
+	if taken > 0 {
+		return placeholder, fmt.Errorf("username %q is taken by a regular user", DeletedUsername)
+	}
+	placeholder = User{
+		Username:     DeletedUsername,
+		Email:        deletedEmail,
+		PasswordHash: noPassword,
+		DisplayName:  "Удаленный пользователь",
+		Role:         RoleUser,
+		Placeholder:  true,
+	}
+	return placeholder, tx.Create(&placeholder).Error
+}
+
+// markDeletedUser помечает заглушку, созданную до появления User.Placeholder:
+// ее узнают по имени, email и отсутствию пароля вместе.
+func markDeletedUser(db *gorm.DB) error {
+	return db.Model(&User{}).
+		Where("username = ? AND email = ? AND password_hash = ? AND NOT placeholder", DeletedUsername, deletedEmail, noPassword).
+		Update("placeholder", true).Error
+}
+
+// DeleteAccount сразу удаляет учетную запись: ее топики, сообщения, вложения
+// и история имен передаются заглушке, сессии, запись пользователя и файл
+// аватара удаляются.
+func DeleteAccount(ctx context.Context, db *gorm.DB, store cache.Store, files storage.Store, userID uint) error {
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if user.Placeholder {
+		return apperr.New(apperr.Forbidden)
+	}
+
+	// 1. Передача материалов заглушке и удаление записи — атомарно
+	err = db.Transaction(func(tx *gorm.DB) error {
+		placeholder, err := deletedUser(tx)
+		if err != nil {
+			return err
+		}
+		for _, model := range []any{&Topic{}, &Post{}, &Attachment{}} {
+			if err := tx.Model(model).Where("author_id = ?", userID).Update("author_id", placeholder.ID).Error; err != nil {
+				return err
+			}
+		}
+		// История имен остается: упоминания прежних имен ведут к заглушке
+		if err := tx.Model(&UsernameChange{}).Where("user_id = ?", userID).Update("user_id", placeholder.ID).Error; err != nil {
+			return err
+		}
+		for _, model := range []any{&Session{}, &LoginChallenge{}, &RecoveryCode{}, &Identity{}, &OIDCFlow{}, &IdempotencyKey{}} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&User{}, userID).Error
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to delete account", "user_id", userID, "error", err)
+		return apperr.Wrap(err)
+	}
+
+	// 2. Файл аватара больше не нужен; ошибка хранилища удаление не отменяет
+	if user.AvatarKey != "" {
+		if err := files.Delete(ctx, user.AvatarKey); err != nil {
+			logging.FromContext(ctx).Warn("failed to delete avatar file", "key", user.AvatarKey, "error", err)
+		}
+	}
+	invalidateAuthorListings(ctx, store)
+	logging.FromContext(ctx).Info("account deleted", "user_id", userID)
+	return nil
+}
+
+// PurgeDeletedAccounts удаляет учетные записи, срок удаления которых наступил.
+// Вызывается периодически фоновой задачей сервера.
+func PurgeDeletedAccounts(ctx context.Context, db *gorm.DB, store cache.Store, files storage.Store) {
+	var ids []uint
+	if err := db.WithContext(ctx).Model(&User{}).Where("deletion_scheduled_at <= ?", time.Now()).Pluck("id", &ids).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to find accounts to delete", "error", err)
+		return
+	}
+	for _, id := range ids {
+		DeleteAccount(ctx, db.WithContext(ctx), store, files, id) // Ошибка уже в журнале; повторим при следующем запуске
+	}
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	apperr "REVFORUM/apperr"
+	cache "REVFORUM/cache"
+	logging "REVFORUM/logging"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Операции администрирования пользователей и сводная статистика.
+// Вызываются консольными командами (пакет admin); проверки те же,
+// что и в обработчиках HTTP, поэтому они живут здесь, рядом с моделями.
+
+// roles — допустимые роли в порядке возрастания прав.
+var roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// checkRole проверяет, что role — одна из известных ролей.
+func checkRole(role string) error {
+	for _, r := range roles {
+		if r == role {
+			return nil
+		}
+	}
+	return apperr.New(apperr.InvalidRole).Param("allowed", strings.Join(roles, ", "))
+}
+
+// findUser загружает пользователя; отсутствие — USER_NOT_FOUND.
+func findUser(ctx context.Context, db *gorm.DB, id uint) (User, error) {
+	var user User
+	if err := db.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, apperr.New(apperr.UserNotFound)
+		}
+		logging.FromContext(ctx).Error("failed to load user", "user_id", id, "error", err)
+		return user, apperr.Wrap(err)
+	}
+	return user, nil
+}
+
+// LookupUser ищет пользователя по ID или, если ref не число, по имени
+// (без учета регистра, в том числе по прежнему — см. findUserByName).
+func LookupUser(ctx context.Context, db *gorm.DB, ref string) (User, error) {
+	if id, err := strconv.ParseUint(ref, 10, 32); err == nil {
+		return findUser(ctx, db, uint(id))
+	}
+	return findUserByName(ctx, db, ref)
+}
+
+// SetUserRole меняет роль пользователя. Роль встраивается в сведения об авторе,
+// поэтому сбрасывается кэш списков топиков и постов.
+func SetUserRole(ctx context.Context, db *gorm.DB, store cache.Store, userID uint, role string) (User, error) {
+	if err := checkRole(role); err != nil {
+		return User{}, err
+	}
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return user, err
+	}
+	if err := db.Model(&user).Update("role", role).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to change role", "user_id", userID, "error", err)
+		return user, apperr.Wrap(err)
+	}
+	invalidateAuthorListings(ctx, store)
+	return user, nil
+}
+
+// BanUser блокирует пользователя: войти он больше не сможет, открытые сессии завершаются.
+// Администратора заблокировать нельзя — сначала нужно сменить ему роль.
+func BanUser(ctx context.Context, db *gorm.DB, userID uint, reason string) (User, error) {
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return user, err
+	}
+	if user.Role == RoleAdmin {
+		return user, apperr.New(apperr.CannotBanAdmin)
+	}
+	now := time.Now()
+	if err := db.Model(&user).Updates(map[string]any{"banned_at": now, "ban_reason": reason}).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to ban user", "user_id", userID, "error", err)
+		return user, apperr.Wrap(err)
+	}
+	user.BannedAt, user.BanReason = &now, reason
+	// Незавершенные входы со вторым фактором тоже сгорают
+	if err := db.Where("user_id = ?", userID).Delete(&LoginChallenge{}).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to delete login challenges", "user_id", userID, "error", err)
+		return user, apperr.Wrap(err)
+	}
+	_, err = RevokeSessions(ctx, db, userID, 0)
+	return user, err
+}
+
+// UnbanUser снимает блокировку.
+func UnbanUser(ctx context.Context, db *gorm.DB, userID uint) (User, error) {
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return user, err
+	}
+	if err := db.Model(&user).Updates(map[string]any{"banned_at": nil, "ban_reason": ""}).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to unban user", "user_id", userID, "error", err)
+		return user, apperr.Wrap(err)
+	}
+	user.BannedAt, user.BanReason = nil, ""
+	return user, nil
+}
+
+// ForumStats — сводные показатели форума.
+type ForumStats struct {
+	Users           int64            `json:"users"`
+	UsersByRole     map[string]int64 `json:"users_by_role"`
+	BannedUsers     int64            `json:"banned_users"`
+	Themes          int64            `json:"themes"`
+	ArchivedThemes  int64            `json:"archived_themes"`
+	SubThemes       int64            `json:"sub_themes"`
+	Topics          int64            `json:"topics"`
+	LockedTopics    int64            `json:"locked_topics"`
+	Posts           int64            `json:"posts"`
+	PostsLastDay    int64            `json:"posts_last_day"` // За последние 24 часа
+	Attachments     int64            `json:"attachments"`
+	AttachmentBytes int64            `json:"attachment_bytes"`
+}
+
+// Stats считает сводные показатели форума.
+func Stats(ctx context.Context, db *gorm.DB) (ForumStats, error) {
+	stats := ForumStats{UsersByRole: map[string]int64{}}
+	counts := []struct {
+		dst   *int64
+		model any
+		where string
+		args  []any
+	}{
+		{&stats.Users, &User{}, "", nil},
+		{&stats.BannedUsers, &User{}, "banned_at IS NOT NULL", nil},
+		{&stats.Themes, &Themes_Collection{}, "", nil},
+		{&stats.ArchivedThemes, &Themes_Collection{}, "status = ?", []any{ThemeArchived}},
+		{&stats.SubThemes, &Sub_Themes{}, "", nil},
+		{&stats.Topics, &Topic{}, "", nil},
+		{&stats.LockedTopics, &Topic{}, "locked", nil},
+		{&stats.Posts, &Post{}, "", nil},
+		{&stats.PostsLastDay, &Post{}, "created_at > ?", []any{time.Now().Add(-24 * time.Hour)}},
+		{&stats.Attachments, &Attachment{}, "", nil},
+	}
+	for _, q := range counts {
+		tx := db.Model(q.model)
+		if q.where != "" {
+			tx = tx.Where(q.where, q.args...)
+		}
+		if err := tx.Count(q.dst).Error; err != nil {
+			logging.FromContext(ctx).Error("failed to count stats", "error", err)
+			return stats, apperr.Wrap(err)
+		}
+	}
+
+	var byRole []struct {
+		Role  string
+		Count int64
+	}
+	if err := db.Model(&User{}).Select("role, COUNT(*) AS count").Group("role").Scan(&byRole).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to count users by role", "error", err)
+		return stats, apperr.Wrap(err)
+	}
+	for _, r := range byRole {
+		stats.UsersByRole[r.Role] = r.Count
+	}
+	if err := db.Model(&Attachment{}).Select("COALESCE(SUM(size), 0)").Scan(&stats.AttachmentBytes).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to sum attachment sizes", "error", err)
+		return stats, apperr.Wrap(err)
+	}
+	return stats, nil
+}
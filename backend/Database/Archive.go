@@ -0,0 +1,762 @@
+package database
+
+// Архив форума — JSON Lines: по одному JSON-объекту на строку.
+//
+//	{"type":"header","format":"revforum-archive","version":1,"created_at":"…","password_hashes":false}
+//	{"type":"user","data":{…}}
+//	{"type":"theme","data":{…}}
+//	{"type":"subtheme","data":{…}}
+//	{"type":"topic","data":{…}}
+//	{"type":"post","data":{…}}
+//	{"type":"footer","counts":{"users":2,"themes":1,…}}
+//
+// Записи идут по видам в указанном порядке, поэтому ссылки (автор, родитель)
+// всегда указывают на уже прочитанные строки: архив пишется и читается потоком.
+// Последняя строка — итог: по нему импорт отличает полный архив от оборванного.
+// ID в архиве — исходные; при импорте записи получают новые ID, а ссылки
+// переводятся по таблице соответствия. Даты сохраняются.
+//
+// Вложения и аватары в архив не входят: их файлы лежат в хранилище.
+
+import (
+	apperr "REVFORUM/apperr"
+	cache "REVFORUM/cache"
+	logging "REVFORUM/logging"
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	ArchiveFormat  = "revforum-archive"
+	ArchiveVersion = 2 // Текущая версия; импорт читает все версии до нее включительно
+)
+
+// Политики конфликтов импорта: что делать, если пользователь (по имени или
+// email) или тема (по названию) уже есть в базе.
+const (
+	ConflictFail      = "fail"      // Прервать импорт (по умолчанию)
+	ConflictSkip      = "skip"      // Оставить запись в базе, ссылки архива направить на нее
+	ConflictOverwrite = "overwrite" // Перезаписать запись в базе данными архива
+)
+
+// noPassword — хэш пользователя, импортированного без пароля: с ним нельзя
+// войти, пока администратор не задаст пароль (admin user reset-password).
+const noPassword = "!"
+
+// Виды записей в порядке следования в архиве.
+const (
+	archiveUser     = "user"
+	archiveTheme    = "theme"
+	archiveSubTheme = "subtheme"
+	archiveTopic    = "topic"
+	archivePost     = "post"
+)
+
+var archiveKinds = []string{archiveUser, archiveTheme, archiveSubTheme, archiveTopic, archivePost}
+
+// ArchiveCounts — число записей каждого вида.
+type ArchiveCounts struct {
+	Users     int `json:"users"`
+	Themes    int `json:"themes"`
+	SubThemes int `json:"subthemes"`
+	Topics    int `json:"topics"`
+	Posts     int `json:"posts"`
+}
+
+func (c *ArchiveCounts) of(kind string) *int {
+	switch kind {
+	case archiveUser:
+		return &c.Users
+	case archiveTheme:
+		return &c.Themes
+	case archiveSubTheme:
+		return &c.SubThemes
+	case archiveTopic:
+		return &c.Topics
+	default:
+		return &c.Posts
+	}
+}
+
+// archiveLine — строка архива. Заполнены поля, нужные ее типу.
+type archiveLine struct {
+	Type           string          `json:"type"`
+	Format         string          `json:"format,omitempty"`
+	Version        int             `json:"version,omitempty"`
+	CreatedAt      *time.Time      `json:"created_at,omitempty"`
+	PasswordHashes bool            `json:"password_hashes,omitempty"`
+	Data           json.RawMessage `json:"data,omitempty"`
+	Counts         *ArchiveCounts  `json:"counts,omitempty"`
+}
+
+// Записи архива. Поля перечислены явно, чтобы формат не менялся вместе с JSON API.
+
+type archivedUser struct {
+	ID           uint       `json:"id"`
+	Username     string     `json:"username"`
+	Email        string     `json:"email"`
+	PasswordHash string     `json:"password_hash,omitempty"` // Только при экспорте с паролями
+	CreatedAt    time.Time  `json:"created_at"`
+	DisplayName  string     `json:"display_name,omitempty"`
+	Bio          string     `json:"bio,omitempty"`
+	Signature    string     `json:"signature,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	Website      string     `json:"website,omitempty"`
+	Reputation   int        `json:"reputation"`
+	Role         string     `json:"role"`
+	BannedAt     *time.Time `json:"banned_at,omitempty"`
+	BanReason    string     `json:"ban_reason,omitempty"`
+}
+
+type archivedTheme struct {
+	ID        uint      `json:"id"`
+	Title     string    `json:"title"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type archivedSubTheme struct {
+	ID        uint      `json:"id"`
+	ParentID  uint      `json:"parent_id"`
+	Title     string    `json:"title"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type archivedTopic struct {
+	ID          uint      `json:"id"`
+	SubThemeID  uint      `json:"sub_theme_id"`
+	AuthorID    uint      `json:"author_id"`
+	Title       string    `json:"title"`
+	Content     string    `json:"content,omitempty"`       // Версия 1: текст топика, при импорте становится первым сообщением
+	FirstPostID uint      `json:"first_post_id,omitempty"` // С версии 2: первое сообщение топика
+	Locked      bool      `json:"locked"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type archivedPost struct {
+	ID        uint      `json:"id"`
+	TopicID   uint      `json:"topic_id"`
+	AuthorID  uint      `json:"author_id"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ExportOptions — параметры экспорта.
+type ExportOptions struct {
+	PasswordHashes bool // Включить хэши паролей (иначе после импорта пароли придется задать заново)
+}
+
+// ExportArchive пишет весь форум в w. Чтение идет в одной транзакции
+// REPEATABLE READ, поэтому архив согласован, даже если форум в это время
+// меняется. Таблицы читаются порциями и сразу пишутся в w.
+func ExportArchive(ctx context.Context, db *gorm.DB, w io.Writer, opts ExportOptions) (ArchiveCounts, error) {
+	var counts ArchiveCounts
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	write := func(kind string, v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		*counts.of(kind)++
+		return enc.Encode(archiveLine{Type: kind, Data: data})
+	}
+
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 1. Заголовок
+		now := time.Now().UTC()
+		header := archiveLine{Type: "header", Format: ArchiveFormat, Version: ArchiveVersion, CreatedAt: &now, PasswordHashes: opts.PasswordHashes}
+		if err := enc.Encode(header); err != nil {
+			return err
+		}
+
+		// 2. Записи по видам, в порядке ID
+		err := exportTable(tx, func(u User) error {
+			rec := archivedUser{
+				ID: u.ID, Username: u.Username, Email: u.Email, CreatedAt: u.CreatedAt,
+				DisplayName: u.DisplayName, Bio: u.Bio, Signature: u.Signature, Location: u.Location, Website: u.Website,
+				Reputation: u.Reputation, Role: u.Role, BannedAt: u.BannedAt, BanReason: u.BanReason,
+			}
+			if opts.PasswordHashes {
+				rec.PasswordHash = u.PasswordHash
+			}
+			return write(archiveUser, rec)
+		})
+		if err == nil {
+			err = exportTable(tx, func(t Themes_Collection) error {
+				return write(archiveTheme, archivedTheme{ID: t.ID, Title: t.Title, Status: t.Status, CreatedAt: t.CreatedAt})
+			})
+		}
+		if err == nil {
+			err = exportTable(tx, func(s Sub_Themes) error {
+				return write(archiveSubTheme, archivedSubTheme{ID: s.ID, ParentID: s.ParentID, Title: s.Title, Status: s.Status, CreatedAt: s.CreatedAt})
+			})
+		}
+		if err == nil {
+			err = exportTable(tx, func(t Topic) error {
+				rec := archivedTopic{
+					ID: t.ID, SubThemeID: t.SubThemeID, AuthorID: t.AuthorID, Title: t.Title,
+					Locked: t.Locked, CreatedAt: t.CreatedAt, UpdatedAt: t.UpdatedAt,
+				}
+				if t.FirstPostID != nil {
+					rec.FirstPostID = *t.FirstPostID
+				}
+				return write(archiveTopic, rec)
+			})
+		}
+		if err == nil {
+			err = exportTable(tx, func(p Post) error {
+				return write(archivePost, archivedPost{
+					ID: p.ID, TopicID: p.TopicID, AuthorID: p.AuthorID, Content: p.Content, CreatedAt: p.CreatedAt, UpdatedAt: p.UpdatedAt,
+				})
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		// 3. Итог
+		if err := enc.Encode(archiveLine{Type: "footer", Counts: &counts}); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to export archive", "error", err)
+		return counts, apperr.Wrap(err)
+	}
+	return counts, nil
+}
+
+// exportBatch — сколько строк таблицы читается за один запрос при экспорте.
+const exportBatch = 500
+
+// exportTable читает таблицу модели M порциями по ID и передает строки в fn.
+func exportTable[M any](tx *gorm.DB, fn func(M) error) error {
+	var batch []M
+	return tx.FindInBatches(&batch, exportBatch, func(_ *gorm.DB, _ int) error {
+		for _, row := range batch {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// ImportOptions — параметры импорта.
+type ImportOptions struct {
+	DryRun     bool   // Проверить архив и посчитать изменения, ничего не сохраняя
+	OnConflict string // ConflictFail (по умолчанию), ConflictSkip или ConflictOverwrite
+}
+
+// ImportReport — итог импорта. При DryRun — то, что было бы сделано.
+type ImportReport struct {
+	DryRun      bool          `json:"dry_run"`
+	Created     ArchiveCounts `json:"created"`
+	Skipped     ArchiveCounts `json:"skipped"`
+	Overwritten ArchiveCounts `json:"overwritten"`
+}
+
+// errDryRun откатывает транзакцию пробного импорта.
+var errDryRun = errors.New("dry run")
+
+// ImportArchive загружает архив из r в одной транзакции: при любой ошибке
+// база не меняется. Архив читается потоком, целиком в памяти держится только
+// таблица соответствия ID.
+func ImportArchive(ctx context.Context, db *gorm.DB, store cache.Store, r io.Reader, opts ImportOptions) (ImportReport, error) {
+	// 1. Проверка параметров
+	switch opts.OnConflict {
+	case "":
+		opts.OnConflict = ConflictFail
+	case ConflictFail, ConflictSkip, ConflictOverwrite:
+	default:
+		return ImportReport{}, apperr.New(apperr.InvalidParameter).Param("name", "on_conflict")
+	}
+
+	// 2. Импорт в транзакции; пробный прогон откатывается в конце
+	imp := &importer{opts: opts, report: ImportReport{DryRun: opts.DryRun}, ids: map[string]map[uint]uint{}}
+	for _, kind := range archiveKinds {
+		imp.ids[kind] = map[uint]uint{}
+	}
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		imp.tx = tx
+		if err := imp.run(r); err != nil {
+			return err
+		}
+		if opts.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		var e *apperr.Error
+		if errors.As(err, &e) {
+			return ImportReport{}, e
+		}
+		logging.FromContext(ctx).Error("failed to import archive", "line", imp.line, "error", err)
+		return ImportReport{}, apperr.Wrap(err)
+	}
+
+	// 3. Сброс кэша списков
+	if !opts.DryRun {
+		cache.InvalidateContext(ctx, store,
+			cache.Group(CacheThemes), cache.Group(CacheSubThemes), cache.Group(CacheTopics), cache.Group(CachePosts))
+	}
+	return imp.report, nil
+}
+
+// importBatch — сколько подтем, топиков или сообщений вставляется одним запросом.
+const importBatch = 500
+
+// importer — состояние одного импорта.
+type importer struct {
+	tx     *gorm.DB
+	opts   ImportOptions
+	report ImportReport
+	ids    map[string]map[uint]uint // Вид → ID в архиве → ID в базе
+	read   ArchiveCounts            // Прочитано записей (сверяется с итогом)
+	line   int
+	kind   int // Индекс последнего прочитанного вида в archiveKinds
+
+	// Записи без естественного ключа вставляются пачками; pendingIDs — их ID
+	// в архиве в том же порядке. Ссылки на них идут только из следующих видов,
+	// а при смене вида пачка сбрасывается, поэтому до вставки их никто не ищет.
+	pendingKind string
+	pending     any
+	pendingIDs  []uint
+
+	// Топики с первым сообщением: ссылка на него или, в версии 1, текст.
+	// Сообщения идут после топиков, поэтому ссылки переводятся в конце (см. linkFirstPosts).
+	firstPosts []archivedTopic
+}
+
+// invalid — ошибка формата в текущей строке.
+func (imp *importer) invalid(reason string) error {
+	return apperr.New(apperr.InvalidArchive).Param("line", strconv.Itoa(imp.line)).Param("reason", reason)
+}
+
+func (imp *importer) run(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16<<20) // Сообщения бывают длинными
+	var header, footer bool
+	for sc.Scan() {
+		imp.line++
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var l archiveLine
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			return imp.invalid(err.Error())
+		}
+		switch {
+		case footer:
+			return imp.invalid("данные после итоговой строки")
+		case !header:
+			if l.Type != "header" || l.Format != ArchiveFormat {
+				return imp.invalid("первая строка должна быть заголовком " + ArchiveFormat)
+			}
+			if l.Version < 1 || l.Version > ArchiveVersion {
+				return apperr.New(apperr.UnsupportedArchiveVersion).
+					Param("version", strconv.Itoa(l.Version)).Param("max", strconv.Itoa(ArchiveVersion))
+			}
+			header = true
+		case l.Type == "footer":
+			if err := imp.flush(); err != nil {
+				return err
+			}
+			if l.Counts == nil || *l.Counts != imp.read {
+				return imp.invalid("число записей не совпадает с итогом")
+			}
+			if err := imp.linkFirstPosts(); err != nil {
+				return err
+			}
+			footer = true
+		default:
+			if err := imp.record(l); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		imp.line++
+		return imp.invalid(err.Error())
+	}
+	if !footer {
+		return imp.invalid("архив оборван: нет итоговой строки")
+	}
+	return nil
+}
+
+// record разбирает и сохраняет одну запись.
+func (imp *importer) record(l archiveLine) error {
+	// 1. Виды идут строго по порядку
+	kind := -1
+	for i, k := range archiveKinds {
+		if k == l.Type {
+			kind = i
+		}
+	}
+	if kind < 0 {
+		return imp.invalid("неизвестный тип записи " + strconv.Quote(l.Type))
+	}
+	if kind < imp.kind {
+		return imp.invalid("запись " + l.Type + " после " + archiveKinds[imp.kind])
+	}
+	if kind != imp.kind {
+		if err := imp.flush(); err != nil {
+			return err
+		}
+		imp.kind = kind
+	}
+	*imp.read.of(l.Type)++
+
+	// 2. Разбор и сохранение
+	switch l.Type {
+	case archiveUser:
+		var rec archivedUser
+		if err := imp.decode(l.Data, &rec); err != nil {
+			return err
+		}
+		return imp.user(rec)
+	case archiveTheme:
+		var rec archivedTheme
+		if err := imp.decode(l.Data, &rec); err != nil {
+			return err
+		}
+		return imp.theme(rec)
+	case archiveSubTheme:
+		var rec archivedSubTheme
+		if err := imp.decode(l.Data, &rec); err != nil {
+			return err
+		}
+		parentID, err := imp.ref(archiveTheme, rec.ParentID)
+		if err != nil {
+			return err
+		}
+		return imp.add(rec.ID, Sub_Themes{Title: rec.Title, Status: rec.Status, ParentID: parentID, CreatedAt: rec.CreatedAt})
+	case archiveTopic:
+		var rec archivedTopic
+		if err := imp.decode(l.Data, &rec); err != nil {
+			return err
+		}
+		subThemeID, err := imp.ref(archiveSubTheme, rec.SubThemeID)
+		if err != nil {
+			return err
+		}
+		authorID, err := imp.ref(archiveUser, rec.AuthorID)
+		if err != nil {
+			return err
+		}
+		if rec.Content != "" || rec.FirstPostID != 0 {
+			imp.firstPosts = append(imp.firstPosts, rec)
+		}
+		return imp.add(rec.ID, Topic{
+			Title: rec.Title, Locked: rec.Locked, SubThemeID: subThemeID, AuthorID: authorID,
+			CreatedAt: rec.CreatedAt, UpdatedAt: rec.UpdatedAt,
+		})
+	default:
+		var rec archivedPost
+		if err := imp.decode(l.Data, &rec); err != nil {
+			return err
+		}
+		topicID, err := imp.ref(archiveTopic, rec.TopicID)
+		if err != nil {
+			return err
+		}
+		authorID, err := imp.ref(archiveUser, rec.AuthorID)
+		if err != nil {
+			return err
+		}
+		return imp.add(rec.ID, Post{Content: rec.Content, TopicID: topicID, AuthorID: authorID, CreatedAt: rec.CreatedAt, UpdatedAt: rec.UpdatedAt})
+	}
+}
+
+// decode разбирает data в rec и проверяет, что ID задан и не повторяется.
+func (imp *importer) decode(data json.RawMessage, rec any) error {
+	if len(data) == 0 {
+		return imp.invalid("нет поля data")
+	}
+	var key struct {
+		ID uint `json:"id"`
+	}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return imp.invalid(err.Error())
+	}
+	_ = json.Unmarshal(data, &key) // Уже разобрано выше, ошибки быть не может
+	kind := archiveKinds[imp.kind]
+	if key.ID == 0 {
+		return imp.invalid("нет id")
+	}
+	if _, dup := imp.ids[kind][key.ID]; dup {
+		return imp.invalid(fmt.Sprintf("повторный %s id %d", kind, key.ID))
+	}
+	return nil
+}
+
+// ref переводит ID из архива в ID в базе; ссылка должна указывать на уже прочитанную запись.
+func (imp *importer) ref(kind string, id uint) (uint, error) {
+	newID, ok := imp.ids[kind][id]
+	if !ok {
+		return 0, imp.invalid(fmt.Sprintf("ссылка на отсутствующий %s id %d", kind, id))
+	}
+	return newID, nil
+}
+
+// user сохраняет пользователя с учетом политики конфликтов (по имени и email).
+func (imp *importer) user(rec archivedUser) error {
+	if rec.Username == "" || rec.Email == "" {
+		return imp.invalid("у пользователя нет имени или email")
+	}
+	if rec.Role != RoleUser && rec.Role != RoleModerator && rec.Role != RoleAdmin {
+		return imp.invalid("неизвестная роль " + strconv.Quote(rec.Role))
+	}
+	user := User{
+		Username: rec.Username, Email: rec.Email, PasswordHash: rec.PasswordHash, CreatedAt: rec.CreatedAt,
+		DisplayName: rec.DisplayName, Bio: rec.Bio, Signature: rec.Signature, Location: rec.Location, Website: rec.Website,
+		Reputation: rec.Reputation, Role: rec.Role, BannedAt: rec.BannedAt, BanReason: rec.BanReason,
+	}
+	var existing []User
+	if err := imp.tx.Where("username = ? OR username_key = ? OR email = ?",
+		normalizeUsername(rec.Username), CanonicalUsername(rec.Username), canonicalEmail(rec.Email)).Limit(2).Find(&existing).Error; err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		if user.PasswordHash == "" {
+			user.PasswordHash = noPassword
+		}
+		// Заглушка удаленных пользователей из архива остается заглушкой
+		user.Placeholder = user.Username == DeletedUsername && user.Email == deletedEmail && user.PasswordHash == noPassword
+		if err := imp.tx.Create(&user).Error; err != nil {
+			return err
+		}
+		imp.ids[archiveUser][rec.ID] = user.ID
+		imp.report.Created.Users++
+		return nil
+	}
+	// Имя совпало с одним пользователем, а email — с другим: слить их нельзя
+	if len(existing) > 1 || imp.opts.OnConflict == ConflictFail {
+		return apperr.New(apperr.ArchiveConflict).Param("kind", archiveUser).Param("key", rec.Username)
+	}
+	imp.ids[archiveUser][rec.ID] = existing[0].ID
+	if imp.opts.OnConflict == ConflictSkip {
+		imp.report.Skipped.Users++
+		return nil
+	}
+	omit := []string{"ID", "AvatarKey", "TOTPSecret", "TOTPEnabledAt", "TOTPLastStep", "Placeholder"} // Второй фактор архив не переносит
+	if user.PasswordHash == "" {
+		omit = append(omit, "PasswordHash") // Пароль в базе лучше, чем никакого
+	}
+	if err := imp.tx.Model(&existing[0]).Select("*").Omit(omit...).Updates(&user).Error; err != nil {
+		return err
+	}
+	imp.report.Overwritten.Users++
+	return nil
+}
+
+// theme сохраняет тему с учетом политики конфликтов (по названию).
+func (imp *importer) theme(rec archivedTheme) error {
+	theme := Themes_Collection{Title: rec.Title, Status: rec.Status, CreatedAt: rec.CreatedAt}
+	var existing []Themes_Collection
+	if err := imp.tx.Where("title = ?", rec.Title).Limit(1).Find(&existing).Error; err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		if err := imp.tx.Create(&theme).Error; err != nil {
+			return err
+		}
+		imp.ids[archiveTheme][rec.ID] = theme.ID
+		imp.report.Created.Themes++
+		return nil
+	}
+	if imp.opts.OnConflict == ConflictFail {
+		return apperr.New(apperr.ArchiveConflict).Param("kind", archiveTheme).Param("key", rec.Title)
+	}
+	imp.ids[archiveTheme][rec.ID] = existing[0].ID
+	if imp.opts.OnConflict == ConflictSkip {
+		imp.report.Skipped.Themes++
+		return nil
+	}
+	if err := imp.tx.Model(&existing[0]).Updates(map[string]any{"status": rec.Status, "created_at": rec.CreatedAt}).Error; err != nil {
+		return err
+	}
+	imp.report.Overwritten.Themes++
+	return nil
+}
+
+// add откладывает запись без естественного ключа до пачки.
+func (imp *importer) add(oldID uint, row any) error {
+	kind := archiveKinds[imp.kind]
+	switch v := row.(type) {
+	case Sub_Themes:
+		rows, _ := imp.pending.([]Sub_Themes)
+		imp.pending = append(rows, v)
+	case Topic:
+		rows, _ := imp.pending.([]Topic)
+		imp.pending = append(rows, v)
+	case Post:
+		rows, _ := imp.pending.([]Post)
+		imp.pending = append(rows, v)
+	}
+	imp.pendingKind = kind
+	imp.pendingIDs = append(imp.pendingIDs, oldID)
+	imp.ids[kind][oldID] = 0 // Новый ID станет известен после вставки; пока — для проверки повторов
+	if len(imp.pendingIDs) >= importBatch {
+		return imp.flush()
+	}
+	return nil
+}
+
+// flush вставляет отложенные записи и запоминает их новые ID.
+func (imp *importer) flush() error {
+	if len(imp.pendingIDs) == 0 {
+		return nil
+	}
+	var newIDs []uint
+	switch rows := imp.pending.(type) {
+	case []Sub_Themes:
+		if err := imp.tx.Create(&rows).Error; err != nil {
+			return err
+		}
+		for _, r := range rows {
+			newIDs = append(newIDs, r.ID)
+		}
+	case []Topic:
+		if err := imp.tx.Create(&rows).Error; err != nil {
+			return err
+		}
+		for _, r := range rows {
+			newIDs = append(newIDs, r.ID)
+		}
+	case []Post:
+		if err := imp.tx.Omit("Attachments").Create(&rows).Error; err != nil {
+			return err
+		}
+		for _, r := range rows {
+			newIDs = append(newIDs, r.ID)
+		}
+	}
+	for i, oldID := range imp.pendingIDs {
+		imp.ids[imp.pendingKind][oldID] = newIDs[i]
+	}
+	*imp.report.Created.of(imp.pendingKind) += len(newIDs)
+	imp.pending, imp.pendingIDs = nil, imp.pendingIDs[:0]
+	return nil
+}
+
+// linkFirstPosts связывает импортированные топики с первыми сообщениями.
+// Текст топика из архива версии 1 становится новым сообщением с автором и датой топика.
+func (imp *importer) linkFirstPosts() error {
+	for _, rec := range imp.firstPosts {
+		topicID := imp.ids[archiveTopic][rec.ID]
+		var postID uint
+		if rec.FirstPostID != 0 {
+			var ok bool
+			if postID, ok = imp.ids[archivePost][rec.FirstPostID]; !ok {
+				return imp.invalid(fmt.Sprintf("first_post_id топика %d ссылается на отсутствующий post id %d", rec.ID, rec.FirstPostID))
+			}
+		} else {
+			authorID := imp.ids[archiveUser][rec.AuthorID]
+			post := Post{Content: rec.Content, TopicID: topicID, AuthorID: authorID, CreatedAt: rec.CreatedAt, UpdatedAt: rec.CreatedAt}
+			if err := imp.tx.Omit("Attachments").Create(&post).Error; err != nil {
+				return err
+			}
+			postID = post.ID
+			imp.report.Created.Posts++
+		}
+		result := imp.tx.Model(&Topic{}).
+			Where("id = ? AND EXISTS (SELECT 1 FROM posts WHERE posts.id = ? AND posts.topic_id = topics.id)", topicID, postID).
+			UpdateColumn("first_post_id", postID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return imp.invalid(fmt.Sprintf("first_post_id топика %d указывает на сообщение другого топика", rec.ID))
+		}
+	}
+	return nil
+}
+
+// queryBool разбирает необязательный логический параметр запроса.
+func queryBool(c *gin.Context, name string) (bool, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, apperr.New(apperr.InvalidParameter).Param("name", name)
+	}
+	return v, nil
+}
+
+// ExportArchiveHandler отдает архив всего форума. Только для администраторов.
+// GET /api/v1/admin/export?password_hashes=true
+func ExportArchiveHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		// 1. Проверка прав и параметров
+		if !requireAdmin(c, db) {
+			return
+		}
+		hashes, err := queryBool(c, "password_hashes")
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+
+		// 2. Архив пишется прямо в ответ; после начала передачи статус уже не сменить
+		name := fmt.Sprintf("revforum-%s.jsonl", time.Now().UTC().Format("20060102-150405"))
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+		c.Status(http.StatusOK)
+		if _, err := ExportArchive(c.Request.Context(), db, c.Writer, ExportOptions{PasswordHashes: hashes}); err != nil && !c.Writer.Written() {
+			apperr.Render(c, err)
+		}
+	}
+}
+
+// ImportArchiveHandler загружает архив из тела запроса. Только для администраторов.
+// POST /api/v1/admin/import?dry_run=true&on_conflict=skip
+func ImportArchiveHandler(db *gorm.DB, store cache.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		// 1. Проверка прав и параметров
+		if !requireAdmin(c, db) {
+			return
+		}
+		dryRun, err := queryBool(c, "dry_run")
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+
+		// 2. Импорт
+		report, err := ImportArchive(c.Request.Context(), db, store, c.Request.Body, ImportOptions{
+			DryRun:     dryRun,
+			OnConflict: c.Query("on_conflict"),
+		})
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,371 @@
+package database
+
+import (
+	apperr "REVFORUM/apperr"
+	logging "REVFORUM/logging"
+	storage "REVFORUM/storage"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Attachment — файл, загруженный пользователем и прикрепленный к посту.
+// Сначала файл загружается отдельно (PostID == nil), затем привязывается
+// к посту через attachment_ids в CreatePostRequest.
+type Attachment struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	PostID       *uint     `gorm:"index" json:"post_id"`             // nil, пока вложение не привязано к посту
+	AuthorID     uint      `gorm:"not null;index" json:"author_id"`  // Кто загрузил файл
+	Filename     string    `gorm:"not null" json:"filename"`         // Исходное имя файла (для Content-Disposition)
+	ContentType  string    `gorm:"not null" json:"content_type"`     // Определяется по содержимому, а не по заголовку клиента
+	Size         int64     `gorm:"not null" json:"size"`             // Размер в байтах
+	SHA256       string    `gorm:"not null;size:64" json:"-"`        // Используется как ETag
+	StorageKey   string    `gorm:"not null" json:"-"`                // Ключ оригинала в хранилище
+	ThumbnailKey string    `json:"-"`                                // Ключ миниатюры (только для изображений)
+	Width        int       `json:"width,omitempty"`                  // Размеры изображения
+	Height       int       `json:"height,omitempty"`                 //
+	URL          string    `gorm:"-" json:"url"`                     // Заполняется после чтения из БД
+	ThumbnailURL string    `gorm:"-" json:"thumbnail_url,omitempty"` //
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AfterFind заполняет URL для отдачи клиенту.
+func (a *Attachment) AfterFind(tx *gorm.DB) error {
+	a.fillURLs()
+	return nil
+}
+
+func (a *Attachment) fillURLs() {
+	id := strconv.FormatUint(uint64(a.ID), 10)
+	a.URL = "/api/v1/attachments/" + id
+	a.ThumbnailURL = ""
+	if a.ThumbnailKey != "" {
+		a.ThumbnailURL = "/api/v1/attachments/" + id + "/thumbnail"
+	}
+}
+
+// AttachmentLimits — ограничения на загружаемые файлы.
+type AttachmentLimits struct {
+	MaxSize       int64           // Максимальный размер файла в байтах
+	AllowedTypes  map[string]bool // Разрешенные MIME-типы (по содержимому файла)
+	ThumbnailSide int             // Максимальная сторона миниатюры в пикселях
+}
+
+// errAttachmentsUnavailable — часть attachment_ids не найдена, чужая или уже привязана.
+var errAttachmentsUnavailable = errors.New("attachments unavailable")
+
+// AttachmentLimitsFromEnv читает ограничения из ATTACHMENT_MAX_SIZE (байты)
+// и ATTACHMENT_ALLOWED_TYPES (через запятую); по умолчанию 10 МБ, изображения, PDF и текст.
+func AttachmentLimitsFromEnv() AttachmentLimits {
+	limits := AttachmentLimits{
+		MaxSize:       10 << 20,
+		AllowedTypes:  map[string]bool{},
+		ThumbnailSide: 320,
+	}
+	if v := os.Getenv("ATTACHMENT_MAX_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			log.Fatal("Invalid ATTACHMENT_MAX_SIZE: ", v)
+		}
+		limits.MaxSize = n
+	}
+	types := os.Getenv("ATTACHMENT_ALLOWED_TYPES")
+	if types == "" {
+		types = "image/jpeg,image/png,image/gif,image/webp,application/pdf,text/plain"
+	}
+	for _, t := range strings.Split(types, ",") {
+		limits.AllowedTypes[strings.TrimSpace(t)] = true
+	}
+	return limits
+}
+
+// MaxRequestBody — предел тела запроса загрузки: файл и запас на заголовки multipart.
+func (l AttachmentLimits) MaxRequestBody() int64 {
+	return l.MaxSize + 1<<20
+}
+
+// allowedList — разрешенные типы через запятую для сообщения об ошибке.
+func (l AttachmentLimits) allowedList() string {
+	types := make([]string, 0, len(l.AllowedTypes))
+	for t := range l.AllowedTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return strings.Join(types, ", ")
+}
+
+// UploadAttachmentHandler принимает файл (multipart, поле "file") и сохраняет его в хранилище.
+// POST /api/v1/attachments
+func UploadAttachmentHandler(db *gorm.DB, files storage.Store, limits AttachmentLimits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+
+		// 1. Ограничение размера тела запроса (с запасом на заголовки multipart)
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limits.MaxRequestBody())
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				apperr.Render(c, apperr.New(apperr.FileTooLarge).Param("max_size", strconv.FormatInt(limits.MaxSize, 10)))
+				return
+			}
+			apperr.Render(c, apperr.New(apperr.FileRequired).Param("field", "file"))
+			return
+		}
+		if fileHeader.Size > limits.MaxSize {
+			apperr.Render(c, apperr.New(apperr.FileTooLarge).Param("max_size", strconv.FormatInt(limits.MaxSize, 10)))
+			return
+		}
+
+		// 2. Чтение файла целиком: он нужен для определения типа, хэша и миниатюры
+		f, err := fileHeader.Open()
+		if err != nil {
+			logging.L(c).Error("failed to open uploaded file", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			logging.L(c).Error("failed to read uploaded file", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		// 3. Определение типа по содержимому: заголовку Content-Type от клиента не доверяем
+		contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+		if !limits.AllowedTypes[contentType] {
+			apperr.Render(c, apperr.New(apperr.UnsupportedMediaType).
+				Param("content_type", contentType).
+				Param("allowed", limits.allowedList()))
+			return
+		}
+
+		sum := sha256.Sum256(data)
+		attachment := Attachment{
+			AuthorID:    userID,
+			Filename:    sanitizeFilename(fileHeader.Filename),
+			ContentType: contentType,
+			Size:        int64(len(data)),
+			SHA256:      hex.EncodeToString(sum[:]),
+			StorageKey:  newStorageKey("attachments"),
+		}
+
+		// 4. Миниатюра для изображений
+		var thumbnail []byte
+		var thumbnailType string
+		if strings.HasPrefix(contentType, "image/") {
+			img, err := decodeImage(data)
+			if err != nil {
+				apperr.Render(c, apperr.New(apperr.InvalidImage))
+				return
+			}
+			attachment.Width, attachment.Height = img.Bounds().Dx(), img.Bounds().Dy()
+			thumbnail, thumbnailType, err = encodeImage(resizeImage(img, limits.ThumbnailSide), contentType)
+			if err != nil {
+				logging.L(c).Error("failed to create thumbnail", "error", err)
+				apperr.Render(c, apperr.Wrap(err))
+				return
+			}
+			attachment.ThumbnailKey = attachment.StorageKey + "_thumb"
+		}
+
+		// 5. Сохранение файлов в хранилище
+		ctx := c.Request.Context()
+		if err := files.Put(ctx, attachment.StorageKey, bytes.NewReader(data), attachment.Size, contentType); err != nil {
+			logging.L(c).Error("failed to store attachment", "key", attachment.StorageKey, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		if thumbnail != nil {
+			if err := files.Put(ctx, attachment.ThumbnailKey, bytes.NewReader(thumbnail), int64(len(thumbnail)), thumbnailType); err != nil {
+				logging.L(c).Error("failed to store thumbnail", "key", attachment.ThumbnailKey, "error", err)
+				deleteAttachmentFiles(ctx, files, attachment)
+				apperr.Render(c, apperr.Wrap(err))
+				return
+			}
+		}
+
+		// 6. Запись в БД; при ошибке файлы удаляются, чтобы не копить мусор
+		if err := db.Create(&attachment).Error; err != nil {
+			logging.L(c).Error("failed to create attachment", "error", err)
+			deleteAttachmentFiles(ctx, files, attachment)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		attachment.fillURLs()
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":    "Файл загружен",
+			"attachment": attachment,
+		})
+	}
+}
+
+// GetAttachmentHandler отдает вложение или его миниатюру.
+// GET /api/v1/attachments/:id и GET /api/v1/attachments/:id/thumbnail
+func GetAttachmentHandler(db *gorm.DB, files storage.Store, thumbnail bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			apperr.Render(c, apperr.New(apperr.InvalidParameter).Param("name", "id"))
+			return
+		}
+
+		var attachment Attachment
+		if err := db.First(&attachment, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.AttachmentNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load attachment", "attachment_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		key, contentType, size, etag := attachment.StorageKey, attachment.ContentType, attachment.Size, `"`+attachment.SHA256+`"`
+		if thumbnail {
+			if attachment.ThumbnailKey == "" {
+				apperr.Render(c, apperr.New(apperr.ThumbnailNotFound))
+				return
+			}
+			key, size, etag = attachment.ThumbnailKey, -1, `"`+attachment.SHA256+`-thumb"`
+			contentType = "image/jpeg"
+			if attachment.ContentType == "image/png" || attachment.ContentType == "image/gif" {
+				contentType = "image/png"
+			}
+		}
+
+		// Содержимое по ключу никогда не меняется, поэтому кэшировать можно «навсегда»
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		c.Header("ETag", etag)
+		c.Header("X-Content-Type-Options", "nosniff")
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		r, err := files.Get(c.Request.Context(), key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				apperr.Render(c, apperr.New(apperr.FileNotFound))
+				return
+			}
+			logging.L(c).Error("failed to read attachment from storage", "attachment_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		defer r.Close()
+
+		// Изображения показываем в браузере, остальное — только скачивание
+		disposition := "attachment"
+		if strings.HasPrefix(contentType, "image/") {
+			disposition = "inline"
+		}
+		c.DataFromReader(http.StatusOK, size, contentType, r, map[string]string{
+			"Content-Disposition": mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}),
+		})
+	}
+}
+
+// DeletePostAttachments удаляет из БД вложения постов и возвращает их.
+// Вызывается в транзакции перед удалением постов. Файлы удаляет вызывающий
+// (deleteAttachmentFiles) после фиксации: откат не должен оставить записи
+// без файлов.
+func DeletePostAttachments(db *gorm.DB, postIDs ...uint) ([]Attachment, error) {
+	if len(postIDs) == 0 {
+		return nil, nil
+	}
+	var attachments []Attachment
+	if err := db.Where("post_id IN ?", postIDs).Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+	if err := db.Delete(&attachments).Error; err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// CleanupOrphanAttachments удаляет вложения, которые так и не привязали к посту
+// за время olderThan (например, пользователь загрузил файл и закрыл вкладку).
+func CleanupOrphanAttachments(ctx context.Context, db *gorm.DB, files storage.Store, olderThan time.Duration) {
+	var attachments []Attachment
+	if err := db.Where("post_id IS NULL AND created_at < ?", time.Now().Add(-olderThan)).Find(&attachments).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to find orphan attachments", "error", err)
+		return
+	}
+	for _, a := range attachments {
+		if err := db.Delete(&a).Error; err != nil {
+			logging.FromContext(ctx).Error("failed to delete orphan attachment", "attachment_id", a.ID, "error", err)
+			continue
+		}
+		deleteAttachmentFiles(ctx, files, a)
+	}
+}
+
+// deleteAttachmentFiles удаляет оригинал и миниатюру; ошибки только логируются.
+func deleteAttachmentFiles(ctx context.Context, files storage.Store, a Attachment) {
+	for _, key := range []string{a.StorageKey, a.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := files.Delete(ctx, key); err != nil {
+			logging.FromContext(ctx).Warn("failed to delete file from storage", "key", key, "error", err)
+		}
+	}
+}
+
+// newStorageKey генерирует случайный ключ вида prefix/2025/07/<32 hex>.
+func newStorageKey(prefix string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%s/%s/%s", prefix, time.Now().UTC().Format("2006/01"), hex.EncodeToString(b))
+}
+
+// sanitizeFilename оставляет только имя файла без пути и управляющих символов.
+func sanitizeFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" {
+		return "file"
+	}
+	for len(name) > 255 { // Обрезаем по границе символа, а не байта
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
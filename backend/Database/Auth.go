@@ -1,114 +1,163 @@
 package database
 
 import (
+	apperr "REVFORUM/apperr"
+	logging "REVFORUM/logging"
+	metrics "REVFORUM/metrics"
+	"context"
 	"errors"
-	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
-/*type RegisterRequest struct {
-	Username string `json:"username" binding:"required"` // `binding` для валидации Gin
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"` // Минимальная длина 6
-	// Другие поля при необходимости
-} */
+// RegisterRequest — данные для регистрации пользователя.
+type RegisterRequest struct {
+	Username string `json:"username" binding:"required"`    // `binding` для валидации Gin
+	Email    string `json:"email" binding:"required,email"` // `email` добавляет валидацию формата email
+	Password string `json:"password" binding:"required"`    // Требования — PasswordPolicy (Password.go)
+}
 
-// RegisterHandler — обработчик HTTP-запроса для регистрации.
 // RegisterHandler создает обработчик Gin для регистрации новых пользователей.
-// Принимает экземпляр *gorm.DB для взаимодействия с базой данных.
-func RegisterHandler(db *gorm.DB) gin.HandlerFunc {
+// Пароль проверяется по policy.
+// POST /api/v1/auth/register
+func RegisterHandler(db *gorm.DB, policy PasswordPolicy) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 1. Определение структуры для входящих данных
-		// Эта анонимная структура описывает ожидаемый формат JSON в теле запроса.
-		var json struct {
-			Username string `json:"username" binding:"required"`       // `binding:"required"` требует наличия поля
-			Email    string `json:"email" binding:"required,email"`    // `email` добавляет валидацию формата email
-			Password string `json:"password" binding:"required,min=6"` // `min=6` требует минимум 6 символов
-		}
+		db := withRequest(db, c)
 
-		// 2. Парсинг и валидация JSON из тела запроса
-		// c.ShouldBindJSON парсит тело запроса в структуру json.
-		// Если данные не соответствуют структуре или не проходят валидацию,
-		// функция возвращает ошибку.
-		if err := c.ShouldBindJSON(&json); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные", "details": err.Error()})
+		// 1. Парсинг и валидация JSON из тела запроса
+		var req RegisterRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperr.Render(c, apperr.Binding(err))
 			return // Важно: завершаем обработчик при ошибке
 		}
 
-		// 3. Проверка уникальности username и email
-		// Перед созданием пользователя, проверяем, не существует ли уже
-		// пользователь с таким же username или email.
-		var existingUser User // Предполагается, что структура User определена в другом месте
-		result := db.Where("username = ? OR email = ?", json.Username, json.Email).First(&existingUser)
-		if result.Error == nil {
-			// Если ошибка nil, значит запись найдена - пользователь уже существует.
-			c.JSON(http.StatusConflict, gin.H{"error": "Пользователь с таким именем или email уже существует"})
-			return
-		}
-		// Если ошибка НЕ равна ErrRecordNotFound, это означает другую проблему с БД.
-		if result.Error != gorm.ErrRecordNotFound {
-			// Логируем внутреннюю ошибку и возвращаем общее сообщение клиенту.
-			log.Printf("Ошибка БД при проверке уникальности: %v", result.Error)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка базы данных"})
+		// 2. Зарезервированные имена (admin, system…) занимает только администратор
+		if usernameReserved(req.Username) {
+			apperr.Render(c, apperr.New(apperr.UsernameReserved))
 			return
 		}
-		// Если ошибка ErrRecordNotFound - это ожидаемый результат, продолжаем.
 
-		// 4. Хэширование пароля
-		// Никогда не храним пароль в открытом виде!
-		// Используем bcrypt для создания хеша пароля.
-		// Второй параметр (12) - это "стоимость" или количество раундов хеширования.
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(json.Password), 12)
+		// 3. Создание пользователя: те же проверки, что и у команды admin user create
+		newUser, err := RegisterUser(c.Request.Context(), db, req, RoleUser, policy)
 		if err != nil {
-			// Если хеширование не удалось, возвращаем ошибку сервера.
-			log.Printf("Ошибка хеширования пароля: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обработки пароля"})
+			apperr.Render(c, err)
 			return
 		}
 
-		// 5. Создание объекта пользователя для БД
-		// Создаем новый экземпляр структуры User с данными из запроса.
-		// Важно: сохраняем ХЕШ пароля, а не оригинальный пароль!
-		newUser := User{
-			Username:     json.Username,
-			Email:        json.Email,
-			PasswordHash: string(hashedPassword), // Преобразуем []byte в string
-			// Поле CreatedAt (если определено как time.Time с соответствующими тегами)
-			// будет автоматически заполнено GORM текущим временем.
-		}
-
-		// 6. Сохранение пользователя в базе данных
-		// Используем GORM для создания новой записи в таблице users.
-		if err := db.Create(&newUser).Error; err != nil {
-			// Если возникла ошибка при создании (например, нарушение других ограничений БД),
-			// логируем и возвращаем ошибку.
-			log.Printf("Ошибка создания пользователя в БД: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания пользователя", "details": err.Error()})
-			return
-		}
-
-		// 7. Отправка успешного ответа
-		// Если все прошло успешно, возвращаем статус 201 Created
-		// и, опционально, информацию о созданном пользователе (без пароля).
+		// 4. Отправка успешного ответа: только безопасные данные, без хэша пароля
 		c.JSON(http.StatusCreated, gin.H{
 			"message": "Пользователь успешно зарегистрирован",
-			"user": gin.H{ // Возвращаем только безопасные данные
+			"user": gin.H{
 				"id":       newUser.ID,
 				"username": newUser.Username,
 				"email":    newUser.Email,
-				// "created_at": newUser.CreatedAt, // Можно добавить, если поле есть
 			},
 		})
 	}
 }
 
-// LoginUser — функция аутентификации пользователя.
-func LoginHandler(db *gorm.DB) gin.HandlerFunc {
+// RegisterUser создает пользователя с ролью role. Общая часть регистрации
+// через API и консольной команды: проверка полей и пароля по policy,
+// уникальность имени и email без учета регистра (см. Username.go),
+// хэширование пароля.
+func RegisterUser(ctx context.Context, db *gorm.DB, req RegisterRequest, role string, policy PasswordPolicy) (User, error) {
+	// 1. Проверка полей, пароля и роли
+	if err := validate(&req); err != nil {
+		return User{}, err
+	}
+	if err := checkUsername("username", req.Username); err != nil {
+		return User{}, err
+	}
+	if err := policy.check("password", req.Password, req.Username, req.Email); err != nil {
+		return User{}, err
+	}
+	if err := checkRole(role); err != nil {
+		return User{}, err
+	}
+
+	// 2. Проверка уникальности username и email; имя заглушки удаленных занято всегда.
+	// Это лишь быстрый ответ: параллельную регистрацию остановит уникальный индекс (шаг 4)
+	taken, err := usernameInUse(db, req.Username, 0)
+	if err == nil && !taken {
+		var n int64
+		err = db.Model(&User{}).Where("email = ?", canonicalEmail(req.Email)).Count(&n).Error
+		taken = n > 0
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to check user uniqueness", "error", err)
+		return User{}, apperr.Wrap(err)
+	}
+	if taken {
+		return User{}, apperr.New(apperr.UserAlreadyExists)
+	}
+
+	// 3. Хэширование пароля: никогда не храним пароль в открытом виде
+	hash, err := policy.hash(req.Password)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to hash password", "error", err)
+		return User{}, apperr.Wrap(err)
+	}
+
+	// 4. Сохранение пользователя
+	newUser := User{
+		Username:     req.Username,
+		Email:        req.Email,
+		PasswordHash: hash,
+		Role:         role,
+	}
+	if err := db.Create(&newUser).Error; err != nil {
+		if isUniqueViolation(err) {
+			return User{}, apperr.New(apperr.UserAlreadyExists)
+		}
+		logging.FromContext(ctx).Error("failed to create user", "error", err)
+		return User{}, apperr.Wrap(err)
+	}
+	metrics.Registrations.Inc()
+	return newUser, nil
+}
+
+// ResetPasswordRequest — новый пароль; правила те же, что при регистрации.
+type ResetPasswordRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// ResetPassword задает пользователю новый пароль без проверки старого
+// и завершает все его сессии. Используется администратором (admin user reset-password).
+func ResetPassword(ctx context.Context, db *gorm.DB, userID uint, req ResetPasswordRequest, policy PasswordPolicy) error {
+	if err := validate(&req); err != nil {
+		return err
+	}
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if err := policy.check("password", req.Password, user.Username, user.Email); err != nil {
+		return err
+	}
+	hash, err := policy.hash(req.Password)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to hash password", "error", err)
+		return apperr.Wrap(err)
+	}
+	if err := db.Model(&user).Update("password_hash", hash).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to reset password", "user_id", userID, "error", err)
+		return apperr.Wrap(err)
+	}
+	// Кто бы ни знал старый пароль, его сессии больше не действуют
+	_, err = RevokeSessions(ctx, db, userID, 0)
+	return err
+}
+
+// LoginUser — функция аутентификации пользователя. Открывает сессию
+// сроком ttl (см. Session.go). Хэш, посчитанный слабее policy,
+// пересчитывается из введенного пароля.
+func LoginHandler(db *gorm.DB, ttl time.Duration, policy PasswordPolicy) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
 		// 1. Определение структуры для входящих данных
 		var json struct {
 			Username string `json:"username" binding:"required"`
@@ -117,25 +166,26 @@ func LoginHandler(db *gorm.DB) gin.HandlerFunc {
 
 		// 2. Парсинг и валидация JSON из тела запроса
 		if err := c.ShouldBindJSON(&json); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные", "details": err.Error()})
+			apperr.Render(c, apperr.Binding(err))
 			return
 		}
 
-		// 3. Поиск пользователя в БД по имени пользователя
+		// 3. Поиск пользователя в БД по имени пользователя (без учета регистра)
 		var registeredUser User // Предполагается, что структура User определена
-		result := db.Where("username = ?", json.Username).First(&registeredUser)
+		result := db.Scopes(byUsername(json.Username)).First(&registeredUser)
 		if result.Error != nil {
 			// Проверяем тип ошибки
 			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 				// Пользователь с таким именем не найден.
 				// Для безопасности лучше возвращать общее сообщение, не раскрывая,
 				// существует ли пользователь.
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверное имя пользователя или пароль"})
+				metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+				apperr.Render(c, apperr.New(apperr.InvalidCredentials))
 				return
 			} else {
 				// Другая ошибка БД
-				log.Printf("Ошибка БД при поиске пользователя: %v", result.Error)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+				logging.L(c).Error("failed to load user for login", "error", result.Error)
+				apperr.Render(c, apperr.Wrap(result.Error))
 				return
 			}
 		}
@@ -143,40 +193,81 @@ func LoginHandler(db *gorm.DB) gin.HandlerFunc {
 		// 4. Сравнение хэша пароля из БД с введенным паролем
 		// !!! ВАЖНО: Мы НЕ хэшируем json.Password заново.
 		// Мы сравниваем хэш из БД (registeredUser.PasswordHash) с открытым паролем (json.Password).
-		err := bcrypt.CompareHashAndPassword([]byte(registeredUser.PasswordHash), []byte(json.Password))
-		if err != nil {
-			// Ошибка bcrypt.CompareHashAndPassword означает, что пароли не совпадают
+		if !verifyPassword(registeredUser.PasswordHash, json.Password) {
+			// Пароли не совпадают.
 			// И снова: для безопасности возвращаем общее сообщение.
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверное имя пользователя или пароль"})
+			metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+			apperr.Render(c, apperr.New(apperr.InvalidCredentials))
 			return
 		}
 
 		// 5. Если мы дошли до этого места, значит имя и пароль верны.
-		// Пользователь аутентифицирован.
-
-		// 6. (Опционально, но рекомендуется для будущих защищенных запросов)
-		// Генерируем JWT-токен для пользователя.
-		// tokenString, err := generateJWT(registeredUser.ID) // Ты должен реализовать эту функцию
-		// if err != nil {
-		//     log.Printf("Ошибка генерации JWT: %v", err)
-		//     c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации токена"})
-		//     return
-		// }
-
-		// 7. Отправляем успешный ответ
-		// Можно просто сообщение об успехе
-		// c.JSON(http.StatusOK, gin.H{"message": "Вход выполнен успешно"})
-
-		// Или сообщение об успехе + базовую информацию о пользователе (без пароля!)
-		// Или сообщение об успехе + JWT токен
+		// Пароль известен только сейчас: самое время обновить хэш
+		rehashPassword(c.Request.Context(), db, registeredUser, json.Password, policy)
+		finishLogin(c, db, registeredUser, ttl)
+	}
+}
+
+// finishLogin завершает вход пользователя, чья личность уже подтверждена
+// паролем или провайдером OIDC. О блокировке сообщаем только ему.
+// Со вторым фактором вместо сессии выдается challenge_token: вход
+// завершает POST /api/v1/auth/login/2fa.
+func finishLogin(c *gin.Context, db *gorm.DB, user User, ttl time.Duration) {
+	if user.BannedAt != nil {
+		metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+		apperr.Render(c, apperr.New(apperr.UserBanned))
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		token, expiresAt, err := createLoginChallenge(c.Request.Context(), db, user.ID)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{
-			"message": "Вход выполнен успешно",
-			"user": gin.H{
-				"id":       registeredUser.ID,
-				"username": registeredUser.Username,
-				"email":    registeredUser.Email,
-			},
-			// "token": tokenString, // Если будет JWT
+			"message":             "Введите код из приложения-аутентификатора или резервный код",
+			"two_factor_required": true,
+			"challenge_token":     token,
+			"expires_at":          expiresAt,
 		})
+		return
+	}
+	completeLogin(c, db, user, ttl)
+}
+
+// completeLogin открывает сессию и отвечает на успешный вход: токен
+// и базовая информация о пользователе (без пароля!). Токен клиент
+// передает в Authorization: Bearer. Через нее проходит любой вход, поэтому
+// блокировка проверяется и здесь: пользователя могли заблокировать, пока
+// он вводил второй фактор.
+func completeLogin(c *gin.Context, db *gorm.DB, user User, ttl time.Duration) {
+	if user.BannedAt != nil {
+		metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+		apperr.Render(c, apperr.New(apperr.UserBanned))
+		return
 	}
+	session, token, err := CreateSession(c.Request.Context(), db, user.ID, ttl, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		apperr.Render(c, err)
+		return
+	}
+	// Роль требует 2FA, а она не включена: права роли не действуют до включения
+	setupRequired, err := twoFactorRequired(c.Request.Context(), db, user.Role)
+	if err != nil {
+		apperr.Render(c, err)
+		return
+	}
+	metrics.Logins.WithLabelValues(metrics.LoginSuccess).Inc()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Вход выполнен успешно",
+		"user": gin.H{
+			"id":       user.ID,
+			"username": user.Username,
+			"email":    user.Email,
+		},
+		"token":                     token,
+		"expires_at":                session.ExpiresAt,
+		"two_factor_setup_required": setupRequired && user.TOTPEnabledAt == nil,
+	})
 }
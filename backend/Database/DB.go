@@ -1,11 +1,16 @@
 package database
 
 import (
+	logging "REVFORUM/logging"
+	metrics "REVFORUM/metrics"
+	tracing "REVFORUM/tracing"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -14,57 +19,150 @@ import (
 // Предполагается, что структуры User, Themes_Collection, Sub_Themes и другие
 // уже определены в других файлах вашего проекта и экспортируются (с большой буквы).
 
+// Роли пользователей.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type User struct {
 	ID           uint      `gorm:"primaryKey"`           // Уникальный идентификатор
 	Username     string    `gorm:"uniqueIndex;not null"` // Имя пользователя, уникальное и обязательное
-	Email        string    `gorm:"uniqueIndex;not null"` // Email пользователя, уникальный и обязательное
-	PasswordHash string    `gorm:"not null"`             // Хэш пароля, обязательный
+	UsernameKey  *string   `gorm:"uniqueIndex" json:"-"` // Имя без учета регистра и похожих символов (см. Username.go)
+	Email        string    `gorm:"uniqueIndex;not null"` // Email пользователя в нижнем регистре, уникальный и обязательный
+	PasswordHash string    `gorm:"not null" json:"-"`    // Хэш пароля, обязательный; наружу не отдается
 	CreatedAt    time.Time // Время создания записи
+
+	// Публичный профиль (см. Profile.go)
+	DisplayName string `gorm:"size:50"`               // Отображаемое имя, если отличается от Username
+	Bio         string `gorm:"type:text"`             // О себе
+	Signature   string `gorm:"size:300"`              // Подпись под сообщениями
+	Location    string `gorm:"size:100"`              // Откуда пользователь
+	Website     string `gorm:"size:200"`              // Личный сайт (http/https)
+	AvatarKey   string `json:"-"`                     // Ключ аватара в хранилище; пусто — аватара нет
+	Reputation  int    `gorm:"not null;default:0"`    // Репутация (растет от реакций на сообщения)
+	Role        string `gorm:"not null;default:user"` // user, moderator или admin
+
+	// Блокировка (см. BanUser): заблокированный пользователь не может войти
+	BannedAt  *time.Time `gorm:"index"`
+	BanReason string     `gorm:"size:300" json:"-"`
+
+	// Назначенное удаление учетной записи (см. Account.go); nil — не назначено
+	DeletionScheduledAt *time.Time `gorm:"index"`
+	Placeholder         bool       `gorm:"not null;default:false" json:"-"` // Заглушка «удаленный пользователь» (см. deletedUser)
+
+	// Двухфакторная аутентификация (см. TwoFactor.go)
+	TOTPSecret           string     `gorm:"column:totp_secret;size:64" json:"-"`               // Секрет base32; до включения — выданный, но не подтвержденный
+	TOTPEnabledAt        *time.Time `gorm:"column:totp_enabled_at" json:"-"`                   // nil — 2FA выключена
+	TOTPLastStep         int64      `gorm:"column:totp_last_step;not null;default:0" json:"-"` // Шаг последнего принятого кода: повтор кода отвергается
+	TwoFactorFailures    int        `gorm:"not null;default:0" json:"-"`                       // Неверные коды подряд по всем входам; сбрасывается верным кодом
+	TwoFactorLockedUntil *time.Time `json:"-"`                                                 // До этого времени коды не принимаются (см. reserveTwoFactorAttempt)
 }
 
-var DB *gorm.DB
+// Группы ключей кэша для списков (см. cache.GroupKey).
+const (
+	CacheThemes    = "themes"    // GET /api/v1/themes
+	CacheSubThemes = "subthemes" // GET /api/v1/themes/:id/subthemes, ID — родительская тема
+	CacheTopics    = "topics"    // GET /api/v1/subthemes/:id/topics, ID — подтема
+	CachePosts     = "posts"     // GET /api/v1/topics/:id/posts, ID — топик
+)
 
-func Init() {
+// Init загружает переменные окружения, настраивает журнал и подключается
+// к базе. Ошибки фатальны: вызывается только при старте процесса.
+func Init() *gorm.DB {
 	// Загрузка переменных окружения
 	err := godotenv.Load("./resource/.env")
 	if err != nil {
 		log.Fatal("Error loading .env file: ", err)
 	}
+	// Журнал настраивается после загрузки .env: формат и уровень задаются там
+	logging.Init()
 
-	// Формирование строки подключения
-	dsn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=%s",
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_NAME"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_SSLMODE"),
-		os.Getenv("DB_TIMEZONE"),
-	)
-
-	// Подключение к базе данных
-	_db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	// !!! ВАЖНО: Проверка ошибки подключения ДОЛЖНА быть здесь, сразу после gorm.Open !!!
+	db, err := Open(DSN())
 	if err != nil {
 		log.Fatal("Failed to connect to database: ", err)
 	}
-	// Если мы дошли до этой строки, подключение успешно, _db != nil
+	slog.Info("connected to the database and migrated successfully")
+	return db
+}
+
+// Open подключается к PostgreSQL по dsn, подключает плагины метрик
+// и трассировки и выполняет миграцию. Глобального состояния не меняет:
+// полученный *gorm.DB передается обработчикам явно (см. server.New).
+func Open(dsn string) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logging.NewGormLogger()})
+	if err != nil {
+		return nil, err
+	}
+
+	// Метрики длительности запросов и статистика пула соединений
+	if err := db.Use(metrics.GormPlugin{}); err != nil {
+		return nil, fmt.Errorf("metrics plugin: %w", err)
+	}
+	// Спан на каждый SQL-запрос, если запрос выполняется с контекстом (см. withRequest)
+	if err := db.Use(tracing.GormPlugin()); err != nil {
+		return nil, fmt.Errorf("tracing plugin: %w", err)
+	}
 
 	// Автоматическая миграция структур в таблицы БД
-	// Убедитесь, что все используемые здесь структуры (User, Themes_Collection и т.д.)
-	// определены и импортированы (если находятся в других пакетах).
-	err = _db.AutoMigrate(
+	if err := Migrate(db); err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	return db, nil
+}
+
+// Migrate создает и обновляет таблицы всех моделей. Вызывается при старте
+// сервера и тестовым окружением (см. пакет dbtest).
+func Migrate(db *gorm.DB) error {
+	err := db.AutoMigrate(
 		&User{},              // Модель пользователя
 		&Themes_Collection{}, // Модель основных тем
 		&Sub_Themes{},        // Модель подтем
-		&Topic{},             // Добавьте сюда другие модели, когда они будут созданы
-		&Post{},              // Например, Topic и Post для топиков и сообщений
+		&Topic{},             // Топики
+		&Post{},              // Сообщения в топиках
+		&Attachment{},        // Вложения постов
+		&Session{},           // Сессии входа
+		&LoginChallenge{},    // Входы, ожидающие второго фактора
+		&RecoveryCode{},      // Резервные коды 2FA
+		&Setting{},           // Настройки, изменяемые администратором
+		&Identity{},          // Привязанные провайдеры OIDC
+		&OIDCFlow{},          // Начатые входы через провайдеров
+		&UsernameChange{},    // История имен пользователей
+		&IdempotencyKey{},    // Ключи идемпотентности POST и сохраненные ответы
 	)
 	if err != nil {
-		log.Fatal("Failed to migrate database: ", err)
+		return err
+	}
+	// Текст топиков, созданных до появления первых сообщений
+	if err := migrateTopicContent(db); err != nil {
+		return err
+	}
+	// Заглушка удаленных пользователей, созданная до появления ее признака
+	if err := markDeletedUser(db); err != nil {
+		return err
 	}
+	// Ключи имен у пользователей, созданных до их появления или по прежним правилам
+	return backfillUserKeys(db)
+}
+
+// DSN формирует строку подключения к PostgreSQL из переменных окружения.
+// Используется и GORM, и отдельными соединениями (например, LISTEN/NOTIFY).
+func DSN() string {
+	return fmt.Sprintf(
+		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=%s",
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWORD"),
+		os.Getenv("DB_NAME"),
+		os.Getenv("DB_PORT"),
+		os.Getenv("DB_SSLMODE"),
+		os.Getenv("DB_TIMEZONE"),
+	)
+}
 
-	fmt.Println("Connected to the database and migrated successfully!")
-	DB = _db
+// withRequest привязывает запросы к БД к контексту HTTP-запроса: журнал GORM
+// получает его request_id, а SQL-запросы становятся дочерними спанами трассы.
+func withRequest(db *gorm.DB, c *gin.Context) *gorm.DB {
+	return db.WithContext(c.Request.Context())
 }
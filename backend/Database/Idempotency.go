@@ -0,0 +1,286 @@
+package database
+
+import (
+	apperr "REVFORUM/apperr"
+	logging "REVFORUM/logging"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Ключи идемпотентности.
+//
+// Клиент, который может повторить создание темы, подтемы, топика, поста или
+// вложения (например, после обрыва связи), передает заголовок Idempotency-Key
+// с уникальным значением. Первый запрос с ключом
+// выполняется как обычно, а его успешный ответ хранится TTL: повтор с тем же
+// телом получает сохраненный ответ с заголовком Idempotent-Replayed: true,
+// а обработчик второй раз не вызывается. Тот же ключ с другим телом или
+// маршрутом — 422, повтор, пока первый запрос еще выполняется, — 409.
+// Ключи свои у каждого пользователя. Ответы с ошибкой не сохраняются:
+// запрос с тем же ключом можно повторить, в том числе исправив тело.
+//
+// Ответ хранится как есть, поэтому Idempotency подключается только к маршрутам
+// создания ресурсов форума: ответы входа и настройки 2FA содержат токены
+// сессий, секреты и резервные коды, которые не должны оседать в базе.
+
+// Заголовки запроса и ответа.
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	IdempotencyMaxJSONBody   = 1 << 20 // Предел тела JSON-запросов создания с ключом (см. Idempotency)
+	idempotencyKeyMaxLength  = 255
+	idempotencyMemoryBody    = 1 << 20 // Тело больше этого (загрузка вложения) копируется во временный файл
+)
+
+// idempotencyLockTimeout — через сколько запись о выполняющемся запросе
+// считается брошенной (процесс упал, не сохранив ответ) и ключ можно занять снова.
+const idempotencyLockTimeout = time.Minute
+
+// IdempotencyKey — ключ идемпотентности и сохраненный ответ на первый запрос с ним.
+type IdempotencyKey struct {
+	ID          uint      `gorm:"primaryKey"`
+	UserID      uint      `gorm:"not null;uniqueIndex:idx_idempotency_keys_user_key"`
+	Key         string    `gorm:"not null;size:255;uniqueIndex:idx_idempotency_keys_user_key"`
+	RequestHash string    `gorm:"not null;size:64"` // SHA-256 метода, адреса и тела запроса
+	Status      int       `gorm:"not null"`         // 0 — запрос еще выполняется
+	ContentType string    `gorm:"size:100"`
+	Body        []byte    // Тело ответа как есть
+	CreatedAt   time.Time `gorm:"not null"`
+	ExpiresAt   time.Time `gorm:"not null;index"`
+}
+
+// IdempotencyTTLFromEnv читает IDEMPOTENCY_TTL — сколько хранится ответ
+// на запрос с ключом, например 24h (по умолчанию сутки; 0 — ключи не поддерживаются).
+func IdempotencyTTLFromEnv() time.Duration {
+	ttl := 24 * time.Hour
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatal("Invalid IDEMPOTENCY_TTL: ", v)
+		}
+		ttl = d
+	}
+	return ttl
+}
+
+// Idempotency обрабатывает заголовок Idempotency-Key у POST-запросов маршрута.
+// Подключается к маршрутам создания ресурсов (см. выше), после Authenticate:
+// ключи различаются по пользователям. Тело хешируется целиком до обработчика,
+// поэтому читается не больше maxBody — предела самого маршрута. Если ttl == 0,
+// заголовок игнорируется.
+func Idempotency(db *gorm.DB, ttl time.Duration, maxBody int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if c.Request.Method != http.MethodPost || key == "" || ttl <= 0 {
+			c.Next()
+			return
+		}
+		db := withRequest(db, c)
+
+		// 1. Проверка ключа и отпечаток запроса; тело анонимного запроса не читается:
+		// создание доступно только пользователям
+		userID := currentUserID(c)
+		if userID == 0 {
+			apperr.Render(c, apperr.New(apperr.Unauthenticated))
+			return
+		}
+		if !validIdempotencyKey(key) {
+			apperr.Render(c, apperr.New(apperr.InvalidParameter).Param("name", IdempotencyKeyHeader))
+			return
+		}
+		hash, body, err := hashRequestBody(c.Request, maxBody)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		defer body.Close()
+		c.Request.Body = body
+
+		// 2. Занять ключ; повтор выполненного запроса получает сохраненный ответ
+		record, claimed, err := claimIdempotencyKey(db, userID, key, hash, ttl)
+		if err != nil {
+			var appErr *apperr.Error
+			if !errors.As(err, &appErr) {
+				logging.L(c).Error("failed to claim idempotency key", "error", err)
+				err = apperr.Wrap(err)
+			}
+			apperr.Render(c, err)
+			return
+		}
+		if !claimed {
+			c.Header(IdempotentReplayedHeader, "true")
+			c.Data(record.Status, record.ContentType, record.Body)
+			c.Abort()
+			return
+		}
+
+		// 3. Выполнение запроса с копированием ответа
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		// 4. Успешный ответ сохраняется, ошибка освобождает ключ. Клиент мог уже
+		// отключиться, но ответ все равно нужно сохранить — для его повтора
+		db = db.WithContext(context.WithoutCancel(c.Request.Context()))
+		if status := w.Status(); status >= 200 && status < 300 {
+			err = db.Model(&IdempotencyKey{}).Where("id = ?", record.ID).Updates(map[string]any{
+				"status": status, "content_type": w.Header().Get("Content-Type"), "body": w.body.Bytes(),
+			}).Error
+		} else {
+			err = db.Delete(&IdempotencyKey{}, record.ID).Error
+		}
+		if err != nil {
+			logging.L(c).Warn("failed to store idempotent response", "user_id", userID, "error", err)
+		}
+	}
+}
+
+// validIdempotencyKey: от 1 до 255 видимых символов ASCII (обычно UUID).
+func validIdempotencyKey(key string) bool {
+	if len(key) > idempotencyKeyMaxLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < '!' || key[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// hashRequestBody считает SHA-256 метода, адреса и тела запроса по мере чтения
+// и возвращает копию тела для обработчика: небольшое — в памяти, большое —
+// во временном файле, который удаляется при закрытии копии. Тело больше
+// maxBody — FileTooLarge.
+func hashRequestBody(r *http.Request, maxBody int64) (string, io.ReadCloser, error) {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	src := io.LimitReader(r.Body, maxBody+1)
+	tooLarge := apperr.New(apperr.FileTooLarge).Param("max_size", strconv.FormatInt(maxBody, 10))
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(io.MultiWriter(h, &buf), io.LimitReader(src, idempotencyMemoryBody+1)); err != nil {
+		return "", nil, apperr.New(apperr.InvalidRequestBody)
+	}
+	if int64(buf.Len()) > maxBody {
+		return "", nil, tooLarge
+	}
+	if buf.Len() <= idempotencyMemoryBody {
+		return hex.EncodeToString(h.Sum(nil)), io.NopCloser(&buf), nil
+	}
+
+	f, err := os.CreateTemp("", "idempotency-*")
+	if err != nil {
+		return "", nil, apperr.Wrap(err)
+	}
+	spool := &tempBody{File: f}
+	n, err := buf.WriteTo(f) // Начало тела уже учтено в хеше
+	if err == nil {
+		var rest int64
+		rest, err = io.Copy(io.MultiWriter(h, f), src)
+		n += rest
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spool.Close()
+		return "", nil, apperr.New(apperr.InvalidRequestBody)
+	}
+	if n > maxBody {
+		spool.Close()
+		return "", nil, tooLarge
+	}
+	return hex.EncodeToString(h.Sum(nil)), spool, nil
+}
+
+// tempBody — тело запроса во временном файле; Close удаляет файл.
+type tempBody struct{ *os.File }
+
+func (b *tempBody) Close() error {
+	b.File.Close()
+	return os.Remove(b.Name())
+}
+
+// claimIdempotencyKey занимает ключ пользователя под новый запрос (claimed == true)
+// или возвращает сохраненный ответ на такой же запрос. Уникальный индекс не дает
+// двум параллельным запросам занять один ключ.
+func claimIdempotencyKey(db *gorm.DB, userID uint, key, hash string, ttl time.Duration) (IdempotencyKey, bool, error) {
+	for range 3 {
+		now := time.Now()
+		record := IdempotencyKey{UserID: userID, Key: key, RequestHash: hash, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+		err := db.Create(&record).Error
+		if err == nil {
+			return record, true, nil
+		}
+		if !isUniqueViolation(err) {
+			return record, false, err
+		}
+
+		// Ключ уже занят
+		var existing IdempotencyKey
+		err = db.Where("user_id = ? AND key = ?", userID, key).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue // Запись только что удалили: ключ свободен
+		}
+		if err != nil {
+			return existing, false, err
+		}
+		abandoned := existing.Status == 0 && now.Sub(existing.CreatedAt) > idempotencyLockTimeout
+		if !now.Before(existing.ExpiresAt) || abandoned {
+			if err := db.Delete(&IdempotencyKey{}, existing.ID).Error; err != nil {
+				return existing, false, err
+			}
+			continue
+		}
+		switch {
+		case existing.RequestHash != hash:
+			return existing, false, apperr.New(apperr.IdempotencyKeyReused)
+		case existing.Status == 0:
+			return existing, false, apperr.New(apperr.IdempotencyKeyInProgress)
+		}
+		return existing, false, nil
+	}
+	return IdempotencyKey{}, false, apperr.New(apperr.IdempotencyKeyInProgress)
+}
+
+// CleanupExpiredIdempotencyKeys удаляет ключи, срок хранения которых истек.
+func CleanupExpiredIdempotencyKeys(ctx context.Context, db *gorm.DB) {
+	result := db.WithContext(ctx).Where("expires_at <= ?", time.Now()).Delete(&IdempotencyKey{})
+	if result.Error != nil {
+		logging.FromContext(ctx).Error("failed to delete expired idempotency keys", "error", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		logging.FromContext(ctx).Info("expired idempotency keys deleted", "count", result.RowsAffected)
+	}
+}
+
+// recordingWriter передает ответ клиенту и копирует его тело.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
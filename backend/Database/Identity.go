@@ -0,0 +1,456 @@
+package database
+
+import (
+	apperr "REVFORUM/apperr"
+	logging "REVFORUM/logging"
+	metrics "REVFORUM/metrics"
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Вход через внешних провайдеров (OIDC.go) и привязка их к учетным записям.
+//
+// 1. POST /api/v1/auth/oidc/:provider/start возвращает authorization_url;
+//    state, nonce и code_verifier остаются на сервере (OIDCFlow).
+// 2. Провайдер возвращает браузер на redirect_url фронтенда с code и state.
+// 3. Фронтенд передает их в POST /api/v1/auth/oidc/:provider/callback:
+//    сервер меняет code на id_token, проверяет его и входит так же, как
+//    POST /api/v1/auth/login (в том числе со вторым фактором).
+//
+// Учетная запись ищется по паре (провайдер, sub). Незнакомому sub создается
+// новая учетная запись без пароля, если провайдер подтвердил email и этот
+// email свободен. С чужим email автоматически не связываемся: владелец
+// учетной записи входит паролем и привязывает провайдера сам (start с
+// link=true), иначе провайдер с неподтвержденными адресами открыл бы чужие
+// учетные записи.
+
+const oidcFlowTTL = 10 * time.Minute
+
+// Identity — учетная запись внешнего провайдера, привязанная к пользователю.
+type Identity struct {
+	ID          uint       `gorm:"primaryKey" json:"-"`
+	UserID      uint       `gorm:"not null;uniqueIndex:idx_identities_user_provider" json:"-"`
+	Provider    string     `gorm:"size:50;not null;uniqueIndex:idx_identities_user_provider;uniqueIndex:idx_identities_subject" json:"provider"`
+	Subject     string     `gorm:"size:255;not null;uniqueIndex:idx_identities_subject" json:"-"` // sub из id_token
+	Email       string     `gorm:"size:255" json:"email"`                                         // Email у провайдера на момент последнего входа
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// OIDCFlow — начатый вход через провайдера, ожидающий callback.
+type OIDCFlow struct {
+	ID           uint   `gorm:"primaryKey"`
+	StateHash    string `gorm:"not null;uniqueIndex;size:64"` // SHA-256 state
+	Provider     string `gorm:"size:50;not null"`
+	Nonce        string `gorm:"size:64;not null"`
+	CodeVerifier string `gorm:"size:128;not null"` // PKCE
+	UserID       *uint  // Привязка провайдера к этому пользователю; nil — вход
+	CreatedAt    time.Time
+	ExpiresAt    time.Time `gorm:"not null;index"`
+}
+
+// OIDCProviderInfo — провайдер в списке GET /api/v1/auth/oidc.
+type OIDCProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// OIDCStart — адрес, на который фронтенд отправляет браузер.
+type OIDCStart struct {
+	AuthorizationURL string    `json:"authorization_url"`
+	ExpiresAt        time.Time `json:"expires_at"` // До этого момента нужно завершить вход
+}
+
+// OIDCCallbackRequest — параметры, с которыми провайдер вернул браузер.
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// StartOIDC начинает вход через провайдера или, если linkUserID не nil,
+// привязку провайдера к этому пользователю.
+func StartOIDC(ctx context.Context, db *gorm.DB, p *OIDCProvider, linkUserID *uint) (OIDCStart, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("oidc discovery failed", "provider", p.Name, "error", err)
+		return OIDCStart{}, apperr.Wrap(err)
+	}
+	var state, nonce, verifier string
+	for _, v := range []*string{&state, &nonce, &verifier} {
+		if *v, err = randomToken(32); err != nil {
+			return OIDCStart{}, apperr.Wrap(err)
+		}
+	}
+	flow := OIDCFlow{
+		StateHash:    hashToken(state),
+		Provider:     p.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		UserID:       linkUserID,
+		ExpiresAt:    time.Now().Add(oidcFlowTTL),
+	}
+	if err := db.Create(&flow).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to save oidc flow", "provider", p.Name, "error", err)
+		return OIDCStart{}, apperr.Wrap(err)
+	}
+	return OIDCStart{AuthorizationURL: p.authorizationURL(d, state, nonce, verifier), ExpiresAt: flow.ExpiresAt}, nil
+}
+
+// finishOIDC завершает поток: state одноразовый, code меняется на
+// проверенный id_token. Ошибки протокола клиенту не раскрываются.
+func finishOIDC(ctx context.Context, db *gorm.DB, p *OIDCProvider, req OIDCCallbackRequest) (OIDCFlow, oidcClaims, error) {
+	// 1. Поток по state; удаляется сразу, чтобы state нельзя было использовать повторно
+	var flow OIDCFlow
+	err := db.Where("state_hash = ? AND provider = ?", hashToken(req.State), p.Name).First(&flow).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return flow, oidcClaims{}, apperr.New(apperr.OIDCStateInvalid)
+		}
+		logging.FromContext(ctx).Error("failed to load oidc flow", "error", err)
+		return flow, oidcClaims{}, apperr.Wrap(err)
+	}
+	if result := db.Delete(&flow); result.Error != nil || result.RowsAffected == 0 {
+		return flow, oidcClaims{}, apperr.New(apperr.OIDCStateInvalid)
+	}
+	if time.Now().After(flow.ExpiresAt) {
+		return flow, oidcClaims{}, apperr.New(apperr.OIDCStateInvalid)
+	}
+
+	// 2. Обмен кода и проверка id_token
+	d, err := p.discover(ctx)
+	if err == nil {
+		var raw string
+		if raw, err = p.exchange(ctx, d, req.Code, flow.CodeVerifier); err == nil {
+			var claims oidcClaims
+			if claims, err = p.verifyIDToken(ctx, d, raw, flow.Nonce, time.Now()); err == nil {
+				return flow, claims, nil
+			}
+		}
+	}
+	logging.FromContext(ctx).Warn("oidc login failed", "provider", p.Name, "error", err)
+	return flow, oidcClaims{}, apperr.New(apperr.OIDCLoginFailed)
+}
+
+// oidcUser возвращает пользователя, привязанного к учетной записи провайдера,
+// или создает нового.
+func oidcUser(ctx context.Context, db *gorm.DB, provider string, claims oidcClaims) (User, error) {
+	var user User
+	created := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 1. Уже привязанная учетная запись
+		var identity Identity
+		err := tx.Where("provider = ? AND subject = ?", provider, claims.Subject).First(&identity).Error
+		if err == nil {
+			now := time.Now()
+			if err := tx.Model(&identity).Updates(map[string]any{"email": claims.Email, "last_login_at": now}).Error; err != nil {
+				return err
+			}
+			return tx.First(&user, identity.UserID).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// 2. Новая учетная запись: только с подтвержденным свободным email
+		if claims.Email == "" || !claims.EmailVerified {
+			return apperr.New(apperr.OIDCEmailUnverified)
+		}
+		var taken int64
+		if err := tx.Model(&User{}).Where("email = ?", canonicalEmail(claims.Email)).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return apperr.New(apperr.OIDCEmailInUse)
+		}
+		username, err := freeUsername(tx, claims)
+		if err != nil {
+			return err
+		}
+		user = User{Username: username, Email: claims.Email, PasswordHash: noPassword, DisplayName: truncate(claims.Name, 50), Role: RoleUser}
+		if err := tx.Create(&user).Error; err != nil {
+			if isUniqueViolation(err) { // Параллельная регистрация с тем же именем или email
+				return apperr.New(apperr.UserAlreadyExists)
+			}
+			return err
+		}
+		created = true
+		now := time.Now()
+		return tx.Create(&Identity{UserID: user.ID, Provider: provider, Subject: claims.Subject, Email: claims.Email, LastLoginAt: &now}).Error
+	})
+	var appErr *apperr.Error
+	if errors.As(err, &appErr) {
+		return User{}, err
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to resolve oidc user", "provider", provider, "error", err)
+		return User{}, apperr.Wrap(err)
+	}
+	if created {
+		metrics.Registrations.Inc()
+		logging.FromContext(ctx).Info("user registered via oidc", "user_id", user.ID, "provider", provider)
+	}
+	return user, nil
+}
+
+// freeUsername подбирает свободное имя из preferred_username или email:
+// недопустимые символы отбрасываются, занятое (без учета регистра) или
+// зарезервированное имя получает номер.
+func freeUsername(tx *gorm.DB, claims oidcClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' {
+			return r
+		}
+		return -1
+	}, base)
+	if strings.IndexFunc(base, func(r rune) bool { return !unicode.IsDigit(r) }) < 0 {
+		base = "user" + base // Имя из одних цифр читалось бы как ID
+	}
+	base = truncate(normalizeUsername(base), usernameMaxLength-2)
+	if utf8.RuneCountInString(base) < usernameMinLength {
+		base = "user"
+	}
+	for i := 1; ; i++ {
+		name := base
+		if i > 1 {
+			name = base + strconv.Itoa(i)
+		}
+		if usernameReserved(name) {
+			continue
+		}
+		taken, err := usernameInUse(tx, name, 0)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return name, nil
+		}
+	}
+}
+
+// truncate обрезает строку до n символов.
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// linkIdentity привязывает учетную запись провайдера к пользователю.
+// У пользователя может быть одна учетная запись каждого провайдера,
+// а учетная запись провайдера — только у одного пользователя.
+func linkIdentity(ctx context.Context, db *gorm.DB, userID uint, provider string, claims oidcClaims) (Identity, error) {
+	var existing Identity
+	err := db.Where("(provider = ? AND subject = ?) OR (provider = ? AND user_id = ?)", provider, claims.Subject, provider, userID).First(&existing).Error
+	if err == nil {
+		return Identity{}, apperr.New(apperr.IdentityAlreadyLinked).Param("provider", provider)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		logging.FromContext(ctx).Error("failed to check identity", "user_id", userID, "error", err)
+		return Identity{}, apperr.Wrap(err)
+	}
+	identity := Identity{UserID: userID, Provider: provider, Subject: claims.Subject, Email: claims.Email}
+	if err := db.Create(&identity).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to link identity", "user_id", userID, "provider", provider, "error", err)
+		return Identity{}, apperr.Wrap(err)
+	}
+	logging.FromContext(ctx).Info("identity linked", "user_id", userID, "provider", provider)
+	return identity, nil
+}
+
+// ListIdentities возвращает привязанных провайдеров пользователя.
+func ListIdentities(ctx context.Context, db *gorm.DB, userID uint) ([]Identity, error) {
+	identities := []Identity{}
+	if err := db.Where("user_id = ?", userID).Order("provider ASC").Find(&identities).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to list identities", "user_id", userID, "error", err)
+		return nil, apperr.Wrap(err)
+	}
+	return identities, nil
+}
+
+// UnlinkIdentity отвязывает провайдера. Последний способ входа отвязать
+// нельзя: без пароля и других провайдеров в учетную запись не войти.
+func UnlinkIdentity(ctx context.Context, db *gorm.DB, userID uint, provider string) error {
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	identities, err := ListIdentities(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(identities, func(id Identity) bool { return id.Provider == provider })
+	if i < 0 {
+		return apperr.New(apperr.IdentityNotFound).Param("provider", provider)
+	}
+	if user.PasswordHash == noPassword && len(identities) == 1 {
+		return apperr.New(apperr.LastLoginMethod)
+	}
+	if err := db.Delete(&identities[i]).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to unlink identity", "user_id", userID, "provider", provider, "error", err)
+		return apperr.Wrap(err)
+	}
+	logging.FromContext(ctx).Info("identity unlinked", "user_id", userID, "provider", provider)
+	return nil
+}
+
+// --- HTTP ---
+
+// oidcProvider находит провайдера из пути; ненастроенному — 404.
+func oidcProvider(c *gin.Context, providers OIDCProviders) (*OIDCProvider, bool) {
+	name := c.Param("provider")
+	p, ok := providers[name]
+	if !ok {
+		apperr.Render(c, apperr.New(apperr.OIDCProviderNotFound).Param("provider", name))
+		return nil, false
+	}
+	return p, true
+}
+
+// OIDCProvidersHandler возвращает настроенных провайдеров для кнопок входа.
+// GET /api/v1/auth/oidc
+func OIDCProvidersHandler(providers OIDCProviders) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list := make([]OIDCProviderInfo, 0, len(providers))
+		for _, p := range providers {
+			list = append(list, OIDCProviderInfo{Name: p.Name, DisplayName: p.title()})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// OIDCStartHandler начинает вход через провайдера; с ?link=true — привязку
+// провайдера к текущему пользователю (нужна сессия).
+// POST /api/v1/auth/oidc/:provider/start
+func OIDCStartHandler(db *gorm.DB, providers OIDCProviders) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		p, ok := oidcProvider(c, providers)
+		if !ok {
+			return
+		}
+		link, err := queryBool(c, "link")
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		var linkUserID *uint
+		if link {
+			userID, ok := requireUser(c)
+			if !ok {
+				return
+			}
+			linkUserID = &userID
+		}
+
+		start, err := StartOIDC(c.Request.Context(), db, p, linkUserID)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, start)
+	}
+}
+
+// OIDCCallbackHandler завершает вход или привязку провайдера.
+// POST /api/v1/auth/oidc/:provider/callback
+func OIDCCallbackHandler(db *gorm.DB, providers OIDCProviders, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		ctx := c.Request.Context()
+		p, ok := oidcProvider(c, providers)
+		if !ok {
+			return
+		}
+		var req OIDCCallbackRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperr.Render(c, apperr.Binding(err))
+			return
+		}
+
+		// 1. Проверка state и id_token
+		flow, claims, err := finishOIDC(ctx, db, p, req)
+		if err != nil {
+			if flow.UserID == nil {
+				metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+			}
+			apperr.Render(c, err)
+			return
+		}
+
+		// 2. Привязка: завершить ее может только тот, кто начал, — иначе
+		// чужой state привязал бы к нему учетную запись жертвы
+		if flow.UserID != nil {
+			if currentUserID(c) != *flow.UserID {
+				apperr.Render(c, apperr.New(apperr.OIDCStateInvalid))
+				return
+			}
+			identity, err := linkIdentity(ctx, db, *flow.UserID, p.Name, claims)
+			if err != nil {
+				apperr.Render(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "Провайдер привязан", "identity": identity})
+			return
+		}
+
+		// 3. Вход: найденный или новый пользователь, дальше как при входе паролем
+		user, err := oidcUser(ctx, db, p.Name, claims)
+		if err != nil {
+			metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+			apperr.Render(c, err)
+			return
+		}
+		finishLogin(c, db, user, ttl)
+	}
+}
+
+// ListIdentitiesHandler возвращает привязанных провайдеров текущего пользователя.
+// GET /api/v1/me/identities
+func ListIdentitiesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		identities, err := ListIdentities(c.Request.Context(), db, userID)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, identities)
+	}
+}
+
+// UnlinkIdentityHandler отвязывает провайдера от текущего пользователя.
+// DELETE /api/v1/me/identities/:provider
+func UnlinkIdentityHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		if err := UnlinkIdentity(c.Request.Context(), db, userID, c.Param("provider")); err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
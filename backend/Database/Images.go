@@ -0,0 +1,81 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif" // Регистрирует декодер GIF для image.Decode
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Регистрирует декодер WebP для image.Decode
+)
+
+// maxImagePixels защищает от «бомб» — маленьких файлов с огромным разрешением.
+const maxImagePixels = 40_000_000
+
+// errImageTooLarge возвращается, если разрешение изображения превышает maxImagePixels.
+var errImageTooLarge = errors.New("разрешение изображения слишком большое")
+
+// decodeImage декодирует изображение, предварительно проверив его размеры по заголовку.
+func decodeImage(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, errImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// resizeImage вписывает изображение в квадрат maxSide×maxSide с сохранением пропорций.
+// Изображения меньше квадрата не увеличиваются.
+func resizeImage(img image.Image, maxSide int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSide && h <= maxSide {
+		return img
+	}
+	if w >= h {
+		h = h * maxSide / w
+		w = maxSide
+	} else {
+		w = w * maxSide / h
+		h = maxSide
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// encodeImage кодирует изображение: PNG для форматов с прозрачностью, иначе JPEG.
+// Возвращает данные и их Content-Type.
+func encodeImage(img image.Image, sourceType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	switch sourceType {
+	case "image/png", "image/gif":
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/png", nil
+	default:
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+}
+
+// cropSquare вырезает из центра изображения квадрат по меньшей стороне.
+func cropSquare(img image.Image) image.Image {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), img, image.Pt(x0, y0), draw.Src)
+	return dst
+}
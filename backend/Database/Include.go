@@ -0,0 +1,131 @@
+package database
+
+import (
+	apperr "REVFORUM/apperr"
+	cache "REVFORUM/cache"
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AuthorSummary — краткие сведения об авторе для встраивания в списки топиков и постов.
+type AuthorSummary struct {
+	ID          uint   `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	Role        string `json:"role"`
+	PostCount   int64  `json:"post_count"`
+}
+
+// parseIncludes разбирает параметр ?include=a,b и проверяет, что запрошены
+// только разрешенные для этого списка связи.
+func parseIncludes(c *gin.Context, allowed ...string) (map[string]bool, error) {
+	includes := map[string]bool{}
+	raw := c.Query("include")
+	if raw == "" {
+		return includes, nil
+	}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		ok := false
+		for _, a := range allowed {
+			if name == a {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, apperr.New(apperr.InvalidInclude).
+				Param("value", name).
+				Param("allowed", strings.Join(allowed, ", "))
+		}
+		includes[name] = true
+	}
+	return includes, nil
+}
+
+// loadAuthors загружает сводки авторов двумя запросами независимо от их числа:
+// пользователи и количество их постов.
+func loadAuthors(db *gorm.DB, ids []uint) (map[uint]*AuthorSummary, error) {
+	authors := map[uint]*AuthorSummary{}
+	ids = uniqueIDs(ids)
+	if len(ids) == 0 {
+		return authors, nil
+	}
+
+	var users []User
+	if err := db.Select("id", "username", "display_name", "avatar_key", "role").
+		Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		authors[u.ID] = &AuthorSummary{
+			ID:          u.ID,
+			Username:    u.Username,
+			DisplayName: u.DisplayName,
+			AvatarURL:   avatarURL(u),
+			Role:        u.Role,
+		}
+	}
+
+	var counts []struct {
+		AuthorID uint
+		Count    int64
+	}
+	if err := db.Model(&Post{}).Select("author_id, COUNT(*) AS count").
+		Where("author_id IN ?", ids).Group("author_id").Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		if a := authors[c.AuthorID]; a != nil {
+			a.PostCount = c.Count
+		}
+	}
+	return authors, nil
+}
+
+// countPostsByTopic считает посты для набора топиков одним запросом.
+func countPostsByTopic(db *gorm.DB, topicIDs []uint) (map[uint]int64, error) {
+	counts := map[uint]int64{}
+	if len(topicIDs) == 0 {
+		return counts, nil
+	}
+	var rows []struct {
+		TopicID uint
+		Count   int64
+	}
+	if err := db.Model(&Post{}).Select("topic_id, COUNT(*) AS count").
+		Where("topic_id IN ?", topicIDs).Group("topic_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		counts[r.TopicID] = r.Count
+	}
+	return counts, nil
+}
+
+// uniqueIDs убирает повторы, сохраняя порядок.
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	out := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// invalidateAuthorListings сбрасывает кэш всех списков, куда встраиваются
+// сведения об авторах (после смены имени, аватара, подписи или роли, а также
+// создания и удаления постов: в сведения входит число постов автора).
+func invalidateAuthorListings(ctx context.Context, store cache.Store) {
+	cache.InvalidateContext(ctx, store, cache.Group(CacheTopics), cache.Group(CachePosts))
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// Mention — упоминание @имя в тексте поста, подгружается по ?include=mentions.
+type Mention struct {
+	Name     string `json:"name"`     // Имя, как оно написано в тексте, без @
+	UserID   uint   `json:"user_id"`  // Упомянутый пользователь
+	Username string `json:"username"` // Его текущее имя; отличается от Name, если упомянуто прежнее
+}
+
+// mentionPattern — @имя в начале текста или после символа, который не может
+// быть частью имени или email (user@example.com — не упоминание).
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@-])@([\p{L}\p{N}_.-]+)`)
+
+// mentionNames извлекает имена из текста без повторов (с точностью до ключа).
+// Точка и дефис в конце считаются знаками препинания: «спасибо, @bob.»
+func mentionNames(text string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		name := strings.TrimRight(m[1], ".-")
+		if n := utf8.RuneCountInString(name); n < usernameMinLength || n > usernameMaxLength {
+			continue
+		}
+		if key := CanonicalUsername(name); !seen[key] {
+			seen[key] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Имя в упоминании относится к тому, кто носил его, когда пост был написан,
+// а не к нынешнему владельцу: после удержания имя может занять другой
+// пользователь, и старые упоминания не должны вести к нему. Если в тот момент
+// имя было свободно, упоминание ведет к последнему, кто от него отказался
+// (как findUserByName), а если таких не было — к нынешнему владельцу.
+
+// nameTenure — время, когда пользователь носил имя: [from, until); until
+// нулевое, если носит до сих пор.
+type nameTenure struct {
+	userID      uint
+	from, until time.Time
+}
+
+// mentionOwners — владельцы упомянутых имен по ключу, в порядке времени.
+type mentionOwners struct {
+	tenures map[string][]nameTenure
+	names   map[uint]string // Текущие имена владельцев
+}
+
+// ownerAt возвращает, к кому относилось имя с ключом key в момент at.
+func (o mentionOwners) ownerAt(key string, at time.Time) (uint, bool) {
+	var current, former uint
+	for _, t := range o.tenures[key] {
+		if !at.Before(t.from) && (t.until.IsZero() || at.Before(t.until)) {
+			return t.userID, true
+		}
+		if t.until.IsZero() {
+			current = t.userID
+		} else if !at.Before(t.until) {
+			former = t.userID // Записи идут по времени: остается последний отказавшийся
+		}
+	}
+	if former != 0 {
+		return former, true
+	}
+	return current, current != 0
+}
+
+// resolveMentions загружает владельцев имен тремя запросами независимо от
+// числа имен: нынешние владельцы, история имен их и прежних владельцев
+// и сами прежние владельцы. Как и у findUserByName, точное совпадение
+// нынешнего имени важнее совпадения ключа.
+func resolveMentions(db *gorm.DB, names []string) (mentionOwners, error) {
+	owners := mentionOwners{tenures: map[string][]nameTenure{}, names: map[uint]string{}}
+	if len(names) == 0 {
+		return owners, nil
+	}
+	exact := make([]string, len(names))
+	keys := make([]string, len(names))
+	for i, name := range names {
+		exact[i], keys[i] = normalizeUsername(name), CanonicalUsername(name)
+	}
+
+	// 1. Нынешние владельцы
+	var users []User
+	if err := db.Select("id", "username", "username_key", "created_at").
+		Where("username IN ? OR username_key IN ?", exact, keys).Find(&users).Error; err != nil {
+		return owners, err
+	}
+	byExact, byKey := map[string]User{}, map[string]User{}
+	registered := map[uint]time.Time{}
+	var holderIDs []uint
+	for _, u := range users {
+		byExact[u.Username] = u
+		if u.UsernameKey != nil {
+			byKey[*u.UsernameKey] = u
+		}
+		owners.names[u.ID] = u.Username
+		registered[u.ID] = u.CreatedAt
+		holderIDs = append(holderIDs, u.ID)
+	}
+	holders := map[string]uint{}
+	for i := range names {
+		u, ok := byExact[exact[i]]
+		if !ok {
+			u, ok = byKey[keys[i]]
+		}
+		if ok {
+			holders[keys[i]] = u.ID
+		}
+	}
+
+	// 2. Вся история имен нынешних и прежних владельцев: по ней видно,
+	// с какого момента каждый носил имя
+	var changes []UsernameChange
+	err := db.Select("user_id", "old_key", "created_at").
+		Where("user_id IN (?) OR user_id IN ?",
+			db.Model(&UsernameChange{}).Select("user_id").Where("old_key IN ?", keys), holderIDs).
+		Order("created_at ASC").Find(&changes).Error
+	if err != nil {
+		return owners, err
+	}
+
+	// 3. Прежние владельцы, которых еще нет среди нынешних
+	var missing []uint
+	for _, ch := range changes {
+		if _, ok := owners.names[ch.UserID]; !ok {
+			missing = append(missing, ch.UserID)
+		}
+	}
+	if missing = uniqueIDs(missing); len(missing) > 0 {
+		var former []User
+		if err := db.Select("id", "username", "created_at").Where("id IN ?", missing).Find(&former).Error; err != nil {
+			return owners, err
+		}
+		for _, u := range former {
+			owners.names[u.ID] = u.Username
+			registered[u.ID] = u.CreatedAt
+		}
+	}
+
+	// Имя, от которого отказались, носили с предыдущей смены (или с регистрации)
+	wanted := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		wanted[k] = true
+	}
+	since := map[uint]time.Time{}
+	for _, ch := range changes {
+		from, ok := since[ch.UserID]
+		if !ok {
+			from = registered[ch.UserID]
+		}
+		since[ch.UserID] = ch.CreatedAt
+		if wanted[ch.OldKey] && owners.names[ch.UserID] != "" {
+			owners.tenures[ch.OldKey] = append(owners.tenures[ch.OldKey], nameTenure{userID: ch.UserID, from: from, until: ch.CreatedAt})
+		}
+	}
+	for key, id := range holders {
+		from, ok := since[id]
+		if !ok {
+			from = registered[id]
+		}
+		owners.tenures[key] = append(owners.tenures[key], nameTenure{userID: id, from: from})
+	}
+	return owners, nil
+}
+
+// fillMentions заполняет Mentions у постов по владельцам имен на момент
+// создания поста; неизвестные имена пропускаются.
+func fillMentions(db *gorm.DB, posts []Post) error {
+	perPost := make([][]string, len(posts))
+	var all []string
+	for i, p := range posts {
+		perPost[i] = mentionNames(p.Content)
+		all = append(all, perPost[i]...)
+	}
+	owners, err := resolveMentions(db, all)
+	if err != nil {
+		return err
+	}
+	for i, names := range perPost {
+		for _, name := range names {
+			if id, ok := owners.ownerAt(CanonicalUsername(name), posts[i].CreatedAt); ok {
+				posts[i].Mentions = append(posts[i].Mentions, Mention{Name: name, UserID: id, Username: owners.names[id]})
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,371 @@
+package database
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Клиент OpenID Connect: поток authorization code с PKCE (RFC 7636).
+//
+// Настройки провайдера берутся из его документа discovery
+// (<issuer>/.well-known/openid-configuration), ключи подписи — из jwks_uri.
+// Документ и ключи запрашиваются при первом входе и кэшируются; ключи
+// перечитываются, если в id_token встретился незнакомый kid (провайдер
+// сменил ключ). Поддерживается подпись RS256 — ее обязан уметь любой
+// провайдер OIDC. Данные пользователя берутся из id_token, userinfo
+// не запрашивается.
+
+const (
+	oidcHTTPTimeout = 10 * time.Second
+	oidcClockSkew   = time.Minute // Допустимое расхождение часов с провайдером
+)
+
+// OIDCProvider — внешний провайдер входа.
+type OIDCProvider struct {
+	Name         string   // Имя в пути /api/v1/auth/oidc/:provider
+	DisplayName  string   // Для кнопки «Войти через …»
+	Issuer       string   // Должен совпадать с issuer в discovery и iss в id_token
+	ClientID     string   // Выдается провайдером при регистрации приложения
+	ClientSecret string   // Пусто — публичный клиент, защищенный только PKCE
+	RedirectURL  string   // Страница фронтенда, которая получит code и state
+	Scopes       []string // Всегда включает openid
+
+	HTTPClient *http.Client // nil — клиент с таймаутом oidcHTTPTimeout
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey // Ключи подписи по kid
+}
+
+// OIDCProviders — настроенные провайдеры по имени.
+type OIDCProviders map[string]*OIDCProvider
+
+// OIDCProvidersFromEnv читает провайдеров из OIDC_PROVIDERS (имена через
+// запятую) и для каждого имени NAME — OIDC_NAME_ISSUER, OIDC_NAME_CLIENT_ID,
+// OIDC_NAME_CLIENT_SECRET, OIDC_NAME_REDIRECT_URL, а также необязательные
+// OIDC_NAME_DISPLAY_NAME и OIDC_NAME_SCOPES (через пробел, по умолчанию
+// «openid email profile»). Без OIDC_PROVIDERS вход через провайдеров выключен.
+func OIDCProvidersFromEnv() OIDCProviders {
+	providers := OIDCProviders{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		env := func(key string) string {
+			return os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + key)
+		}
+		p := &OIDCProvider{
+			Name:         name,
+			DisplayName:  env("DISPLAY_NAME"),
+			Issuer:       env("ISSUER"),
+			ClientID:     env("CLIENT_ID"),
+			ClientSecret: env("CLIENT_SECRET"),
+			RedirectURL:  env("REDIRECT_URL"),
+			Scopes:       strings.Fields(env("SCOPES")),
+		}
+		if p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+			log.Fatal("OIDC provider ", name, ": ISSUER, CLIENT_ID and REDIRECT_URL are required")
+		}
+		providers[name] = p
+	}
+	return providers
+}
+
+// oidcDiscovery — нужная часть документа discovery.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcClaims — поля id_token, которые проверяются или используются.
+type oidcClaims struct {
+	Issuer            string       `json:"iss"`
+	Subject           string       `json:"sub"`
+	Audience          oidcAudience `json:"aud"`
+	AuthorizedParty   string       `json:"azp"`
+	Expiry            int64        `json:"exp"`
+	IssuedAt          int64        `json:"iat"`
+	Nonce             string       `json:"nonce"`
+	Email             string       `json:"email"`
+	EmailVerified     bool         `json:"email_verified"`
+	PreferredUsername string       `json:"preferred_username"`
+	Name              string       `json:"name"`
+}
+
+// oidcAudience — aud: по спецификации строка или массив строк.
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = oidcAudience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (p *OIDCProvider) title() string {
+	if p.DisplayName != "" {
+		return p.DisplayName
+	}
+	return p.Name
+}
+
+func (p *OIDCProvider) client() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return &http.Client{Timeout: oidcHTTPTimeout}
+}
+
+// getJSON запрашивает документ провайдера и разбирает его в out.
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// discover возвращает документ discovery; после первого успешного запроса — из памяти.
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var d oidcDiscovery
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	// Подмененный документ не должен выдать себя за другого провайдера
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("discovery: issuer %q, expected %q", d.Issuer, p.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("discovery: authorization_endpoint, token_endpoint and jwks_uri are required")
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// publicKey возвращает ключ подписи kid, при необходимости перечитывая jwks_uri.
+func (p *OIDCProvider) publicKey(ctx context.Context, d *oidcDiscovery, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.keys = keys
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("jwks: no RSA key %q", kid)
+	}
+	return key, nil
+}
+
+// authorizationURL — адрес страницы входа провайдера. code_challenge —
+// SHA-256 от code_verifier, который останется на сервере до обмена кода.
+func (p *OIDCProvider) authorizationURL(d *oidcDiscovery, state, nonce, verifier string) string {
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	} else if !slices.Contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// exchange меняет code на id_token (token endpoint, client_secret_basic).
+func (p *OIDCProvider) exchange(ctx context.Context, d *oidcDiscovery, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("token: status %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token: status %d: %s", resp.StatusCode, body.Error)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token: no id_token in response")
+	}
+	return body.IDToken, nil
+}
+
+// verifyIDToken проверяет подпись и поля id_token: издателя, получателя,
+// срок и nonce, выданный при начале входа.
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, d *oidcDiscovery, raw, nonce string, now time.Time) (oidcClaims, error) {
+	var claims oidcClaims
+
+	// 1. Заголовок, тело и подпись JWT
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("id_token: malformed JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return claims, fmt.Errorf("id_token header: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return claims, fmt.Errorf("id_token: unsupported alg %q", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, fmt.Errorf("id_token signature: %w", err)
+	}
+	key, err := p.publicKey(ctx, d, header.Kid)
+	if err != nil {
+		return claims, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return claims, fmt.Errorf("id_token: %w", err)
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return claims, fmt.Errorf("id_token claims: %w", err)
+	}
+
+	// 2. Токен выдан этим провайдером этому клиенту для этого входа
+	switch {
+	case claims.Issuer != p.Issuer:
+		return claims, fmt.Errorf("id_token: iss %q", claims.Issuer)
+	case !slices.Contains(claims.Audience, p.ClientID):
+		return claims, fmt.Errorf("id_token: aud %v", claims.Audience)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID:
+		return claims, fmt.Errorf("id_token: azp %q", claims.AuthorizedParty)
+	case claims.Nonce == "" || claims.Nonce != nonce:
+		return claims, errors.New("id_token: nonce mismatch")
+	case claims.Subject == "":
+		return claims, errors.New("id_token: empty sub")
+	}
+
+	// 3. Срок действия
+	if now.After(time.Unix(claims.Expiry, 0).Add(oidcClockSkew)) {
+		return claims, errors.New("id_token: expired")
+	}
+	if time.Unix(claims.IssuedAt, 0).After(now.Add(oidcClockSkew)) {
+		return claims, errors.New("id_token: issued in the future")
+	}
+	return claims, nil
+}
+
+// decodeJWTPart разбирает часть JWT (base64url без выравнивания) в out.
+func decodeJWTPart(part string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// randomToken возвращает случайную строку base64url из n байт.
+func randomToken(n int) (string, error) {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
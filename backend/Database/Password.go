@@ -0,0 +1,345 @@
+package database
+
+import (
+	apperr "REVFORUM/apperr"
+	logging "REVFORUM/logging"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Пароли: политика сложности, хэширование и его обновление.
+//
+// Политика проверяется при регистрации, смене и сбросе пароля: длина, число
+// видов символов, запрет имени пользователя и email внутри пароля и список
+// распространенных и утекших паролей (встроенный common_passwords.txt
+// и, если задан, PASSWORD_BLOCKLIST_FILE).
+//
+// Хэш — bcrypt или argon2id в формате PHC
+// ($argon2id$v=19$m=<КиБ>,t=<проходы>,p=<потоки>$<соль>$<хэш>). Хэш,
+// посчитанный другим алгоритмом или слабее текущих настроек, при входе
+// пересчитывается из только что проверенного пароля: новые настройки
+// доходят до всех, кто входит, без сброса паролей.
+
+const (
+	PasswordHashBcrypt   = "bcrypt"
+	PasswordHashArgon2id = "argon2id"
+
+	bcryptMaxBytes = 72 // Дальше bcrypt пароль не читает
+	argon2KeyLen   = 32
+	argon2SaltLen  = 16
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonPasswords — встроенный список в нижнем регистре.
+var commonPasswords = sync.OnceValue(func() map[string]bool {
+	return readPasswordList(strings.NewReader(commonPasswordsFile))
+})
+
+// PasswordPolicy — требования к паролям и настройки хэширования.
+// Нулевое значение — настройки по умолчанию.
+type PasswordPolicy struct {
+	MinLength  int             // Символов; 0 — 8
+	MinClasses int             // Видов символов: строчные, прописные, цифры, прочие; 0 — 1
+	Blocklist  map[string]bool // Запрещенные пароли сверх встроенного списка, в нижнем регистре
+
+	Hash          string // PasswordHashBcrypt или PasswordHashArgon2id; пусто — bcrypt
+	BcryptCost    int    // 0 — 12
+	Argon2Time    uint32 // Проходов; 0 — 3
+	Argon2Memory  uint32 // КиБ; 0 — 64 МиБ
+	Argon2Threads uint8  // 0 — 4
+}
+
+// PasswordPolicyFromEnv читает политику из PASSWORD_MIN_LENGTH,
+// PASSWORD_MIN_CLASSES, PASSWORD_BLOCKLIST_FILE (по паролю в строке),
+// PASSWORD_HASH (bcrypt или argon2id), PASSWORD_BCRYPT_COST
+// и PASSWORD_ARGON2_TIME, PASSWORD_ARGON2_MEMORY (КиБ), PASSWORD_ARGON2_THREADS.
+func PasswordPolicyFromEnv() PasswordPolicy {
+	number := func(name string, max int) int {
+		v := os.Getenv(name)
+		if v == "" {
+			return 0
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > max {
+			log.Fatal("Invalid ", name, ": ", v)
+		}
+		return n
+	}
+	p := PasswordPolicy{
+		MinLength:     number("PASSWORD_MIN_LENGTH", 1024),
+		MinClasses:    number("PASSWORD_MIN_CLASSES", 4),
+		Hash:          os.Getenv("PASSWORD_HASH"),
+		BcryptCost:    number("PASSWORD_BCRYPT_COST", bcrypt.MaxCost),
+		Argon2Time:    uint32(number("PASSWORD_ARGON2_TIME", 100)),
+		Argon2Memory:  uint32(number("PASSWORD_ARGON2_MEMORY", 4<<20)),
+		Argon2Threads: uint8(number("PASSWORD_ARGON2_THREADS", 255)),
+	}
+	if p.Hash != "" && p.Hash != PasswordHashBcrypt && p.Hash != PasswordHashArgon2id {
+		log.Fatal("Invalid PASSWORD_HASH: ", p.Hash)
+	}
+	if p.BcryptCost != 0 && p.BcryptCost < bcrypt.MinCost {
+		log.Fatal("Invalid PASSWORD_BCRYPT_COST: ", p.BcryptCost)
+	}
+	if path := os.Getenv("PASSWORD_BLOCKLIST_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal("Cannot read PASSWORD_BLOCKLIST_FILE: ", err)
+		}
+		defer f.Close()
+		p.Blocklist = readPasswordList(f)
+	}
+	return p
+}
+
+// readPasswordList читает пароли по одному в строке; # — комментарий.
+func readPasswordList(r io.Reader) map[string]bool {
+	list := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			list[strings.ToLower(line)] = true
+		}
+	}
+	return list
+}
+
+// withDefaults подставляет значения по умолчанию вместо нулевых.
+func (p PasswordPolicy) withDefaults() PasswordPolicy {
+	if p.MinLength == 0 {
+		p.MinLength = 8
+	}
+	if p.MinClasses == 0 {
+		p.MinClasses = 1
+	}
+	if p.Hash == "" {
+		p.Hash = PasswordHashBcrypt
+	}
+	if p.BcryptCost == 0 {
+		p.BcryptCost = 12
+	}
+	if p.Argon2Time == 0 {
+		p.Argon2Time = 3
+	}
+	if p.Argon2Memory == 0 {
+		p.Argon2Memory = 64 << 10
+	}
+	if p.Argon2Threads == 0 {
+		p.Argon2Threads = 4
+	}
+	return p
+}
+
+// check проверяет пароль пользователя username с адресом email. Нарушения
+// возвращаются ошибками поля field в VALIDATION_FAILED.
+func (p PasswordPolicy) check(field, password, username, email string) error {
+	p = p.withDefaults()
+	e := apperr.New(apperr.ValidationFailed)
+
+	// 1. Длина: bcrypt не различает пароли длиннее 72 байт
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		e.Field(field, "min", strconv.Itoa(p.MinLength))
+	}
+	if p.Hash == PasswordHashBcrypt && len(password) > bcryptMaxBytes {
+		e.Field(field, "max_bytes", strconv.Itoa(bcryptMaxBytes))
+	}
+
+	// 2. Виды символов
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if lower+upper+digit+other < p.MinClasses {
+		e.Field(field, "password_classes", strconv.Itoa(p.MinClasses))
+	}
+
+	// 3. Имя пользователя и email внутри пароля
+	lowered := strings.ToLower(password)
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	for _, personal := range []string{strings.ToLower(username), local} {
+		if utf8.RuneCountInString(personal) >= 3 && strings.Contains(lowered, personal) {
+			e.Field(field, "password_personal", "")
+			break
+		}
+	}
+
+	// 4. Распространенные и утекшие пароли
+	if commonPasswords()[lowered] || p.Blocklist[lowered] {
+		e.Field(field, "password_common", "")
+	}
+
+	if len(e.Fields) > 0 {
+		return e
+	}
+	return nil
+}
+
+// hash возвращает хэш пароля выбранным алгоритмом.
+func (p PasswordPolicy) hash(password string) (string, error) {
+	p = p.withDefaults()
+	if p.Hash == PasswordHashArgon2id {
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Argon2Memory, p.Argon2Time, p.Argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+	return string(hash), err
+}
+
+// argon2Hash — разобранный хэш argon2id.
+type argon2Hash struct {
+	memory, time uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+func parseArgon2(hash string) (argon2Hash, bool) {
+	var h argon2Hash
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != PasswordHashArgon2id || parts[2] != "v="+strconv.Itoa(argon2.Version) {
+		return h, false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil || h.time == 0 || h.threads == 0 {
+		return h, false
+	}
+	var err1, err2 error
+	h.salt, err1 = base64.RawStdEncoding.DecodeString(parts[4])
+	h.key, err2 = base64.RawStdEncoding.DecodeString(parts[5])
+	return h, err1 == nil && err2 == nil && len(h.key) > 0
+}
+
+// verifyPassword сверяет пароль с хэшем любого поддерживаемого алгоритма.
+// Хэш noPassword (учетная запись без пароля) не подходит ни к одному паролю.
+func verifyPassword(hash, password string) bool {
+	if h, ok := parseArgon2(hash); ok {
+		key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+		return subtle.ConstantTimeCompare(key, h.key) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// needsRehash сообщает, что хэш посчитан другим алгоритмом или слабее
+// текущих настроек. Понижение настроек старые хэши не трогает.
+func (p PasswordPolicy) needsRehash(hash string) bool {
+	p = p.withDefaults()
+	if p.Hash == PasswordHashArgon2id {
+		h, ok := parseArgon2(hash)
+		return !ok || h.memory < p.Argon2Memory || h.time < p.Argon2Time || h.threads < p.Argon2Threads
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < p.BcryptCost
+}
+
+// rehashPassword пересчитывает хэш только что проверенного пароля, если
+// настройки хэширования изменились. Ошибка не мешает входу и только пишется в журнал.
+func rehashPassword(ctx context.Context, db *gorm.DB, user User, password string, policy PasswordPolicy) {
+	if !policy.needsRehash(user.PasswordHash) {
+		return
+	}
+	hash, err := policy.hash(password)
+	if err == nil {
+		// Условие на старый хэш: параллельная смена пароля не перезаписывается
+		err = db.Model(&User{}).Where("id = ? AND password_hash = ?", user.ID, user.PasswordHash).Update("password_hash", hash).Error
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to rehash password", "user_id", user.ID, "error", err)
+		return
+	}
+	logging.FromContext(ctx).Info("password rehashed", "user_id", user.ID, "algorithm", policy.withDefaults().Hash)
+}
+
+// ChangePasswordRequest — смена пароля владельцем учетной записи.
+// Текущий пароль не нужен только учетной записи без пароля (созданной
+// входом через провайдера OIDC).
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// ChangePassword меняет пароль после проверки текущего и завершает все
+// сессии пользователя, кроме keepSessionID. Возвращает число завершенных сессий.
+func ChangePassword(ctx context.Context, db *gorm.DB, userID, keepSessionID uint, req ChangePasswordRequest, policy PasswordPolicy) (int64, error) {
+	if err := validate(&req); err != nil {
+		return 0, err
+	}
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return 0, err
+	}
+	if user.PasswordHash != noPassword && !verifyPassword(user.PasswordHash, req.CurrentPassword) {
+		return 0, apperr.New(apperr.InvalidCurrentPassword)
+	}
+	if err := policy.check("new_password", req.NewPassword, user.Username, user.Email); err != nil {
+		return 0, err
+	}
+	hash, err := policy.hash(req.NewPassword)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to hash password", "error", err)
+		return 0, apperr.Wrap(err)
+	}
+	if err := db.Model(&user).Update("password_hash", hash).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to change password", "user_id", userID, "error", err)
+		return 0, apperr.Wrap(err)
+	}
+	logging.FromContext(ctx).Info("password changed", "user_id", userID)
+	return RevokeSessions(ctx, db, userID, keepSessionID)
+}
+
+// ChangePasswordHandler меняет пароль текущего пользователя. Остальные
+// сессии завершаются: кто бы ни знал старый пароль, он больше не в системе.
+// PUT /api/v1/me/password
+func ChangePasswordHandler(db *gorm.DB, policy PasswordPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		var req ChangePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperr.Render(c, apperr.Binding(err))
+			return
+		}
+
+		revoked, err := ChangePassword(c.Request.Context(), db, userID, currentSessionID(c), req, policy)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Пароль изменен", "revoked_sessions": revoked})
+	}
+}
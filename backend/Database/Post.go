@@ -1,9 +1,15 @@
 package database
 
 import (
+	apperr "REVFORUM/apperr"
+	cache "REVFORUM/cache"
+	logging "REVFORUM/logging"
+	metrics "REVFORUM/metrics"
+	realtime "REVFORUM/realtime"
+	storage "REVFORUM/storage"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -11,112 +17,384 @@ import (
 
 // Post представляет одно сообщение (пост) в топике.
 type Post struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Content   string    `gorm:"type:text;not null" json:"content"` // Содержание сообщения, обязательно
-	CreatedAt time.Time `json:"created_at"`                        // Дата создания
-	UpdatedAt time.Time `json:"updated_at"`                        // Дата последнего обновления
-	AuthorID  uint      `gorm:"not null" json:"author_id"`         // ID автора (ссылка на User)
-	TopicID   uint      `gorm:"not null;index" json:"topic_id"`    // ID родительского топика (ссылка на Topic)
-	// Поля для связи (не сериализуются в JSON по умолчанию)
-	// Author User  `gorm:"foreignKey:AuthorID"` // Связь с пользователем
-	// Topic  Topic `gorm:"foreignKey:TopicID"`  // Связь с топиком
+	ID              uint         `gorm:"primaryKey" json:"id"`
+	Content         string       `gorm:"type:text;not null" json:"content"`    // Содержание сообщения, обязательно
+	CreatedAt       time.Time    `json:"created_at"`                           // Дата создания
+	UpdatedAt       time.Time    `json:"updated_at"`                           // Дата последнего обновления
+	AuthorID        uint         `gorm:"not null" json:"author_id"`            // ID автора (ссылка на User)
+	TopicID         uint         `gorm:"not null;index" json:"topic_id"`       // ID родительского топика (ссылка на Topic)
+	Attachments     []Attachment `gorm:"foreignKey:PostID" json:"attachments"` // Прикрепленные файлы
+	AuthorSignature string       `gorm:"-" json:"author_signature,omitempty"`  // Подпись автора, выводится под сообщением
+	// Связанные объекты, подгружаются по ?include= (см. Include.go)
+	Author   *AuthorSummary `gorm:"-" json:"author,omitempty"`
+	Topic    *Topic         `gorm:"-" json:"topic,omitempty"`
+	Mentions []Mention      `gorm:"-" json:"mentions,omitempty"` // Найденные @имя, в том числе по прежним именам (см. Mention.go)
 }
 
 // CreatePostRequest структура для входящих данных при создании поста.
 type CreatePostRequest struct {
 	Content string `json:"content" binding:"required"`  // Обязательное поле
 	TopicID uint   `json:"topic_id" binding:"required"` // Обязательное поле
+	// ID заранее загруженных вложений (POST /api/v1/attachments)
+	AttachmentIDs []uint `json:"attachment_ids" binding:"max=10,unique"`
 	// AuthorID будет браться из токена/сессии пользователя
 }
 
 // CreatePostHandler обработчик для создания нового поста.
-// POST /api/posts
-func CreatePostHandler(c *gin.Context) {
-	// TODO: Получить userID из JWT токена (после реализации аутентификации)
-	// userID := getUserIDFromContext(c) // Пример функции
-	// if userID == 0 {
-	// 	c.JSON(http.StatusUnauthorized, gin.H{"error": "Пользователь не аутентифицирован"})
-	// 	return
-	// }
-	// Пока используем фиктивный ID для тестирования
-	userID := uint(1) // ЗАМЕНИТЬ НА РЕАЛЬНЫЙ ID ИЗ ТОКЕНА
-
-	var req CreatePostRequest
-
-	// 1. Парсинг и валидация JSON
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные", "details": err.Error()})
-		return
-	}
+// POST /api/v1/topics/:id/posts (топик из пути)
+// POST /api/themes/subthemes/topics/posts (устаревший, topic_id в теле)
+func CreatePostHandler(db *gorm.DB, store cache.Store, events *realtime.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
 
-	// 2. (Опционально) Проверка существования Topic
-	var topic Topic
-	if err := DB.First(&topic, req.TopicID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Указанный топик не существует"})
+		userID, ok := requireUser(c)
+		if !ok {
 			return
 		}
-		log.Printf("Ошибка БД при поиске топика: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
-		return
+
+		var req CreatePostRequest
+
+		// 1. Парсинг и валидация JSON
+		if err := bindCreate(c, &req, &req.TopicID); err != nil {
+			apperr.Render(c, err)
+			return
+		}
+
+		// 2. (Опционально) Проверка существования Topic
+		var topic Topic
+		if err := db.First(&topic, req.TopicID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				apperr.Render(c, apperr.New(apperr.TopicNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load topic", "topic_id", req.TopicID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		if topic.Locked {
+			apperr.Render(c, apperr.New(apperr.TopicLocked))
+			return
+		}
+
+		// 3. Создание объекта поста
+		newPost := Post{
+			Content:  req.Content,
+			AuthorID: userID, // Устанавливаем ID автора
+			TopicID:  req.TopicID,
+			// CreatedAt и UpdatedAt заполнятся автоматически
+		}
+
+		// 4. Сохранение в БД вместе с привязкой вложений (в одной транзакции)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&newPost).Error; err != nil {
+				return err
+			}
+			if len(req.AttachmentIDs) == 0 {
+				return nil
+			}
+			// Привязать можно только свои и еще не привязанные вложения
+			result := tx.Model(&Attachment{}).
+				Where("id IN ? AND author_id = ? AND post_id IS NULL", req.AttachmentIDs, userID).
+				Update("post_id", newPost.ID)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected != int64(len(req.AttachmentIDs)) {
+				return errAttachmentsUnavailable
+			}
+			return tx.Where("post_id = ?", newPost.ID).Order("id ASC").Find(&newPost.Attachments).Error
+		})
+		if errors.Is(err, errAttachmentsUnavailable) {
+			apperr.Render(c, apperr.New(apperr.AttachmentsUnavailable))
+			return
+		}
+		if err != nil {
+			logging.L(c).Error("failed to create post", "topic_id", req.TopicID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		metrics.PostsCreated.Inc()
+
+		// 5. (Опционально) Обновить updated_at у топика
+		// db.Model(&topic).Update("updated_at", time.Now())
+
+		// 6. Сброс кэша: посты топика, счетчик постов в топиках подтемы и у автора
+		invalidateAuthorListings(c.Request.Context(), store)
+
+		// 7. Уведомление подписчиков топика и его подтемы
+		events.Publish(c.Request.Context(), realtime.PostCreated, realtime.TopicChannel(newPost.TopicID), newPost)
+		events.Publish(c.Request.Context(), realtime.PostCreated, realtime.SubThemeChannel(topic.SubThemeID), newPost)
+
+		// 8. Отправка успешного ответа (201 Created)
+		// Можно вернуть полный объект или только ID и сообщение
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Сообщение успешно отправлено",
+			"post":    newPost, // Возвращаем созданный пост
+		})
 	}
+}
+
+// GetPostsByTopicHandler обработчик для получения списка постов по ID топика.
+// GET /api/v1/topics/:id/posts
+func GetPostsByTopicHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		// 1. Получение ID топика из параметров URL
+		topicIDStr := c.Param("id") // :id из маршрута
+		topicID, err := strconv.ParseUint(topicIDStr, 10, 32)
+		if err != nil {
+			apperr.Render(c, apperr.New(apperr.InvalidParameter).Param("name", "id"))
+			return
+		}
+
+		// 2. Разбор ?include= (author — сводка автора, topic — сам топик, mentions — упомянутые пользователи)
+		includes, err := parseIncludes(c, "author", "topic", "mentions")
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
 
-	// 3. Создание объекта поста
-	newPost := Post{
-		Content:  req.Content,
-		AuthorID: userID, // Устанавливаем ID автора
-		TopicID:  req.TopicID,
-		// CreatedAt и UpdatedAt заполнятся автоматически
+		// 3. Подготовка переменной для результата
+		var posts []Post
+
+		// 4. Запрос к БД для получения постов с указанным TopicID
+		// Сортируем по дате создания, старые первыми
+		// Вложения подгружаются одним дополнительным запросом для всех постов
+		if err := db.Where("topic_id = ?", topicID).Order("created_at ASC").
+			Preload("Attachments", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+			Find(&posts).Error; err != nil {
+			logging.L(c).Error("failed to list posts", "topic_id", topicID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		// 5. Подписи авторов — одним запросом для всех постов
+		if err := fillSignatures(db, posts); err != nil {
+			logging.L(c).Error("failed to load author signatures", "topic_id", topicID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		// 6. Связанные объекты по ?include= — по одному-два запроса на связь
+		if includes["author"] {
+			authorIDs := make([]uint, len(posts))
+			for i, p := range posts {
+				authorIDs[i] = p.AuthorID
+			}
+			authors, err := loadAuthors(db, authorIDs)
+			if err != nil {
+				logging.L(c).Error("failed to load post authors", "topic_id", topicID, "error", err)
+				apperr.Render(c, apperr.Wrap(err))
+				return
+			}
+			for i := range posts {
+				posts[i].Author = authors[posts[i].AuthorID]
+			}
+		}
+		if includes["topic"] {
+			var topic Topic
+			err := db.First(&topic, topicID).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				logging.L(c).Error("failed to load topic", "topic_id", topicID, "error", err)
+				apperr.Render(c, apperr.Wrap(err))
+				return
+			}
+			if err == nil {
+				for i := range posts {
+					posts[i].Topic = &topic
+				}
+			}
+		}
+		if includes["mentions"] {
+			if err := fillMentions(db, posts); err != nil {
+				logging.L(c).Error("failed to resolve mentions", "topic_id", topicID, "error", err)
+				apperr.Render(c, apperr.Wrap(err))
+				return
+			}
+		}
+
+		// 7. Отправка результата в JSON
+		c.JSON(http.StatusOK, posts) // Отправляем массив постов
 	}
+}
 
-	// 4. Сохранение в БД
-	if err := DB.Create(&newPost).Error; err != nil {
-		log.Printf("Ошибка создания поста в БД: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания поста", "details": err.Error()})
-		return
+// GetPostHandler обработчик для получения поста по ID.
+// GET /api/v1/posts/:id
+func GetPostHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		id, err := pathID(c)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		post, err := loadPost(db, id)
+		if err != nil {
+			renderPostError(c, id, err)
+			return
+		}
+		c.JSON(http.StatusOK, post)
 	}
+}
+
+// UpdatePostRequest — новый текст поста.
+type UpdatePostRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+// UpdatePostHandler изменяет текст поста. Доступно автору, модераторам и администраторам.
+// PATCH /api/v1/posts/:id
+func UpdatePostHandler(db *gorm.DB, store cache.Store, events *realtime.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
 
-	// 5. (Опционально) Обновить updated_at у топика
-	// database.DB.Model(&topic).Update("updated_at", time.Now())
+		// 1. Разбор ID и тела запроса
+		id, err := pathID(c)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		var req UpdatePostRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperr.Render(c, apperr.Binding(err))
+			return
+		}
+
+		// 2. Поиск поста и проверка прав
+		post, err := loadPost(db, id)
+		if err != nil {
+			renderPostError(c, id, err)
+			return
+		}
+		if !requireAuthorOrModerator(c, db, post.AuthorID) {
+			return
+		}
 
-	// 6. Отправка успешного ответа (201 Created)
-	// Можно вернуть полный объект или только ID и сообщение
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Сообщение успешно отправлено",
-		"post":    newPost, // Возвращаем созданный пост
-	})
+		// 3. Сохранение
+		if err := db.Model(&post).Update("content", req.Content).Error; err != nil {
+			logging.L(c).Error("failed to update post", "post_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		// 4. Сброс кэша списка постов топика и уведомление подписчиков
+		var topic Topic
+		if err := db.Select("id", "sub_theme_id").First(&topic, post.TopicID).Error; err != nil {
+			logging.L(c).Error("failed to load topic", "topic_id", post.TopicID, "error", err)
+		}
+		cache.Invalidate(c, store, cache.GroupKey(CachePosts, strconv.FormatUint(uint64(post.TopicID), 10)))
+		events.Publish(c.Request.Context(), realtime.PostUpdated, realtime.TopicChannel(post.TopicID), post)
+		if topic.ID != 0 {
+			events.Publish(c.Request.Context(), realtime.PostUpdated, realtime.SubThemeChannel(topic.SubThemeID), post)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Сообщение обновлено", "post": post})
+	}
 }
 
-// GetPostsByTopicHandler обработчик для получения списка постов по ID топика.
-// GET /api/topics/:id/posts
-// ВАЖНО: Этот маршрут должен идти ПОСЛЕ /api/topics/:id, чтобы не перекрывать его.
-// Лучше использовать отдельный префикс, например GET /api/topics/:id/posts
-// Или изменить маршрут получения топика на /api/topics/:id/details или подобное.
-func GetPostsByTopicHandler(c *gin.Context) {
-	// 1. Получение ID топика из параметров URL
-	topicIDStr := c.Param("id") // :id из маршрута
-	topicID, err := strconv.ParseUint(topicIDStr, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID топика"})
-		return
+// DeletePostHandler удаляет пост вместе с вложениями.
+// Доступно автору, модераторам и администраторам.
+// DELETE /api/v1/posts/:id
+func DeletePostHandler(db *gorm.DB, store cache.Store, events *realtime.Hub, files storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		// 1. Поиск поста и проверка прав
+		id, err := pathID(c)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		post, err := loadPost(db, id)
+		if err != nil {
+			renderPostError(c, id, err)
+			return
+		}
+		if !requireAuthorOrModerator(c, db, post.AuthorID) {
+			return
+		}
+
+		// 2. Первое сообщение удаляется только вместе с топиком (DELETE /topics/:id)
+		var topic Topic
+		if err := db.Select("id", "sub_theme_id", "first_post_id").First(&topic, post.TopicID).Error; err != nil {
+			logging.L(c).Error("failed to load topic", "topic_id", post.TopicID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		if topic.FirstPostID != nil && *topic.FirstPostID == post.ID {
+			apperr.Render(c, apperr.New(apperr.FirstPostNotDeletable))
+			return
+		}
+
+		// 3. Удаление вложений и поста (файлы — после фиксации)
+		var attachments []Attachment
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if attachments, err = DeletePostAttachments(tx, post.ID); err != nil {
+				return err
+			}
+			return tx.Delete(&Post{}, post.ID).Error
+		})
+		if err != nil {
+			logging.L(c).Error("failed to delete post", "post_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		for _, a := range attachments {
+			deleteAttachmentFiles(c.Request.Context(), files, a)
+		}
+
+		// 4. Сброс кэша: посты топика, счетчик постов в топиках подтемы и у автора
+		invalidateAuthorListings(c.Request.Context(), store)
+
+		// 5. Уведомление подписчиков топика и подтемы
+		deleted := gin.H{"id": post.ID, "topic_id": post.TopicID}
+		events.Publish(c.Request.Context(), realtime.PostDeleted, realtime.TopicChannel(post.TopicID), deleted)
+		events.Publish(c.Request.Context(), realtime.PostDeleted, realtime.SubThemeChannel(topic.SubThemeID), deleted)
+
+		c.Status(http.StatusNoContent)
 	}
+}
 
-	// 2. Подготовка переменной для результата
-	var posts []Post
+// loadPost загружает пост с вложениями.
+func loadPost(db *gorm.DB, id uint) (Post, error) {
+	var post Post
+	err := db.Preload("Attachments", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		First(&post, id).Error
+	return post, err
+}
 
-	// 3. Запрос к БД для получения постов с указанным TopicID
-	// Сортируем по дате создания, старые первыми
-	if err := DB.Where("topic_id = ?", topicID).Order("created_at ASC").Find(&posts).Error; err != nil {
-		log.Printf("Ошибка получения постов из БД для topic_id=%d: %v", topicID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сообщений"})
+// renderPostError отвечает на ошибку loadPost.
+func renderPostError(c *gin.Context, id uint, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apperr.Render(c, apperr.New(apperr.PostNotFound))
 		return
 	}
-
-	// 4. Отправка результата в JSON
-	c.JSON(http.StatusOK, posts) // Отправляем массив постов
+	logging.L(c).Error("failed to load post", "post_id", id, "error", err)
+	apperr.Render(c, apperr.Wrap(err))
 }
 
-// TODO: Добавить обработчики для:
-// - Получения конкретного поста по ID (GET /api/posts/:id)
-// - Обновления поста (PUT/PATCH /api/posts/:id) (только автор/модератор)
-// - Удаления поста (DELETE /api/posts/:id) (только автор/модератор/админ)
+// fillSignatures заполняет AuthorSignature у постов.
+func fillSignatures(db *gorm.DB, posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	authorIDs := make([]uint, 0, len(posts))
+	for _, p := range posts {
+		authorIDs = append(authorIDs, p.AuthorID)
+	}
+	var authors []User
+	if err := db.Select("id", "signature").Where("id IN ?", authorIDs).Find(&authors).Error; err != nil {
+		return err
+	}
+	signatures := make(map[uint]string, len(authors))
+	for _, a := range authors {
+		signatures[a.ID] = a.Signature
+	}
+	for i := range posts {
+		posts[i].AuthorSignature = signatures[posts[i].AuthorID]
+	}
+	return nil
+}
@@ -0,0 +1,399 @@
+package database
+
+import (
+	apperr "REVFORUM/apperr"
+	cache "REVFORUM/cache"
+	logging "REVFORUM/logging"
+	storage "REVFORUM/storage"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// avatarSide — размер стороны аватара после обработки на сервере.
+const avatarSide = 256
+
+// maxAvatarSize — максимальный размер загружаемого файла аватара.
+const maxAvatarSize = 2 << 20
+
+// recentTopicsLimit — сколько последних топиков показывать в профиле.
+const recentTopicsLimit = 5
+
+// PublicProfile — публичные данные пользователя для GET /api/v1/users/:id.
+type PublicProfile struct {
+	ID           uint      `json:"id"`
+	Username     string    `json:"username"`
+	DisplayName  string    `json:"display_name"`
+	AvatarURL    string    `json:"avatar_url,omitempty"`
+	Bio          string    `json:"bio"`
+	Signature    string    `json:"signature"`
+	Location     string    `json:"location"`
+	Website      string    `json:"website"`
+	JoinedAt     time.Time `json:"joined_at"`
+	PostCount    int64     `json:"post_count"`
+	Reputation   int       `json:"reputation"`
+	RecentTopics []Topic   `json:"recent_topics"`
+}
+
+// avatarURL возвращает адрес аватара; версия в запросе меняется вместе с файлом,
+// поэтому ответ можно кэшировать без перепроверки.
+func avatarURL(u User) string {
+	if u.AvatarKey == "" {
+		return ""
+	}
+	return "/api/v1/users/" + strconv.FormatUint(uint64(u.ID), 10) + "/avatar?v=" + path.Base(u.AvatarKey)
+}
+
+// ListUsersHandler возвращает список всех пользователей.
+// GET /api/v1/users
+func ListUsersHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		var users []User
+		if err := db.Find(&users).Error; err != nil {
+			logging.L(c).Error("failed to list users", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		c.JSON(http.StatusOK, users)
+	}
+}
+
+// GetUserProfileHandler возвращает публичный профиль пользователя.
+// GET /api/v1/users/:id — ID или имя, в том числе прежнее (см. UsernameChange.go)
+func GetUserProfileHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		// 1. Поиск пользователя по ID или имени из параметров URL
+		user, err := LookupUser(c.Request.Context(), db, c.Param("id"))
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+
+		profile := PublicProfile{
+			ID:          user.ID,
+			Username:    user.Username,
+			DisplayName: user.DisplayName,
+			AvatarURL:   avatarURL(user),
+			Bio:         user.Bio,
+			Signature:   user.Signature,
+			Location:    user.Location,
+			Website:     user.Website,
+			JoinedAt:    user.CreatedAt,
+			Reputation:  user.Reputation,
+		}
+
+		// 2. Статистика: количество постов и последние топики
+		if err := db.Model(&Post{}).Where("author_id = ?", user.ID).Count(&profile.PostCount).Error; err != nil {
+			logging.L(c).Error("failed to count user posts", "user", user.ID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		if err := db.Where("author_id = ?", user.ID).Order("created_at DESC").Limit(recentTopicsLimit).
+			Find(&profile.RecentTopics).Error; err != nil {
+			logging.L(c).Error("failed to load user topics", "user", user.ID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, profile)
+	}
+}
+
+// UpdateProfileRequest — изменяемые поля профиля. Поле, которое не передано,
+// не меняется; пустая строка очищает поле.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name" binding:"omitempty,max=50"`
+	Bio         *string `json:"bio" binding:"omitempty,max=1000"`
+	Signature   *string `json:"signature" binding:"omitempty,max=300"`
+	Location    *string `json:"location" binding:"omitempty,max=100"`
+	Website     *string `json:"website" binding:"omitempty,max=200"`
+}
+
+// UpdateProfileHandler изменяет профиль текущего пользователя.
+// PATCH /api/v1/me/profile
+func UpdateProfileHandler(db *gorm.DB, store cache.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+
+		// 1. Парсинг и валидация JSON
+		var req UpdateProfileRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperr.Render(c, apperr.Binding(err))
+			return
+		}
+
+		// 2. Сбор изменений; пробелы по краям не сохраняем
+		updates := map[string]any{}
+		fields := map[string]*string{
+			"display_name": req.DisplayName,
+			"bio":          req.Bio,
+			"signature":    req.Signature,
+			"location":     req.Location,
+			"website":      req.Website,
+		}
+		for column, value := range fields {
+			if value != nil {
+				updates[column] = strings.TrimSpace(*value)
+			}
+		}
+		if website, ok := updates["website"].(string); ok && website != "" && !isHTTPURL(website) {
+			apperr.Render(c, apperr.New(apperr.ValidationFailed).Field("website", "url", ""))
+			return
+		}
+		if len(updates) == 0 {
+			apperr.Render(c, apperr.New(apperr.NoFieldsToUpdate))
+			return
+		}
+
+		// 3. Сохранение
+		result := db.Model(&User{}).Where("id = ?", userID).Updates(updates)
+		if result.Error != nil {
+			logging.L(c).Error("failed to update profile", "error", result.Error)
+			apperr.Render(c, apperr.Wrap(result.Error))
+			return
+		}
+		if result.RowsAffected == 0 {
+			apperr.Render(c, apperr.New(apperr.UserNotFound))
+			return
+		}
+
+		// 4. Имя и подпись выводятся в списках топиков и постов — сбрасываем их кэш
+		if req.Signature != nil || req.DisplayName != nil {
+			invalidateAuthorListings(c.Request.Context(), store)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Профиль обновлен"})
+	}
+}
+
+// isHTTPURL проверяет, что строка — абсолютная ссылка http(s). Другие схемы
+// (например javascript:) в профиле опасны, так как ссылка выводится другим пользователям.
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// UploadAvatarHandler принимает изображение (multipart, поле "avatar"),
+// обрезает его до квадрата и уменьшает до avatarSide×avatarSide.
+// POST /api/v1/me/avatar
+func UploadAvatarHandler(db *gorm.DB, store cache.Store, files storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+
+		// 1. Чтение файла с ограничением размера
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAvatarSize+1<<20)
+		fileHeader, err := c.FormFile("avatar")
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				apperr.Render(c, apperr.New(apperr.FileTooLarge).Param("max_size", strconv.Itoa(maxAvatarSize)))
+				return
+			}
+			apperr.Render(c, apperr.New(apperr.FileRequired).Param("field", "avatar"))
+			return
+		}
+		if fileHeader.Size > maxAvatarSize {
+			apperr.Render(c, apperr.New(apperr.FileTooLarge).Param("max_size", strconv.Itoa(maxAvatarSize)))
+			return
+		}
+		f, err := fileHeader.Open()
+		if err != nil {
+			logging.L(c).Error("failed to open uploaded avatar", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			logging.L(c).Error("failed to read uploaded avatar", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		// 2. Проверка типа по содержимому и обработка изображения
+		contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+		switch contentType {
+		case "image/jpeg", "image/png", "image/gif", "image/webp":
+		default:
+			apperr.Render(c, apperr.New(apperr.UnsupportedMediaType).
+				Param("content_type", contentType).
+				Param("allowed", "image/jpeg, image/png, image/gif, image/webp"))
+			return
+		}
+		img, err := decodeImage(data)
+		if err != nil {
+			apperr.Render(c, apperr.New(apperr.InvalidImage))
+			return
+		}
+		avatar, avatarType, err := encodeImage(resizeImage(cropSquare(img), avatarSide), contentType)
+		if err != nil {
+			logging.L(c).Error("failed to encode avatar", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		// 3. Поиск пользователя (нужен ключ старого аватара)
+		var user User
+		if err := db.First(&user, userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.UserNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load current user", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		// 4. Сохранение нового файла, затем ссылки на него; старый файл удаляется последним
+		ctx := c.Request.Context()
+		ext := ".jpg"
+		if avatarType == "image/png" {
+			ext = ".png"
+		}
+		key := newStorageKey("avatars") + ext
+		if err := files.Put(ctx, key, bytes.NewReader(avatar), int64(len(avatar)), avatarType); err != nil {
+			logging.L(c).Error("failed to store avatar", "key", key, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		oldKey := user.AvatarKey // Запоминаем до Update: GORM записывает новое значение в user
+		if err := db.Model(&user).Update("avatar_key", key).Error; err != nil {
+			logging.L(c).Error("failed to save avatar key", "error", err)
+			files.Delete(ctx, key)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		invalidateAuthorListings(c.Request.Context(), store)
+		if oldKey != "" {
+			if err := files.Delete(ctx, oldKey); err != nil {
+				logging.L(c).Warn("failed to delete old avatar", "key", oldKey, "error", err)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Аватар обновлен", "avatar_url": avatarURL(user)})
+	}
+}
+
+// DeleteAvatarHandler удаляет аватар текущего пользователя.
+// DELETE /api/v1/me/avatar
+func DeleteAvatarHandler(db *gorm.DB, store cache.Store, files storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+
+		var user User
+		if err := db.First(&user, userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.UserNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load current user", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		if user.AvatarKey == "" {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		if err := db.Model(&user).Update("avatar_key", "").Error; err != nil {
+			logging.L(c).Error("failed to clear avatar key", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		invalidateAuthorListings(c.Request.Context(), store)
+		if err := files.Delete(c.Request.Context(), user.AvatarKey); err != nil {
+			logging.L(c).Warn("failed to delete avatar file", "key", user.AvatarKey, "error", err)
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// GetAvatarHandler отдает аватар пользователя.
+// GET /api/v1/users/:id/avatar
+func GetAvatarHandler(db *gorm.DB, files storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			apperr.Render(c, apperr.New(apperr.InvalidParameter).Param("name", "id"))
+			return
+		}
+
+		var user User
+		if err := db.Select("id", "avatar_key").First(&user, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.UserNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load user", "user", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		if user.AvatarKey == "" {
+			apperr.Render(c, apperr.New(apperr.AvatarNotFound))
+			return
+		}
+
+		// Ссылка из avatarURL содержит версию файла, поэтому кэшируем надолго;
+		// по адресу без ?v= браузер все равно перепроверит ETag.
+		etag := `"` + path.Base(user.AvatarKey) + `"`
+		c.Header("ETag", etag)
+		c.Header("X-Content-Type-Options", "nosniff")
+		if c.Query("v") == path.Base(user.AvatarKey) {
+			c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			c.Header("Cache-Control", "public, no-cache")
+		}
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		r, err := files.Get(c.Request.Context(), user.AvatarKey)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				apperr.Render(c, apperr.New(apperr.FileNotFound))
+				return
+			}
+			logging.L(c).Error("failed to read avatar from storage", "user", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		defer r.Close()
+
+		contentType := "image/jpeg"
+		if path.Ext(user.AvatarKey) == ".png" {
+			contentType = "image/png"
+		}
+		c.DataFromReader(http.StatusOK, -1, contentType, r, nil)
+	}
+}
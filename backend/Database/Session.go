@@ -0,0 +1,336 @@
+package database
+
+import (
+	apperr "REVFORUM/apperr"
+	logging "REVFORUM/logging"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Сессии входа.
+//
+// POST /api/v1/auth/login создает сессию и отдает клиенту ее токен; дальше
+// клиент передает его в заголовке Authorization: Bearer <токен>. В базе
+// хранится только SHA-256 токена, поэтому утечка таблицы не дает войти.
+// Пользователь видит свои сессии (GET /api/v1/me/sessions) и может завершить
+// любую из них или все сразу. Сессии завершаются также при блокировке,
+// сбросе пароля и удалении учетной записи.
+
+// sessionContextKey — ключ gin.Context с ID текущей сессии.
+const sessionContextKey = "session_id"
+
+// lastSeenResolution — как часто обновлять время последнего запроса сессии:
+// писать в базу на каждый запрос незачем.
+const lastSeenResolution = time.Minute
+
+// Session — сессия входа на одном устройстве.
+type Session struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	UserID     uint      `gorm:"not null;index" json:"-"`
+	TokenHash  string    `gorm:"not null;uniqueIndex;size:64" json:"-"` // SHA-256 токена в hex
+	Device     string    `gorm:"size:100" json:"device"`                // Браузер и ОС, разобранные из User-Agent
+	UserAgent  string    `gorm:"size:500" json:"user_agent"`
+	IP         string    `gorm:"size:45" json:"ip"` // Адрес последнего запроса
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `gorm:"not null" json:"last_seen_at"`
+	ExpiresAt  time.Time `gorm:"not null;index" json:"expires_at"`
+	Current    bool      `gorm:"-" json:"current"` // Сессия, с которой пришел запрос
+}
+
+// SessionTTLFromEnv читает SESSION_TTL — срок жизни сессии, например 720h
+// (по умолчанию 30 суток).
+func SessionTTLFromEnv() time.Duration {
+	ttl := 30 * 24 * time.Hour
+	if v := os.Getenv("SESSION_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatal("Invalid SESSION_TTL: ", v)
+		}
+		ttl = d
+	}
+	return ttl
+}
+
+// hashToken возвращает хэш токена, под которым сессия хранится в базе.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession открывает сессию пользователя и возвращает ее вместе с токеном.
+// Токен больше нигде не сохраняется: потерянный токен не восстановить.
+func CreateSession(ctx context.Context, db *gorm.DB, userID uint, ttl time.Duration, userAgent, ip string) (Session, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return Session{}, "", apperr.Wrap(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	// Postgres не примет невалидный UTF-8, поэтому обрезаем по границе символа
+	userAgent = strings.ToValidUTF8(userAgent, "")
+	for len(userAgent) > 500 {
+		_, size := utf8.DecodeLastRuneInString(userAgent)
+		userAgent = userAgent[:len(userAgent)-size]
+	}
+	now := time.Now()
+	session := Session{
+		UserID:     userID,
+		TokenHash:  hashToken(token),
+		Device:     describeDevice(userAgent),
+		UserAgent:  userAgent,
+		IP:         ip,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to create session", "user_id", userID, "error", err)
+		return Session{}, "", apperr.Wrap(err)
+	}
+	return session, token, nil
+}
+
+// bearerToken достает токен из заголовка Authorization: Bearer <токен>.
+func bearerToken(c *gin.Context) string {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Authenticate определяет пользователя по токену сессии. Запрос без токена
+// проходит анонимно (обработчики, которым нужен пользователь, ответят 401
+// сами), а с неизвестным или истекшим токеном — получает 401 сразу, чтобы
+// клиент не принял чужую анонимную выдачу за свою.
+func Authenticate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if token == "" {
+			c.Next()
+			return
+		}
+		db := withRequest(db, c)
+
+		// 1. Поиск действующей сессии по хэшу токена
+		var session Session
+		now := time.Now()
+		err := db.Where("token_hash = ? AND expires_at > ?", hashToken(token), now).First(&session).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.Unauthenticated))
+				return
+			}
+			logging.L(c).Error("failed to load session", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		// 2. Время и адрес последнего запроса — не чаще раза в lastSeenResolution
+		if ip := c.ClientIP(); now.Sub(session.LastSeenAt) >= lastSeenResolution || ip != session.IP {
+			err := db.Model(&Session{}).Where("id = ?", session.ID).
+				Updates(map[string]any{"last_seen_at": now, "ip": ip}).Error
+			if err != nil {
+				logging.L(c).Warn("failed to update session last seen", "session_id", session.ID, "error", err)
+			}
+		}
+
+		c.Set(logging.UserIDKey, session.UserID)
+		c.Set(sessionContextKey, session.ID)
+		c.Next()
+	}
+}
+
+// currentUserID возвращает ID пользователя, вошедшего по токену (см. Authenticate);
+// 0 — запрос анонимный.
+func currentUserID(c *gin.Context) uint {
+	return c.GetUint(logging.UserIDKey)
+}
+
+// currentSessionID возвращает ID сессии запроса; 0 — запрос анонимный.
+func currentSessionID(c *gin.Context) uint {
+	return c.GetUint(sessionContextKey)
+}
+
+// requireUser возвращает ID текущего пользователя. Анонимному запросу
+// отвечает 401 и возвращает false.
+func requireUser(c *gin.Context) (uint, bool) {
+	userID := currentUserID(c)
+	if userID == 0 {
+		apperr.Render(c, apperr.New(apperr.Unauthenticated))
+		return 0, false
+	}
+	return userID, true
+}
+
+// RevokeSessions завершает все сессии пользователя, кроме keepID (0 — все).
+// Возвращает число завершенных сессий.
+func RevokeSessions(ctx context.Context, db *gorm.DB, userID, keepID uint) (int64, error) {
+	result := db.Where("user_id = ? AND id <> ?", userID, keepID).Delete(&Session{})
+	if result.Error != nil {
+		logging.FromContext(ctx).Error("failed to revoke sessions", "user_id", userID, "error", result.Error)
+		return 0, apperr.Wrap(result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// CleanupExpiredSessions удаляет истекшие сессии и незавершенные входы
+// (с 2FA и через провайдеров OIDC). Вызывается периодически фоновой задачей сервера.
+func CleanupExpiredSessions(ctx context.Context, db *gorm.DB) {
+	now := time.Now()
+	for _, model := range []any{&LoginChallenge{}, &OIDCFlow{}} {
+		if err := db.WithContext(ctx).Where("expires_at <= ?", now).Delete(model).Error; err != nil {
+			logging.FromContext(ctx).Error("failed to delete expired login attempts", "error", err)
+		}
+	}
+	result := db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&Session{})
+	if result.Error != nil {
+		logging.FromContext(ctx).Error("failed to delete expired sessions", "error", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		logging.FromContext(ctx).Info("expired sessions deleted", "count", result.RowsAffected)
+	}
+}
+
+// ListSessionsHandler возвращает действующие сессии текущего пользователя,
+// начиная с последней активной.
+// GET /api/v1/me/sessions
+func ListSessionsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+
+		var sessions []Session
+		err := db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+			Order("last_seen_at DESC, id DESC").Find(&sessions).Error
+		if err != nil {
+			logging.L(c).Error("failed to list sessions", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == currentSessionID(c)
+		}
+		c.JSON(http.StatusOK, sessions)
+	}
+}
+
+// RevokeSessionHandler завершает одну сессию текущего пользователя, в том числе текущую.
+// DELETE /api/v1/me/sessions/:id
+func RevokeSessionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		id, err := pathID(c)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+
+		// Чужая сессия неотличима от несуществующей
+		result := db.Where("id = ? AND user_id = ?", id, userID).Delete(&Session{})
+		if result.Error != nil {
+			logging.L(c).Error("failed to revoke session", "session_id", id, "error", result.Error)
+			apperr.Render(c, apperr.Wrap(result.Error))
+			return
+		}
+		if result.RowsAffected == 0 {
+			apperr.Render(c, apperr.New(apperr.SessionNotFound))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// RevokeAllSessionsHandler завершает все сессии текущего пользователя («выйти везде»).
+// С ?keep_current=true текущая сессия остается.
+// DELETE /api/v1/me/sessions
+func RevokeAllSessionsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		keepCurrent, err := queryBool(c, "keep_current")
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		var keepID uint
+		if keepCurrent {
+			keepID = currentSessionID(c)
+		}
+
+		revoked, err := RevokeSessions(c.Request.Context(), db, userID, keepID)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Сессии завершены", "revoked": revoked})
+	}
+}
+
+// LogoutHandler завершает текущую сессию.
+// POST /api/v1/auth/logout
+func LogoutHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		if _, ok := requireUser(c); !ok {
+			return
+		}
+		if err := db.Delete(&Session{}, currentSessionID(c)).Error; err != nil {
+			logging.L(c).Error("failed to delete session", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// describeDevice кратко описывает устройство по User-Agent: «Firefox, Windows».
+// Разбор грубый, но для списка сессий этого достаточно; неизвестное — пустая строка.
+func describeDevice(userAgent string) string {
+	// Порядок важен: Edge и Opera представляются еще и Chrome, Chrome — Safari
+	browsers := []struct{ marker, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"YaBrowser/", "Яндекс Браузер"}, {"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"}, {"Safari/", "Safari"}, {"curl/", "curl"},
+	}
+	systems := []struct{ marker, name string }{
+		{"Windows", "Windows"}, {"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	}
+	var parts []string
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.marker) {
+			parts = append(parts, b.name)
+			break
+		}
+	}
+	for _, s := range systems {
+		if strings.Contains(userAgent, s.marker) {
+			parts = append(parts, s.name)
+			break
+		}
+	}
+	return strings.Join(parts, ", ")
+}
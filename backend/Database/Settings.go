@@ -0,0 +1,34 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Setting — настройка форума, которую администратор меняет без перезапуска
+// сервера (в отличие от переменных окружения). Здесь же хранится служебное
+// состояние миграций (например, версия ключей имен).
+type Setting struct {
+	Key       string `gorm:"primaryKey;size:100"`
+	Value     string `gorm:"type:text;not null"`
+	UpdatedAt time.Time
+}
+
+// getSetting возвращает значение настройки; если ее нет — def.
+func getSetting(db *gorm.DB, key, def string) (string, error) {
+	var s Setting
+	if err := db.First(&s, "key = ?", key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return def, nil
+		}
+		return "", err
+	}
+	return s.Value, nil
+}
+
+// putSetting создает или заменяет настройку.
+func putSetting(db *gorm.DB, key, value string) error {
+	return db.Save(&Setting{Key: key, Value: value}).Error
+}
@@ -1,12 +1,16 @@
 package database // Или handlers, или другой подходящий пакет
 
 import (
+	apperr "REVFORUM/apperr"
+	logging "REVFORUM/logging"
+	"context"
 	"errors"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	cache "REVFORUM/cache"
+
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -21,6 +25,9 @@ type Themes_Collection struct {
 	// SubThemes []Sub_Themes `gorm:"foreignKey:ParentID" json:"sub_themes,omitempty"`
 }
 
+// ThemeArchived — статус архивной темы (см. ArchiveTheme).
+const ThemeArchived = "archived"
+
 // Sub_Themes представляет подтему внутри основной темы.
 type Sub_Themes struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
@@ -39,51 +46,27 @@ type CreateThemeRequest struct {
 }
 
 // CreateThemeHandler обработчик для создания новой основной темы.
-func CreateThemeHandler(db *gorm.DB) gin.HandlerFunc {
+// POST /api/v1/themes
+func CreateThemeHandler(db *gorm.DB, store cache.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
 		var req CreateThemeRequest
 
 		// 1. Парсинг и валидация JSON из тела запроса
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные", "details": err.Error()})
-			return
-		}
-
-		// 2. (Опционально) Проверка уникальности Title
-		// Эта проверка имеет смысл, если Title должен быть уникальным глобально.
-		var existingTheme Themes_Collection
-		result := db.Where("title = ?", req.Title).First(&existingTheme)
-		if result.Error == nil {
-			// Тема с таким названием уже существует
-			c.JSON(http.StatusConflict, gin.H{"error": "Тема с данным названием уже существует!"})
+			apperr.Render(c, apperr.Binding(err))
 			return
 		}
-		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			// Другая ошибка БД
-			log.Printf("Ошибка БД при проверке уникальности темы: %v", result.Error)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка базы данных"})
-			return
-		}
-
-		db.AutoMigrate(&Themes_Collection{})
-
-		// 3. Создание объекта темы для БД
-		// GORM автоматически заполнит ID и CreatedAt
-		newTheme := Themes_Collection{
-			Title:  req.Title,
-			Status: req.Status,
-			// ID и CreatedAt будут заполнены GORM
-		}
 
-		// 4. Сохранение темы в базе данных
-		if err := db.Create(&newTheme).Error; err != nil {
-			log.Printf("Ошибка создания темы в БД: %v", err)
-			// Проверь, может быть ошибка ограничений (например, status не валиден)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания темы", "details": err.Error()})
+		// 2. Создание темы и сброс кэша списка тем
+		newTheme, err := CreateTheme(c.Request.Context(), db, store, req)
+		if err != nil {
+			apperr.Render(c, err)
 			return
 		}
 
-		// 5. Отправка успешного ответа (201 Created)
+		// 3. Отправка успешного ответа (201 Created)
 		c.JSON(http.StatusCreated, gin.H{
 			"message": "Тема успешно создана",
 			"theme":   newTheme, // Возвращаем созданную тему (ID, CreatedAt будут заполнены)
@@ -91,15 +74,71 @@ func CreateThemeHandler(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
+// CreateTheme создает тему с уникальным названием и сбрасывает кэш списка тем.
+// Общая часть CreateThemeHandler и команды admin theme create.
+func CreateTheme(ctx context.Context, db *gorm.DB, store cache.Store, req CreateThemeRequest) (Themes_Collection, error) {
+	// 1. Проверка полей
+	if err := validate(&req); err != nil {
+		return Themes_Collection{}, err
+	}
+
+	// 2. Проверка уникальности Title
+	var existingTheme Themes_Collection
+	result := db.Where("title = ?", req.Title).First(&existingTheme)
+	if result.Error == nil {
+		return Themes_Collection{}, apperr.New(apperr.ThemeAlreadyExists)
+	}
+	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		logging.FromContext(ctx).Error("failed to check theme uniqueness", "error", result.Error)
+		return Themes_Collection{}, apperr.Wrap(result.Error)
+	}
+
+	// 3. Сохранение темы; ID и CreatedAt заполнит GORM
+	newTheme := Themes_Collection{Title: req.Title, Status: req.Status}
+	if err := db.Create(&newTheme).Error; err != nil {
+		if isUniqueViolation(err) { // Параллельное создание темы с тем же названием
+			return Themes_Collection{}, apperr.New(apperr.ThemeAlreadyExists)
+		}
+		logging.FromContext(ctx).Error("failed to create theme", "error", err)
+		return Themes_Collection{}, apperr.Wrap(err)
+	}
+
+	// 4. Сброс кэша списка тем
+	cache.InvalidateContext(ctx, store, cache.GroupKey(CacheThemes, ""))
+	return newTheme, nil
+}
+
+// ArchiveTheme переводит тему в статус ThemeArchived. Тема и ее подтемы
+// остаются доступны для чтения; клиенты показывают архив отдельно.
+func ArchiveTheme(ctx context.Context, db *gorm.DB, store cache.Store, id uint) (Themes_Collection, error) {
+	var theme Themes_Collection
+	if err := db.First(&theme, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return theme, apperr.New(apperr.ThemeNotFound)
+		}
+		logging.FromContext(ctx).Error("failed to load theme", "theme_id", id, "error", err)
+		return theme, apperr.Wrap(err)
+	}
+	if err := db.Model(&theme).Update("status", ThemeArchived).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to archive theme", "theme_id", id, "error", err)
+		return theme, apperr.Wrap(err)
+	}
+	cache.InvalidateContext(ctx, store, cache.GroupKey(CacheThemes, ""))
+	return theme, nil
+}
+
 // GetThemesHandler обработчик для получения списка основных тем.
+// GET /api/v1/themes
 func GetThemesHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
 		var themes []Themes_Collection
 
 		// Получаем все темы из БД
 		if err := db.Find(&themes).Error; err != nil {
-			log.Printf("Ошибка получения тем из БД: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения тем"})
+			logging.L(c).Error("failed to list themes", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
 			return
 		}
 
@@ -108,12 +147,158 @@ func GetThemesHandler(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-// TODO: Добавить обработчики для:
-// - Создания подтем (CreateSubThemeHandler)
-// - Получения подтем по ID родительской темы (GetSubThemesHandler)
-// - Получения конкретной темы по ID (GetThemeByIDHandler)
-// - Обновления темы (UpdateThemeHandler)
-// - Удаления темы (DeleteThemeHandler)
+// GetThemeHandler обработчик для получения темы по ID.
+// GET /api/v1/themes/:id
+func GetThemeHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		id, err := pathID(c)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		var theme Themes_Collection
+		if err := db.First(&theme, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.ThemeNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load theme", "theme_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		c.JSON(http.StatusOK, theme)
+	}
+}
+
+// UpdateThemeRequest — изменяемые поля темы. Непереданное поле не меняется.
+type UpdateThemeRequest struct {
+	Title  *string `json:"title" binding:"omitempty,min=1,max=200"`
+	Status *string `json:"status" binding:"omitempty,min=1"`
+}
+
+// UpdateThemeHandler изменяет тему. Доступно модераторам и администраторам.
+// PATCH /api/v1/themes/:id
+func UpdateThemeHandler(db *gorm.DB, store cache.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		// 1. Разбор ID и тела запроса
+		id, err := pathID(c)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		var req UpdateThemeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperr.Render(c, apperr.Binding(err))
+			return
+		}
+		updates := map[string]any{}
+		if req.Title != nil {
+			updates["title"] = *req.Title
+		}
+		if req.Status != nil {
+			updates["status"] = *req.Status
+		}
+		if len(updates) == 0 {
+			apperr.Render(c, apperr.New(apperr.NoFieldsToUpdate))
+			return
+		}
+
+		// 2. Проверка прав
+		if !requireModerator(c, db) {
+			return
+		}
+
+		// 3. Поиск темы
+		var theme Themes_Collection
+		if err := db.First(&theme, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.ThemeNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load theme", "theme_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		// 4. Новое название должно оставаться уникальным
+		if req.Title != nil && *req.Title != theme.Title {
+			var count int64
+			if err := db.Model(&Themes_Collection{}).Where("title = ? AND id <> ?", *req.Title, id).Count(&count).Error; err != nil {
+				logging.L(c).Error("failed to check theme uniqueness", "error", err)
+				apperr.Render(c, apperr.Wrap(err))
+				return
+			}
+			if count > 0 {
+				apperr.Render(c, apperr.New(apperr.ThemeAlreadyExists))
+				return
+			}
+		}
+
+		// 5. Сохранение и сброс кэша списка тем
+		if err := db.Model(&theme).Updates(updates).Error; err != nil {
+			logging.L(c).Error("failed to update theme", "theme_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		cache.Invalidate(c, store, cache.GroupKey(CacheThemes, ""))
+
+		c.JSON(http.StatusOK, gin.H{"message": "Тема обновлена", "theme": theme})
+	}
+}
+
+// DeleteThemeHandler удаляет пустую тему. Доступно модераторам и администраторам.
+// Тему с подтемами удалить нельзя, чтобы случайно не потерять обсуждения.
+// DELETE /api/v1/themes/:id
+func DeleteThemeHandler(db *gorm.DB, store cache.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		id, err := pathID(c)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		if !requireModerator(c, db) {
+			return
+		}
+
+		var theme Themes_Collection
+		if err := db.First(&theme, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.ThemeNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load theme", "theme_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		var subThemes int64
+		if err := db.Model(&Sub_Themes{}).Where("parent_id = ?", id).Count(&subThemes).Error; err != nil {
+			logging.L(c).Error("failed to count subthemes", "theme_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		if subThemes > 0 {
+			apperr.Render(c, apperr.New(apperr.ThemeNotEmpty))
+			return
+		}
+
+		if err := db.Delete(&theme).Error; err != nil {
+			logging.L(c).Error("failed to delete theme", "theme_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		cache.Invalidate(c, store,
+			cache.GroupKey(CacheThemes, ""),
+			cache.GroupKey(CacheSubThemes, strconv.FormatUint(uint64(id), 10)),
+		)
+		c.Status(http.StatusNoContent)
+	}
+}
 
 // CreateSubThemeRequest структура для входящих данных при создании подтемы.
 type CreateSubThemeRequest struct {
@@ -123,13 +308,17 @@ type CreateSubThemeRequest struct {
 }
 
 // CreateSubThemeHandler обработчик для создания новой подтемы.
-func CreateSubThemeHandler(db *gorm.DB) gin.HandlerFunc {
+// POST /api/v1/themes/:id/subthemes (родительская тема из пути)
+// POST /api/themes/subthemes (устаревший, parent_id в теле)
+func CreateSubThemeHandler(db *gorm.DB, store cache.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
 		var req CreateSubThemeRequest
 
 		// 1. Парсинг и валидация JSON из тела запроса
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные", "details": err.Error()})
+		if err := bindCreate(c, &req, &req.ParentID); err != nil {
+			apperr.Render(c, err)
 			return
 		}
 
@@ -137,11 +326,11 @@ func CreateSubThemeHandler(db *gorm.DB) gin.HandlerFunc {
 		var parentTheme Themes_Collection
 		if err := db.First(&parentTheme, req.ParentID).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Родительская тема не найдена"})
+				apperr.Render(c, apperr.New(apperr.ThemeNotFound))
 				return
 			}
-			log.Printf("Ошибка БД при поиске родительской темы: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
+			logging.L(c).Error("failed to load parent theme", "parent_id", req.ParentID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
 			return
 		}
 
@@ -153,7 +342,7 @@ func CreateSubThemeHandler(db *gorm.DB) gin.HandlerFunc {
 		// 	return
 		// }
 		// if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		// 	log.Printf("Ошибка БД при проверке уникальности подтемы: %v", result.Error)
+		// 	logging.L(c).Error("failed to check subtheme uniqueness", "error", result.Error)
 		// 	c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка базы данных"})
 		// 	return
 		// }
@@ -168,12 +357,15 @@ func CreateSubThemeHandler(db *gorm.DB) gin.HandlerFunc {
 
 		// 5. Сохранение подтемы в базе данных
 		if err := db.Create(&newSubTheme).Error; err != nil {
-			log.Printf("Ошибка создания подтемы в БД: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания подтемы", "details": err.Error()})
+			logging.L(c).Error("failed to create subtheme", "parent_id", req.ParentID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
 			return
 		}
 
-		// 6. Отправка успешного ответа (201 Created)
+		// 6. Сброс кэша списка подтем родительской темы
+		cache.Invalidate(c, store, cache.GroupKey(CacheSubThemes, strconv.FormatUint(uint64(req.ParentID), 10)))
+
+		// 7. Отправка успешного ответа (201 Created)
 		c.JSON(http.StatusCreated, gin.H{
 			"message":   "Подтема успешно создана",
 			"sub_theme": newSubTheme, // Возвращаем созданную подтему
@@ -182,14 +374,16 @@ func CreateSubThemeHandler(db *gorm.DB) gin.HandlerFunc {
 }
 
 // GetSubThemesHandler обработчик для получения списка подтем по ID родительской темы.
-// Ожидает ID родительской темы в параметрах URL, например, GET /api/themes/123/subthemes
+// Ожидает ID родительской темы в параметрах URL, например, GET /api/v1/themes/123/subthemes
 func GetSubThemesHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
 		// 1. Получение ID родительской темы из параметров URL
-		parentIDStr := c.Param("id") // Предполагается маршрут вида /api/themes/:id/subthemes
+		parentIDStr := c.Param("id") // Предполагается маршрут вида /api/v1/themes/:id/subthemes
 		parentID, err := strconv.ParseUint(parentIDStr, 10, 32)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID родительской темы"})
+			apperr.Render(c, apperr.New(apperr.InvalidParameter).Param("name", "id"))
 			return
 		}
 
@@ -198,8 +392,8 @@ func GetSubThemesHandler(db *gorm.DB) gin.HandlerFunc {
 
 		// 3. Запрос к БД для получения подтем с указанным ParentID
 		if err := db.Where("parent_id = ?", parentID).Find(&subThemes).Error; err != nil {
-			log.Printf("Ошибка получения подтем из БД для parent_id=%d: %v", parentID, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения подтем"})
+			logging.L(c).Error("failed to list subthemes", "parent_id", parentID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
 			return
 		}
 
@@ -207,3 +401,144 @@ func GetSubThemesHandler(db *gorm.DB) gin.HandlerFunc {
 		c.JSON(http.StatusOK, subThemes) // Отправляем массив подтем
 	}
 }
+
+// GetSubThemeHandler обработчик для получения подтемы по ID.
+// GET /api/v1/subthemes/:id
+func GetSubThemeHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		id, err := pathID(c)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		var subTheme Sub_Themes
+		if err := db.First(&subTheme, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.SubThemeNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load subtheme", "sub_theme_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		c.JSON(http.StatusOK, subTheme)
+	}
+}
+
+// UpdateSubThemeRequest — изменяемые поля подтемы. Непереданное поле не меняется.
+type UpdateSubThemeRequest struct {
+	Title  *string `json:"title" binding:"omitempty,min=1,max=200"`
+	Status *string `json:"status" binding:"omitempty,min=1"`
+}
+
+// UpdateSubThemeHandler изменяет подтему. Доступно модераторам и администраторам.
+// PATCH /api/v1/subthemes/:id
+func UpdateSubThemeHandler(db *gorm.DB, store cache.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		// 1. Разбор ID и тела запроса
+		id, err := pathID(c)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		var req UpdateSubThemeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperr.Render(c, apperr.Binding(err))
+			return
+		}
+		updates := map[string]any{}
+		if req.Title != nil {
+			updates["title"] = *req.Title
+		}
+		if req.Status != nil {
+			updates["status"] = *req.Status
+		}
+		if len(updates) == 0 {
+			apperr.Render(c, apperr.New(apperr.NoFieldsToUpdate))
+			return
+		}
+
+		// 2. Проверка прав
+		if !requireModerator(c, db) {
+			return
+		}
+
+		// 3. Поиск и сохранение подтемы
+		var subTheme Sub_Themes
+		if err := db.First(&subTheme, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.SubThemeNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load subtheme", "sub_theme_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		if err := db.Model(&subTheme).Updates(updates).Error; err != nil {
+			logging.L(c).Error("failed to update subtheme", "sub_theme_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		// 4. Сброс кэша: список подтем темы и топики подтемы (встраивают ее по ?include=sub_theme)
+		cache.Invalidate(c, store,
+			cache.GroupKey(CacheSubThemes, strconv.FormatUint(uint64(subTheme.ParentID), 10)),
+			cache.GroupKey(CacheTopics, strconv.FormatUint(uint64(id), 10)),
+		)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Подтема обновлена", "sub_theme": subTheme})
+	}
+}
+
+// DeleteSubThemeHandler удаляет пустую подтему. Доступно модераторам и администраторам.
+// DELETE /api/v1/subthemes/:id
+func DeleteSubThemeHandler(db *gorm.DB, store cache.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		id, err := pathID(c)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		if !requireModerator(c, db) {
+			return
+		}
+
+		var subTheme Sub_Themes
+		if err := db.First(&subTheme, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.SubThemeNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load subtheme", "sub_theme_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		var topics int64
+		if err := db.Model(&Topic{}).Where("sub_theme_id = ?", id).Count(&topics).Error; err != nil {
+			logging.L(c).Error("failed to count topics", "sub_theme_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		if topics > 0 {
+			apperr.Render(c, apperr.New(apperr.SubThemeNotEmpty))
+			return
+		}
+
+		if err := db.Delete(&subTheme).Error; err != nil {
+			logging.L(c).Error("failed to delete subtheme", "sub_theme_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		cache.Invalidate(c, store,
+			cache.GroupKey(CacheSubThemes, strconv.FormatUint(uint64(subTheme.ParentID), 10)),
+			cache.GroupKey(CacheTopics, strconv.FormatUint(uint64(id), 10)),
+		)
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -1,10 +1,17 @@
 package database
 
 import (
+	apperr "REVFORUM/apperr"
+	cache "REVFORUM/cache"
+	logging "REVFORUM/logging"
+	metrics "REVFORUM/metrics"
+	realtime "REVFORUM/realtime"
+	storage "REVFORUM/storage"
+	"context"
 	"errors"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
@@ -13,123 +20,450 @@ import (
 // Topic представляет топик или вопрос внутри подтемы.
 type Topic struct {
 	ID         uint      `gorm:"primaryKey" json:"id"`
-	Title      string    `gorm:"not null" json:"title"`              // Заголовок/вопрос топика
-	Content    string    `gorm:"type:text" json:"content"`           // Содержание/описание топика
-	CreatedAt  time.Time `json:"created_at"`                         // Дата создания
-	UpdatedAt  time.Time `json:"updated_at"`                         // Дата последнего обновления
-	AuthorID   uint      `gorm:"not null" json:"author_id"`          // ID автора (ссылка на User)
-	SubThemeID uint      `gorm:"not null;index" json:"sub_theme_id"` // ID родительской подтемы (ссылка на Sub_Themes)
-	// Поля для связи (не сериализуются в JSON по умолчанию)
-	// Author    User      `gorm:"foreignKey:AuthorID"`    // Связь с пользователем
-	// SubTheme  Sub_Themes `gorm:"foreignKey:SubThemeID"` // Связь с подтемой
+	Title      string    `gorm:"not null" json:"title"`                // Заголовок/вопрос топика
+	CreatedAt  time.Time `json:"created_at"`                           // Дата создания
+	UpdatedAt  time.Time `json:"updated_at"`                           // Дата последнего обновления
+	AuthorID   uint      `gorm:"not null" json:"author_id"`            // ID автора (ссылка на User)
+	SubThemeID uint      `gorm:"not null;index" json:"sub_theme_id"`   // ID родительской подтемы (ссылка на Sub_Themes)
+	Locked     bool      `gorm:"not null;default:false" json:"locked"` // Закрыт для новых сообщений (см. SetTopicLocked)
+	// Первое сообщение — текст, с которым топик создан. Пусто у топиков
+	// без текста, созданных до появления первых сообщений, и после удаления первого сообщения.
+	FirstPostID *uint `gorm:"index" json:"first_post_id"`
+	// Связанные объекты, подгружаются по ?include= (см. Include.go)
+	Author   *AuthorSummary `gorm:"-" json:"author,omitempty"`
+	SubTheme *Sub_Themes    `gorm:"-" json:"sub_theme,omitempty"`
 }
 
 // CreateTopicRequest структура для входящих данных при создании топика.
 type CreateTopicRequest struct {
 	Title      string `json:"title" binding:"required"`        // Обязательное поле - вопрос
-	Content    string `json:"content"`                         // Опциональное поле - содержание/описание
+	Content    string `json:"content" binding:"required"`      // Обязательное поле - текст первого сообщения
 	SubThemeID uint   `json:"sub_theme_id" binding:"required"` // Обязательное поле
 	// AuthorID будет браться из токена/сессии пользователя
 }
 
 // CreateTopicHandler обработчик для создания нового топика.
-// POST /api/topics
-func CreateTopicHandler(c *gin.Context) {
-	// TODO: Получить userID из JWT токена (после реализации аутентификации)
-	// userID := getUserIDFromContext(c) // Пример функции
-	// if userID == 0 {
-	// 	c.JSON(http.StatusUnauthorized, gin.H{"error": "Пользователь не аутентифицирован"})
-	// 	return
-	// }
-	// Пока используем фиктивный ID для тестирования
-	userID := uint(1) // ЗАМЕНИТЬ НА РЕАЛЬНЫЙ ID ИЗ ТОКЕНА
-
-	var req CreateTopicRequest
-
-	// 1. Парсинг и валидация JSON
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные", "details": err.Error()})
-		return
-	}
+// POST /api/v1/subthemes/:id/topics (подтема из пути)
+// POST /api/themes/subthemes/topics (устаревший, sub_theme_id в теле)
+func CreateTopicHandler(db *gorm.DB, store cache.Store, events *realtime.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
 
-	// 2. (Опционально) Проверка существования SubTheme
-	var subTheme Sub_Themes
-	if err := DB.First(&subTheme, req.SubThemeID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Указанная подтема не существует"})
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+
+		var req CreateTopicRequest
+
+		// 1. Парсинг и валидация JSON
+		if err := bindCreate(c, &req, &req.SubThemeID); err != nil {
+			apperr.Render(c, err)
+			return
+		}
+
+		// 2. (Опционально) Проверка существования SubTheme
+		var subTheme Sub_Themes
+		if err := db.First(&subTheme, req.SubThemeID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.SubThemeNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load subtheme", "sub_theme_id", req.SubThemeID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
 			return
 		}
-		log.Printf("Ошибка БД при поиске подтемы: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сервера"})
-		return
+
+		// 3. Создание топика и его первого сообщения в одной транзакции
+		newTopic := Topic{
+			Title:      req.Title,
+			AuthorID:   userID, // Устанавливаем ID автора
+			SubThemeID: req.SubThemeID,
+			// CreatedAt и UpdatedAt заполнятся автоматически
+		}
+		var firstPost Post
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&newTopic).Error; err != nil {
+				return err
+			}
+			firstPost = Post{Content: req.Content, AuthorID: userID, TopicID: newTopic.ID, CreatedAt: newTopic.CreatedAt}
+			if err := tx.Omit("Attachments").Create(&firstPost).Error; err != nil {
+				return err
+			}
+			newTopic.FirstPostID = &firstPost.ID
+			return tx.Model(&newTopic).UpdateColumn("first_post_id", firstPost.ID).Error
+		})
+		if err != nil {
+			logging.L(c).Error("failed to create topic", "sub_theme_id", req.SubThemeID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		metrics.TopicsCreated.Inc()
+		metrics.PostsCreated.Inc()
+
+		// 4. Сброс кэша: список топиков подтемы и счетчик постов у автора
+		invalidateAuthorListings(c.Request.Context(), store)
+
+		// 5. Уведомление подписчиков подтемы
+		events.Publish(c.Request.Context(), realtime.TopicCreated, realtime.SubThemeChannel(newTopic.SubThemeID), newTopic)
+
+		// 6. Отправка успешного ответа (201 Created)
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Топик успешно создан",
+			"topic":   newTopic,  // Созданный топик, first_post_id указывает на post
+			"post":    firstPost, // Первое сообщение
+		})
 	}
+}
 
-	// 3. Создание объекта топика
-	newTopic := Topic{
-		Title:      req.Title,
-		Content:    req.Content,
-		AuthorID:   userID, // Устанавливаем ID автора
-		SubThemeID: req.SubThemeID,
-		// CreatedAt и UpdatedAt заполнятся автоматически
+// GetTopicsBySubThemeHandler обработчик для получения списка топиков по ID подтемы.
+// GET /api/v1/subthemes/:id/topics
+func GetTopicsBySubThemeHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		// 1. Получение ID подтемы из параметров URL
+		subThemeIDStr := c.Param("id")
+		subThemeID, err := strconv.ParseUint(subThemeIDStr, 10, 32)
+		if err != nil {
+			apperr.Render(c, apperr.New(apperr.InvalidParameter).Param("name", "id"))
+			return
+		}
+
+		// 2. Разбор ?include= (author — сводка автора, sub_theme — подтема)
+		includes, err := parseIncludes(c, "author", "sub_theme")
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+
+		// 3. Подготовка переменной для результата
+		var topics []Topic
+
+		// 4. Запрос к БД для получения топиков с указанным SubThemeID
+		// Сортируем по дате создания, новые первыми
+		if err := db.Where("sub_theme_id = ?", subThemeID).Order("created_at DESC").Find(&topics).Error; err != nil {
+			logging.L(c).Error("failed to list topics", "sub_theme_id", subThemeID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		// 5. Связанные данные — фиксированное число запросов независимо от числа топиков
+		type TopicWithPostCount struct {
+			Topic
+			PostCount int64 `json:"post_count"`
+		}
+
+		topicIDs := make([]uint, len(topics))
+		authorIDs := make([]uint, len(topics))
+		for i, topic := range topics {
+			topicIDs[i] = topic.ID
+			authorIDs[i] = topic.AuthorID
+		}
+		counts, err := countPostsByTopic(db, topicIDs)
+		if err != nil {
+			logging.L(c).Error("failed to count topic posts", "sub_theme_id", subThemeID, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		var authors map[uint]*AuthorSummary
+		if includes["author"] {
+			if authors, err = loadAuthors(db, authorIDs); err != nil {
+				logging.L(c).Error("failed to load topic authors", "sub_theme_id", subThemeID, "error", err)
+				apperr.Render(c, apperr.Wrap(err))
+				return
+			}
+		}
+		var subTheme *Sub_Themes
+		if includes["sub_theme"] {
+			var loaded Sub_Themes
+			err := db.First(&loaded, subThemeID).Error
+			switch {
+			case err == nil:
+				subTheme = &loaded
+			case !errors.Is(err, gorm.ErrRecordNotFound):
+				logging.L(c).Error("failed to load subtheme", "sub_theme_id", subThemeID, "error", err)
+				apperr.Render(c, apperr.Wrap(err))
+				return
+			}
+		}
+
+		topicsWithCount := make([]TopicWithPostCount, len(topics))
+		for i, topic := range topics {
+			topic.Author = authors[topic.AuthorID]
+			topic.SubTheme = subTheme
+			topicsWithCount[i].Topic = topic
+			topicsWithCount[i].PostCount = counts[topic.ID]
+		}
+
+		// 6. Отправка результата в JSON
+		c.JSON(http.StatusOK, topicsWithCount) // Возвращаем топики с количеством постов
 	}
+}
 
-	// 4. Сохранение в БД
-	if err := DB.Create(&newTopic).Error; err != nil {
-		log.Printf("Ошибка создания топика в БД: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания топика", "details": err.Error()})
-		return
+// GetTopicHandler обработчик для получения топика по ID.
+// GET /api/v1/topics/:id
+func GetTopicHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		id, err := pathID(c)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		var topic Topic
+		if err := db.First(&topic, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.TopicNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load topic", "topic_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		c.JSON(http.StatusOK, topic)
 	}
+}
 
-	// 5. Отправка успешного ответа (201 Created)
-	// Можно вернуть полный объект или только ID и сообщение
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Топик успешно создан",
-		"topic":   newTopic, // Возвращаем созданный топик
-	})
+// UpdateTopicRequest — изменяемые поля топика. Непереданное поле не меняется.
+// Текст топика — это его первое сообщение, он меняется через PATCH /api/v1/posts/:id.
+type UpdateTopicRequest struct {
+	Title *string `json:"title" binding:"omitempty,min=1,max=300"`
 }
 
-// GetTopicsBySubThemeHandler обработчик для получения списка топиков по ID подтемы.
-// GET /api/subthemes/:id/topics
-// handlers/topic_handlers.go (обновленный фрагмент)
-// GetTopicsBySubThemeHandler обработчик для получения списка топиков по ID подтемы.
-// GET /api/subthemes/:id/topics
-func GetTopicsBySubThemeHandler(c *gin.Context) {
-	// 1. Получение ID подтемы из параметров URL
-	subThemeIDStr := c.Param("id")
-	subThemeID, err := strconv.ParseUint(subThemeIDStr, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID подтемы"})
-		return
+// UpdateTopicHandler изменяет топик. Доступно автору, модераторам и администраторам.
+// PATCH /api/v1/topics/:id
+func UpdateTopicHandler(db *gorm.DB, store cache.Store, events *realtime.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+
+		// 1. Разбор ID и тела запроса
+		id, err := pathID(c)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		var req UpdateTopicRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperr.Render(c, apperr.Binding(err))
+			return
+		}
+		updates := map[string]any{}
+		if req.Title != nil {
+			updates["title"] = *req.Title
+		}
+		if len(updates) == 0 {
+			apperr.Render(c, apperr.New(apperr.NoFieldsToUpdate))
+			return
+		}
+
+		// 2. Поиск топика и проверка прав
+		var topic Topic
+		if err := db.First(&topic, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.TopicNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load topic", "topic_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		if !requireAuthorOrModerator(c, db, topic.AuthorID) {
+			return
+		}
+
+		// 3. Сохранение
+		if err := db.Model(&topic).Updates(updates).Error; err != nil {
+			logging.L(c).Error("failed to update topic", "topic_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+
+		// 4. Сброс кэша: список топиков подтемы и посты топика (встраивают его по ?include=topic)
+		cache.Invalidate(c, store,
+			cache.GroupKey(CacheTopics, strconv.FormatUint(uint64(topic.SubThemeID), 10)),
+			cache.GroupKey(CachePosts, strconv.FormatUint(uint64(topic.ID), 10)),
+		)
+
+		// 5. Уведомление подписчиков топика и подтемы
+		events.Publish(c.Request.Context(), realtime.TopicUpdated, realtime.TopicChannel(topic.ID), topic)
+		events.Publish(c.Request.Context(), realtime.TopicUpdated, realtime.SubThemeChannel(topic.SubThemeID), topic)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Топик обновлен", "topic": topic})
 	}
+}
 
-	// 2. Подготовка переменной для результата
-	var topics []Topic
+// DeleteTopicHandler удаляет топик вместе с его постами и их вложениями.
+// Доступно автору, модераторам и администраторам.
+// DELETE /api/v1/topics/:id
+func DeleteTopicHandler(db *gorm.DB, store cache.Store, events *realtime.Hub, files storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
 
-	// 3. Запрос к БД для получения топиков с указанным SubThemeID
-	// Сортируем по дате создания, новые первыми
-	if err := DB.Where("sub_theme_id = ?", subThemeID).Order("created_at DESC").Find(&topics).Error; err != nil {
-		log.Printf("Ошибка получения топиков из БД для sub_theme_id=%d: %v", subThemeID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения топиков"})
-		return
+		// 1. Поиск топика и проверка прав
+		id, err := pathID(c)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		var topic Topic
+		if err := db.First(&topic, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.TopicNotFound))
+				return
+			}
+			logging.L(c).Error("failed to load topic", "topic_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		if !requireAuthorOrModerator(c, db, topic.AuthorID) {
+			return
+		}
+
+		// 2. Удаление постов с вложениями и самого топика в одной транзакции;
+		// файлы вложений — только после ее фиксации
+		var attachments []Attachment
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var postIDs []uint
+			if err := tx.Model(&Post{}).Where("topic_id = ?", id).Pluck("id", &postIDs).Error; err != nil {
+				return err
+			}
+			var err error
+			if attachments, err = DeletePostAttachments(tx, postIDs...); err != nil {
+				return err
+			}
+			if err := tx.Where("topic_id = ?", id).Delete(&Post{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&topic).Error
+		})
+		if err != nil {
+			logging.L(c).Error("failed to delete topic", "topic_id", id, "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		for _, a := range attachments {
+			deleteAttachmentFiles(c.Request.Context(), files, a)
+		}
+
+		// 3. Сброс кэша: топики подтемы, посты топика и счетчики постов авторов
+		cache.Invalidate(c, store,
+			cache.GroupKey(CacheTopics, strconv.FormatUint(uint64(topic.SubThemeID), 10)),
+			cache.GroupKey(CachePosts, strconv.FormatUint(uint64(topic.ID), 10)),
+		)
+		invalidateAuthorListings(c.Request.Context(), store)
+
+		// 4. Уведомление подписчиков топика и подтемы
+		events.Publish(c.Request.Context(), realtime.TopicDeleted, realtime.TopicChannel(topic.ID), gin.H{"id": topic.ID})
+		events.Publish(c.Request.Context(), realtime.TopicDeleted, realtime.SubThemeChannel(topic.SubThemeID), gin.H{"id": topic.ID})
+
+		c.Status(http.StatusNoContent)
 	}
+}
 
-	// 4. (Опционально) Получить количество постов для каждого топика
-	type TopicWithPostCount struct {
-		Topic
-		PostCount int64 `json:"post_count"`
+// MoveTopic переносит топик в другую подтему и уведомляет подписчиков
+// топика и обеих подтем.
+func MoveTopic(ctx context.Context, db *gorm.DB, store cache.Store, events *realtime.Hub, topicID, subThemeID uint) (Topic, error) {
+	// 1. Поиск топика и новой подтемы
+	topic, err := findTopic(ctx, db, topicID)
+	if err != nil {
+		return topic, err
 	}
+	var target Sub_Themes
+	if err := db.First(&target, subThemeID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return topic, apperr.New(apperr.SubThemeNotFound)
+		}
+		logging.FromContext(ctx).Error("failed to load subtheme", "sub_theme_id", subThemeID, "error", err)
+		return topic, apperr.Wrap(err)
+	}
+	from := topic.SubThemeID
+	if from == subThemeID {
+		return topic, nil
+	}
+
+	// 2. Перенос
+	if err := db.Model(&topic).Update("sub_theme_id", subThemeID).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to move topic", "topic_id", topicID, "error", err)
+		return topic, apperr.Wrap(err)
+	}
+
+	// 3. Сброс кэша списков обеих подтем и уведомление подписчиков
+	cache.InvalidateContext(ctx, store,
+		cache.GroupKey(CacheTopics, strconv.FormatUint(uint64(from), 10)),
+		cache.GroupKey(CacheTopics, strconv.FormatUint(uint64(subThemeID), 10)),
+		cache.GroupKey(CachePosts, strconv.FormatUint(uint64(topicID), 10)), // Посты встраивают топик по ?include=topic
+	)
+	events.Publish(ctx, realtime.TopicUpdated, realtime.TopicChannel(topic.ID), topic)
+	events.Publish(ctx, realtime.TopicDeleted, realtime.SubThemeChannel(from), gin.H{"id": topic.ID})
+	events.Publish(ctx, realtime.TopicCreated, realtime.SubThemeChannel(subThemeID), topic)
+	return topic, nil
+}
 
-	topicsWithCount := make([]TopicWithPostCount, len(topics))
-	for i, topic := range topics {
-		topicsWithCount[i].Topic = topic
-		DB.Model(&Post{}).Where("topic_id = ?", topic.ID).Count(&topicsWithCount[i].PostCount)
+// SetTopicLocked закрывает топик для новых сообщений (locked = true) или открывает его.
+func SetTopicLocked(ctx context.Context, db *gorm.DB, store cache.Store, events *realtime.Hub, topicID uint, locked bool) (Topic, error) {
+	topic, err := findTopic(ctx, db, topicID)
+	if err != nil {
+		return topic, err
+	}
+	if err := db.Model(&topic).Update("locked", locked).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to lock topic", "topic_id", topicID, "locked", locked, "error", err)
+		return topic, apperr.Wrap(err)
 	}
+	cache.InvalidateContext(ctx, store,
+		cache.GroupKey(CacheTopics, strconv.FormatUint(uint64(topic.SubThemeID), 10)),
+		cache.GroupKey(CachePosts, strconv.FormatUint(uint64(topicID), 10)),
+	)
+	events.Publish(ctx, realtime.TopicUpdated, realtime.TopicChannel(topic.ID), topic)
+	events.Publish(ctx, realtime.TopicUpdated, realtime.SubThemeChannel(topic.SubThemeID), topic)
+	return topic, nil
+}
 
-	// 5. Отправка результата в JSON
-	c.JSON(http.StatusOK, topicsWithCount) // Возвращаем топики с количеством постов
+// findTopic загружает топик; отсутствие — TOPIC_NOT_FOUND.
+func findTopic(ctx context.Context, db *gorm.DB, id uint) (Topic, error) {
+	var topic Topic
+	if err := db.First(&topic, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return topic, apperr.New(apperr.TopicNotFound)
+		}
+		logging.FromContext(ctx).Error("failed to load topic", "topic_id", id, "error", err)
+		return topic, apperr.Wrap(err)
+	}
+	return topic, nil
 }
 
-// TODO: Добавить обработчики для:
-// - Получения конкретного топика по ID (с сообщениями?) (GET /api/topics/:id)
-// - Обновления топика (PUT/PATCH /api/topics/:id) (только автор/модератор)
-// - Удаления топика (DELETE /api/topics/:id) (только автор/модератор/админ)
+// migrateTopicContent переносит текст топиков, созданных до появления первых
+// сообщений (столбец content), в первые сообщения с автором и датой топика
+// и удаляет столбец. Всё выполняется в одной транзакции: при ошибке текст
+// остается в топиках и перенос повторится при следующем запуске.
+func migrateTopicContent(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&Topic{}, "content") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var legacy []struct {
+			ID        uint
+			AuthorID  uint
+			Content   string
+			CreatedAt time.Time
+		}
+		if err := tx.Table("topics").Select("id", "author_id", "content", "created_at").
+			Where("content <> '' AND first_post_id IS NULL").Order("id").Scan(&legacy).Error; err != nil {
+			return err
+		}
+		for _, t := range legacy {
+			post := Post{Content: t.Content, AuthorID: t.AuthorID, TopicID: t.ID, CreatedAt: t.CreatedAt, UpdatedAt: t.CreatedAt}
+			if err := tx.Omit("Attachments").Create(&post).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&Topic{}).Where("id = ?", t.ID).UpdateColumn("first_post_id", post.ID).Error; err != nil {
+				return err
+			}
+		}
+		if len(legacy) > 0 {
+			slog.Info("topic content moved into first posts", "topics", len(legacy))
+		}
+		return tx.Exec("ALTER TABLE topics DROP COLUMN content").Error
+	})
+}
@@ -0,0 +1,695 @@
+package database
+
+import (
+	apperr "REVFORUM/apperr"
+	logging "REVFORUM/logging"
+	metrics "REVFORUM/metrics"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Двухфакторная аутентификация: одноразовые коды TOTP (RFC 6238) из
+// приложения-аутентификатора и резервные коды на случай потери телефона.
+//
+// Подключение: POST /api/v1/me/2fa/setup выдает секрет и ссылку otpauth://
+// для QR-кода, POST /api/v1/me/2fa/enable включает 2FA после проверки первого
+// кода и возвращает резервные коды — показываются один раз, в базе хранятся
+// только их хэши. Вход с 2FA проходит в два шага: POST /api/v1/auth/login
+// вместо сессии выдает challenge_token, а POST /api/v1/auth/login/2fa
+// обменивает его и код на сессию. Администратор может потребовать 2FA для
+// модераторов и администраторов: без нее их права не действуют.
+
+const (
+	totpIssuer = "RevForum"
+	totpDigits = 6
+	totpPeriod = 30 // Секунд на один код
+	totpSkew   = 1  // Сколько соседних шагов принимается из-за расхождения часов
+
+	recoveryCodeCount = 10
+
+	challengeTTL         = 5 * time.Minute
+	challengeMaxAttempts = 5 // После стольких неверных кодов нужно снова ввести пароль
+
+	// Неверные коды считаются и по всем входам пользователя: каждые
+	// twoFactorLockoutEvery ошибок подряд закрывают ввод кодов на срок,
+	// который удваивается с каждой следующей серией, но не дольше twoFactorMaxLockout
+	twoFactorLockoutEvery = 10
+	twoFactorLockout      = 15 * time.Minute
+	twoFactorMaxLockout   = 24 * time.Hour
+
+	settingTwoFactorRoles = "two_factor_required_roles" // Роли через запятую
+)
+
+// totpEncoding — запись секрета TOTP, которую понимают приложения-аутентификаторы.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// staffRoles — роли, для которых можно требовать 2FA.
+var staffRoles = []string{RoleModerator, RoleAdmin}
+
+// RecoveryCode — резервный код 2FA; каждый действует один раз.
+type RecoveryCode struct {
+	ID       uint       `gorm:"primaryKey"`
+	UserID   uint       `gorm:"not null;index"`
+	CodeHash string     `gorm:"not null;size:64"` // SHA-256 кода без дефиса
+	UsedAt   *time.Time // nil — код еще не использован
+}
+
+// LoginChallenge — вход, ожидающий второго фактора.
+type LoginChallenge struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	TokenHash string `gorm:"not null;uniqueIndex;size:64"` // SHA-256 challenge_token
+	Attempts  int    `gorm:"not null;default:0"`           // Неверные коды
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// TwoFactorStatus — состояние 2FA текущего пользователя.
+type TwoFactorStatus struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int64      `json:"recovery_codes_left"`
+	Required          bool       `json:"required"` // Роль пользователя требует 2FA
+}
+
+// TwoFactorSetup — секрет для приложения-аутентификатора.
+type TwoFactorSetup struct {
+	Secret     string `json:"secret"`      // Для ручного ввода
+	OTPAuthURI string `json:"otpauth_uri"` // Для QR-кода
+}
+
+// TwoFactorPolicy — роли, которым 2FA обязательна.
+type TwoFactorPolicy struct {
+	RequiredRoles []string `json:"required_roles"`
+}
+
+// TwoFactorCodeRequest — код из приложения или резервный код.
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TwoFactorLoginRequest — второй шаг входа.
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// --- TOTP ---
+
+// TOTPCode возвращает код TOTP для секрета в момент at.
+func TOTPCode(secret string, at time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpAt(key, at.Unix()/totpPeriod), nil
+}
+
+// totpAt — код HOTP (RFC 4226) для шага step.
+func totpAt(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// matchTOTP ищет шаг, код которого совпадает с code, в окне ±totpSkew.
+// Шаги не новее lastStep отвергаются: один код нельзя использовать дважды.
+func matchTOTP(secret, code string, lastStep int64, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > lastStep && hmac.Equal([]byte(totpAt(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// otpauthURI — ссылка для QR-кода (формат Google Authenticator).
+func otpauthURI(secret, username string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+username) + "?" + q.Encode()
+}
+
+// normalizeCode убирает пробелы и дефисы: коды часто копируют с ними.
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// isTOTPCode отличает код из приложения (только цифры) от резервного.
+func isTOTPCode(code string) bool {
+	return len(code) == totpDigits && strings.Trim(code, "0123456789") == ""
+}
+
+// --- Резервные коды ---
+
+// replaceRecoveryCodes выдает новый набор резервных кодов вместо прежнего.
+// Коды возвращаются открытыми только здесь.
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(raw)) // 8 символов
+		codes[i] = code[:4] + "-" + code[4:]
+		rows[i] = RecoveryCode{UserID: userID, CodeHash: hashToken(code)}
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// checkSecondFactor проверяет код из приложения или резервный код и сразу
+// его расходует. Неверный код — INVALID_TWO_FACTOR_CODE.
+func checkSecondFactor(ctx context.Context, db *gorm.DB, user User, code string) error {
+	code = normalizeCode(code)
+	var result *gorm.DB
+	if isTOTPCode(code) {
+		step, ok := matchTOTP(user.TOTPSecret, code, user.TOTPLastStep, time.Now())
+		if !ok {
+			return apperr.New(apperr.InvalidTwoFactorCode)
+		}
+		// Условие на totp_last_step защищает от двух одновременных входов одним кодом
+		result = db.Model(&User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+	} else {
+		result = db.Model(&RecoveryCode{}).Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(code)).
+			Update("used_at", time.Now())
+	}
+	if result.Error != nil {
+		logging.FromContext(ctx).Error("failed to use second factor", "user_id", user.ID, "error", result.Error)
+		return apperr.Wrap(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return apperr.New(apperr.InvalidTwoFactorCode)
+	}
+	return nil
+}
+
+// --- Политика ---
+
+// TwoFactorRequiredRoles возвращает роли, которым 2FA обязательна.
+func TwoFactorRequiredRoles(ctx context.Context, db *gorm.DB) ([]string, error) {
+	value, err := getSetting(db, settingTwoFactorRoles, "")
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to load two-factor policy", "error", err)
+		return nil, apperr.Wrap(err)
+	}
+	roles := []string{}
+	for _, role := range strings.Split(value, ",") {
+		if role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+// SetTwoFactorRequiredRoles задает роли, которым 2FA обязательна (только
+// moderator и admin; пустой список — 2FA необязательна никому). Пользователи
+// этих ролей без 2FA сохраняют вход, но их права не действуют, пока 2FA
+// не включена.
+func SetTwoFactorRequiredRoles(ctx context.Context, db *gorm.DB, roles []string) ([]string, error) {
+	clean := []string{}
+	for _, role := range staffRoles {
+		if slices.Contains(roles, role) {
+			clean = append(clean, role)
+		}
+	}
+	for _, role := range roles {
+		if !slices.Contains(staffRoles, role) {
+			return nil, apperr.New(apperr.InvalidRole).Param("allowed", strings.Join(staffRoles, ", "))
+		}
+	}
+	if err := putSetting(db, settingTwoFactorRoles, strings.Join(clean, ",")); err != nil {
+		logging.FromContext(ctx).Error("failed to save two-factor policy", "error", err)
+		return nil, apperr.Wrap(err)
+	}
+	logging.FromContext(ctx).Info("two-factor policy changed", "roles", clean)
+	return clean, nil
+}
+
+// twoFactorRequired сообщает, обязательна ли 2FA для роли.
+func twoFactorRequired(ctx context.Context, db *gorm.DB, role string) (bool, error) {
+	roles, err := TwoFactorRequiredRoles(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(roles, role), nil
+}
+
+// --- Операции ---
+
+// SetupTwoFactor выдает новый секрет. 2FA включится только после проверки
+// кода (EnableTwoFactor), поэтому повторный вызов просто заменяет секрет.
+func SetupTwoFactor(ctx context.Context, db *gorm.DB, userID uint) (TwoFactorSetup, error) {
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return TwoFactorSetup{}, err
+	}
+	if user.TOTPEnabledAt != nil {
+		return TwoFactorSetup{}, apperr.New(apperr.TwoFactorAlreadyEnabled)
+	}
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return TwoFactorSetup{}, apperr.Wrap(err)
+	}
+	secret := totpEncoding.EncodeToString(raw)
+	if err := db.Model(&user).Updates(map[string]any{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to save totp secret", "user_id", userID, "error", err)
+		return TwoFactorSetup{}, apperr.Wrap(err)
+	}
+	return TwoFactorSetup{Secret: secret, OTPAuthURI: otpauthURI(secret, user.Username)}, nil
+}
+
+// EnableTwoFactor включает 2FA, если code подходит к выданному секрету,
+// и возвращает резервные коды.
+func EnableTwoFactor(ctx context.Context, db *gorm.DB, userID uint, code string) ([]string, error) {
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabledAt != nil {
+		return nil, apperr.New(apperr.TwoFactorAlreadyEnabled)
+	}
+	if user.TOTPSecret == "" {
+		return nil, apperr.New(apperr.TwoFactorNotEnabled)
+	}
+	step, ok := matchTOTP(user.TOTPSecret, normalizeCode(code), 0, time.Now())
+	if !ok {
+		return nil, apperr.New(apperr.InvalidTwoFactorCode)
+	}
+
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]any{"totp_enabled_at": time.Now(), "totp_last_step": step}).Error; err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to enable two-factor", "user_id", userID, "error", err)
+		return nil, apperr.Wrap(err)
+	}
+	logging.FromContext(ctx).Info("two-factor enabled", "user_id", userID)
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes заменяет резервные коды; нужен действующий код 2FA.
+func RegenerateRecoveryCodes(ctx context.Context, db *gorm.DB, userID uint, code string) ([]string, error) {
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabledAt == nil {
+		return nil, apperr.New(apperr.TwoFactorNotEnabled)
+	}
+	if err := checkSecondFactor(ctx, db, user, code); err != nil {
+		return nil, err
+	}
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to regenerate recovery codes", "user_id", userID, "error", err)
+		return nil, apperr.Wrap(err)
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor выключает 2FA по действующему коду. Если роль
+// пользователя требует 2FA, выключить ее нельзя.
+func DisableTwoFactor(ctx context.Context, db *gorm.DB, userID uint, code string) error {
+	user, err := findUser(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if user.TOTPEnabledAt == nil {
+		return apperr.New(apperr.TwoFactorNotEnabled)
+	}
+	required, err := twoFactorRequired(ctx, db, user.Role)
+	if err != nil {
+		return err
+	}
+	if required {
+		return apperr.New(apperr.TwoFactorRequired)
+	}
+	if err := checkSecondFactor(ctx, db, user, code); err != nil {
+		return err
+	}
+	return ResetTwoFactor(ctx, db, userID)
+}
+
+// ResetTwoFactor выключает 2FA без проверки кода: секрет, резервные коды
+// и незавершенные входы удаляются. Используется администратором, когда
+// пользователь потерял телефон (admin user reset-2fa).
+func ResetTwoFactor(ctx context.Context, db *gorm.DB, userID uint) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ?", userID).
+			Updates(map[string]any{"totp_secret": "", "totp_enabled_at": nil, "totp_last_step": 0})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return apperr.New(apperr.UserNotFound)
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&LoginChallenge{}).Error
+	})
+	var appErr *apperr.Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to disable two-factor", "user_id", userID, "error", err)
+		return apperr.Wrap(err)
+	}
+	logging.FromContext(ctx).Info("two-factor disabled", "user_id", userID)
+	return nil
+}
+
+// createLoginChallenge начинает вход, ожидающий второго фактора.
+func createLoginChallenge(ctx context.Context, db *gorm.DB, userID uint) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, apperr.Wrap(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	challenge := LoginChallenge{UserID: userID, TokenHash: hashToken(token), ExpiresAt: time.Now().Add(challengeTTL)}
+	if err := db.Create(&challenge).Error; err != nil {
+		logging.FromContext(ctx).Error("failed to create login challenge", "user_id", userID, "error", err)
+		return "", time.Time{}, apperr.Wrap(err)
+	}
+	return token, challenge.ExpiresAt, nil
+}
+
+// reserveTwoFactorAttempt засчитывает попытку ввода кода до его проверки:
+// параллельные запросы не обойдут блокировку. Верный код сбрасывает счетчик
+// (см. resetTwoFactorFailures).
+func reserveTwoFactorAttempt(ctx context.Context, db *gorm.DB, userID uint) error {
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.TwoFactorLockedUntil != nil && now.Before(*user.TwoFactorLockedUntil) {
+			return apperr.New(apperr.TwoFactorLocked).
+				Param("retry_at", user.TwoFactorLockedUntil.UTC().Format(time.RFC3339))
+		}
+		failures := user.TwoFactorFailures + 1
+		updates := map[string]any{"two_factor_failures": failures}
+		if failures%twoFactorLockoutEvery == 0 {
+			lockout := twoFactorLockout
+			for i := 1; i < failures/twoFactorLockoutEvery && lockout < twoFactorMaxLockout; i++ {
+				lockout *= 2
+			}
+			updates["two_factor_locked_until"] = now.Add(min(lockout, twoFactorMaxLockout))
+		}
+		return tx.Model(&user).Updates(updates).Error
+	})
+	var appErr *apperr.Error
+	if err != nil && !errors.As(err, &appErr) {
+		logging.FromContext(ctx).Error("failed to count two-factor attempt", "user_id", userID, "error", err)
+		return apperr.Wrap(err)
+	}
+	return err
+}
+
+// resetTwoFactorFailures обнуляет счетчик неверных кодов после верного.
+func resetTwoFactorFailures(ctx context.Context, db *gorm.DB, userID uint) {
+	err := db.Model(&User{}).Where("id = ?", userID).
+		Updates(map[string]any{"two_factor_failures": 0, "two_factor_locked_until": nil}).Error
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to reset two-factor failures", "user_id", userID, "error", err)
+	}
+}
+
+// --- HTTP ---
+
+// TwoFactorLoginHandler — второй шаг входа: challenge_token и код меняются на сессию.
+// POST /api/v1/auth/login/2fa
+func TwoFactorLoginHandler(db *gorm.DB, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		ctx := c.Request.Context()
+
+		var req TwoFactorLoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperr.Render(c, apperr.Binding(err))
+			return
+		}
+
+		// 1. Действующий вызов и его пользователь
+		var challenge LoginChallenge
+		err := db.Where("token_hash = ? AND expires_at > ?", hashToken(req.ChallengeToken), time.Now()).First(&challenge).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apperr.Render(c, apperr.New(apperr.TwoFactorChallengeExpired))
+				return
+			}
+			logging.L(c).Error("failed to load login challenge", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		user, err := findUser(ctx, db, challenge.UserID)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+
+		// 2. Попытка расходуется до проверки кода одним UPDATE: параллельные
+		// запросы с тем же challenge_token не обойдут challengeMaxAttempts
+		result := db.Model(&LoginChallenge{}).Where("id = ? AND attempts < ?", challenge.ID, challengeMaxAttempts).
+			Update("attempts", gorm.Expr("attempts + 1"))
+		if result.Error != nil {
+			logging.L(c).Error("failed to count login challenge attempt", "error", result.Error)
+			apperr.Render(c, apperr.Wrap(result.Error))
+			return
+		}
+		if result.RowsAffected == 0 {
+			apperr.Render(c, apperr.New(apperr.TwoFactorChallengeExpired))
+			return
+		}
+
+		// 3. Проверка кода; после challengeMaxAttempts ошибок вызов сгорает,
+		// а серия ошибок по всем вызовам закрывает ввод кодов на время
+		if err := reserveTwoFactorAttempt(ctx, db, user.ID); err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		if err := checkSecondFactor(ctx, db, user, req.Code); err != nil {
+			var appErr *apperr.Error
+			if errors.As(err, &appErr) && appErr.Code == apperr.InvalidTwoFactorCode {
+				metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+				db.Where("id = ? AND attempts >= ?", challenge.ID, challengeMaxAttempts).Delete(&LoginChallenge{})
+			}
+			apperr.Render(c, err)
+			return
+		}
+
+		// 4. Вызов одноразовый: из параллельных верных запросов сессию получит один
+		result = db.Delete(&challenge)
+		if result.Error != nil {
+			logging.L(c).Error("failed to delete login challenge", "error", result.Error)
+			apperr.Render(c, apperr.Wrap(result.Error))
+			return
+		}
+		if result.RowsAffected == 0 {
+			apperr.Render(c, apperr.New(apperr.TwoFactorChallengeExpired))
+			return
+		}
+		resetTwoFactorFailures(ctx, db, user.ID)
+		completeLogin(c, db, user, ttl)
+	}
+}
+
+// currentUser загружает текущего пользователя; анонимному запросу — 401.
+func currentUser(c *gin.Context, db *gorm.DB) (User, bool) {
+	userID, ok := requireUser(c)
+	if !ok {
+		return User{}, false
+	}
+	user, err := findUser(c.Request.Context(), db, userID)
+	if err != nil {
+		apperr.Render(c, err)
+		return User{}, false
+	}
+	return user, true
+}
+
+// TwoFactorStatusHandler сообщает, включена ли 2FA у текущего пользователя.
+// GET /api/v1/me/2fa
+func TwoFactorStatusHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		user, ok := currentUser(c, db)
+		if !ok {
+			return
+		}
+		status := TwoFactorStatus{Enabled: user.TOTPEnabledAt != nil, EnabledAt: user.TOTPEnabledAt}
+		if err := db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&status.RecoveryCodesLeft).Error; err != nil {
+			logging.L(c).Error("failed to count recovery codes", "error", err)
+			apperr.Render(c, apperr.Wrap(err))
+			return
+		}
+		required, err := twoFactorRequired(c.Request.Context(), db, user.Role)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		status.Required = required
+		c.JSON(http.StatusOK, status)
+	}
+}
+
+// SetupTwoFactorHandler выдает секрет для приложения-аутентификатора.
+// POST /api/v1/me/2fa/setup
+func SetupTwoFactorHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		setup, err := SetupTwoFactor(c.Request.Context(), db, userID)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, setup)
+	}
+}
+
+// twoFactorCodeHandler — общий вид обработчиков, которые принимают код 2FA.
+func twoFactorCodeHandler(db *gorm.DB, run func(c *gin.Context, db *gorm.DB, userID uint, code string)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		var req TwoFactorCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperr.Render(c, apperr.Binding(err))
+			return
+		}
+		run(c, db, userID, req.Code)
+	}
+}
+
+// EnableTwoFactorHandler включает 2FA по первому коду из приложения.
+// POST /api/v1/me/2fa/enable
+func EnableTwoFactorHandler(db *gorm.DB) gin.HandlerFunc {
+	return twoFactorCodeHandler(db, func(c *gin.Context, db *gorm.DB, userID uint, code string) {
+		codes, err := EnableTwoFactor(c.Request.Context(), db, userID, code)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Двухфакторная аутентификация включена; сохраните резервные коды", "recovery_codes": codes})
+	})
+}
+
+// RegenerateRecoveryCodesHandler выдает новые резервные коды вместо прежних.
+// POST /api/v1/me/2fa/recovery-codes
+func RegenerateRecoveryCodesHandler(db *gorm.DB) gin.HandlerFunc {
+	return twoFactorCodeHandler(db, func(c *gin.Context, db *gorm.DB, userID uint, code string) {
+		codes, err := RegenerateRecoveryCodes(c.Request.Context(), db, userID, code)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Резервные коды заменены", "recovery_codes": codes})
+	})
+}
+
+// DisableTwoFactorHandler выключает 2FA.
+// DELETE /api/v1/me/2fa
+func DisableTwoFactorHandler(db *gorm.DB) gin.HandlerFunc {
+	return twoFactorCodeHandler(db, func(c *gin.Context, db *gorm.DB, userID uint, code string) {
+		if err := DisableTwoFactor(c.Request.Context(), db, userID, code); err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Двухфакторная аутентификация выключена"})
+	})
+}
+
+// GetTwoFactorPolicyHandler возвращает роли, которым 2FA обязательна.
+// GET /api/v1/admin/2fa-policy
+func GetTwoFactorPolicyHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		if !requireAdmin(c, db) {
+			return
+		}
+		roles, err := TwoFactorRequiredRoles(c.Request.Context(), db)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, TwoFactorPolicy{RequiredRoles: roles})
+	}
+}
+
+// SetTwoFactorPolicyHandler задает роли, которым 2FA обязательна.
+// PUT /api/v1/admin/2fa-policy
+func SetTwoFactorPolicyHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		if !requireAdmin(c, db) {
+			return
+		}
+		var policy TwoFactorPolicy
+		if err := c.ShouldBindJSON(&policy); err != nil {
+			apperr.Render(c, apperr.Binding(err))
+			return
+		}
+		roles, err := SetTwoFactorRequiredRoles(c.Request.Context(), db, policy.RequiredRoles)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, TwoFactorPolicy{RequiredRoles: roles})
+	}
+}
@@ -0,0 +1,262 @@
+package database
+
+import (
+	apperr "REVFORUM/apperr"
+	logging "REVFORUM/logging"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+// Имена пользователей и email сравниваются без учета регистра.
+//
+// Имя хранится в том виде, в каком его ввели (после NFKC), а уникальность
+// проверяется по ключу UsernameKey: нижний регистр и замена похожих символов
+// (кириллическая «а», греческая «ο», цифра 0 и т. п.) на латинские, чтобы
+// «Admin», «аdmin» с кириллической «а» и «adm1n» не выдавали себя друг за
+// друга. Вертикальные штрихи (i, I, l, 1, ı, |) неразличимы во многих
+// шрифтах и сводятся к одной букве l: «WIll» и «Wlll» — одно имя. Email
+// хранится сразу в нижнем регистре. Оба поля заполняет хук BeforeSave,
+// поэтому ключ верен при любом способе создания пользователя.
+
+const (
+	usernameMinLength = 3
+	usernameMaxLength = 32
+)
+
+// reservedUsernames — имена, которые нельзя занять при регистрации (сравниваются
+// по ключу). Администратор может создать такого пользователя командой admin user create.
+var reservedUsernames = []string{
+	"admin", "administrator", "moderator", "mod", "system", "root",
+	"support", "staff", "revforum",
+}
+
+// confusables — символы, похожие на латинские буквы и цифры, в нижнем регистре.
+var confusables = map[rune]rune{
+	// Кириллица
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p',
+	'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'l', 'ї': 'l', 'ј': 'j', 'һ': 'h',
+	'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ү': 'y', 'ӏ': 'l',
+	// Греческий
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'l', 'κ': 'k', 'ν': 'v', 'ο': 'o',
+	'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ϲ': 'c', 'ϳ': 'j',
+	// Латиница и цифры; i (и I после нижнего регистра) совпадает с l
+	'i': 'l', 'ı': 'l', '|': 'l', 'ɑ': 'a', 'ɡ': 'g', 'ʟ': 'l', '0': 'o', '1': 'l',
+}
+
+// normalizeUsername приводит введенное имя к NFKC: полноширинные буквы,
+// лигатуры и т. п. становятся обычными символами.
+func normalizeUsername(name string) string {
+	return strings.TrimSpace(norm.NFKC.String(name))
+}
+
+// CanonicalUsername — ключ уникальности имени: NFKC, нижний регистр
+// и замена похожих символов.
+func CanonicalUsername(name string) string {
+	return strings.Map(func(r rune) rune {
+		if c, ok := confusables[r]; ok {
+			return c
+		}
+		return r
+	}, strings.ToLower(normalizeUsername(name)))
+}
+
+// canonicalEmail — email в том виде, в котором он хранится и сравнивается.
+func canonicalEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// BeforeSave заполняет ключ имени и приводит email к нижнему регистру.
+func (u *User) BeforeSave(tx *gorm.DB) error {
+	if u.Username != "" {
+		u.Username = normalizeUsername(u.Username)
+		key := CanonicalUsername(u.Username)
+		u.UsernameKey = &key
+	}
+	u.Email = canonicalEmail(u.Email)
+	return nil
+}
+
+// checkUsername проверяет длину и символы имени: буквы, цифры, «_», «-» и «.»,
+// но не одни цифры.
+func checkUsername(field, name string) error {
+	e := apperr.New(apperr.ValidationFailed)
+	name = normalizeUsername(name)
+	if n := utf8.RuneCountInString(name); n < usernameMinLength {
+		e.Field(field, "min", strconv.Itoa(usernameMinLength))
+	} else if n > usernameMaxLength {
+		e.Field(field, "max", strconv.Itoa(usernameMaxLength))
+	}
+	if strings.IndexFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.'
+	}) >= 0 {
+		e.Field(field, "username_chars", "")
+	} else if strings.IndexFunc(name, func(r rune) bool { return !unicode.IsDigit(r) }) < 0 {
+		e.Field(field, "username_digits", "") // Число в ссылке на пользователя — его ID (см. LookupUser)
+	}
+	if len(e.Fields) > 0 {
+		return e
+	}
+	return nil
+}
+
+// usernameReserved сообщает, зарезервировано ли имя.
+func usernameReserved(name string) bool {
+	key := CanonicalUsername(name)
+	return slices.ContainsFunc(reservedUsernames, func(r string) bool { return CanonicalUsername(r) == key })
+}
+
+// usernameInUse сообщает, занято ли имя (с точностью до ключа) кем-то кроме
+// exceptUserID или удерживается за ним после смены (см. UsernameChange.go).
+// Имя заглушки удаленных пользователей занято всегда, даже пока ее нет в базе.
+func usernameInUse(db *gorm.DB, name string, exceptUserID uint) (bool, error) {
+	if CanonicalUsername(name) == DeletedUsername {
+		return true, nil
+	}
+	var n int64
+	if err := db.Model(&User{}).Scopes(byUsername(name)).Where("id <> ?", exceptUserID).Count(&n).Error; err != nil || n > 0 {
+		return n > 0, err
+	}
+	return usernameHeld(db, name, exceptUserID)
+}
+
+// byUsername выбирает пользователя по имени без учета регистра и похожих
+// символов. Точное совпадение имени важнее совпадения ключа: так находится
+// и пользователь, оставшийся без ключа (см. backfillUserKeys).
+func byUsername(name string) func(*gorm.DB) *gorm.DB {
+	exact := normalizeUsername(name)
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("username = ? OR (username_key = ? AND NOT EXISTS (SELECT 1 FROM users WHERE username = ?))",
+			exact, CanonicalUsername(name), exact)
+	}
+}
+
+// isUniqueViolation сообщает, что запись нарушила уникальный индекс: так
+// проявляется гонка двух запросов, каждый из которых прошел предварительную проверку.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.Is(err, gorm.ErrDuplicatedKey) || errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// Версия правил CanonicalUsername. Ключи, построенные прежними правилами,
+// пересчитываются при миграции один раз; номер версии хранится в Setting.
+// Версия 2: «i» сводится к «l».
+const (
+	usernameKeyVersion        = "2"
+	settingUsernameKeyVersion = "username_key_version"
+)
+
+// backfillUserKeys заполняет ключи имен и приводит email к нижнему регистру
+// у пользователей, созданных до появления ключа, а после смены правил
+// (usernameKeyVersion) однократно пересчитывает ключи у пользователей
+// и в истории имен. Пользователь, чье имя или email совпали с уже
+// приведенными, остается без ключа: его нужно переименовать вручную,
+// до тех пор регистрация с похожим именем возможна.
+func backfillUserKeys(db *gorm.DB) error {
+	version, err := getSetting(db, settingUsernameKeyVersion, "1")
+	if err != nil {
+		return err
+	}
+	query := db.Select("id", "username", "email", "username_key").Order("id")
+	if version == usernameKeyVersion {
+		query = query.Where("username_key IS NULL")
+	}
+	var users []User
+	if err := query.Find(&users).Error; err != nil {
+		return err
+	}
+	for _, u := range users {
+		key := CanonicalUsername(u.Username)
+		if u.UsernameKey != nil && *u.UsernameKey == key {
+			continue
+		}
+		err := db.Model(&User{}).Where("id = ?", u.ID).
+			UpdateColumns(map[string]any{"username_key": key, "email": canonicalEmail(u.Email)}).Error
+		if isUniqueViolation(err) {
+			slog.Warn("username or email collides with another user after case folding", "user_id", u.ID, "username", u.Username)
+			if u.UsernameKey != nil {
+				err = db.Model(&User{}).Where("id = ?", u.ID).UpdateColumn("username_key", nil).Error
+			} else {
+				err = nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if version == usernameKeyVersion {
+		return nil
+	}
+
+	var changes []UsernameChange
+	if err := db.Select("id", "old_username", "old_key").Find(&changes).Error; err != nil {
+		return err
+	}
+	for _, ch := range changes {
+		if key := CanonicalUsername(ch.OldUsername); key != ch.OldKey {
+			if err := db.Model(&UsernameChange{}).Where("id = ?", ch.ID).UpdateColumn("old_key", key).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return putSetting(db, settingUsernameKeyVersion, usernameKeyVersion)
+}
+
+// UsernameAvailability — ответ проверки имени.
+type UsernameAvailability struct {
+	Username  string `json:"username"`         // Имя после нормализации
+	Available bool   `json:"available"`        // Можно ли зарегистрироваться с этим именем
+	Reason    string `json:"reason,omitempty"` // taken или reserved, если имя недоступно
+}
+
+// Причины недоступности имени.
+const (
+	UsernameTakenReason    = "taken"
+	UsernameReservedReason = "reserved"
+)
+
+// CheckUsernameAvailability проверяет, можно ли зарегистрироваться с именем name.
+func CheckUsernameAvailability(ctx context.Context, db *gorm.DB, name string) (UsernameAvailability, error) {
+	if err := checkUsername("username", name); err != nil {
+		return UsernameAvailability{}, err
+	}
+	result := UsernameAvailability{Username: normalizeUsername(name), Available: true}
+	if usernameReserved(name) {
+		result.Available, result.Reason = false, UsernameReservedReason
+		return result, nil
+	}
+	taken, err := usernameInUse(db, name, 0)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to check username", "username", name, "error", err)
+		return result, apperr.Wrap(err)
+	}
+	if taken {
+		result.Available, result.Reason = false, UsernameTakenReason
+	}
+	return result, nil
+}
+
+// UsernameAvailabilityHandler проверяет имя перед регистрацией.
+// GET /api/v1/auth/username-available?username=
+func UsernameAvailabilityHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		result, err := CheckUsernameAvailability(c.Request.Context(), db, c.Query("username"))
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
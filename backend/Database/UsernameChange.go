@@ -0,0 +1,221 @@
+package database
+
+import (
+	apperr "REVFORUM/apperr"
+	cache "REVFORUM/cache"
+	logging "REVFORUM/logging"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Смена имени пользователя.
+//
+// Имя можно менять не чаще раза в Cooldown. Каждая смена записывается
+// в UsernameChange; старое имя еще Hold остается за прежним владельцем:
+// ни зарегистрироваться с ним, ни взять его при смене другой пользователь
+// не может, а сам владелец может вернуть его себе. Поиск профиля по имени
+// и упоминания @имя находят пользователя и по старым именам — и после
+// окончания удержания, пока имя никто не занял.
+
+// UsernameChange — запись истории имен.
+type UsernameChange struct {
+	ID          uint      `gorm:"primaryKey" json:"-"`
+	UserID      uint      `gorm:"not null;index" json:"-"`
+	OldUsername string    `gorm:"not null" json:"old_username"`
+	OldKey      string    `gorm:"not null;index" json:"-"` // CanonicalUsername(OldUsername)
+	NewUsername string    `gorm:"not null" json:"new_username"`
+	CreatedAt   time.Time `json:"changed_at"`
+	HeldUntil   time.Time `gorm:"not null" json:"-"` // До этого времени старое имя никому не выдается
+}
+
+// UsernameChangeRules — ограничения смены имени.
+type UsernameChangeRules struct {
+	Cooldown time.Duration // Минимальный промежуток между сменами
+	Hold     time.Duration // Сколько старое имя остается за прежним владельцем
+}
+
+// UsernameChangeRulesFromEnv читает USERNAME_CHANGE_COOLDOWN (по умолчанию
+// 30 суток) и USERNAME_HOLD (по умолчанию 90 суток), например 720h.
+func UsernameChangeRulesFromEnv() UsernameChangeRules {
+	duration := func(name string, def time.Duration) time.Duration {
+		v := os.Getenv(name)
+		if v == "" {
+			return def
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatal("Invalid "+name+": ", v)
+		}
+		return d
+	}
+	return UsernameChangeRules{
+		Cooldown: duration("USERNAME_CHANGE_COOLDOWN", 30*24*time.Hour),
+		Hold:     duration("USERNAME_HOLD", 90*24*time.Hour),
+	}
+}
+
+// ChangeUsernameRequest — новое имя; правила те же, что при регистрации.
+type ChangeUsernameRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+// ChangeUsername меняет имя пользователя и возвращает его вместе со временем,
+// когда имя можно будет сменить снова.
+func ChangeUsername(ctx context.Context, db *gorm.DB, store cache.Store, userID uint, req ChangeUsernameRequest, rules UsernameChangeRules) (User, time.Time, error) {
+	// 1. Проверка имени
+	if err := validate(&req); err != nil {
+		return User{}, time.Time{}, err
+	}
+	if err := checkUsername("username", req.Username); err != nil {
+		return User{}, time.Time{}, err
+	}
+	name := normalizeUsername(req.Username)
+
+	// 2. Проверки и смена — в одной транзакции под блокировкой строки пользователя:
+	// параллельные смены выполняются по очереди, и вторая уже видит запись
+	// в истории первой. Смену на занятое имя остановит уникальный индекс
+	var user User
+	var oldName string
+	var caseOnly bool
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return apperr.New(apperr.UserNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		oldName = user.Username
+		if name == oldName {
+			return nil
+		}
+		if CanonicalUsername(name) == CanonicalUsername(oldName) {
+			// Только регистр или похожие символы: имя то же, ни ожидания, ни записи в истории
+			caseOnly = true
+			return tx.Model(&user).Update("username", name).Error
+		}
+		if usernameReserved(name) {
+			return apperr.New(apperr.UsernameReserved)
+		}
+
+		// Не чаще раза в Cooldown
+		var last UsernameChange
+		err = tx.Where("user_id = ?", userID).Order("created_at DESC").First(&last).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil && now.Before(last.CreatedAt.Add(rules.Cooldown)) {
+			return apperr.New(apperr.UsernameChangeTooSoon).
+				Param("available_at", last.CreatedAt.Add(rules.Cooldown).UTC().Format(time.RFC3339))
+		}
+
+		taken, err := usernameInUse(tx, name, userID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return apperr.New(apperr.UserAlreadyExists)
+		}
+		key := CanonicalUsername(name)
+		if err := tx.Model(&user).Updates(map[string]any{"username": name, "username_key": key}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&UsernameChange{
+			UserID: userID, OldUsername: oldName, OldKey: CanonicalUsername(oldName),
+			NewUsername: name, CreatedAt: now, HeldUntil: now.Add(rules.Hold),
+		}).Error
+	})
+	var appErr *apperr.Error
+	if errors.As(err, &appErr) {
+		return user, time.Time{}, err
+	}
+	if isUniqueViolation(err) {
+		return user, time.Time{}, apperr.New(apperr.UserAlreadyExists)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to change username", "user_id", userID, "error", err)
+		return user, time.Time{}, apperr.Wrap(err)
+	}
+	if name == oldName {
+		return user, time.Time{}, nil
+	}
+	if caseOnly {
+		invalidateAuthorListings(ctx, store)
+		user.Username = name
+		return user, time.Time{}, nil
+	}
+
+	// 3. Имя встраивается в сведения об авторе в кэшированных списках
+	invalidateAuthorListings(ctx, store)
+	logging.FromContext(ctx).Info("username changed", "user_id", userID, "old", oldName, "new", name)
+	user.Username = name
+	return user, now.Add(rules.Cooldown), nil
+}
+
+// ChangeUsernameHandler меняет имя текущего пользователя.
+// PUT /api/v1/me/username
+func ChangeUsernameHandler(db *gorm.DB, store cache.Store, rules UsernameChangeRules) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := withRequest(db, c)
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		var req ChangeUsernameRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperr.Render(c, apperr.Binding(err))
+			return
+		}
+
+		user, next, err := ChangeUsername(c.Request.Context(), db, store, userID, req, rules)
+		if err != nil {
+			apperr.Render(c, err)
+			return
+		}
+		resp := gin.H{"message": "Имя пользователя изменено", "username": user.Username}
+		if !next.IsZero() {
+			resp["next_change_at"] = next.UTC()
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// usernameHeld сообщает, удерживается ли имя за другим пользователем после смены.
+func usernameHeld(db *gorm.DB, name string, exceptUserID uint) (bool, error) {
+	var n int64
+	err := db.Model(&UsernameChange{}).
+		Where("old_key = ? AND held_until > ? AND user_id <> ?", CanonicalUsername(name), time.Now(), exceptUserID).
+		Count(&n).Error
+	return n > 0, err
+}
+
+// findUserByName ищет пользователя по текущему имени, а если такого нет —
+// по прежнему: последним, кто от него отказался.
+func findUserByName(ctx context.Context, db *gorm.DB, name string) (User, error) {
+	var user User
+	err := db.Scopes(byUsername(name)).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		var change UsernameChange
+		err = db.Where("old_key = ?", CanonicalUsername(name)).Order("created_at DESC").First(&change).Error
+		if err == nil {
+			err = db.First(&user, change.UserID).Error
+		}
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, apperr.New(apperr.UserNotFound)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to load user", "username", name, "error", err)
+		return user, apperr.Wrap(err)
+	}
+	return user, nil
+}
@@ -0,0 +1,135 @@
+// Package dbtest поднимает одноразовую базу PostgreSQL для интеграционных тестов
+// и предоставляет построители тестовых данных (см. Fixtures.go).
+//
+// Использование в пакете с тестами:
+//
+//	func TestMain(m *testing.M) { os.Exit(dbtest.Run(m)) }
+//
+//	func TestSomething(t *testing.T) {
+//		db := dbtest.Open(t) // Пустая база со всеми таблицами
+//		f := dbtest.NewFixtures(t, db)
+//		topic := f.Topic(f.SubTheme(f.Theme()), f.User())
+//		...
+//	}
+//
+// Сервер запускается один раз на тестовый бинарник при первом вызове Open;
+// если PostgreSQL недоступен, тесты пропускаются (а с TEST_DATABASE_URL — падают).
+package dbtest
+
+import (
+	database "REVFORUM/database"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var (
+	once     sync.Once
+	shared   *gorm.DB
+	cleanup  func()
+	startErr error
+)
+
+// Run выполняет тесты пакета и затем останавливает сервер, если он запускался.
+func Run(m *testing.M) int {
+	code := m.Run()
+	if cleanup != nil {
+		cleanup()
+	}
+	return code
+}
+
+// Open возвращает подключение к тестовой базе. Все таблицы очищаются,
+// а счетчики ID сбрасываются, поэтому каждый тест начинает с пустой базы.
+// Тесты, использующие Open, нельзя запускать параллельно.
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("интеграционный тест пропущен в режиме -short")
+	}
+	once.Do(func() { shared, cleanup, startErr = start() })
+	if startErr != nil {
+		if os.Getenv("TEST_DATABASE_URL") != "" {
+			t.Fatalf("тестовая база недоступна: %v", startErr)
+		}
+		t.Skipf("PostgreSQL недоступен, интеграционный тест пропущен: %v", startErr)
+	}
+	if err := truncate(shared); err != nil {
+		t.Fatalf("не удалось очистить тестовую базу: %v", err)
+	}
+	return shared
+}
+
+// start запускает сервер, создает в нем базу со случайным именем и применяет миграции.
+func start() (*gorm.DB, func(), error) {
+	srv, err := startServer()
+	if err != nil {
+		return nil, nil, err
+	}
+	quiet := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+
+	admin, err := gorm.Open(postgres.Open(srv.dsn), quiet)
+	if err != nil {
+		srv.stop()
+		return nil, nil, err
+	}
+	name := "revforum_test_" + randomHex(6)
+	if err := admin.Exec("CREATE DATABASE " + name).Error; err != nil {
+		closeDB(admin)
+		srv.stop()
+		return nil, nil, err
+	}
+	dropAndStop := func() {
+		admin.Exec("DROP DATABASE IF EXISTS " + name + " WITH (FORCE)")
+		closeDB(admin)
+		srv.stop()
+	}
+
+	db, err := gorm.Open(postgres.Open(withDatabase(srv.dsn, name)), quiet)
+	if err != nil {
+		dropAndStop()
+		return nil, nil, err
+	}
+	if err := database.Migrate(db); err != nil {
+		closeDB(db)
+		dropAndStop()
+		return nil, nil, fmt.Errorf("миграции: %w", err)
+	}
+	return db, func() {
+		closeDB(db)
+		dropAndStop()
+	}, nil
+}
+
+// truncate очищает все таблицы текущей схемы одним запросом.
+func truncate(db *gorm.DB) error {
+	var tables []string
+	if err := db.Raw("SELECT quote_ident(tablename) FROM pg_tables WHERE schemaname = current_schema()").
+		Scan(&tables).Error; err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return db.Exec("TRUNCATE " + strings.Join(tables, ", ") + " RESTART IDENTITY CASCADE").Error
+}
+
+func closeDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,152 @@
+package dbtest
+
+import (
+	database "REVFORUM/database"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Password — пароль всех пользователей, созданных Fixtures.User.
+const Password = "password123"
+
+// passwordHash считается один раз: bcrypt медленный даже с минимальной стоимостью.
+var passwordHash = func() string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+	return string(hash)
+}()
+
+// Fixtures создает тестовые данные с разумными значениями по умолчанию.
+// Поля можно переопределить функциями-опциями:
+//
+//	admin := f.User(func(u *database.User) { u.Role = database.RoleAdmin })
+//
+// Уникальные поля (имена, заголовки тем) получают порядковый номер, поэтому
+// объекты одного типа можно создавать сколько угодно. Ошибка записи в базу
+// завершает тест.
+//
+// Первый созданный пользователь получает ID 1 — от его имени обработчики
+// выполняют запросы, пока в API нет аутентификации.
+type Fixtures struct {
+	t   testing.TB
+	db  *gorm.DB
+	seq int
+}
+
+// NewFixtures создает построитель поверх базы из Open.
+func NewFixtures(t testing.TB, db *gorm.DB) *Fixtures {
+	return &Fixtures{t: t, db: db}
+}
+
+func (f *Fixtures) next() int {
+	f.seq++
+	return f.seq
+}
+
+func (f *Fixtures) create(v any) {
+	f.t.Helper()
+	if err := f.db.Create(v).Error; err != nil {
+		f.t.Fatalf("не удалось создать %T: %v", v, err)
+	}
+}
+
+// User создает пользователя с ролью user и паролем Password.
+func (f *Fixtures) User(opts ...func(*database.User)) database.User {
+	f.t.Helper()
+	n := f.next()
+	u := database.User{
+		Username:     fmt.Sprintf("user%d", n),
+		Email:        fmt.Sprintf("user%d@example.com", n),
+		PasswordHash: passwordHash,
+		Role:         database.RoleUser,
+	}
+	for _, opt := range opts {
+		opt(&u)
+	}
+	f.create(&u)
+	return u
+}
+
+// Moderator — опция User, выдающая роль модератора.
+func Moderator(u *database.User) { u.Role = database.RoleModerator }
+
+// Admin — опция User, выдающая роль администратора.
+func Admin(u *database.User) { u.Role = database.RoleAdmin }
+
+// Theme создает открытую тему.
+func (f *Fixtures) Theme(opts ...func(*database.Themes_Collection)) database.Themes_Collection {
+	f.t.Helper()
+	theme := database.Themes_Collection{Title: fmt.Sprintf("Тема %d", f.next()), Status: "open"}
+	for _, opt := range opts {
+		opt(&theme)
+	}
+	f.create(&theme)
+	return theme
+}
+
+// SubTheme создает открытую подтему в теме parent.
+func (f *Fixtures) SubTheme(parent database.Themes_Collection, opts ...func(*database.Sub_Themes)) database.Sub_Themes {
+	f.t.Helper()
+	sub := database.Sub_Themes{Title: fmt.Sprintf("Подтема %d", f.next()), Status: "open", ParentID: parent.ID}
+	for _, opt := range opts {
+		opt(&sub)
+	}
+	f.create(&sub)
+	return sub
+}
+
+// Topic создает топик автора author в подтеме sub — без первого сообщения,
+// чтобы тесты сами решали, какие сообщения есть в топике.
+func (f *Fixtures) Topic(sub database.Sub_Themes, author database.User, opts ...func(*database.Topic)) database.Topic {
+	f.t.Helper()
+	topic := database.Topic{
+		Title:      fmt.Sprintf("Топик %d", f.next()),
+		SubThemeID: sub.ID,
+		AuthorID:   author.ID,
+	}
+	for _, opt := range opts {
+		opt(&topic)
+	}
+	f.create(&topic)
+	return topic
+}
+
+// Post создает пост автора author в топике topic.
+func (f *Fixtures) Post(topic database.Topic, author database.User, opts ...func(*database.Post)) database.Post {
+	f.t.Helper()
+	post := database.Post{
+		Content:  fmt.Sprintf("Сообщение %d", f.next()),
+		TopicID:  topic.ID,
+		AuthorID: author.ID,
+	}
+	for _, opt := range opts {
+		opt(&post)
+	}
+	f.create(&post)
+	return post
+}
+
+// Attachment создает запись о вложении автора author (без файла в хранилище).
+// Чтобы привязать вложение к посту, задайте PostID опцией.
+func (f *Fixtures) Attachment(author database.User, opts ...func(*database.Attachment)) database.Attachment {
+	f.t.Helper()
+	n := f.next()
+	a := database.Attachment{
+		AuthorID:    author.ID,
+		Filename:    fmt.Sprintf("file%d.txt", n),
+		ContentType: "text/plain",
+		Size:        1,
+		SHA256:      fmt.Sprintf("%064d", n),
+		StorageKey:  fmt.Sprintf("attachments/test/%d", n),
+	}
+	for _, opt := range opts {
+		opt(&a)
+	}
+	f.create(&a)
+	return a
+}
@@ -0,0 +1,151 @@
+package dbtest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+)
+
+// server — запущенный для тестов сервер PostgreSQL.
+type server struct {
+	dsn  string // Строка подключения к служебной базе postgres
+	stop func() // Останавливает сервер и удаляет его каталог
+}
+
+// startServer поднимает сервер PostgreSQL, перебирая способы по порядку:
+//
+//  1. TEST_DATABASE_URL — уже запущенный сервер (например, сервис в CI);
+//  2. initdb и pg_ctl из PG_BIN, PATH или /usr/lib/postgresql/*/bin;
+//  3. embedded-postgres — бинарники скачиваются при первом запуске и кэшируются.
+//
+// Локальный сервер PostgreSQL отказывается работать от root, поэтому под root
+// возможен только первый способ.
+func startServer() (*server, error) {
+	if dsn := os.Getenv("TEST_DATABASE_URL"); dsn != "" {
+		return &server{dsn: dsn, stop: func() {}}, nil
+	}
+	if os.Geteuid() == 0 {
+		return nil, errors.New("PostgreSQL нельзя запустить от root; задайте TEST_DATABASE_URL")
+	}
+	if bin := findBinaries(); bin != "" {
+		return startLocal(bin)
+	}
+	return startEmbedded()
+}
+
+// findBinaries ищет каталог с initdb и pg_ctl.
+func findBinaries() string {
+	if dir := os.Getenv("PG_BIN"); dir != "" {
+		return dir
+	}
+	if path, err := exec.LookPath("pg_ctl"); err == nil {
+		return filepath.Dir(path)
+	}
+	// Debian и Ubuntu не кладут pg_ctl в PATH; берем самую новую версию
+	dirs, _ := filepath.Glob("/usr/lib/postgresql/*/bin")
+	sort.Strings(dirs)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if _, err := os.Stat(filepath.Join(dirs[i], "pg_ctl")); err == nil {
+			return dirs[i]
+		}
+	}
+	return ""
+}
+
+// startLocal создает кластер во временном каталоге и запускает его на свободном порту.
+// fsync выключен: данные все равно выбрасываются после тестов.
+func startLocal(bin string) (*server, error) {
+	dir, err := os.MkdirTemp("", "revforum-pg-")
+	if err != nil {
+		return nil, err
+	}
+	data := filepath.Join(dir, "data")
+	initdb := exec.Command(filepath.Join(bin, "initdb"), "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-locale")
+	if out, err := initdb.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("initdb: %v: %s", err, out)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -F", port, dir)
+	start := exec.Command(filepath.Join(bin, "pg_ctl"), "-D", data, "-o", opts, "-l", filepath.Join(dir, "server.log"), "-w", "start")
+	if out, err := start.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("pg_ctl start: %v: %s", err, out)
+	}
+
+	return &server{
+		dsn: fmt.Sprintf("host=127.0.0.1 port=%d user=postgres dbname=postgres sslmode=disable", port),
+		stop: func() {
+			exec.Command(filepath.Join(bin, "pg_ctl"), "-D", data, "-m", "immediate", "stop").Run()
+			os.RemoveAll(dir)
+		},
+	}, nil
+}
+
+// startEmbedded запускает PostgreSQL из пакета embedded-postgres.
+func startEmbedded() (*server, error) {
+	dir, err := os.MkdirTemp("", "revforum-pg-")
+	if err != nil {
+		return nil, err
+	}
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	pg := embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().
+		Version(embeddedpostgres.V16).
+		Port(uint32(port)).
+		RuntimePath(filepath.Join(dir, "runtime")).
+		DataPath(filepath.Join(dir, "data")).
+		StartTimeout(time.Minute).
+		Logger(io.Discard))
+	if err := pg.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("embedded-postgres: %w", err)
+	}
+
+	return &server{
+		dsn: fmt.Sprintf("host=127.0.0.1 port=%d user=postgres password=postgres dbname=postgres sslmode=disable", port),
+		stop: func() {
+			pg.Stop()
+			os.RemoveAll(dir)
+		},
+	}, nil
+}
+
+// freePort возвращает свободный TCP-порт на 127.0.0.1.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// withDatabase подставляет имя базы в строку подключения формата URL или key=value.
+func withDatabase(dsn, name string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if u, err := url.Parse(dsn); err == nil {
+			u.Path = "/" + name
+			return u.String()
+		}
+	}
+	return dsn + " dbname=" + name // В формате key=value побеждает последнее значение
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// GormLogger пишет журнал GORM через slog. Если запрос к БД выполняется
+// с контекстом запроса (db.WithContext), в записи попадает его request_id.
+type GormLogger struct {
+	Level         logger.LogLevel
+	SlowThreshold time.Duration // Запросы дольше порога пишутся как warn
+}
+
+// NewGormLogger создает логгер, который пишет ошибки и медленные запросы.
+func NewGormLogger() *GormLogger {
+	return &GormLogger{Level: logger.Warn, SlowThreshold: 200 * time.Millisecond}
+}
+
+func (l *GormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	clone := *l
+	clone.Level = level
+	return &clone
+}
+
+func (l *GormLogger) Info(ctx context.Context, msg string, args ...any) {
+	if l.Level >= logger.Info {
+		FromContext(ctx).Info(fmt.Sprintf(msg, args...), "component", "gorm")
+	}
+}
+
+func (l *GormLogger) Warn(ctx context.Context, msg string, args ...any) {
+	if l.Level >= logger.Warn {
+		FromContext(ctx).Warn(fmt.Sprintf(msg, args...), "component", "gorm")
+	}
+}
+
+func (l *GormLogger) Error(ctx context.Context, msg string, args ...any) {
+	if l.Level >= logger.Error {
+		FromContext(ctx).Error(fmt.Sprintf(msg, args...), "component", "gorm")
+	}
+}
+
+func (l *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.Level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	log := FromContext(ctx)
+
+	switch {
+	// «Не найдено» — обычный исход для First, обработчики разбирают его сами.
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.Level >= logger.Error:
+		sql, rows := fc()
+		log.Error("sql query failed", "component", "gorm", "error", err,
+			"sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	case l.SlowThreshold > 0 && elapsed > l.SlowThreshold && l.Level >= logger.Warn:
+		sql, rows := fc()
+		log.Warn("slow sql query", "component", "gorm",
+			"sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	case l.Level >= logger.Info:
+		sql, rows := fc()
+		log.Debug("sql query", "component", "gorm",
+			"sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	}
+}
+
+var _ logger.Interface = (*GormLogger)(nil)
@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Init настраивает slog.Default по переменным окружения:
+//
+//	LOG_FORMAT — json (для продакшена) или text (по умолчанию, для разработки)
+//	LOG_LEVEL  — debug, info (по умолчанию), warn или error
+//	LOG_OUTPUT — stdout (по умолчанию) или stderr, чтобы журнал не смешивался
+//	             с выводом консольных команд
+//
+// Стандартный пакет log тоже перенаправляется в slog, чтобы сторонние библиотеки
+// писали в тот же поток.
+func Init() {
+	out := os.Stdout
+	if os.Getenv("LOG_OUTPUT") == "stderr" {
+		out = os.Stderr
+	}
+	slog.SetDefault(slog.New(newHandler(out, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))))
+}
+
+func newHandler(w io.Writer, format, level string) slog.Handler {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil || level == "" {
+		lvl = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.NewJSONHandler(w, opts)
+	case "", "text":
+		return slog.NewTextHandler(w, opts)
+	default:
+		log.Fatal("Unknown LOG_FORMAT: ", format)
+		return nil
+	}
+}
+
+type ctxKey struct{}
+
+// WithLogger кладет логгер запроса в context.Context, чтобы его могли достать
+// код без доступа к gin.Context (например, логгер GORM).
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, logger)
+}
+
+// FromContext возвращает логгер запроса или slog.Default, если его нет.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// UserIDKey — ключ gin.Context с ID текущего пользователя.
+const UserIDKey = "user_id"
+
+// L возвращает логгер запроса с request_id, route и method; если
+// пользователь уже определен, добавляется user_id.
+func L(c *gin.Context) *slog.Logger {
+	logger := FromContext(c.Request.Context())
+	if userID, ok := c.Get(UserIDKey); ok {
+		logger = logger.With("user_id", userID)
+	}
+	return logger
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader — заголовок, в котором ID запроса приходит от прокси и возвращается клиенту.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDKey — ключ gin.Context с ID запроса.
+const RequestIDKey = "request_id"
+
+// Middleware присваивает запросу ID (или берет корректный из X-Request-ID),
+// создает логгер запроса и по завершении пишет строку журнала доступа.
+// Заменяет стандартный логгер gin.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		c.Set(RequestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched" // 404: не пишем в журнал произвольные пути как маршрут
+		}
+		logger := slog.Default().With(
+			"request_id", requestID,
+			"method", c.Request.Method,
+			"route", route,
+		)
+		// Если запрос трассируется, по trace_id запись журнала находится в трассе
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			logger = logger.With("trace_id", span.TraceID().String())
+		}
+		c.Request = c.Request.WithContext(WithLogger(c.Request.Context(), logger))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		attrs := []any{
+			"status", status,
+			"latency_ms", time.Since(start).Milliseconds(),
+			"size", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+			"path", c.Request.URL.Path,
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		L(c).Log(c.Request.Context(), level, "request completed", attrs...)
+	}
+}
+
+// validRequestID принимает только короткие ID из безопасных символов,
+// чтобы клиент не мог внедрить в журнал произвольный текст.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// namespace — общий префикс всех метрик форума.
+const namespace = "revforum"
+
+// Registry — собственный реестр вместо prometheus.DefaultRegisterer,
+// чтобы в /metrics попадало только то, что зарегистрировано здесь.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequestDuration — длительность обработки HTTP-запросов.
+	// route — шаблон маршрута gin (/api/themes/:id/subthemes), а не фактический путь,
+	// чтобы число рядов не росло с каждым ID.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// DBQueryDuration — длительность SQL-запросов GORM по операции и таблице.
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "GORM query latency by operation and table.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+
+	// DBQueryErrors — неудачные SQL-запросы (кроме «запись не найдена»).
+	DBQueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "query_errors_total",
+		Help:      "Failed GORM queries by operation and table.",
+	}, []string{"operation", "table"})
+
+	// Registrations — успешные регистрации пользователей.
+	Registrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "Successfully registered users.",
+	})
+
+	// Logins — попытки входа; result: success или failure.
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by result (success, failure).",
+	}, []string{"result"})
+
+	// TopicsCreated — созданные топики.
+	TopicsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "topics_created_total",
+		Help:      "Created topics.",
+	})
+
+	// PostsCreated — созданные посты.
+	PostsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "posts_created_total",
+		Help:      "Created posts.",
+	})
+)
+
+// Значения метки result для Logins.
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		DBQueryDuration,
+		DBQueryErrors,
+		Registrations,
+		Logins,
+		TopicsCreated,
+		PostsCreated,
+	)
+	// Ряды с нулями видны сразу, а не только после первого входа
+	Logins.WithLabelValues(LoginSuccess)
+	Logins.WithLabelValues(LoginFailure)
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Middleware измеряет длительность каждого запроса.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched" // 404: произвольные пути не должны плодить ряды
+		}
+		HTTPRequestDuration.
+			WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// Access — кому разрешено читать /metrics.
+type Access struct {
+	Token    string       // Если задан, нужен заголовок Authorization: Bearer <Token>
+	Networks []*net.IPNet // Если заданы, адрес клиента должен входить в одну из сетей
+}
+
+// AccessFromEnv читает настройки доступа:
+//
+//	METRICS_TOKEN        — bearer-токен для сборщика метрик
+//	METRICS_ALLOWED_NETS — список CIDR через запятую (по умолчанию только loopback)
+//
+// Проверяются оба условия: токен (если задан) и сеть клиента.
+// METRICS_ALLOWED_NETS=any снимает ограничение по сети — тогда обязателен токен.
+func AccessFromEnv() Access {
+	access := Access{Token: os.Getenv("METRICS_TOKEN")}
+
+	nets := os.Getenv("METRICS_ALLOWED_NETS")
+	if nets == "" {
+		nets = "127.0.0.0/8,::1/128"
+	}
+	if nets == "any" {
+		if access.Token == "" {
+			log.Fatal("METRICS_ALLOWED_NETS=any requires METRICS_TOKEN")
+		}
+		return access
+	}
+	for _, cidr := range strings.Split(nets, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			log.Fatal("Invalid METRICS_ALLOWED_NETS: ", cidr)
+		}
+		access.Networks = append(access.Networks, network)
+	}
+	return access
+}
+
+// Handler отдает метрики в формате Prometheus тем, кому это разрешено access.
+func Handler(access Access) gin.HandlerFunc {
+	h := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+	return func(c *gin.Context) {
+		if !access.allowed(c) {
+			// 404, а не 403: посторонним незачем знать, что эндпоинт существует
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+func (a Access) allowed(c *gin.Context) bool {
+	if a.Token != "" {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+			return false
+		}
+	}
+	if len(a.Networks) == 0 {
+		return true
+	}
+	// Адрес TCP-соединения, а не X-Forwarded-For: заголовок подделывается клиентом.
+	// За обратным прокси сеть ограничивайте на нем или используйте токен.
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.Networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MemoryBroker доставляет события внутри одного процесса.
+// Подходит, когда бэкенд запущен в одном экземпляре.
+type MemoryBroker struct {
+	events chan Event
+}
+
+// NewMemoryBroker создает брокер с очередью на buffer событий.
+func NewMemoryBroker(buffer int) *MemoryBroker {
+	return &MemoryBroker{events: make(chan Event, buffer)}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, e Event) error {
+	select {
+	case b.events <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *MemoryBroker) Run(ctx context.Context, deliver func(Event)) error {
+	for {
+		select {
+		case e := <-b.events:
+			deliver(e)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// pgChannel — канал PostgreSQL NOTIFY, общий для всех экземпляров бэкенда.
+const pgChannel = "revforum_events"
+
+// pgPayloadLimit — запас до лимита NOTIFY в 8000 байт.
+const pgPayloadLimit = 7900
+
+// PostgresBroker синхронизирует несколько экземпляров бэкенда через LISTEN/NOTIFY.
+type PostgresBroker struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresBroker открывает пул соединений к PostgreSQL по dsn.
+func NewPostgresBroker(ctx context.Context, dsn string) (*PostgresBroker, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return &PostgresBroker{pool: pool}, nil
+}
+
+func (b *PostgresBroker) Publish(ctx context.Context, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if len(payload) > pgPayloadLimit {
+		// Полезная нагрузка не влезает в NOTIFY — отправляем событие без данных,
+		// клиент перезапросит объект по REST.
+		e.Data = nil
+		if payload, err = json.Marshal(e); err != nil {
+			return err
+		}
+	}
+	_, err = b.pool.Exec(ctx, "SELECT pg_notify($1, $2)", pgChannel, string(payload))
+	return err
+}
+
+// Run слушает канал на выделенном соединении и переподключается при обрывах.
+func (b *PostgresBroker) Run(ctx context.Context, deliver func(Event)) error {
+	defer b.pool.Close()
+	for {
+		err := b.listen(ctx, deliver)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.Warn("event listener connection lost, reconnecting", "error", err)
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *PostgresBroker) listen(ctx context.Context, deliver func(Event)) error {
+	conn, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgChannel); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(n.Payload), &e); err != nil {
+			slog.Warn("malformed event notification", "channel", pgChannel, "error", err)
+			continue
+		}
+		deliver(e)
+	}
+}
+
+// Init создает хаб по переменной окружения REALTIME_BROKER:
+// memory (по умолчанию) или postgres — тогда используется dsn.
+// Хаб начинает доставку событий в отдельной горутине.
+func Init(dsn string) *Hub {
+	var broker Broker
+	switch kind := os.Getenv("REALTIME_BROKER"); kind {
+	case "", "memory":
+		broker = NewMemoryBroker(256)
+	case "postgres":
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		pg, err := NewPostgresBroker(ctx, dsn)
+		if err != nil {
+			log.Fatal("Failed to start postgres event broker: ", err)
+		}
+		broker = pg
+	default:
+		log.Fatal("Unknown REALTIME_BROKER: ", kind)
+	}
+
+	hub := NewHub(broker)
+	go hub.Run(context.Background())
+	return hub
+}
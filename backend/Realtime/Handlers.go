@@ -0,0 +1,234 @@
+package realtime
+
+import (
+	apperr "REVFORUM/apperr"
+	logging "REVFORUM/logging"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// maxChannels ограничивает число каналов на одно подключение.
+const maxChannels = 50
+
+// pingInterval — как часто отправлять keep-alive, чтобы прокси не закрывали соединение.
+const pingInterval = 25 * time.Second
+
+// SSEHandler отдает поток событий Server-Sent Events.
+// GET /api/v1/events?topic=1&topic=2&subtheme=3
+func SSEHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 1. Разбор каналов из строки запроса
+		var channels []string
+		for _, id := range c.QueryArray("topic") {
+			channels = append(channels, "topic:"+id)
+		}
+		for _, id := range c.QueryArray("subtheme") {
+			channels = append(channels, "subtheme:"+id)
+		}
+		for i, ch := range channels {
+			canonical, err := validateChannel(ch)
+			if err != nil {
+				apperr.Render(c, apperr.New(apperr.InvalidChannel).Param("channel", ch))
+				return
+			}
+			channels[i] = canonical
+		}
+		if len(channels) == 0 || len(channels) > maxChannels {
+			apperr.Render(c, apperr.New(apperr.TooManyChannels).Param("max", strconv.Itoa(maxChannels)))
+			return
+		}
+
+		// 2. Подписка на каналы до конца соединения
+		sub := hub.Subscribe(channels...)
+		defer hub.Unsubscribe(sub)
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no") // Отключаем буферизацию в nginx
+		// Заголовки уходят сразу: клиент узнает об открытии потока, не дожидаясь первого события
+		c.Status(http.StatusOK)
+		c.Writer.WriteHeaderNow()
+		c.Writer.Flush()
+
+		ping := time.NewTicker(pingInterval)
+		defer ping.Stop()
+
+		// 3. Отправка событий, пока клиент не отключится
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case e := <-sub.C:
+				c.SSEvent(e.Type, e)
+				return true
+			case <-ping.C:
+				_, err := io.WriteString(w, ": ping\n\n") // Комментарий SSE, клиент его игнорирует
+				return err == nil
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
+
+// TypingRequest — уведомление «пользователь печатает» для клиентов на SSE,
+// у которых нет обратного канала. Печатает всегда текущий пользователь.
+type TypingRequest struct {
+	TopicID uint `json:"topic_id" binding:"required"`
+}
+
+// TypingHandler публикует событие typing в канал топика.
+// POST /api/v1/events/typing
+func TypingHandler(hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := currentUserID(c)
+		if userID == 0 {
+			apperr.Render(c, apperr.New(apperr.Unauthenticated))
+			return
+		}
+		var req TypingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apperr.Render(c, apperr.Binding(err))
+			return
+		}
+		hub.Publish(c.Request.Context(), Typing, TopicChannel(req.TopicID), gin.H{"user_id": userID})
+		c.Status(http.StatusAccepted)
+	}
+}
+
+// wsMessage — сообщение клиента по WebSocket.
+type wsMessage struct {
+	Action  string `json:"action"`  // subscribe, unsubscribe или typing
+	Channel string `json:"channel"` // Например topic:5
+}
+
+// WebSocketHandler принимает WebSocket-подключения. Клиент управляет подписками
+// сообщениями {"action":"subscribe","channel":"topic:5"} и может отправлять typing,
+// если подключился с токеном сессии.
+// GET /api/v1/ws
+func WebSocketHandler(hub *Hub, allowedOrigins []string) gin.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true // Не браузер — CSRF невозможен
+			}
+			for _, allowed := range allowedOrigins {
+				if origin == allowed {
+					return true
+				}
+			}
+			return false
+		},
+	}
+
+	return func(c *gin.Context) {
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			return // Upgrader уже ответил клиенту
+		}
+		defer conn.Close()
+
+		userID := currentUserID(c) // Пользователь определен при рукопожатии (см. database.Authenticate)
+		sub := hub.Subscribe()
+		defer hub.Unsubscribe(sub)
+
+		// Писать в соединение может только одна горутина, поэтому ответы
+		// на сообщения клиента идут через тот же цикл, что и события.
+		replies := make(chan gin.H, 8)
+		done := make(chan struct{})
+		go writeLoop(conn, sub, replies, done)
+		defer close(done)
+		reply := func(r gin.H) {
+			select {
+			case replies <- r:
+			default: // Клиент не читает ответы — не блокируем чтение
+			}
+		}
+
+		for {
+			var msg wsMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return // Клиент отключился или прислал не JSON
+			}
+			channel, err := validateChannel(msg.Channel)
+			if err != nil {
+				reply(apperr.Body(c, apperr.New(apperr.InvalidChannel).Param("channel", msg.Channel)))
+				continue
+			}
+			switch msg.Action {
+			case "subscribe":
+				if len(sub.channels) >= maxChannels {
+					reply(apperr.Body(c, apperr.New(apperr.TooManyChannels).Param("max", strconv.Itoa(maxChannels))))
+					continue
+				}
+				hub.Join(sub, channel)
+			case "unsubscribe":
+				hub.Leave(sub, channel)
+			case "typing":
+				if userID == 0 {
+					reply(apperr.Body(c, apperr.New(apperr.Unauthenticated)))
+					continue
+				}
+				if !strings.HasPrefix(channel, "topic:") {
+					reply(apperr.Body(c, apperr.New(apperr.TypingTopicOnly)))
+					continue
+				}
+				hub.Publish(c.Request.Context(), Typing, channel, gin.H{"user_id": userID})
+			default:
+				reply(apperr.Body(c, apperr.New(apperr.UnknownAction).Param("action", msg.Action)))
+			}
+		}
+	}
+}
+
+func writeLoop(conn *websocket.Conn, sub *Subscriber, replies <-chan gin.H, done <-chan struct{}) {
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case e := <-sub.C:
+			err = conn.WriteJSON(e)
+		case r := <-replies:
+			err = conn.WriteJSON(r)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second))
+		case <-done:
+			return
+		}
+		if err != nil {
+			conn.Close() // Разблокирует ReadJSON в обработчике
+			return
+		}
+	}
+}
+
+// validateChannel проверяет, что канал имеет вид topic:<id> или subtheme:<id>,
+// и возвращает его в том виде, в каком в него публикуются события: topic:05 — это topic:5.
+func validateChannel(channel string) (string, error) {
+	kind, id, ok := strings.Cut(channel, ":")
+	if !ok || (kind != "topic" && kind != "subtheme") {
+		return "", errors.New("ожидается topic:<id> или subtheme:<id>")
+	}
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return "", errors.New("некорректный ID в канале " + channel)
+	}
+	if kind == "topic" {
+		return TopicChannel(uint(n)), nil
+	}
+	return SubThemeChannel(uint(n)), nil
+}
+
+// currentUserID возвращает ID пользователя, вошедшего по токену; 0 — запрос анонимный.
+func currentUserID(c *gin.Context) uint {
+	return c.GetUint(logging.UserIDKey)
+}
@@ -0,0 +1,156 @@
+package realtime
+
+import (
+	logging "REVFORUM/logging"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Типы событий, которые получают подписчики.
+const (
+	TopicCreated = "topic.created"
+	TopicUpdated = "topic.updated"
+	TopicDeleted = "topic.deleted"
+	PostCreated  = "post.created"
+	PostUpdated  = "post.updated"
+	PostDeleted  = "post.deleted"
+	Typing       = "typing"
+)
+
+// Event — сообщение, рассылаемое подписчикам канала.
+type Event struct {
+	Type    string          `json:"type"`           // Тип события (см. константы выше)
+	Channel string          `json:"channel"`        // Канал: topic:<id> или subtheme:<id>
+	Data    json.RawMessage `json:"data,omitempty"` // Полезная нагрузка, например созданный пост
+	SentAt  time.Time       `json:"sent_at"`        // Время публикации
+}
+
+// TopicChannel возвращает имя канала событий топика.
+func TopicChannel(id uint) string { return "topic:" + strconv.FormatUint(uint64(id), 10) }
+
+// SubThemeChannel возвращает имя канала событий подтемы.
+func SubThemeChannel(id uint) string { return "subtheme:" + strconv.FormatUint(uint64(id), 10) }
+
+// Broker доставляет события между экземплярами бэкенда.
+// Событие, опубликованное через Publish, должно вернуться в deliver каждого экземпляра,
+// включая тот, что его опубликовал.
+type Broker interface {
+	Publish(ctx context.Context, e Event) error
+	// Run передает полученные события в deliver до отмены ctx.
+	Run(ctx context.Context, deliver func(Event)) error
+}
+
+// subscriberBuffer — сколько событий может ждать отправки одному клиенту.
+// Если клиент не успевает их забирать, новые события для него отбрасываются.
+const subscriberBuffer = 64
+
+// Subscriber — одно подключение клиента (WebSocket или SSE).
+type Subscriber struct {
+	C        chan Event
+	channels map[string]struct{} // Защищено Hub.mu
+}
+
+// Hub хранит подписки клиентов этого экземпляра и рассылает им события из брокера.
+type Hub struct {
+	broker Broker
+	mu     sync.RWMutex
+	subs   map[string]map[*Subscriber]struct{} // канал -> подписчики
+}
+
+// NewHub создает хаб поверх брокера. Доставка начинается после вызова Run.
+func NewHub(broker Broker) *Hub {
+	return &Hub{
+		broker: broker,
+		subs:   make(map[string]map[*Subscriber]struct{}),
+	}
+}
+
+// Run читает события из брокера и раздает их подписчикам до отмены ctx.
+func (h *Hub) Run(ctx context.Context) {
+	if err := h.broker.Run(ctx, h.dispatch); err != nil && ctx.Err() == nil {
+		slog.Error("event broker stopped", "error", err)
+	}
+}
+
+// Publish публикует событие в канал. Хаб может быть nil — тогда событие никуда не уходит,
+// поэтому обработчики могут вызывать Publish без проверок.
+func (h *Hub) Publish(ctx context.Context, eventType, channel string, data any) {
+	if h == nil {
+		return
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to encode event", "event", eventType, "error", err)
+		return
+	}
+	e := Event{Type: eventType, Channel: channel, Data: payload, SentAt: time.Now().UTC()}
+	if err := h.broker.Publish(ctx, e); err != nil {
+		logging.FromContext(ctx).Error("failed to publish event", "event", eventType, "channel", channel, "error", err)
+	}
+}
+
+// Subscribe регистрирует нового подписчика на перечисленные каналы.
+func (h *Hub) Subscribe(channels ...string) *Subscriber {
+	s := &Subscriber{C: make(chan Event, subscriberBuffer), channels: make(map[string]struct{})}
+	for _, ch := range channels {
+		h.Join(s, ch)
+	}
+	return s
+}
+
+// Join добавляет канал к подписке.
+func (h *Hub) Join(s *Subscriber, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs[channel] == nil {
+		h.subs[channel] = make(map[*Subscriber]struct{})
+	}
+	h.subs[channel][s] = struct{}{}
+	s.channels[channel] = struct{}{}
+}
+
+// Leave убирает канал из подписки.
+func (h *Hub) Leave(s *Subscriber, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leave(s, channel)
+}
+
+// Unsubscribe отписывает клиента от всех каналов; вызывается при закрытии соединения.
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for channel := range s.channels {
+		h.leave(s, channel)
+	}
+}
+
+// leave вызывается под h.mu.
+func (h *Hub) leave(s *Subscriber, channel string) {
+	delete(s.channels, channel)
+	if subs := h.subs[channel]; subs != nil {
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(h.subs, channel)
+		}
+	}
+}
+
+// dispatch раздает событие подписчикам канала, не блокируясь на медленных клиентах.
+func (h *Hub) dispatch(e Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for s := range h.subs[e.Channel] {
+		select {
+		case s.C <- e:
+		default:
+			slog.Warn("slow subscriber, event dropped", "channel", e.Channel, "event", e.Type)
+		}
+	}
+}
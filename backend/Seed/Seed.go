@@ -0,0 +1,327 @@
+// Package seed заполняет пустую базу правдоподобными данными: пользователями,
+// темами, подтемами, топиками и сообщениями на русском и английском.
+//
+// Данные полностью определяются зерном (Options.Seed) и моментом Options.Now,
+// от которого отсчитываются даты, поэтому прогоны с одинаковыми параметрами
+// дают одинаковую базу — это удобно для сравнения замеров пагинации и поиска.
+package seed
+
+import (
+	cache "REVFORUM/cache"
+	database "REVFORUM/database"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Password — пароль всех созданных пользователей.
+const Password = "password"
+
+// Profile — объем данных. Количества подтем, топиков и сообщений указаны
+// на один родительский объект.
+type Profile struct {
+	Users             int
+	Themes            int
+	SubThemesPerTheme int
+	TopicsPerSubTheme int
+	PostsPerTopic     int           // Ответов в среднем: у обычного топика от половины до полутора, у «горячего» — в 20 раз больше; плюс первое сообщение
+	Span              time.Duration // За какой период до Options.Now разбросаны даты
+}
+
+// Profiles — именованные профили для команды admin seed.
+var Profiles = map[string]Profile{
+	// Хватает на несколько страниц каждого списка; создается за секунды
+	"small": {Users: 25, Themes: 4, SubThemesPerTheme: 3, TopicsPerSubTheme: 8, PostsPerTopic: 12, Span: 180 * 24 * time.Hour},
+	// ~20 тыс. топиков и ~600 тыс. сообщений для замеров глубокой пагинации и поиска
+	"load-test": {Users: 2000, Themes: 20, SubThemesPerTheme: 5, TopicsPerSubTheme: 200, PostsPerTopic: 25, Span: 3 * 365 * 24 * time.Hour},
+}
+
+// Options — параметры генерации.
+type Options struct {
+	Profile
+	Seed      int64
+	Now       time.Time // Самая поздняя дата; по умолчанию — текущие сутки (UTC)
+	BatchSize int       // Строк в одном INSERT; по умолчанию 1000
+}
+
+// Result — сколько записей создано.
+type Result struct {
+	Users, Themes, SubThemes, Topics, Posts int
+}
+
+// ErrNotEmpty — в базе уже есть пользователи или темы.
+var ErrNotEmpty = errors.New("база не пуста: заполнять можно только пустую базу")
+
+const (
+	hotTopicChance = 100 // Каждый сотый топик «горячий»
+	hotTopicFactor = 20
+	lockedChance   = 30 // Каждый тридцатый топик закрыт
+	moderatorRatio = 50 // Каждый пятидесятый пользователь — модератор
+	topicChunk     = 500
+)
+
+// Generate заполняет базу в одной транзакции: при ошибке база остается пустой.
+// После заполнения сбрасывается кэш списков.
+func Generate(ctx context.Context, db *gorm.DB, store cache.Store, opts Options) (Result, error) {
+	// 1. Проверка параметров
+	p := opts.Profile
+	if p.Users < 1 || p.Themes < 0 || p.SubThemesPerTheme < 0 || p.TopicsPerSubTheme < 0 || p.PostsPerTopic < 0 || p.Span <= 0 {
+		return Result{}, fmt.Errorf("неверный профиль: %+v", p)
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now().UTC().Truncate(24 * time.Hour)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+
+	// 2. Один хэш на всех: bcrypt на каждого пользователя занял бы минуты
+	hash, err := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.DefaultCost)
+	if err != nil {
+		return Result{}, err
+	}
+
+	g := &generator{
+		rnd:   rand.New(rand.NewPCG(uint64(opts.Seed), 0x5eed)),
+		opts:  opts,
+		hash:  string(hash),
+		start: opts.Now.Add(-p.Span),
+	}
+	var res Result
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var users, themes int64
+		if err := tx.Model(&database.User{}).Count(&users).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&database.Themes_Collection{}).Count(&themes).Error; err != nil {
+			return err
+		}
+		if users > 0 || themes > 0 {
+			return ErrNotEmpty
+		}
+		var err error
+		res, err = g.run(tx)
+		return err
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	// 3. Сброс кэша списков
+	cache.InvalidateContext(ctx, store,
+		cache.Group(database.CacheThemes), cache.Group(database.CacheSubThemes),
+		cache.Group(database.CacheTopics), cache.Group(database.CachePosts))
+	return res, nil
+}
+
+// generator хранит состояние одного прогона. Случайные числа берутся строго
+// в одном порядке, поэтому результат не зависит от ID, выданных базой.
+type generator struct {
+	rnd   *rand.Rand
+	opts  Options
+	hash  string
+	start time.Time
+	users []database.User
+	langs []string // Язык каждого пользователя из users
+}
+
+func (g *generator) run(tx *gorm.DB) (Result, error) {
+	var res Result
+	p := g.opts.Profile
+
+	// 1. Пользователи: первый — администратор, регистрации в первой половине периода
+	joined := g.times(p.Users, g.start, g.start.Add(p.Span/2))
+	for i := range p.Users {
+		lang := g.lang()
+		user := database.User{
+			Username:     text{g.rnd, lang}.username(i + 1),
+			PasswordHash: g.hash,
+			CreatedAt:    joined[i],
+			Role:         database.RoleUser,
+		}
+		switch {
+		case i == 0:
+			user.Username, user.Role = "admin", database.RoleAdmin
+		case g.rnd.IntN(moderatorRatio) == 0:
+			user.Role = database.RoleModerator
+		}
+		user.Email = user.Username + "@example.com"
+		g.users = append(g.users, user)
+		g.langs = append(g.langs, lang)
+	}
+	if err := tx.CreateInBatches(&g.users, g.opts.BatchSize).Error; err != nil {
+		return res, err
+	}
+	res.Users = len(g.users)
+
+	// 2. Темы и подтемы; темы сверх списка названий получают номер
+	themes := make([]database.Themes_Collection, p.Themes)
+	created := g.times(p.Themes, g.start, g.start.Add(24*time.Hour))
+	for i := range themes {
+		title := themeTitles[i%len(themeTitles)]
+		if i >= len(themeTitles) {
+			title = fmt.Sprintf("%s %d", title, i/len(themeTitles)+1)
+		}
+		themes[i] = database.Themes_Collection{Title: title, Status: "open", CreatedAt: created[i]}
+	}
+	if len(themes) > 0 {
+		if err := tx.CreateInBatches(&themes, g.opts.BatchSize).Error; err != nil {
+			return res, err
+		}
+	}
+	res.Themes = len(themes)
+
+	var subThemes []database.Sub_Themes
+	for _, theme := range themes {
+		offset := g.rnd.IntN(len(subThemeTitles))
+		created := g.times(p.SubThemesPerTheme, theme.CreatedAt, theme.CreatedAt.Add(7*24*time.Hour))
+		for j := range p.SubThemesPerTheme {
+			subThemes = append(subThemes, database.Sub_Themes{
+				Title:     subThemeTitles[(offset+j)%len(subThemeTitles)],
+				Status:    "open",
+				ParentID:  theme.ID,
+				CreatedAt: created[j],
+			})
+		}
+	}
+	if len(subThemes) > 0 {
+		if err := tx.CreateInBatches(&subThemes, g.opts.BatchSize).Error; err != nil {
+			return res, err
+		}
+	}
+	res.SubThemes = len(subThemes)
+
+	// 3. Топики и сообщения — порциями, чтобы load-test не держал всё в памяти
+	var topics []database.Topic
+	var replies [][]database.Post
+	flush := func() error {
+		if len(topics) == 0 {
+			return nil
+		}
+		if err := tx.CreateInBatches(&topics, g.opts.BatchSize).Error; err != nil {
+			return err
+		}
+		var posts []database.Post
+		for i, topic := range topics {
+			for _, post := range replies[i] {
+				post.TopicID = topic.ID
+				posts = append(posts, post)
+			}
+		}
+		if len(posts) > 0 {
+			if err := tx.Omit("Attachments").CreateInBatches(&posts, g.opts.BatchSize).Error; err != nil {
+				return err
+			}
+		}
+		// Первое сообщение каждого топика вставлено раньше ответов, то есть с меньшим ID
+		ids := make([]uint, len(topics))
+		for i, topic := range topics {
+			ids[i] = topic.ID
+		}
+		if err := tx.Exec("UPDATE topics SET first_post_id = (SELECT MIN(id) FROM posts WHERE posts.topic_id = topics.id) WHERE id IN ?", ids).Error; err != nil {
+			return err
+		}
+		res.Topics += len(topics)
+		res.Posts += len(posts)
+		topics, replies = topics[:0], replies[:0]
+		return nil
+	}
+	for _, subTheme := range subThemes {
+		for range p.TopicsPerSubTheme {
+			topic, posts := g.topic(subTheme)
+			topics = append(topics, topic)
+			replies = append(replies, posts)
+			if len(topics) == topicChunk {
+				if err := flush(); err != nil {
+					return res, err
+				}
+			}
+		}
+	}
+	return res, flush()
+}
+
+// topic создает топик в подтеме, его первое сообщение и ответы (без TopicID).
+func (g *generator) topic(subTheme database.Sub_Themes) (database.Topic, []database.Post) {
+	p := g.opts.Profile
+	author := g.rnd.IntN(len(g.users))
+	created := after(subTheme.CreatedAt, g.users[author].CreatedAt)
+	created = g.times(1, created, g.opts.Now)[0]
+	t := text{g.rnd, g.langs[author]}
+
+	topic := database.Topic{
+		Title:      t.title(),
+		CreatedAt:  created,
+		UpdatedAt:  created,
+		AuthorID:   g.users[author].ID,
+		SubThemeID: subTheme.ID,
+		Locked:     g.rnd.IntN(lockedChance) == 0,
+	}
+
+	// Число ответов: от половины до полутора среднего, у «горячих» — больше
+	n := 0
+	if p.PostsPerTopic > 0 {
+		n = p.PostsPerTopic/2 + g.rnd.IntN(p.PostsPerTopic+1)
+		if g.rnd.IntN(hotTopicChance) == 0 {
+			n *= hotTopicFactor
+		}
+	}
+	// Первое сообщение — текст топика от его автора
+	posts := make([]database.Post, n+1)
+	posts[0] = database.Post{
+		Content:   t.paragraph(1 + g.rnd.IntN(4)),
+		CreatedAt: created,
+		UpdatedAt: created,
+		AuthorID:  topic.AuthorID,
+	}
+	for i, at := range g.times(n, created, g.opts.Now) {
+		author := g.rnd.IntN(len(g.users))
+		at = after(at, g.users[author].CreatedAt)
+		posts[i+1] = database.Post{
+			Content:   text{g.rnd, g.langs[author]}.paragraph(1 + g.rnd.IntN(5)),
+			CreatedAt: at,
+			UpdatedAt: at,
+			AuthorID:  g.users[author].ID,
+		}
+		if at.After(topic.UpdatedAt) {
+			topic.UpdatedAt = at
+		}
+	}
+	return topic, posts
+}
+
+// lang выбирает язык пользователя: большинство пишет по-русски.
+func (g *generator) lang() string {
+	if g.rnd.IntN(10) < 3 {
+		return langEN
+	}
+	return langRU
+}
+
+// times возвращает n упорядоченных моментов в [from, to) с точностью до секунды.
+func (g *generator) times(n int, from, to time.Time) []time.Time {
+	span := int64(to.Sub(from) / time.Second)
+	out := make([]time.Time, n)
+	for i := range out {
+		out[i] = from
+		if span > 0 {
+			out[i] = from.Add(time.Duration(g.rnd.Int64N(span)) * time.Second)
+		}
+	}
+	slices.SortFunc(out, func(a, b time.Time) int { return a.Compare(b) })
+	return out
+}
+
+// after — более поздний из моментов: пользователь не пишет до регистрации.
+func after(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
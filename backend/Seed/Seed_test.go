@@ -0,0 +1,137 @@
+package seed
+
+import (
+	cache "REVFORUM/cache"
+	database "REVFORUM/database"
+	dbtest "REVFORUM/dbtest"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(dbtest.Run(m))
+}
+
+var now = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+// snapshot — содержимое базы без ID в виде строк, пригодных для сравнения.
+func snapshot(t *testing.T, db *gorm.DB) []string {
+	t.Helper()
+	var users []database.User
+	var topics []database.Topic
+	var posts []database.Post
+	for _, err := range []error{
+		db.Order("id").Find(&users).Error,
+		db.Order("id").Find(&topics).Error,
+		db.Order("id").Find(&posts).Error,
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out []string
+	for _, u := range users {
+		out = append(out, fmt.Sprintf("user %s %s %s", u.Username, u.Role, u.CreatedAt.UTC()))
+	}
+	for _, tp := range topics {
+		out = append(out, fmt.Sprintf("topic %s %d %t %t %s %s", tp.Title, tp.AuthorID, tp.Locked, tp.FirstPostID != nil, tp.CreatedAt.UTC(), tp.UpdatedAt.UTC()))
+	}
+	for _, p := range posts {
+		out = append(out, fmt.Sprintf("post %d %s %d %s", p.TopicID, p.Content, p.AuthorID, p.CreatedAt.UTC()))
+	}
+	return out
+}
+
+func generate(t *testing.T, db *gorm.DB, seed int64) Result {
+	t.Helper()
+	opts := Options{Profile: Profiles["small"], Seed: seed, Now: now, BatchSize: 50}
+	opts.Profile.Users = 10
+	res, err := Generate(context.Background(), db, cache.NewLRU(10), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	db := dbtest.Open(t)
+	res := generate(t, db, 42)
+	first := snapshot(t, db)
+
+	db = dbtest.Open(t)
+	if again := generate(t, db, 42); again != res {
+		t.Fatalf("разные объемы при одном зерне: %+v и %+v", res, again)
+	}
+	if !slices.Equal(first, snapshot(t, db)) {
+		t.Fatal("одно зерно дало разные данные")
+	}
+
+	db = dbtest.Open(t)
+	generate(t, db, 43)
+	if slices.Equal(first, snapshot(t, db)) {
+		t.Fatal("другое зерно дало те же данные")
+	}
+}
+
+func TestGenerateProfile(t *testing.T) {
+	db := dbtest.Open(t)
+	res := generate(t, db, 1)
+
+	p := Profiles["small"]
+	want := Result{Users: 10, Themes: p.Themes, SubThemes: p.Themes * p.SubThemesPerTheme, Topics: p.Themes * p.SubThemesPerTheme * p.TopicsPerSubTheme}
+	got := res
+	got.Posts = 0
+	if got != want {
+		t.Fatalf("создано %+v, ожидалось %+v", res, want)
+	}
+	var posts int64
+	db.Model(&database.Post{}).Count(&posts)
+	if int(posts) != res.Posts || res.Posts < want.Topics*p.PostsPerTopic/2 {
+		t.Fatalf("сообщений %d (в отчете %d)", posts, res.Posts)
+	}
+
+	// Даты правдоподобны: ответы не раньше топика, топики не раньше автора, всё — до now
+	var bad int64
+	db.Raw(`SELECT COUNT(*) FROM posts p JOIN topics t ON t.id = p.topic_id JOIN users u ON u.id = p.author_id
+		WHERE p.created_at < t.created_at OR p.created_at < u.created_at OR p.created_at > ?`, now).Scan(&bad)
+	if bad != 0 {
+		t.Fatalf("сообщений с неверной датой: %d", bad)
+	}
+	db.Raw(`SELECT COUNT(*) FROM topics t JOIN users u ON u.id = t.author_id
+		WHERE t.created_at < u.created_at OR t.updated_at < t.created_at`).Scan(&bad)
+	if bad != 0 {
+		t.Fatalf("топиков с неверной датой: %d", bad)
+	}
+	db.Raw(`SELECT COUNT(*) FROM topics t LEFT JOIN posts p ON p.id = t.first_post_id
+		WHERE p.id IS NULL OR p.topic_id <> t.id OR p.author_id <> t.author_id OR p.created_at <> t.created_at`).Scan(&bad)
+	if bad != 0 {
+		t.Fatalf("топиков без своего первого сообщения: %d", bad)
+	}
+
+	var admin database.User
+	if err := db.Where("username = ?", "admin").First(&admin).Error; err != nil || admin.Role != database.RoleAdmin {
+		t.Fatalf("нет администратора: %v %+v", err, admin)
+	}
+}
+
+func TestGenerateRefusesNonEmptyDatabase(t *testing.T) {
+	db := dbtest.Open(t)
+	dbtest.NewFixtures(t, db).User()
+
+	_, err := Generate(context.Background(), db, cache.NewLRU(10), Options{Profile: Profiles["small"], Now: now})
+	if !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("ожидалась ErrNotEmpty, получено %v", err)
+	}
+	var topics int64
+	db.Model(&database.Topic{}).Count(&topics)
+	if topics != 0 {
+		t.Fatal("в непустую базу добавлены данные")
+	}
+}
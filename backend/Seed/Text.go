@@ -0,0 +1,148 @@
+package seed
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+// Банки слов и шаблонов для правдоподобных заголовков и сообщений.
+// Каждый пользователь пишет на одном языке, поэтому тексты не смешиваются.
+
+const (
+	langRU = "ru"
+	langEN = "en"
+)
+
+// text — генератор текстов на языке lang поверх общего источника случайности.
+type text struct {
+	rnd  *rand.Rand
+	lang string
+}
+
+func pick[T any](rnd *rand.Rand, items []T) T {
+	return items[rnd.IntN(len(items))]
+}
+
+// Названия разделов — на русском, как на живом форуме.
+var themeTitles = []string{
+	"Программирование",
+	"Железо и сборка ПК",
+	"Игры",
+	"Наука",
+	"Путешествия",
+	"Кулинария",
+	"Музыка",
+	"Спорт",
+	"Автомобили",
+	"Кино и сериалы",
+	"Книги",
+	"Фотография",
+	"Финансы",
+	"Здоровье",
+	"Образование",
+	"Дом и сад",
+	"Работа и карьера",
+	"Хобби",
+	"Новости сайта",
+	"Обратная связь",
+}
+
+var subThemeTitles = []string{
+	"Общее обсуждение",
+	"Вопросы новичков",
+	"Новости",
+	"Обзоры",
+	"Помощь",
+	"Советы и лайфхаки",
+	"Мероприятия",
+	"Барахолка",
+	"Проекты участников",
+	"Флудилка",
+}
+
+var firstNames = map[string][]string{
+	langRU: {"ivan", "olga", "dmitry", "anna", "sergey", "maria", "alexey", "elena", "nikita", "daria", "pavel", "irina", "artem", "ksenia", "mikhail", "yulia"},
+	langEN: {"john", "emily", "michael", "sarah", "david", "laura", "james", "kate", "robert", "lucy", "daniel", "megan", "chris", "anna", "peter", "zoe"},
+}
+
+var lastNames = map[string][]string{
+	langRU: {"petrov", "ivanova", "smirnov", "kuznetsova", "popov", "sokolova", "lebedev", "kozlova", "novikov", "morozova", "volkov", "orlova"},
+	langEN: {"smith", "johnson", "brown", "taylor", "miller", "wilson", "moore", "clark", "walker", "young", "king", "wright"},
+}
+
+var nouns = map[string][]string{
+	langRU: {"роутер", "видеокарта", "PostgreSQL", "Docker", "ноутбук", "объектив", "велосипед", "рецепт борща", "ипотека",
+		"кроссовки", "Python", "Go", "React", "сервер", "палатка", "гитара", "SSD", "монитор", "смартфон", "кофемашина"},
+	langEN: {"router", "graphics card", "PostgreSQL", "Docker", "laptop", "lens", "bike", "sourdough recipe", "mortgage",
+		"running shoes", "Python", "Go", "React", "home server", "tent", "guitar", "SSD", "monitor", "phone", "espresso machine"},
+}
+
+var titleTemplates = map[string][]string{
+	langRU: {"Как настроить %s?", "Проблема с %s после обновления", "Посоветуйте %s до 30 тысяч", "Сравнение: %s или %s?",
+		"Мой опыт: полгода с %s", "Стоит ли брать %s в 2024?", "%s: частые ошибки новичков", "Нужна помощь с %s",
+		"Обзор: %s спустя год", "Почему %s работает медленно?"},
+	langEN: {"How do I set up %s?", "Problem with %s after update", "Recommend a %s under $300", "Comparison: %s or %s?",
+		"My experience: six months with %s", "Is %s worth it this year?", "%s: common beginner mistakes", "Need help with %s",
+		"Review: %s a year later", "Why is %s so slow?"},
+}
+
+var sentenceTemplates = map[string][]string{
+	langRU: {
+		"У меня похожая ситуация с %s, решилось только после переустановки.",
+		"Попробуйте сначала обновить %s, обычно это помогает.",
+		"Я бы смотрел в сторону %s — по соотношению цены и качества лучше вариантов нет.",
+		"Пользуюсь %s уже второй год, полет нормальный.",
+		"Не согласен: %s сильно переоценен.",
+		"Есть подробная инструкция по %s, могу скинуть ссылку в личку.",
+		"А какая у вас версия %s?",
+		"Спасибо, заработало!",
+		"Поддерживаю предыдущего оратора.",
+		"Тоже интересует этот вопрос, подписался.",
+		"Главное — не экономить на %s, потом дороже выйдет.",
+		"Проверьте логи, скорее всего дело в %s.",
+	},
+	langEN: {
+		"I had the same issue with %s, only a reinstall fixed it.",
+		"Try updating %s first, that usually helps.",
+		"I'd go with %s, nothing beats it for the price.",
+		"Been using %s for two years now, no complaints.",
+		"Disagree: %s is heavily overrated.",
+		"There's a detailed guide on %s, I can DM you the link.",
+		"Which version of %s are you on?",
+		"Thanks, that worked!",
+		"Agree with the previous post.",
+		"Following, I'm curious about this too.",
+		"Don't cut corners on %s, it costs more in the end.",
+		"Check the logs, it's most likely %s.",
+	},
+}
+
+// username — правдоподобное имя пользователя; n делает его уникальным.
+func (t text) username(n int) string {
+	return fmt.Sprintf("%s_%s%d", pick(t.rnd, firstNames[t.lang]), pick(t.rnd, lastNames[t.lang]), n)
+}
+
+// fill подставляет в шаблон столько существительных, сколько в нем %s.
+func (t text) fill(template string) string {
+	args := make([]any, strings.Count(template, "%s"))
+	for i := range args {
+		args[i] = pick(t.rnd, nouns[t.lang])
+	}
+	s := fmt.Sprintf(template, args...)
+	return strings.ToUpper(s[:1]) + s[1:] // Шаблон может начинаться с %s
+}
+
+// title — заголовок топика.
+func (t text) title() string {
+	return t.fill(pick(t.rnd, titleTemplates[t.lang]))
+}
+
+// paragraph — сообщение из sentences предложений.
+func (t text) paragraph(sentences int) string {
+	parts := make([]string, sentences)
+	for i := range parts {
+		parts[i] = t.fill(pick(t.rnd, sentenceTemplates[t.lang]))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,192 @@
+package Server
+
+import (
+	database "REVFORUM/database"
+	dbtest "REVFORUM/dbtest"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// archiveLines разбирает архив на строки JSON.
+func archiveLines(t *testing.T, data []byte) []map[string]json.RawMessage {
+	t.Helper()
+	var lines []map[string]json.RawMessage
+	for _, raw := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var l map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &l); err != nil {
+			t.Fatalf("строка архива не разбирается: %v\n%s", err, raw)
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// forumForArchive создает администратора, входит под ним и создает небольшой форум.
+func forumForArchive(e *testEnv) (database.User, database.Topic) {
+	admin := e.signIn(e.f.User(dbtest.Admin))
+	author := e.f.User(func(u *database.User) { u.Bio = "о себе" })
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), author)
+	e.f.Post(topic, author)
+	e.f.Post(topic, admin)
+	return author, topic
+}
+
+func TestArchiveExport(t *testing.T) {
+	e := newTestEnv(t)
+	forumForArchive(e)
+
+	w := e.do("GET", "/api/v1/admin/export", nil)
+	expectStatus(t, w, http.StatusOK, nil)
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), ".jsonl") {
+		t.Fatalf("нет имени файла: %q", w.Header().Get("Content-Disposition"))
+	}
+	lines := archiveLines(t, w.Body.Bytes())
+	var types []string
+	for _, l := range lines {
+		var typ string
+		json.Unmarshal(l["type"], &typ)
+		types = append(types, typ)
+	}
+	if got := strings.Join(types, ","); got != "header,user,user,theme,subtheme,topic,post,post,footer" {
+		t.Fatalf("строки архива: %s", got)
+	}
+	if bytes.Contains(w.Body.Bytes(), []byte("password_hash")) {
+		t.Fatal("хэши паролей выгружены без password_hashes=true")
+	}
+	var counts database.ArchiveCounts
+	json.Unmarshal(lines[len(lines)-1]["counts"], &counts)
+	if counts != (database.ArchiveCounts{Users: 2, Themes: 1, SubThemes: 1, Topics: 1, Posts: 2}) {
+		t.Fatalf("итог архива: %+v", counts)
+	}
+
+	w = e.do("GET", "/api/v1/admin/export?password_hashes=true", nil)
+	expectStatus(t, w, http.StatusOK, nil)
+	if !bytes.Contains(w.Body.Bytes(), []byte("password_hash")) {
+		t.Fatal("хэши паролей не выгружены")
+	}
+	expectError(t, e.do("GET", "/api/v1/admin/export?password_hashes=maybe", nil), http.StatusBadRequest, "INVALID_PARAMETER")
+}
+
+func TestArchiveImportIntoEmptyForum(t *testing.T) {
+	e := newTestEnv(t)
+	author, topic := forumForArchive(e)
+	w := e.do("GET", "/api/v1/admin/export", nil)
+	expectStatus(t, w, http.StatusOK, nil)
+	archive := w.Body.Bytes()
+
+	// Новая база, в которой есть только администратор с другим именем
+	e.db = dbtest.Open(t)
+	e.f = dbtest.NewFixtures(t, e.db)
+	e.signIn(e.f.User(dbtest.Admin, func(u *database.User) { u.Username, u.Email = "root", "root@example.com" }))
+
+	var report database.ImportReport
+	expectStatus(t, e.do("POST", "/api/v1/admin/import", archive), http.StatusOK, &report)
+	if report.Created != (database.ArchiveCounts{Users: 2, Themes: 1, SubThemes: 1, Topics: 1, Posts: 2}) || report.DryRun {
+		t.Fatalf("итог импорта: %+v", report)
+	}
+
+	// ID новые, ссылки переведены, даты и поля сохранены
+	var user database.User
+	if err := e.db.Where("username = ?", author.Username).First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.ID == author.ID || user.Bio != "о себе" || !user.CreatedAt.Equal(author.CreatedAt) || user.PasswordHash == author.PasswordHash {
+		t.Fatalf("пользователь импортирован неверно: %+v", user)
+	}
+	var imported database.Topic
+	if err := e.db.Where("title = ?", topic.Title).First(&imported).Error; err != nil {
+		t.Fatal(err)
+	}
+	if imported.AuthorID != user.ID || !imported.CreatedAt.Equal(topic.CreatedAt) {
+		t.Fatalf("топик импортирован неверно: %+v", imported)
+	}
+	if e.count(&database.Post{}, "topic_id = ?", imported.ID) != 2 || e.count(&database.Post{}, "author_id = ?", user.ID) != 1 {
+		t.Fatal("сообщения не привязаны к новым топику и автору")
+	}
+
+	// Без хэша пароля войти нельзя
+	expectError(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": author.Username, "password": "password"}),
+		http.StatusUnauthorized, "INVALID_CREDENTIALS")
+}
+
+func TestArchiveImportConflictsAndDryRun(t *testing.T) {
+	e := newTestEnv(t)
+	forumForArchive(e)
+	w := e.do("GET", "/api/v1/admin/export", nil)
+	expectStatus(t, w, http.StatusOK, nil)
+	archive := w.Body.Bytes()
+
+	// Пользователи и тема из архива уже есть в базе
+	expectError(t, e.do("POST", "/api/v1/admin/import", archive), http.StatusConflict, "ARCHIVE_CONFLICT")
+
+	var report database.ImportReport
+	expectStatus(t, e.do("POST", "/api/v1/admin/import?on_conflict=skip&dry_run=true", archive), http.StatusOK, &report)
+	want := database.ImportReport{
+		DryRun:  true,
+		Created: database.ArchiveCounts{SubThemes: 1, Topics: 1, Posts: 2},
+		Skipped: database.ArchiveCounts{Users: 2, Themes: 1},
+	}
+	if report != want {
+		t.Fatalf("итог пробного импорта: %+v", report)
+	}
+	if e.count(&database.Topic{}, "1 = 1") != 1 {
+		t.Fatal("пробный импорт изменил базу")
+	}
+
+	// overwrite возвращает пользователю данные из архива
+	e.db.Model(&database.User{}).Where("bio = ?", "о себе").Update("bio", "изменено")
+	expectStatus(t, e.do("POST", "/api/v1/admin/import?on_conflict=overwrite", archive), http.StatusOK, &report)
+	if report.Overwritten.Users != 2 || report.Created.Topics != 1 {
+		t.Fatalf("итог импорта: %+v", report)
+	}
+	if e.count(&database.User{}, "bio = ?", "о себе") != 1 || e.count(&database.Topic{}, "1 = 1") != 2 {
+		t.Fatal("данные не перезаписаны или топик не добавлен")
+	}
+	if e.count(&database.User{}, "password_hash = ?", "!") != 0 {
+		t.Fatal("overwrite без хэша в архиве не должен сбрасывать пароль")
+	}
+}
+
+func TestArchiveImportRejectsBrokenArchive(t *testing.T) {
+	e := newTestEnv(t)
+	forumForArchive(e)
+	w := e.do("GET", "/api/v1/admin/export", nil)
+	expectStatus(t, w, http.StatusOK, nil)
+	lines := bytes.Split(bytes.TrimSpace(w.Body.Bytes()), []byte("\n"))
+	join := func(lines ...[]byte) []byte { return append(bytes.Join(lines, []byte("\n")), '\n') }
+	before := e.count(&database.Post{}, "1 = 1")
+
+	// Оборванный архив: нет итоговой строки
+	body := expectError(t, e.do("POST", "/api/v1/admin/import?on_conflict=skip", join(lines[:len(lines)-1]...)), http.StatusBadRequest, "INVALID_ARCHIVE")
+	if body.Params["line"] == "" {
+		t.Fatalf("нет номера строки: %+v", body)
+	}
+	if e.count(&database.Post{}, "1 = 1") != before {
+		t.Fatal("частичный импорт не откачен")
+	}
+
+	// Сообщение раньше топика, на который ссылается
+	swapped := join(append(append([][]byte{lines[0], lines[1], lines[2], lines[3], lines[4]}, lines[6], lines[5]), lines[7:]...)...)
+	expectError(t, e.do("POST", "/api/v1/admin/import?on_conflict=skip", swapped), http.StatusBadRequest, "INVALID_ARCHIVE")
+
+	future := []byte(`{"type":"header","format":"revforum-archive","version":99}` + "\n")
+	expectError(t, e.do("POST", "/api/v1/admin/import", future), http.StatusBadRequest, "UNSUPPORTED_ARCHIVE_VERSION")
+	expectError(t, e.do("POST", "/api/v1/admin/import", []byte("не json\n")), http.StatusBadRequest, "INVALID_ARCHIVE")
+	expectError(t, e.do("POST", "/api/v1/admin/import?on_conflict=merge", join(lines...)), http.StatusBadRequest, "INVALID_PARAMETER")
+}
+
+func TestArchiveRequiresAdmin(t *testing.T) {
+	e := newTestEnv(t)
+	e.signIn(e.f.User(dbtest.Moderator)) // Текущий пользователь — модератор, но не администратор
+
+	expectError(t, e.do("GET", "/api/v1/admin/export", nil), http.StatusForbidden, "FORBIDDEN")
+	header := []byte(`{"type":"header","format":"revforum-archive","version":1,"created_at":"` + time.Now().Format(time.RFC3339) + `"}` + "\n")
+	expectError(t, e.do("POST", "/api/v1/admin/import", header), http.StatusForbidden, "FORBIDDEN")
+}
@@ -0,0 +1,95 @@
+package Server
+
+import (
+	database "REVFORUM/database"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAttachmentsUploadAndGet(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+
+	var uploaded struct{ Attachment database.Attachment }
+	expectStatus(t, e.upload("/api/v1/attachments", "file", "../../notes.txt", []byte("содержимое файла")),
+		http.StatusCreated, &uploaded)
+	a := uploaded.Attachment
+	if a.AuthorID != me.ID || a.PostID != nil || a.ContentType != "text/plain" || a.Filename != "notes.txt" {
+		t.Fatalf("неожиданное вложение: %+v", a)
+	}
+	if a.ThumbnailURL != "" {
+		t.Fatalf("у текстового файла не должно быть миниатюры: %q", a.ThumbnailURL)
+	}
+
+	w := e.do("GET", a.URL, nil)
+	expectStatus(t, w, http.StatusOK, nil)
+	if w.Body.String() != "содержимое файла" {
+		t.Fatalf("неожиданное содержимое: %q", w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment") {
+		t.Fatalf("текстовый файл должен скачиваться, Content-Disposition: %q", cd)
+	}
+	expectStatus(t, e.do("GET", a.URL, nil, "If-None-Match", w.Header().Get("ETag")), http.StatusNotModified, nil)
+	expectError(t, e.do("GET", a.URL+"/thumbnail", nil), http.StatusNotFound, "THUMBNAIL_NOT_FOUND")
+}
+
+func TestAttachmentsImageThumbnail(t *testing.T) {
+	e := newTestEnv(t)
+	e.signIn(e.f.User())
+
+	var uploaded struct{ Attachment database.Attachment }
+	expectStatus(t, e.upload("/api/v1/attachments", "file", "photo.png", testPNG(t, 800, 400)), http.StatusCreated, &uploaded)
+	a := uploaded.Attachment
+	if a.Width != 800 || a.Height != 400 || a.ThumbnailURL == "" {
+		t.Fatalf("неожиданное вложение: %+v", a)
+	}
+
+	w := e.do("GET", a.ThumbnailURL, nil)
+	expectStatus(t, w, http.StatusOK, nil)
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Fatalf("Content-Type миниатюры %q, ожидался image/png", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); strings.HasPrefix(cd, "attachment") {
+		t.Fatalf("изображение должно показываться в браузере, Content-Disposition: %q", cd)
+	}
+}
+
+func TestAttachmentsUploadErrors(t *testing.T) {
+	e := newTestEnv(t)
+	e.signIn(e.f.User())
+
+	expectError(t, e.upload("/api/v1/attachments", "", "", nil), http.StatusBadRequest, "FILE_REQUIRED")
+	body := expectError(t, e.upload("/api/v1/attachments", "file", "app.exe", []byte("MZ\x90\x00\x03\x00\x00\x00")),
+		http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE")
+	if body.Params["allowed"] == "" {
+		t.Fatalf("в ошибке нет списка разрешенных типов: %+v", body.Params)
+	}
+	broken := testPNG(t, 10, 10)[:40]
+	expectError(t, e.upload("/api/v1/attachments", "file", "broken.png", broken), http.StatusBadRequest, "INVALID_IMAGE")
+	if n := e.count(&database.Attachment{}, "1 = 1"); n != 0 {
+		t.Fatalf("после ошибок создано %d вложений", n)
+	}
+}
+
+func TestAttachmentsGetErrors(t *testing.T) {
+	e := newTestEnv(t)
+	missing := e.f.Attachment(e.f.User()) // Запись есть, файла в хранилище нет
+
+	expectError(t, e.do("GET", "/api/v1/attachments/999", nil), http.StatusNotFound, "ATTACHMENT_NOT_FOUND")
+	expectError(t, e.do("GET", "/api/v1/attachments/abc", nil), http.StatusBadRequest, "INVALID_PARAMETER")
+	expectError(t, e.do("GET", fmt.Sprintf("/api/v1/attachments/%d", missing.ID), nil), http.StatusNotFound, "FILE_NOT_FOUND")
+}
+
+func TestAttachmentsCannotBeReused(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), me)
+	first := e.f.Post(topic, me)
+	attached := e.f.Attachment(me, func(a *database.Attachment) { a.PostID = &first.ID })
+
+	expectError(t, e.do("POST", fmt.Sprintf("/api/v1/topics/%d/posts", topic.ID),
+		map[string]any{"content": "повтор", "attachment_ids": []uint{attached.ID}}),
+		http.StatusBadRequest, "ATTACHMENTS_UNAVAILABLE")
+}
@@ -0,0 +1,46 @@
+package Server
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openapiSpec — спецификация OpenAPI 3 всех маршрутов из newRouter.
+// Тест TestOpenAPIMatchesRoutes падает, если маршруты и спецификация расходятся.
+//
+//go:embed openapi.json
+var openapiSpec []byte
+
+// OpenAPIHandler отдает спецификацию API.
+// GET /api/openapi.json
+func OpenAPIHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openapiSpec)
+}
+
+// docsPage — Swagger UI поверх /api/openapi.json; скрипты и стили берутся с CDN.
+const docsPage = `<!DOCTYPE html>
+<html lang="ru">
+<head>
+  <meta charset="utf-8">
+  <title>RevForum API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: "/api/openapi.json", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`
+
+// DocsHandler отдает страницу интерактивной документации.
+// GET /api/docs
+func DocsHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+}
@@ -0,0 +1,135 @@
+package Server
+
+import (
+	realtime "REVFORUM/realtime"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTyping(t *testing.T) {
+	e := newTestEnv(t)
+	events := e.subscribe(realtime.TopicChannel(7))
+
+	// Печатает всегда текущий пользователь: user_id из тела не используется
+	expectError(t, e.do("POST", "/api/v1/events/typing", map[string]uint{"topic_id": 7}), http.StatusUnauthorized, "UNAUTHENTICATED")
+	me := e.signIn(e.f.User())
+	expectStatus(t, e.do("POST", "/api/v1/events/typing", map[string]uint{"topic_id": 7, "user_id": me.ID + 1}), http.StatusAccepted, nil)
+	ev := expectEvent(t, events, realtime.Typing)
+	if !strings.Contains(string(ev.Data), fmt.Sprintf(`"user_id":%d`, me.ID)) {
+		t.Fatalf("неожиданные данные события: %s", ev.Data)
+	}
+
+	body := expectError(t, e.do("POST", "/api/v1/events/typing", map[string]uint{}), http.StatusBadRequest, "VALIDATION_FAILED")
+	expectField(t, body, "topic_id")
+}
+
+func TestSSEErrors(t *testing.T) {
+	e := newTestEnv(t)
+
+	expectError(t, e.do("GET", "/api/v1/events", nil), http.StatusBadRequest, "TOO_MANY_CHANNELS")
+	body := expectError(t, e.do("GET", "/api/v1/events?topic=abc", nil), http.StatusBadRequest, "INVALID_CHANNEL")
+	if body.Params["channel"] != "topic:abc" {
+		t.Fatalf("неожиданные параметры ошибки: %+v", body.Params)
+	}
+}
+
+func TestSSEStream(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), me)
+	srv := httptest.NewServer(e.router)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// Ведущий ноль не уводит в отдельный канал, куда никто не публикует
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/api/v1/events?topic=0"+itoa(topic.ID), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Content-Type %q, ожидался text/event-stream", ct)
+	}
+
+	// Заголовки пришли — подписка уже зарегистрирована
+	expectStatus(t, e.do("POST", "/api/v1/topics/"+itoa(topic.ID)+"/posts", map[string]string{"content": "в эфир"}), http.StatusCreated, nil)
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if scanner.Text() == "event:"+realtime.PostCreated {
+			return
+		}
+	}
+	t.Fatalf("событие %s не пришло в поток SSE: %v", realtime.PostCreated, scanner.Err())
+}
+
+func TestWebSocket(t *testing.T) {
+	e := newTestEnv(t)
+	srv := httptest.NewServer(e.router)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/v1/ws"
+
+	// Без токена можно только читать
+	anon, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer anon.Close()
+	anon.SetReadDeadline(time.Now().Add(5 * time.Second))
+	anon.WriteJSON(map[string]string{"action": "typing", "channel": "topic:5"})
+	var reply map[string]any
+	if err := anon.ReadJSON(&reply); err != nil || reply["code"] != "UNAUTHENTICATED" {
+		t.Fatalf("ожидалась ошибка UNAUTHENTICATED, получено %v (%v)", reply, err)
+	}
+
+	me := e.signIn(e.f.User())
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + e.token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Ошибки возвращаются в формате apperr, соединение не рвется
+	conn.WriteJSON(map[string]string{"action": "subscribe", "channel": "forum:1"})
+	if err := conn.ReadJSON(&reply); err != nil || reply["code"] != "INVALID_CHANNEL" {
+		t.Fatalf("ожидалась ошибка INVALID_CHANNEL, получено %v (%v)", reply, err)
+	}
+
+	// typing от одного клиента приходит подписчику канала, в том числе ему самому;
+	// topic:05 — тот же канал, что и topic:5
+	conn.WriteJSON(map[string]string{"action": "subscribe", "channel": "topic:05"})
+	conn.WriteJSON(map[string]any{"action": "typing", "channel": "topic:5", "user_id": me.ID + 1})
+	var ev realtime.Event
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]uint
+	json.Unmarshal(ev.Data, &data)
+	if ev.Type != realtime.Typing || ev.Channel != "topic:5" || data["user_id"] != me.ID {
+		t.Fatalf("неожиданное событие: %+v", ev)
+	}
+}
+
+func TestWebSocketRejectsForeignOrigin(t *testing.T) {
+	e := newTestEnv(t)
+	srv := httptest.NewServer(e.router)
+	defer srv.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v1/ws",
+		http.Header{"Origin": {"http://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("ожидался отказ 403 для чужого Origin, получено %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package Server
+
+import (
+	database "REVFORUM/database"
+	dbtest "REVFORUM/dbtest"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestTopicCreateIsAtomic(t *testing.T) {
+	e := newTestEnv(t)
+	e.signIn(e.f.User())
+	sub := e.f.SubTheme(e.f.Theme())
+
+	// Вставка первого сообщения падает — топик не должен остаться
+	const name = "test:fail_posts"
+	e.db.Callback().Create().Before("gorm:create").Register(name, func(tx *gorm.DB) {
+		if tx.Statement.Table == "posts" {
+			tx.AddError(errors.New("posts unavailable"))
+		}
+	})
+	w := e.do("POST", fmt.Sprintf("/api/v1/subthemes/%d/topics", sub.ID), map[string]string{"title": "Тема", "content": "Текст"})
+	e.db.Callback().Create().Remove(name)
+	expectError(t, w, http.StatusInternalServerError, "INTERNAL_ERROR")
+	if n := e.count(&database.Topic{}, "sub_theme_id = ?", sub.ID); n != 0 {
+		t.Fatalf("топиков после неудачи: %d", n)
+	}
+}
+
+func TestTopicFirstPost(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+	sub := e.f.SubTheme(e.f.Theme())
+
+	var created struct {
+		Topic database.Topic `json:"topic"`
+	}
+	expectStatus(t, e.do("POST", fmt.Sprintf("/api/v1/subthemes/%d/topics", sub.ID), map[string]string{"title": "Тема", "content": "Первое"}),
+		http.StatusCreated, &created)
+	topicID := created.Topic.ID
+	reply := e.f.Post(created.Topic, me, func(p *database.Post) { p.CreatedAt = time.Now().Add(time.Minute) })
+
+	// Первое сообщение — обычный пост: первым в списке, правится как пост
+	var posts []database.Post
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/topics/%d/posts", topicID), nil), http.StatusOK, &posts)
+	if len(posts) != 2 || posts[0].ID != *created.Topic.FirstPostID || posts[1].ID != reply.ID {
+		t.Fatalf("посты топика: %+v", posts)
+	}
+	expectStatus(t, e.do("PATCH", fmt.Sprintf("/api/v1/posts/%d", posts[0].ID), map[string]string{"content": "Исправлено"}), http.StatusOK, nil)
+
+	var topic database.Topic
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/topics/%d", topicID), nil), http.StatusOK, &topic)
+	if topic.FirstPostID == nil || *topic.FirstPostID != posts[0].ID {
+		t.Fatalf("топик: %+v", topic)
+	}
+
+	// Первое сообщение отдельно от топика не удаляется, ответы — удаляются
+	expectError(t, e.do("DELETE", fmt.Sprintf("/api/v1/posts/%d", posts[0].ID), nil), http.StatusConflict, "FIRST_POST_NOT_DELETABLE")
+	if n := e.count(&database.Post{}, "id = ?", posts[0].ID); n != 1 {
+		t.Fatal("первое сообщение удалено")
+	}
+	expectStatus(t, e.do("DELETE", fmt.Sprintf("/api/v1/posts/%d", reply.ID), nil), http.StatusNoContent, nil)
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/topics/%d", topicID), nil), http.StatusOK, &topic)
+	if topic.FirstPostID == nil || *topic.FirstPostID != posts[0].ID {
+		t.Fatalf("топик после удаления ответа: %+v", topic)
+	}
+}
+
+func TestMigrateMovesTopicContentIntoFirstPosts(t *testing.T) {
+	e := newTestEnv(t)
+	author, other := e.f.User(), e.f.User()
+	sub := e.f.SubTheme(e.f.Theme())
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	withText := e.f.Topic(sub, author, func(tp *database.Topic) { tp.CreatedAt = created })
+	reply := e.f.Post(withText, other)
+	empty := e.f.Topic(sub, author)
+
+	// Топики из версии, где текст хранился в самом топике
+	if err := e.db.Exec("ALTER TABLE topics ADD COLUMN content text").Error; err != nil {
+		t.Fatal(err)
+	}
+	e.db.Exec("UPDATE topics SET content = ? WHERE id = ?", "Текст топика", withText.ID)
+
+	if err := database.Migrate(e.db); err != nil {
+		t.Fatal(err)
+	}
+	if e.db.Migrator().HasColumn(&database.Topic{}, "content") {
+		t.Fatal("столбец content не удален")
+	}
+	var topics []database.Topic
+	e.db.Order("id").Find(&topics)
+	if topics[0].FirstPostID == nil || topics[1].FirstPostID != nil {
+		t.Fatalf("first_post_id: %v, %v", topics[0].FirstPostID, topics[1].FirstPostID)
+	}
+	var first database.Post
+	e.db.First(&first, *topics[0].FirstPostID)
+	if first.Content != "Текст топика" || first.AuthorID != author.ID || first.TopicID != withText.ID || !first.CreatedAt.Equal(created) {
+		t.Fatalf("первое сообщение: %+v", first)
+	}
+	if e.count(&database.Post{}, "topic_id = ?", empty.ID) != 0 {
+		t.Fatal("у топика без текста появилось сообщение")
+	}
+
+	// Первое сообщение идет раньше ответов, хотя создано позже
+	var posts []database.Post
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/topics/%d/posts", withText.ID), nil), http.StatusOK, &posts)
+	if len(posts) != 2 || posts[0].ID != first.ID || posts[1].ID != reply.ID {
+		t.Fatalf("посты топика: %+v", posts)
+	}
+
+	// Повторный запуск ничего не меняет
+	if err := database.Migrate(e.db); err != nil {
+		t.Fatal(err)
+	}
+	if e.count(&database.Post{}, "1 = 1") != 2 {
+		t.Fatal("повторная миграция создала сообщения")
+	}
+}
+
+func TestArchiveKeepsFirstPosts(t *testing.T) {
+	e := newTestEnv(t)
+	e.signIn(e.f.User(dbtest.Admin))
+	var created struct {
+		Topic database.Topic `json:"topic"`
+	}
+	expectStatus(t, e.do("POST", fmt.Sprintf("/api/v1/subthemes/%d/topics", e.f.SubTheme(e.f.Theme()).ID),
+		map[string]string{"title": "Из архива", "content": "Первое"}), http.StatusCreated, &created)
+	e.f.Post(created.Topic, e.f.User())
+	w := e.do("GET", "/api/v1/admin/export", nil)
+	expectStatus(t, w, http.StatusOK, nil)
+	archive := w.Body.Bytes()
+
+	// Версия 2: ссылка на первое сообщение переводится на новые ID
+	e.db = dbtest.Open(t)
+	e.f = dbtest.NewFixtures(t, e.db)
+	e.signIn(e.f.User(dbtest.Admin, func(u *database.User) { u.Username, u.Email = "root", "root@example.com" }))
+	var report database.ImportReport
+	expectStatus(t, e.do("POST", "/api/v1/admin/import", archive), http.StatusOK, &report)
+	var topic database.Topic
+	e.db.Where("title = ?", "Из архива").First(&topic)
+	var first database.Post
+	if topic.FirstPostID == nil || e.db.First(&first, *topic.FirstPostID).Error != nil || first.Content != "Первое" || first.TopicID != topic.ID {
+		t.Fatalf("первое сообщение после импорта: %+v, %+v", topic, first)
+	}
+
+	// Версия 1: текст топика становится его первым сообщением
+	header := `{"type":"header","format":"revforum-archive","version":1}`
+	lines := []string{
+		header,
+		`{"type":"user","data":{"id":1,"username":"legacy","email":"legacy@example.com","role":"user","created_at":"2020-01-01T00:00:00Z"}}`,
+		`{"type":"theme","data":{"id":1,"title":"Старая тема","status":"open","created_at":"2020-01-01T00:00:00Z"}}`,
+		`{"type":"subtheme","data":{"id":1,"parent_id":1,"title":"Старая подтема","status":"open","created_at":"2020-01-01T00:00:00Z"}}`,
+		`{"type":"topic","data":{"id":1,"sub_theme_id":1,"author_id":1,"title":"Старый топик","content":"Старый текст","created_at":"2020-01-02T00:00:00Z","updated_at":"2020-01-02T00:00:00Z"}}`,
+		`{"type":"post","data":{"id":1,"topic_id":1,"author_id":1,"content":"Ответ","created_at":"2020-01-03T00:00:00Z","updated_at":"2020-01-03T00:00:00Z"}}`,
+		`{"type":"footer","counts":{"users":1,"themes":1,"subthemes":1,"topics":1,"posts":1}}`,
+	}
+	var v1 []byte
+	for _, l := range lines {
+		v1 = append(v1, l+"\n"...)
+	}
+	expectStatus(t, e.do("POST", "/api/v1/admin/import", v1), http.StatusOK, &report)
+	if report.Created.Posts != 2 {
+		t.Fatalf("итог импорта: %+v", report)
+	}
+	var legacy database.Topic
+	var legacyFirst database.Post
+	e.db.Where("title = ?", "Старый топик").First(&legacy)
+	if legacy.FirstPostID == nil || e.db.First(&legacyFirst, *legacy.FirstPostID).Error != nil ||
+		legacyFirst.Content != "Старый текст" || !legacyFirst.CreatedAt.Equal(legacy.CreatedAt) {
+		t.Fatalf("текст топика из архива версии 1: %+v, %+v", legacy, legacyFirst)
+	}
+}
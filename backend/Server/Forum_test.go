@@ -0,0 +1,671 @@
+package Server
+
+import (
+	cache "REVFORUM/cache"
+	database "REVFORUM/database"
+	dbtest "REVFORUM/dbtest"
+	realtime "REVFORUM/realtime"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Темы
+
+func TestThemesCreateAndList(t *testing.T) {
+	e := newTestEnv(t)
+
+	// Пустой список кэшируется; создание темы должно сбросить кэш
+	var themes []database.Themes_Collection
+	expectStatus(t, e.do("GET", "/api/v1/themes", nil), http.StatusOK, &themes)
+	if len(themes) != 0 {
+		t.Fatalf("ожидался пустой список, получено %d", len(themes))
+	}
+
+	var created struct{ Theme database.Themes_Collection }
+	w := e.do("POST", "/api/v1/themes", map[string]string{"title": "Новости", "status": "open"})
+	expectStatus(t, w, http.StatusCreated, &created)
+	if created.Theme.ID == 0 || created.Theme.Title != "Новости" {
+		t.Fatalf("неожиданная тема: %+v", created.Theme)
+	}
+
+	expectStatus(t, e.do("GET", "/api/v1/themes", nil), http.StatusOK, &themes)
+	if len(themes) != 1 || themes[0].Title != "Новости" {
+		t.Fatalf("в списке нет созданной темы: %+v", themes)
+	}
+}
+
+func TestThemesCreateErrors(t *testing.T) {
+	e := newTestEnv(t)
+	theme := e.f.Theme()
+
+	body := expectError(t, e.do("POST", "/api/v1/themes", map[string]string{"title": "Без статуса"}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectField(t, body, "status")
+
+	expectError(t, e.do("POST", "/api/v1/themes", []byte("{")), http.StatusBadRequest, "INVALID_REQUEST_BODY")
+	expectError(t, e.do("POST", "/api/v1/themes", map[string]string{"title": theme.Title, "status": "open"}),
+		http.StatusConflict, "THEME_ALREADY_EXISTS")
+
+	// Гонка: проверка не видит тему, созданную параллельным запросом, — срабатывает уникальный индекс
+	const name = "test:miss_themes"
+	e.db.Callback().Query().Before("gorm:query").Register(name, func(tx *gorm.DB) {
+		if tx.Statement.Table == "themes_collections" {
+			tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "1 = 0"}}})
+		}
+	})
+	w := e.do("POST", "/api/v1/themes", map[string]string{"title": theme.Title, "status": "open"})
+	e.db.Callback().Query().Remove(name)
+	expectError(t, w, http.StatusConflict, "THEME_ALREADY_EXISTS")
+}
+
+func TestThemesConditionalGet(t *testing.T) {
+	e := newTestEnv(t)
+	e.f.Theme()
+
+	w := e.do("GET", "/api/v1/themes", nil)
+	expectStatus(t, w, http.StatusOK, nil)
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("нет ETag в ответе списка")
+	}
+	expectStatus(t, e.do("GET", "/api/v1/themes", nil, "If-None-Match", etag), http.StatusNotModified, nil)
+}
+
+func TestListCacheSkipsStaleResponse(t *testing.T) {
+	e := newTestEnv(t)
+	e.f.Theme()
+	ctx := context.Background()
+	key := cache.GroupKey(database.CacheThemes, "")
+
+	// Список сбросили, пока обработчик читал БД: прочитанное могло устареть
+	const name = "test:concurrent_invalidation"
+	e.db.Callback().Query().After("gorm:query").Register(name, func(tx *gorm.DB) {
+		if tx.Statement.Table == "themes_collections" {
+			cache.InvalidateContext(ctx, e.store, cache.Group(database.CacheThemes))
+		}
+	})
+	w := e.do("GET", "/api/v1/themes", nil)
+	e.db.Callback().Query().Remove(name)
+	expectStatus(t, w, http.StatusOK, nil)
+	if _, ok, _ := e.store.Get(ctx, key); ok {
+		t.Fatal("ответ, построенный до сброса, попал в кэш")
+	}
+
+	expectStatus(t, e.do("GET", "/api/v1/themes", nil), http.StatusOK, nil)
+	if _, ok, _ := e.store.Get(ctx, key); !ok {
+		t.Fatal("ответ не закэширован")
+	}
+}
+
+func TestListCacheKeyIgnoresUnknownParams(t *testing.T) {
+	e := newTestEnv(t)
+	sub := e.f.SubTheme(e.f.Theme())
+	e.f.Topic(sub, e.f.User())
+	ctx := context.Background()
+	path := fmt.Sprintf("/api/v1/subthemes/%d/topics", sub.ID)
+
+	expectStatus(t, e.do("GET", path+"?utm_source=mail&include=sub_theme,+author", nil), http.StatusOK, nil)
+	if _, ok, _ := e.store.Get(ctx, cache.GroupKey(database.CacheTopics, itoa(sub.ID))+"include=author,sub_theme"); !ok {
+		t.Fatal("ключ кэша зависит не только от include")
+	}
+	if _, ok, _ := e.store.Get(ctx, cache.GroupKey(database.CacheTopics, itoa(sub.ID))+"utm_source=mail&include=sub_theme,+author"); ok {
+		t.Fatal("строка запроса попала в ключ кэша")
+	}
+}
+
+func TestThemesGet(t *testing.T) {
+	e := newTestEnv(t)
+	theme := e.f.Theme()
+
+	var got database.Themes_Collection
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/themes/%d", theme.ID), nil), http.StatusOK, &got)
+	if got.ID != theme.ID || got.Title != theme.Title {
+		t.Fatalf("получена %+v, ожидалась %+v", got, theme)
+	}
+
+	expectError(t, e.do("GET", "/api/v1/themes/999", nil), http.StatusNotFound, "THEME_NOT_FOUND")
+	expectError(t, e.do("GET", "/api/v1/themes/abc", nil), http.StatusBadRequest, "INVALID_PARAMETER")
+}
+
+func TestThemesUpdate(t *testing.T) {
+	e := newTestEnv(t)
+	e.signIn(e.f.User(dbtest.Moderator))
+	theme := e.f.Theme()
+	other := e.f.Theme()
+	path := fmt.Sprintf("/api/v1/themes/%d", theme.ID)
+
+	var updated struct{ Theme database.Themes_Collection }
+	expectStatus(t, e.do("PATCH", path, map[string]string{"title": "Переименована"}), http.StatusOK, &updated)
+	if updated.Theme.Title != "Переименована" || updated.Theme.Status != theme.Status {
+		t.Fatalf("неожиданный результат: %+v", updated.Theme)
+	}
+
+	expectError(t, e.do("PATCH", path, map[string]string{}), http.StatusBadRequest, "NO_FIELDS_TO_UPDATE")
+	expectError(t, e.do("PATCH", path, map[string]string{"title": other.Title}), http.StatusConflict, "THEME_ALREADY_EXISTS")
+	expectError(t, e.do("PATCH", "/api/v1/themes/999", map[string]string{"title": "x"}), http.StatusNotFound, "THEME_NOT_FOUND")
+}
+
+func TestThemesRequireModerator(t *testing.T) {
+	e := newTestEnv(t)
+	e.signIn(e.f.User()) // Текущий пользователь без прав модератора
+	theme := e.f.Theme()
+	path := fmt.Sprintf("/api/v1/themes/%d", theme.ID)
+
+	expectError(t, e.do("PATCH", path, map[string]string{"title": "x"}), http.StatusForbidden, "FORBIDDEN")
+	expectError(t, e.do("DELETE", path, nil), http.StatusForbidden, "FORBIDDEN")
+	if e.count(&database.Themes_Collection{}, "id = ?", theme.ID) != 1 {
+		t.Fatal("тема удалена без прав модератора")
+	}
+}
+
+func TestThemesDelete(t *testing.T) {
+	e := newTestEnv(t)
+	e.signIn(e.f.User(dbtest.Admin))
+	empty := e.f.Theme()
+	full := e.f.Theme()
+	e.f.SubTheme(full)
+
+	expectStatus(t, e.do("DELETE", fmt.Sprintf("/api/v1/themes/%d", empty.ID), nil), http.StatusNoContent, nil)
+	if e.count(&database.Themes_Collection{}, "id = ?", empty.ID) != 0 {
+		t.Fatal("тема не удалена")
+	}
+
+	expectError(t, e.do("DELETE", fmt.Sprintf("/api/v1/themes/%d", full.ID), nil), http.StatusConflict, "THEME_NOT_EMPTY")
+	expectError(t, e.do("DELETE", "/api/v1/themes/999", nil), http.StatusNotFound, "THEME_NOT_FOUND")
+}
+
+// Подтемы
+
+func TestSubThemesCreate(t *testing.T) {
+	e := newTestEnv(t)
+	theme := e.f.Theme()
+	other := e.f.Theme()
+
+	// Родитель из пути важнее parent_id в теле
+	var created struct {
+		SubTheme database.Sub_Themes `json:"sub_theme"`
+	}
+	w := e.do("POST", fmt.Sprintf("/api/v1/themes/%d/subthemes", theme.ID),
+		map[string]any{"title": "Вопросы", "status": "open", "parent_id": other.ID})
+	expectStatus(t, w, http.StatusCreated, &created)
+	if created.SubTheme.ParentID != theme.ID {
+		t.Fatalf("parent_id = %d, ожидался %d", created.SubTheme.ParentID, theme.ID)
+	}
+
+	var list []database.Sub_Themes
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/themes/%d/subthemes", theme.ID), nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != created.SubTheme.ID {
+		t.Fatalf("в списке нет созданной подтемы: %+v", list)
+	}
+
+	expectError(t, e.do("POST", "/api/v1/themes/999/subthemes", map[string]string{"title": "x", "status": "open"}),
+		http.StatusNotFound, "THEME_NOT_FOUND")
+	body := expectError(t, e.do("POST", fmt.Sprintf("/api/v1/themes/%d/subthemes", theme.ID), map[string]string{"status": "open"}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectField(t, body, "title")
+	expectError(t, e.do("POST", "/api/v1/themes/abc/subthemes", map[string]string{"title": "x", "status": "open"}),
+		http.StatusBadRequest, "INVALID_PARAMETER")
+}
+
+func TestSubThemesList(t *testing.T) {
+	e := newTestEnv(t)
+	theme := e.f.Theme()
+	e.f.SubTheme(theme)
+	e.f.SubTheme(theme)
+	e.f.SubTheme(e.f.Theme())
+
+	var list []database.Sub_Themes
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/themes/%d/subthemes", theme.ID), nil), http.StatusOK, &list)
+	if len(list) != 2 {
+		t.Fatalf("получено %d подтем, ожидалось 2", len(list))
+	}
+	expectError(t, e.do("GET", "/api/v1/themes/abc/subthemes", nil), http.StatusBadRequest, "INVALID_PARAMETER")
+}
+
+func TestSubThemesGetUpdateDelete(t *testing.T) {
+	e := newTestEnv(t)
+	e.signIn(e.f.User(dbtest.Moderator))
+	theme := e.f.Theme()
+	sub := e.f.SubTheme(theme)
+	path := fmt.Sprintf("/api/v1/subthemes/%d", sub.ID)
+
+	var got database.Sub_Themes
+	expectStatus(t, e.do("GET", path, nil), http.StatusOK, &got)
+	if got.Title != sub.Title {
+		t.Fatalf("получена %+v, ожидалась %+v", got, sub)
+	}
+	expectError(t, e.do("GET", "/api/v1/subthemes/999", nil), http.StatusNotFound, "SUBTHEME_NOT_FOUND")
+
+	var updated struct {
+		SubTheme database.Sub_Themes `json:"sub_theme"`
+	}
+	expectStatus(t, e.do("PATCH", path, map[string]string{"status": "closed"}), http.StatusOK, &updated)
+	if updated.SubTheme.Status != "closed" {
+		t.Fatalf("статус не изменен: %+v", updated.SubTheme)
+	}
+	expectError(t, e.do("PATCH", path, map[string]string{}), http.StatusBadRequest, "NO_FIELDS_TO_UPDATE")
+	expectError(t, e.do("PATCH", "/api/v1/subthemes/999", map[string]string{"status": "x"}), http.StatusNotFound, "SUBTHEME_NOT_FOUND")
+
+	expectStatus(t, e.do("DELETE", path, nil), http.StatusNoContent, nil)
+	expectError(t, e.do("GET", path, nil), http.StatusNotFound, "SUBTHEME_NOT_FOUND")
+	expectError(t, e.do("DELETE", path, nil), http.StatusNotFound, "SUBTHEME_NOT_FOUND")
+}
+
+func TestSubThemesDeleteNotEmpty(t *testing.T) {
+	e := newTestEnv(t)
+	mod := e.signIn(e.f.User(dbtest.Moderator))
+	sub := e.f.SubTheme(e.f.Theme())
+	e.f.Topic(sub, mod)
+
+	expectError(t, e.do("DELETE", fmt.Sprintf("/api/v1/subthemes/%d", sub.ID), nil), http.StatusConflict, "SUBTHEME_NOT_EMPTY")
+}
+
+func TestSubThemesRequireModerator(t *testing.T) {
+	e := newTestEnv(t)
+	e.signIn(e.f.User())
+	sub := e.f.SubTheme(e.f.Theme())
+	path := fmt.Sprintf("/api/v1/subthemes/%d", sub.ID)
+
+	expectError(t, e.do("PATCH", path, map[string]string{"title": "x"}), http.StatusForbidden, "FORBIDDEN")
+	expectError(t, e.do("DELETE", path, nil), http.StatusForbidden, "FORBIDDEN")
+}
+
+// Топики
+
+func TestTopicsCreate(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+	sub := e.f.SubTheme(e.f.Theme())
+	events := e.subscribe(realtime.SubThemeChannel(sub.ID))
+
+	var created struct {
+		Topic database.Topic
+		Post  database.Post
+	}
+	w := e.do("POST", fmt.Sprintf("/api/v1/subthemes/%d/topics", sub.ID), map[string]string{"title": "Как начать?", "content": "Подскажите"})
+	expectStatus(t, w, http.StatusCreated, &created)
+	if created.Topic.AuthorID != me.ID || created.Topic.SubThemeID != sub.ID {
+		t.Fatalf("неожиданный топик: %+v", created.Topic)
+	}
+	if created.Topic.FirstPostID == nil || *created.Topic.FirstPostID != created.Post.ID ||
+		created.Post.TopicID != created.Topic.ID || created.Post.AuthorID != me.ID || created.Post.Content != "Подскажите" {
+		t.Fatalf("первое сообщение: %+v, топик: %+v", created.Post, created.Topic)
+	}
+	expectEvent(t, events, realtime.TopicCreated)
+
+	expectError(t, e.do("POST", "/api/v1/subthemes/999/topics", map[string]string{"title": "x", "content": "y"}),
+		http.StatusNotFound, "SUBTHEME_NOT_FOUND")
+	body := expectError(t, e.do("POST", fmt.Sprintf("/api/v1/subthemes/%d/topics", sub.ID), map[string]string{"content": "без заголовка"}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectField(t, body, "title")
+	body = expectError(t, e.do("POST", fmt.Sprintf("/api/v1/subthemes/%d/topics", sub.ID), map[string]string{"title": "без текста"}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectField(t, body, "content")
+}
+
+func TestTopicsList(t *testing.T) {
+	e := newTestEnv(t)
+	author := e.f.User(func(u *database.User) { u.DisplayName = "Автор" })
+	sub := e.f.SubTheme(e.f.Theme())
+	topic := e.f.Topic(sub, author)
+	e.f.Post(topic, author)
+	e.f.Post(topic, author)
+	e.f.Topic(e.f.SubTheme(e.f.Theme()), author) // Чужая подтема не попадает в список
+
+	var list []struct {
+		database.Topic
+		PostCount int64 `json:"post_count"`
+	}
+	w := e.do("GET", fmt.Sprintf("/api/v1/subthemes/%d/topics?include=author,sub_theme", sub.ID), nil)
+	expectStatus(t, w, http.StatusOK, &list)
+	if len(list) != 1 {
+		t.Fatalf("получено %d топиков, ожидался 1", len(list))
+	}
+	got := list[0]
+	if got.PostCount != 2 {
+		t.Fatalf("post_count = %d, ожидалось 2", got.PostCount)
+	}
+	if got.Author == nil || got.Author.DisplayName != "Автор" || got.Author.PostCount != 2 {
+		t.Fatalf("неожиданный автор: %+v", got.Author)
+	}
+	if got.SubTheme == nil || got.SubTheme.ID != sub.ID {
+		t.Fatalf("неожиданная подтема: %+v", got.SubTheme)
+	}
+
+	body := expectError(t, e.do("GET", fmt.Sprintf("/api/v1/subthemes/%d/topics?include=posts", sub.ID), nil),
+		http.StatusBadRequest, "INVALID_INCLUDE")
+	if body.Params["value"] != "posts" {
+		t.Fatalf("неожиданные параметры ошибки: %+v", body.Params)
+	}
+	expectError(t, e.do("GET", "/api/v1/subthemes/abc/topics", nil), http.StatusBadRequest, "INVALID_PARAMETER")
+
+	// Подтема удалена в обход API: sub_theme не приходит пустым объектом
+	e.db.Delete(&database.Sub_Themes{}, sub.ID)
+	w = e.do("GET", fmt.Sprintf("/api/v1/subthemes/%d/topics?include=sub_theme", sub.ID), nil)
+	var orphans []database.Topic
+	expectStatus(t, w, http.StatusOK, &orphans)
+	if len(orphans) != 1 || orphans[0].SubTheme != nil || strings.Contains(w.Body.String(), `"sub_theme":{`) {
+		t.Fatalf("отсутствующая подтема: %s", w.Body)
+	}
+}
+
+func TestAuthorPostCountStaysFresh(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+	sub := e.f.SubTheme(e.f.Theme())
+	topic := e.f.Topic(sub, me)
+	e.f.Post(topic, me)
+	other := e.f.Topic(e.f.SubTheme(e.f.Theme()), e.f.User())
+
+	postCount := func() int64 {
+		t.Helper()
+		var posts []database.Post
+		expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/topics/%d/posts?include=author", topic.ID), nil), http.StatusOK, &posts)
+		var topics []database.Topic
+		expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/subthemes/%d/topics?include=author", sub.ID), nil), http.StatusOK, &topics)
+		if len(posts) != 1 || posts[0].Author == nil || len(topics) != 1 || topics[0].Author == nil || posts[0].Author.PostCount != topics[0].Author.PostCount {
+			t.Fatalf("авторы: %+v, %+v", posts, topics)
+		}
+		return posts[0].Author.PostCount
+	}
+	if n := postCount(); n != 1 {
+		t.Fatalf("post_count = %d, ожидалось 1", n)
+	}
+
+	// Пост и топик в других местах форума меняют счетчик в закэшированных списках
+	expectStatus(t, e.do("POST", fmt.Sprintf("/api/v1/topics/%d/posts", other.ID), map[string]string{"content": "Ответ"}), http.StatusCreated, nil)
+	if n := postCount(); n != 2 {
+		t.Fatalf("post_count после поста = %d, ожидалось 2", n)
+	}
+	expectStatus(t, e.do("POST", fmt.Sprintf("/api/v1/subthemes/%d/topics", other.SubThemeID), map[string]string{"title": "Тема", "content": "Текст"}),
+		http.StatusCreated, nil)
+	if n := postCount(); n != 3 {
+		t.Fatalf("post_count после топика = %d, ожидалось 3", n)
+	}
+}
+
+func TestTopicsGet(t *testing.T) {
+	e := newTestEnv(t)
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), e.f.User())
+
+	var got database.Topic
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/topics/%d", topic.ID), nil), http.StatusOK, &got)
+	if got.Title != topic.Title {
+		t.Fatalf("получен %+v, ожидался %+v", got, topic)
+	}
+	expectError(t, e.do("GET", "/api/v1/topics/999", nil), http.StatusNotFound, "TOPIC_NOT_FOUND")
+	expectError(t, e.do("GET", "/api/v1/topics/0", nil), http.StatusBadRequest, "INVALID_PARAMETER")
+}
+
+func TestTopicsUpdate(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+	other := e.f.User()
+	sub := e.f.SubTheme(e.f.Theme())
+	mine := e.f.Topic(sub, me)
+	foreign := e.f.Topic(sub, other)
+	events := e.subscribe(realtime.TopicChannel(mine.ID))
+
+	var updated struct{ Topic database.Topic }
+	expectStatus(t, e.do("PATCH", fmt.Sprintf("/api/v1/topics/%d", mine.ID), map[string]string{"title": "Новый заголовок"}),
+		http.StatusOK, &updated)
+	if updated.Topic.Title != "Новый заголовок" || updated.Topic.AuthorID != me.ID {
+		t.Fatalf("неожиданный результат: %+v", updated.Topic)
+	}
+	expectEvent(t, events, realtime.TopicUpdated)
+
+	expectError(t, e.do("PATCH", fmt.Sprintf("/api/v1/topics/%d", foreign.ID), map[string]string{"title": "x"}),
+		http.StatusForbidden, "FORBIDDEN")
+	expectError(t, e.do("PATCH", fmt.Sprintf("/api/v1/topics/%d", mine.ID), map[string]string{}),
+		http.StatusBadRequest, "NO_FIELDS_TO_UPDATE")
+	expectError(t, e.do("PATCH", "/api/v1/topics/999", map[string]string{"title": "x"}),
+		http.StatusNotFound, "TOPIC_NOT_FOUND")
+}
+
+func TestTopicsModeratorEditsForeignTopic(t *testing.T) {
+	e := newTestEnv(t)
+	e.signIn(e.f.User(dbtest.Moderator))
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), e.f.User())
+
+	expectStatus(t, e.do("PATCH", fmt.Sprintf("/api/v1/topics/%d", topic.ID), map[string]string{"title": "Отредактировано"}),
+		http.StatusOK, nil)
+}
+
+func TestTopicsDelete(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+	sub := e.f.SubTheme(e.f.Theme())
+	topic := e.f.Topic(sub, me)
+	post := e.f.Post(topic, me)
+	attachment := e.f.Attachment(me, func(a *database.Attachment) { a.PostID = &post.ID })
+	e.putFile(attachment.StorageKey)
+	foreign := e.f.Topic(sub, e.f.User())
+	events := e.subscribe(realtime.SubThemeChannel(sub.ID))
+
+	expectStatus(t, e.do("DELETE", fmt.Sprintf("/api/v1/topics/%d", topic.ID), nil), http.StatusNoContent, nil)
+	expectEvent(t, events, realtime.TopicDeleted)
+	if n := e.count(&database.Post{}, "topic_id = ?", topic.ID); n != 0 {
+		t.Fatalf("осталось %d постов удаленного топика", n)
+	}
+	if n := e.count(&database.Attachment{}, "post_id = ?", post.ID); n != 0 || e.hasFile(attachment.StorageKey) {
+		t.Fatalf("осталось %d вложений удаленного поста", n)
+	}
+
+	expectError(t, e.do("DELETE", fmt.Sprintf("/api/v1/topics/%d", foreign.ID), nil), http.StatusForbidden, "FORBIDDEN")
+	expectError(t, e.do("DELETE", fmt.Sprintf("/api/v1/topics/%d", topic.ID), nil), http.StatusNotFound, "TOPIC_NOT_FOUND")
+}
+
+// Посты
+
+func TestPostsCreate(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+	sub := e.f.SubTheme(e.f.Theme())
+	topic := e.f.Topic(sub, me)
+	attachment := e.f.Attachment(me)
+	events := e.subscribe(realtime.TopicChannel(topic.ID))
+
+	var created struct{ Post database.Post }
+	w := e.do("POST", fmt.Sprintf("/api/v1/topics/%d/posts", topic.ID),
+		map[string]any{"content": "Привет", "attachment_ids": []uint{attachment.ID}})
+	expectStatus(t, w, http.StatusCreated, &created)
+	if created.Post.TopicID != topic.ID || created.Post.AuthorID != me.ID {
+		t.Fatalf("неожиданный пост: %+v", created.Post)
+	}
+	if len(created.Post.Attachments) != 1 || created.Post.Attachments[0].ID != attachment.ID {
+		t.Fatalf("вложение не привязано: %+v", created.Post.Attachments)
+	}
+	expectEvent(t, events, realtime.PostCreated)
+}
+
+func TestPostsCreateErrors(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+	other := e.f.User()
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), me)
+	foreign := e.f.Attachment(other)
+	path := fmt.Sprintf("/api/v1/topics/%d/posts", topic.ID)
+
+	expectError(t, e.do("POST", "/api/v1/topics/999/posts", map[string]string{"content": "x"}), http.StatusNotFound, "TOPIC_NOT_FOUND")
+	body := expectError(t, e.do("POST", path, map[string]string{}), http.StatusBadRequest, "VALIDATION_FAILED")
+	expectField(t, body, "content")
+	body = expectError(t, e.do("POST", path, map[string]any{"content": "x", "attachment_ids": []uint{1, 1}}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectField(t, body, "attachment_ids")
+
+	// Чужое вложение привязать нельзя, и пост при этом не создается
+	expectError(t, e.do("POST", path, map[string]any{"content": "x", "attachment_ids": []uint{foreign.ID}}),
+		http.StatusBadRequest, "ATTACHMENTS_UNAVAILABLE")
+	if n := e.count(&database.Post{}, "topic_id = ?", topic.ID); n != 0 {
+		t.Fatalf("пост создан несмотря на ошибку: %d", n)
+	}
+
+	locked := e.f.Topic(e.f.SubTheme(e.f.Theme()), me, func(tp *database.Topic) { tp.Locked = true })
+	expectError(t, e.do("POST", fmt.Sprintf("/api/v1/topics/%d/posts", locked.ID), map[string]string{"content": "x"}),
+		http.StatusConflict, "TOPIC_LOCKED")
+}
+
+func TestPostsList(t *testing.T) {
+	e := newTestEnv(t)
+	author := e.signIn(e.f.User(func(u *database.User) { u.Signature = "С уважением" }))
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), author)
+	first := e.f.Post(topic, author)
+	e.f.Post(topic, author)
+	e.f.Attachment(author, func(a *database.Attachment) { a.PostID = &first.ID })
+
+	path := fmt.Sprintf("/api/v1/topics/%d/posts", topic.ID)
+	var posts []database.Post
+	expectStatus(t, e.do("GET", path+"?include=author,topic", nil), http.StatusOK, &posts)
+	if len(posts) != 2 || posts[0].ID != first.ID {
+		t.Fatalf("неожиданный список: %+v", posts)
+	}
+	if posts[0].AuthorSignature != "С уважением" {
+		t.Fatalf("нет подписи автора: %+v", posts[0])
+	}
+	if len(posts[0].Attachments) != 1 || posts[0].Attachments[0].URL == "" {
+		t.Fatalf("нет вложений: %+v", posts[0].Attachments)
+	}
+	if posts[0].Author == nil || posts[0].Author.ID != author.ID || posts[0].Topic == nil || posts[0].Topic.ID != topic.ID {
+		t.Fatalf("нет связанных объектов: %+v", posts[0])
+	}
+
+	// Новый пост сбрасывает кэш списка
+	expectStatus(t, e.do("POST", path, map[string]string{"content": "еще"}), http.StatusCreated, nil)
+	expectStatus(t, e.do("GET", path+"?include=author,topic", nil), http.StatusOK, &posts)
+	if len(posts) != 3 {
+		t.Fatalf("кэш списка постов не сброшен: %d постов", len(posts))
+	}
+
+	// ID с ведущим нулем попадает в тот же кэш и сбрасывается вместе с ним
+	padded := fmt.Sprintf("/api/v1/topics/0%d/posts", topic.ID)
+	expectStatus(t, e.do("GET", padded, nil), http.StatusOK, &posts)
+	expectStatus(t, e.do("POST", path, map[string]string{"content": "и еще"}), http.StatusCreated, nil)
+	expectStatus(t, e.do("GET", padded, nil), http.StatusOK, &posts)
+	if len(posts) != 4 {
+		t.Fatalf("кэш списка по ID %q не сброшен: %d постов", "0"+fmt.Sprint(topic.ID), len(posts))
+	}
+
+	expectError(t, e.do("GET", path+"?include=sub_theme", nil), http.StatusBadRequest, "INVALID_INCLUDE")
+	expectError(t, e.do("GET", "/api/v1/topics/abc/posts", nil), http.StatusBadRequest, "INVALID_PARAMETER")
+}
+
+func TestPostsGet(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.f.User()
+	post := e.f.Post(e.f.Topic(e.f.SubTheme(e.f.Theme()), me), me)
+
+	var got database.Post
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/posts/%d", post.ID), nil), http.StatusOK, &got)
+	if got.Content != post.Content {
+		t.Fatalf("получен %+v, ожидался %+v", got, post)
+	}
+	expectError(t, e.do("GET", "/api/v1/posts/999", nil), http.StatusNotFound, "POST_NOT_FOUND")
+	expectError(t, e.do("GET", "/api/v1/posts/abc", nil), http.StatusBadRequest, "INVALID_PARAMETER")
+}
+
+func TestPostsUpdate(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), me)
+	mine := e.f.Post(topic, me)
+	foreign := e.f.Post(topic, e.f.User())
+	events := e.subscribe(realtime.TopicChannel(topic.ID))
+
+	var updated struct{ Post database.Post }
+	expectStatus(t, e.do("PATCH", fmt.Sprintf("/api/v1/posts/%d", mine.ID), map[string]string{"content": "Исправлено"}),
+		http.StatusOK, &updated)
+	if updated.Post.Content != "Исправлено" {
+		t.Fatalf("текст не изменен: %+v", updated.Post)
+	}
+	expectEvent(t, events, realtime.PostUpdated)
+
+	expectError(t, e.do("PATCH", fmt.Sprintf("/api/v1/posts/%d", foreign.ID), map[string]string{"content": "x"}),
+		http.StatusForbidden, "FORBIDDEN")
+	expectError(t, e.do("PATCH", fmt.Sprintf("/api/v1/posts/%d", mine.ID), map[string]string{}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectError(t, e.do("PATCH", "/api/v1/posts/999", map[string]string{"content": "x"}),
+		http.StatusNotFound, "POST_NOT_FOUND")
+}
+
+func TestPostsDelete(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), me)
+	mine := e.f.Post(topic, me)
+	attachment := e.f.Attachment(me, func(a *database.Attachment) { a.PostID = &mine.ID })
+	e.putFile(attachment.StorageKey)
+	foreign := e.f.Post(topic, e.f.User())
+	events := e.subscribe(realtime.TopicChannel(topic.ID))
+
+	// Транзакция откатилась — файл вложения на месте
+	const name = "test:fail_post_delete"
+	e.db.Callback().Delete().Before("gorm:delete").Register(name, func(tx *gorm.DB) {
+		if tx.Statement.Table == "posts" {
+			tx.AddError(errors.New("сбой базы"))
+		}
+	})
+	w := e.do("DELETE", fmt.Sprintf("/api/v1/posts/%d", mine.ID), nil)
+	e.db.Callback().Delete().Remove(name)
+	expectError(t, w, http.StatusInternalServerError, "INTERNAL_ERROR")
+	if e.count(&database.Attachment{}, "id = ?", attachment.ID) != 1 || !e.hasFile(attachment.StorageKey) {
+		t.Fatal("после отката вложение или его файл удалены")
+	}
+
+	expectStatus(t, e.do("DELETE", fmt.Sprintf("/api/v1/posts/%d", mine.ID), nil), http.StatusNoContent, nil)
+	expectEvent(t, events, realtime.PostDeleted)
+	if e.count(&database.Attachment{}, "id = ?", attachment.ID) != 0 || e.hasFile(attachment.StorageKey) {
+		t.Fatal("вложение удаленного поста осталось")
+	}
+
+	expectError(t, e.do("DELETE", fmt.Sprintf("/api/v1/posts/%d", foreign.ID), nil), http.StatusForbidden, "FORBIDDEN")
+	expectError(t, e.do("DELETE", fmt.Sprintf("/api/v1/posts/%d", mine.ID), nil), http.StatusNotFound, "POST_NOT_FOUND")
+}
+
+// Устаревшие маршруты
+
+func TestLegacyRoutes(t *testing.T) {
+	e := newTestEnv(t)
+	e.signIn(e.f.User())
+	theme := e.f.Theme()
+
+	// Родитель в теле запроса, как раньше
+	var created struct {
+		SubTheme database.Sub_Themes `json:"sub_theme"`
+	}
+	w := e.do("POST", "/api/themes/subthemes", map[string]any{"title": "Старый клиент", "status": "open", "parent_id": theme.ID})
+	expectStatus(t, w, http.StatusCreated, &created)
+	if created.SubTheme.ParentID != theme.ID {
+		t.Fatalf("parent_id = %d, ожидался %d", created.SubTheme.ParentID, theme.ID)
+	}
+	if w.Header().Get("Deprecation") != "true" {
+		t.Fatal("нет заголовка Deprecation")
+	}
+	body := expectError(t, e.do("POST", "/api/themes/subthemes/topics", map[string]string{"title": "x"}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectField(t, body, "sub_theme_id")
+
+	w = e.do("GET", fmt.Sprintf("/api/themes/subthemes/%d/topics", created.SubTheme.ID), nil)
+	expectStatus(t, w, http.StatusOK, nil)
+	want := fmt.Sprintf(`</api/v1/subthemes/%d/topics>; rel="successor-version"`, created.SubTheme.ID)
+	if got := w.Header().Get("Link"); got != want {
+		t.Fatalf("Link = %q, ожидался %q", got, want)
+	}
+
+	// Новые маршруты заголовков устаревания не отдают
+	w = e.do("GET", "/api/v1/themes", nil)
+	if w.Header().Get("Deprecation") != "" {
+		t.Fatal("Deprecation в ответе /api/v1")
+	}
+}
+
+func TestUnknownRoute(t *testing.T) {
+	e := newTestEnv(t)
+	expectError(t, e.do("GET", "/api/v1/nope", nil), http.StatusNotFound, "ROUTE_NOT_FOUND")
+}
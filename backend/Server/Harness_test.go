@@ -0,0 +1,263 @@
+package Server
+
+import (
+	cache "REVFORUM/cache"
+	database "REVFORUM/database"
+	dbtest "REVFORUM/dbtest"
+	realtime "REVFORUM/realtime"
+	storage "REVFORUM/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	gin.SetMode(gin.TestMode)
+	if !testing.Verbose() {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil))) // Журнал запросов только с -v
+	}
+	os.Exit(dbtest.Run(m))
+}
+
+// testEnv — приложение поверх чистой тестовой базы, кэша в памяти
+// и локального хранилища во временном каталоге.
+type testEnv struct {
+	t      *testing.T
+	db     *gorm.DB
+	f      *dbtest.Fixtures
+	store  cache.Store
+	hub    *realtime.Hub
+	files  storage.Store
+	router http.Handler
+	token  string // Токен сессии для e.do и e.upload; пусто — анонимные запросы
+}
+
+// newTestEnv собирает приложение через New с тестовыми зависимостями.
+// configure меняет настройки по умолчанию перед сборкой.
+func newTestEnv(t *testing.T, configure ...func(*Config)) *testEnv {
+	t.Helper()
+	db := dbtest.Open(t)
+
+	files, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := cache.NewLRU(100)
+	hub := realtime.NewHub(realtime.NewMemoryBroker(64))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go hub.Run(ctx)
+
+	cfg := Config{
+		CacheTTL:     time.Minute,
+		AllowOrigins: []string{"http://localhost"},
+		Attachments:  database.AttachmentLimitsFromEnv(),
+		SessionTTL:   time.Hour,
+	}
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+	app := New(cfg, db, Deps{Cache: store, Events: hub, Files: files})
+
+	return &testEnv{
+		t:      t,
+		db:     db,
+		f:      dbtest.NewFixtures(t, db),
+		store:  store,
+		hub:    hub,
+		files:  files,
+		router: app.Handler(),
+	}
+}
+
+// signIn открывает сессию пользователя: дальнейшие e.do и e.upload
+// выполняются от его имени. Возвращает user для удобства записи.
+func (e *testEnv) signIn(user database.User) database.User {
+	e.t.Helper()
+	_, token, err := database.CreateSession(context.Background(), e.db, user.ID, time.Hour, "Go-http-client/1.1", "192.0.2.1")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.token = token
+	return user
+}
+
+// do выполняет запрос к роутеру. body кодируется в JSON, если это не []byte.
+func (e *testEnv) do(method, path string, body any, headers ...string) *httptest.ResponseRecorder {
+	e.t.Helper()
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		r = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if e.token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, req)
+	return w
+}
+
+// upload отправляет файл multipart-формой в поле field.
+func (e *testEnv) upload(path, field, filename string, data []byte, headers ...string) *httptest.ResponseRecorder {
+	e.t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.SetBoundary("revforum-test-boundary") // Повтор загрузки — те же байты, как у клиента, повторяющего запрос
+	if field != "" {
+		fw, err := mw.CreateFormFile(field, filename)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, path, &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if e.token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, req)
+	return w
+}
+
+// subscribe подписывается на каналы хаба; подписка снимается в конце теста.
+func (e *testEnv) subscribe(channels ...string) *realtime.Subscriber {
+	sub := e.hub.Subscribe(channels...)
+	e.t.Cleanup(func() { e.hub.Unsubscribe(sub) })
+	return sub
+}
+
+// expectEvent ждет событие типа eventType из подписки.
+func expectEvent(t *testing.T, sub *realtime.Subscriber, eventType string) realtime.Event {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e := <-sub.C:
+			if e.Type == eventType {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("событие %s не пришло", eventType)
+			return realtime.Event{}
+		}
+	}
+}
+
+// expectStatus проверяет код ответа и разбирает тело в out (если out не nil).
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int, out any) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("код ответа %d, ожидался %d; тело: %s", w.Code, status, w.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("тело ответа не разбирается: %v; тело: %s", err, w.Body.String())
+		}
+	}
+}
+
+// apiError — тело ошибки в формате apperr.
+type apiError struct {
+	Error  string            `json:"error"`
+	Code   string            `json:"code"`
+	Params map[string]string `json:"params"`
+	Fields []struct {
+		Field string `json:"field"`
+		Rule  string `json:"rule"`
+	} `json:"fields"`
+}
+
+// expectError проверяет код ответа и машиночитаемый код ошибки.
+func expectError(t *testing.T, w *httptest.ResponseRecorder, status int, code string) apiError {
+	t.Helper()
+	var body apiError
+	expectStatus(t, w, status, &body)
+	if body.Code != code {
+		t.Fatalf("код ошибки %q, ожидался %q; тело: %s", body.Code, code, w.Body.String())
+	}
+	if body.Error == "" {
+		t.Fatalf("в ошибке нет сообщения; тело: %s", w.Body.String())
+	}
+	return body
+}
+
+// expectField проверяет, что ошибка валидации относится к полю field.
+func expectField(t *testing.T, body apiError, field string) {
+	t.Helper()
+	for _, f := range body.Fields {
+		if f.Field == field {
+			return
+		}
+	}
+	t.Fatalf("нет ошибки для поля %q: %+v", field, body.Fields)
+}
+
+func itoa(id uint) string { return strconv.FormatUint(uint64(id), 10) }
+
+// count возвращает число строк модели, подходящих под условие.
+func (e *testEnv) count(model any, query string, args ...any) int64 {
+	e.t.Helper()
+	var n int64
+	if err := e.db.Model(model).Where(query, args...).Count(&n).Error; err != nil {
+		e.t.Fatal(err)
+	}
+	return n
+}
+
+// putFile кладет в хранилище файл под ключом key.
+func (e *testEnv) putFile(key string) {
+	e.t.Helper()
+	if err := e.files.Put(context.Background(), key, strings.NewReader("x"), 1, "text/plain"); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// hasFile сообщает, есть ли в хранилище файл с ключом key.
+func (e *testEnv) hasFile(key string) bool {
+	e.t.Helper()
+	r, err := e.files.Get(context.Background(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	r.Close()
+	return true
+}
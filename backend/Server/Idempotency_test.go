@@ -0,0 +1,186 @@
+package Server
+
+import (
+	database "REVFORUM/database"
+	dbtest "REVFORUM/dbtest"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// idempotentEnv — окружение с поддержкой Idempotency-Key.
+func idempotentEnv(t *testing.T) *testEnv {
+	return newTestEnv(t, func(cfg *Config) { cfg.IdempotencyTTL = time.Hour })
+}
+
+func TestIdempotencyReplaysResponse(t *testing.T) {
+	e := idempotentEnv(t)
+	me := e.signIn(e.f.User())
+	sub := e.f.SubTheme(e.f.Theme())
+	path := fmt.Sprintf("/api/v1/subthemes/%d/topics", sub.ID)
+	body := map[string]string{"title": "Один раз", "content": "Текст"}
+
+	first := e.do("POST", path, body, database.IdempotencyKeyHeader, "key-1")
+	expectStatus(t, first, http.StatusCreated, nil)
+	if first.Header().Get(database.IdempotentReplayedHeader) != "" {
+		t.Fatal("первый ответ помечен как повтор")
+	}
+	again := e.do("POST", path, body, database.IdempotencyKeyHeader, "key-1")
+	expectStatus(t, again, http.StatusCreated, nil)
+	if again.Header().Get(database.IdempotentReplayedHeader) != "true" || again.Body.String() != first.Body.String() {
+		t.Fatalf("повтор: %q\n%s", again.Header().Get(database.IdempotentReplayedHeader), again.Body)
+	}
+	if n := e.count(&database.Topic{}, "sub_theme_id = ?", sub.ID); n != 1 {
+		t.Fatalf("топиков: %d, ожидался 1", n)
+	}
+
+	// Тот же ключ с другим телом или маршрутом
+	expectError(t, e.do("POST", path, map[string]string{"title": "Другой", "content": "Текст"}, database.IdempotencyKeyHeader, "key-1"),
+		http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED")
+	var topic database.Topic
+	e.db.Where("sub_theme_id = ?", sub.ID).First(&topic)
+	expectError(t, e.do("POST", fmt.Sprintf("/api/v1/topics/%d/posts", topic.ID), body, database.IdempotencyKeyHeader, "key-1"),
+		http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED")
+
+	// Ключи у каждого пользователя свои; без ключа запрос выполняется всегда
+	e.signIn(e.f.User())
+	expectStatus(t, e.do("POST", path, body, database.IdempotencyKeyHeader, "key-1"), http.StatusCreated, nil)
+	e.signIn(me)
+	expectStatus(t, e.do("POST", path, body), http.StatusCreated, nil)
+	if n := e.count(&database.Topic{}, "sub_theme_id = ?", sub.ID); n != 3 {
+		t.Fatalf("топиков: %d, ожидалось 3", n)
+	}
+
+	// Устаревший маршрут создания тоже принимает ключ
+	legacy := map[string]any{"title": "Старый маршрут", "content": "Текст", "sub_theme_id": sub.ID}
+	expectStatus(t, e.do("POST", "/api/themes/subthemes/topics", legacy, database.IdempotencyKeyHeader, "legacy"), http.StatusCreated, nil)
+	w := e.do("POST", "/api/themes/subthemes/topics", legacy, database.IdempotencyKeyHeader, "legacy")
+	expectStatus(t, w, http.StatusCreated, nil)
+	if w.Header().Get(database.IdempotentReplayedHeader) != "true" {
+		t.Fatal("повтор по устаревшему маршруту выполнен заново")
+	}
+}
+
+func TestIdempotencyErrorsAreNotStored(t *testing.T) {
+	e := idempotentEnv(t)
+	e.signIn(e.f.User())
+	sub := e.f.SubTheme(e.f.Theme())
+	path := fmt.Sprintf("/api/v1/subthemes/%d/topics", sub.ID)
+
+	// Ошибка освобождает ключ: исправленный запрос выполняется
+	expectError(t, e.do("POST", path, map[string]string{"title": "Без текста"}, database.IdempotencyKeyHeader, "retry"),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectStatus(t, e.do("POST", path, map[string]string{"title": "С текстом", "content": "Текст"}, database.IdempotencyKeyHeader, "retry"),
+		http.StatusCreated, nil)
+
+	expectError(t, e.do("POST", path, map[string]string{"title": "x", "content": "y"}, database.IdempotencyKeyHeader, "с пробелом"),
+		http.StatusBadRequest, "INVALID_PARAMETER")
+}
+
+func TestIdempotencyBodyLimit(t *testing.T) {
+	e := idempotentEnv(t)
+	sub := e.f.SubTheme(e.f.Theme())
+	path := fmt.Sprintf("/api/v1/subthemes/%d/topics", sub.ID)
+	huge := map[string]string{"title": "Тема", "content": strings.Repeat("x", database.IdempotencyMaxJSONBody)}
+
+	// Без сессии тело не читается вовсе
+	expectError(t, e.do("POST", path, huge, database.IdempotencyKeyHeader, "anon"), http.StatusUnauthorized, "UNAUTHENTICATED")
+
+	// Предел JSON-маршрута меньше предела загрузки вложений
+	e.signIn(e.f.User())
+	expectError(t, e.do("POST", path, huge, database.IdempotencyKeyHeader, "huge"), http.StatusRequestEntityTooLarge, "FILE_TOO_LARGE")
+	if n := e.count(&database.Topic{}, "sub_theme_id = ?", sub.ID); n != 0 {
+		t.Fatalf("топиков: %d", n)
+	}
+	if n := e.count(&database.IdempotencyKey{}, "1 = 1"); n != 0 {
+		t.Fatalf("сохранено ключей: %d", n)
+	}
+}
+
+func TestIdempotencyInProgressAndExpiry(t *testing.T) {
+	e := idempotentEnv(t)
+	me := e.signIn(e.f.User())
+	sub := e.f.SubTheme(e.f.Theme())
+	path := fmt.Sprintf("/api/v1/subthemes/%d/topics", sub.ID)
+	body := map[string]string{"title": "Тема", "content": "Текст"}
+
+	// Первый запрос еще выполняется
+	w := e.do("POST", path, body, database.IdempotencyKeyHeader, "slow")
+	expectStatus(t, w, http.StatusCreated, nil)
+	e.db.Model(&database.IdempotencyKey{}).Where("user_id = ? AND key = ?", me.ID, "slow").Update("status", 0)
+	expectError(t, e.do("POST", path, body, database.IdempotencyKeyHeader, "slow"), http.StatusConflict, "IDEMPOTENCY_KEY_IN_PROGRESS")
+
+	// Брошенный запрос (процесс упал) через некоторое время освобождает ключ
+	e.db.Model(&database.IdempotencyKey{}).Where("key = ?", "slow").Update("created_at", time.Now().Add(-time.Hour))
+	expectStatus(t, e.do("POST", path, body, database.IdempotencyKeyHeader, "slow"), http.StatusCreated, nil)
+
+	// После истечения срока ключ выполняет запрос заново
+	e.db.Model(&database.IdempotencyKey{}).Where("key = ?", "slow").Update("expires_at", time.Now().Add(-time.Minute))
+	w = e.do("POST", path, body, database.IdempotencyKeyHeader, "slow")
+	expectStatus(t, w, http.StatusCreated, nil)
+	if w.Header().Get(database.IdempotentReplayedHeader) != "" {
+		t.Fatal("истекший ключ отдал сохраненный ответ")
+	}
+	if n := e.count(&database.Topic{}, "sub_theme_id = ?", sub.ID); n != 3 {
+		t.Fatalf("топиков: %d, ожидалось 3", n)
+	}
+
+	// Чистка удаляет только истекшие ключи
+	e.do("POST", path, body, database.IdempotencyKeyHeader, "fresh")
+	e.db.Model(&database.IdempotencyKey{}).Where("key = ?", "slow").Update("expires_at", time.Now().Add(-time.Minute))
+	database.CleanupExpiredIdempotencyKeys(context.Background(), e.db)
+	if e.count(&database.IdempotencyKey{}, "key = ?", "slow") != 0 || e.count(&database.IdempotencyKey{}, "key = ?", "fresh") != 1 {
+		t.Fatal("чистка ключей")
+	}
+}
+
+func TestIdempotencyIgnoredForCredentials(t *testing.T) {
+	e := idempotentEnv(t)
+	user := e.f.User()
+	login := map[string]string{"username": user.Username, "password": dbtest.Password}
+
+	// Ответ входа с токеном сессии не сохраняется и не повторяется
+	var first, second loginResponse
+	expectStatus(t, e.do("POST", "/api/v1/auth/login", login, database.IdempotencyKeyHeader, "login"), http.StatusOK, &first)
+	w := e.do("POST", "/api/v1/auth/login", login, database.IdempotencyKeyHeader, "login")
+	expectStatus(t, w, http.StatusOK, &second)
+	if w.Header().Get(database.IdempotentReplayedHeader) != "" || first.Token == "" || first.Token == second.Token {
+		t.Fatalf("вход с Idempotency-Key: %+v, %+v", first, second)
+	}
+	e.token = first.Token
+	expectStatus(t, e.do("POST", "/api/v1/me/2fa/setup", nil, database.IdempotencyKeyHeader, "setup"), http.StatusOK, nil)
+	if n := e.count(&database.IdempotencyKey{}, "1 = 1"); n != 0 {
+		t.Fatalf("сохранено ответов: %d", n)
+	}
+}
+
+func TestIdempotencyLargeUpload(t *testing.T) {
+	e := idempotentEnv(t)
+	me := e.signIn(e.f.User())
+	data := []byte(strings.Repeat("большой файл ", 200_000)) // Больше буфера в памяти: тело идет через временный файл
+
+	first := e.upload("/api/v1/attachments", "file", "big.txt", data, database.IdempotencyKeyHeader, "upload")
+	expectStatus(t, first, http.StatusCreated, nil)
+	again := e.upload("/api/v1/attachments", "file", "big.txt", data, database.IdempotencyKeyHeader, "upload")
+	expectStatus(t, again, http.StatusCreated, nil)
+	if again.Header().Get(database.IdempotentReplayedHeader) != "true" || again.Body.String() != first.Body.String() {
+		t.Fatalf("повтор загрузки: %s", again.Body)
+	}
+	if n := e.count(&database.Attachment{}, "author_id = ?", me.ID); n != 1 {
+		t.Fatalf("вложений: %d, ожидалось 1", n)
+	}
+
+	// Файл сохранен полностью, хотя тело запроса прочитано до обработчика
+	var uploaded struct{ Attachment database.Attachment }
+	expectStatus(t, first, http.StatusCreated, &uploaded)
+	if w := e.do("GET", uploaded.Attachment.URL, nil); w.Body.Len() != len(data) {
+		t.Fatalf("размер файла: %d, ожидался %d", w.Body.Len(), len(data))
+	}
+
+	data[len(data)-1] = '!'
+	expectError(t, e.upload("/api/v1/attachments", "file", "big.txt", data, database.IdempotencyKeyHeader, "upload"),
+		http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED")
+}
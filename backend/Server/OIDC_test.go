@@ -0,0 +1,353 @@
+package Server
+
+import (
+	database "REVFORUM/database"
+	dbtest "REVFORUM/dbtest"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	mockClientID     = "revforum"
+	mockClientSecret = "client-secret"
+	mockRedirectURL  = "http://localhost/login/oidc/mock"
+)
+
+// mockOIDC — локальный провайдер OpenID Connect: discovery, JWKS и token
+// endpoint. Страницу входа заменяет authorize: тест сам «входит»
+// пользователем провайдера и получает code.
+type mockOIDC struct {
+	t      *testing.T
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	signer *rsa.PrivateKey // Чем подписывать id_token; по умолчанию key
+
+	mu     sync.Mutex
+	grants map[string]mockGrant // code → выданный вход
+}
+
+type mockGrant struct {
+	claims    map[string]any
+	challenge string
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDC{t: t, key: key, signer: key, grants: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 m.srv.URL,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "use": "sig", "kid": "k1",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", m.token)
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+// provider — настройки провайдера для Config.OIDC.
+func (m *mockOIDC) provider() *database.OIDCProvider {
+	return &database.OIDCProvider{
+		Name: "mock", DisplayName: "Mock ID", Issuer: m.srv.URL,
+		ClientID: mockClientID, ClientSecret: mockClientSecret, RedirectURL: mockRedirectURL,
+	}
+}
+
+// authorize проверяет адрес страницы входа и выдает code, как это сделал бы
+// провайдер после входа пользователя с claims. Поля iss, aud, nonce, exp и iat
+// подставляются, если их нет в claims.
+func (m *mockOIDC) authorize(authorizationURL string, claims map[string]any) (code, state string) {
+	m.t.Helper()
+	u, err := url.Parse(authorizationURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("client_id") != mockClientID || q.Get("redirect_uri") != mockRedirectURL ||
+		q.Get("response_type") != "code" || !strings.Contains(q.Get("scope"), "openid") ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("state") == "" || q.Get("nonce") == "" {
+		m.t.Fatalf("адрес входа составлен неверно: %s", authorizationURL)
+	}
+	full := map[string]any{
+		"iss": m.srv.URL, "aud": mockClientID, "nonce": q.Get("nonce"),
+		"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+	code = base64.RawURLEncoding.EncodeToString([]byte(q.Get("state")))[:16]
+	m.mu.Lock()
+	m.grants[code] = mockGrant{claims: full, challenge: q.Get("code_challenge")}
+	m.mu.Unlock()
+	return code, q.Get("state")
+}
+
+// token меняет code на id_token, проверяя клиента и code_verifier (PKCE).
+func (m *mockOIDC) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	code := r.PostFormValue("code")
+	m.mu.Lock()
+	grant, ok := m.grants[code]
+	delete(m.grants, code)
+	m.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	switch {
+	case id != mockClientID || secret != mockClientSecret:
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	case !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != mockRedirectURL ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"access_token": "at", "token_type": "Bearer", "id_token": m.sign(grant.claims)})
+}
+
+// sign выпускает id_token с подписью RS256.
+func (m *mockOIDC) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.signer, crypto.SHA256, digest[:])
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// newOIDCEnv — тестовое приложение с провайдером mock.
+func newOIDCEnv(t *testing.T) (*testEnv, *mockOIDC) {
+	m := newMockOIDC(t)
+	e := newTestEnv(t, func(cfg *Config) {
+		cfg.OIDC = database.OIDCProviders{"mock": m.provider()}
+	})
+	return e, m
+}
+
+// oidcStart начинает вход (или привязку с link) и возвращает адрес страницы провайдера.
+func (e *testEnv) oidcStart(link bool) string {
+	e.t.Helper()
+	path := "/api/v1/auth/oidc/mock/start"
+	if link {
+		path += "?link=true"
+	}
+	var start database.OIDCStart
+	expectStatus(e.t, e.do("POST", path, nil), http.StatusOK, &start)
+	if !start.ExpiresAt.After(time.Now()) {
+		e.t.Fatalf("срок входа: %+v", start)
+	}
+	return start.AuthorizationURL
+}
+
+// oidcCallback передает серверу code и state, с которыми провайдер вернул браузер.
+func (e *testEnv) oidcCallback(code, state string) *httptest.ResponseRecorder {
+	e.t.Helper()
+	return e.do("POST", "/api/v1/auth/oidc/mock/callback", map[string]string{"code": code, "state": state})
+}
+
+func TestOIDCLoginCreatesAccount(t *testing.T) {
+	e, m := newOIDCEnv(t)
+
+	var providers []database.OIDCProviderInfo
+	expectStatus(t, e.do("GET", "/api/v1/auth/oidc", nil), http.StatusOK, &providers)
+	if len(providers) != 1 || providers[0].Name != "mock" || providers[0].DisplayName != "Mock ID" {
+		t.Fatalf("провайдеры: %+v", providers)
+	}
+	expectError(t, e.do("POST", "/api/v1/auth/oidc/other/start", nil), http.StatusNotFound, "OIDC_PROVIDER_NOT_FOUND")
+
+	// Первый вход создает учетную запись без пароля
+	alice := map[string]any{"sub": "alice-1", "email": "alice@example.com", "email_verified": true, "preferred_username": "alice smith", "name": "Алиса"}
+	code, state := m.authorize(e.oidcStart(false), alice)
+	var login loginResponse
+	expectStatus(t, e.oidcCallback(code, state), http.StatusOK, &login)
+	if login.Token == "" {
+		t.Fatalf("вход не выдал сессию: %+v", login)
+	}
+	var user database.User
+	if err := e.db.Where("email = ?", "alice@example.com").First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "alicesmith" || user.DisplayName != "Алиса" {
+		t.Fatalf("учетная запись создана неверно: %+v", user)
+	}
+	expectError(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": "alicesmith", "password": "!"}), http.StatusUnauthorized, "INVALID_CREDENTIALS")
+
+	// state одноразовый
+	expectError(t, e.oidcCallback(code, state), http.StatusBadRequest, "OIDC_STATE_INVALID")
+
+	// Повторный вход попадает в ту же учетную запись; занятое имя получает номер
+	e.f.User(func(u *database.User) { u.Username = "bob" })
+	code, state = m.authorize(e.oidcStart(false), alice)
+	expectStatus(t, e.oidcCallback(code, state), http.StatusOK, &login)
+	code, state = m.authorize(e.oidcStart(false), map[string]any{"sub": "bob-1", "email": "bob@example.com", "email_verified": true, "preferred_username": "bob"})
+	expectStatus(t, e.oidcCallback(code, state), http.StatusOK, &login)
+	if e.count(&database.User{}, "email = ?", "alice@example.com") != 1 || e.count(&database.User{}, "username = ?", "bob2") != 1 {
+		t.Fatal("учетные записи провайдера сопоставлены неверно")
+	}
+	e.token = login.Token
+	var identities []database.Identity
+	expectStatus(t, e.do("GET", "/api/v1/me/identities", nil), http.StatusOK, &identities)
+	if len(identities) != 1 || identities[0].Provider != "mock" || identities[0].Email != "bob@example.com" || identities[0].LastLoginAt == nil {
+		t.Fatalf("привязки: %+v", identities)
+	}
+
+	// Имя из одних цифр (например, email 12345@…) получает приставку
+	code, state = m.authorize(e.oidcStart(false), map[string]any{"sub": "num-1", "email": "12345@example.com", "email_verified": true})
+	expectStatus(t, e.oidcCallback(code, state), http.StatusOK, &login)
+	if e.count(&database.User{}, "username = ?", "user12345") != 1 {
+		t.Fatal("имя из одних цифр не получило приставку")
+	}
+}
+
+func TestOIDCRejectsBadLogins(t *testing.T) {
+	e, m := newOIDCEnv(t)
+	claims := func(extra map[string]any) map[string]any {
+		c := map[string]any{"sub": "u-1", "email": "u@example.com", "email_verified": true}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	// id_token не от этого входа, не этому клиенту, просроченный или с чужой подписью
+	for name, extra := range map[string]map[string]any{
+		"nonce":    {"nonce": "other"},
+		"audience": {"aud": []string{"someone-else"}},
+		"issuer":   {"iss": "https://evil.example.com"},
+		"expired":  {"exp": time.Now().Add(-time.Hour).Unix()},
+	} {
+		code, state := m.authorize(e.oidcStart(false), claims(extra))
+		if w := e.oidcCallback(code, state); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: код ответа %d; тело: %s", name, w.Code, w.Body.String())
+		}
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.signer = other
+	code, state := m.authorize(e.oidcStart(false), claims(nil))
+	expectError(t, e.oidcCallback(code, state), http.StatusUnauthorized, "OIDC_LOGIN_FAILED")
+	m.signer = m.key
+
+	// Код без своего code_verifier: state от другого входа
+	code, _ = m.authorize(e.oidcStart(false), claims(nil))
+	_, state = m.authorize(e.oidcStart(false), claims(nil))
+	expectError(t, e.oidcCallback(code, state), http.StatusUnauthorized, "OIDC_LOGIN_FAILED")
+	expectError(t, e.oidcCallback("code", "unknown"), http.StatusBadRequest, "OIDC_STATE_INVALID")
+
+	// Новая учетная запись — только с подтвержденным свободным email
+	code, state = m.authorize(e.oidcStart(false), claims(map[string]any{"email_verified": false}))
+	expectError(t, e.oidcCallback(code, state), http.StatusBadRequest, "OIDC_EMAIL_UNVERIFIED")
+	owner := e.f.User()
+	code, state = m.authorize(e.oidcStart(false), claims(map[string]any{"email": owner.Email}))
+	expectError(t, e.oidcCallback(code, state), http.StatusConflict, "OIDC_EMAIL_IN_USE")
+	if e.count(&database.Identity{}, "1 = 1") != 0 {
+		t.Fatal("провайдер привязан без проверки")
+	}
+}
+
+func TestOIDCLinkAndUnlink(t *testing.T) {
+	e, m := newOIDCEnv(t)
+	user := e.f.User()
+	linked := map[string]any{"sub": "linked-1", "email": "someone@example.com", "email_verified": true}
+
+	// Привязка требует сессии и завершается только тем, кто ее начал
+	expectError(t, e.do("POST", "/api/v1/auth/oidc/mock/start?link=true", nil), http.StatusUnauthorized, "UNAUTHENTICATED")
+	e.signIn(user)
+	code, state := m.authorize(e.oidcStart(true), linked)
+	e.signIn(e.f.User())
+	expectError(t, e.oidcCallback(code, state), http.StatusBadRequest, "OIDC_STATE_INVALID")
+
+	e.signIn(user)
+	code, state = m.authorize(e.oidcStart(true), linked)
+	var resp struct {
+		Identity database.Identity `json:"identity"`
+	}
+	expectStatus(t, e.oidcCallback(code, state), http.StatusOK, &resp)
+	if resp.Identity.Provider != "mock" || e.count(&database.Identity{}, "user_id = ? AND subject = ?", user.ID, "linked-1") != 1 {
+		t.Fatalf("провайдер не привязан: %+v", resp)
+	}
+	code, state = m.authorize(e.oidcStart(true), map[string]any{"sub": "linked-2"})
+	expectError(t, e.oidcCallback(code, state), http.StatusConflict, "IDENTITY_ALREADY_LINKED")
+
+	// Вход через привязанного провайдера — в учетную запись с паролем
+	e.token = ""
+	code, state = m.authorize(e.oidcStart(false), linked)
+	var login struct {
+		User struct {
+			ID uint `json:"id"`
+		} `json:"user"`
+	}
+	expectStatus(t, e.oidcCallback(code, state), http.StatusOK, &login)
+	if login.User.ID != user.ID {
+		t.Fatalf("вход в учетную запись %d, ожидалась %d", login.User.ID, user.ID)
+	}
+
+	// Пароль остается, поэтому провайдера можно отвязать
+	e.signIn(user)
+	expectStatus(t, e.do("DELETE", "/api/v1/me/identities/mock", nil), http.StatusNoContent, nil)
+	expectError(t, e.do("DELETE", "/api/v1/me/identities/mock", nil), http.StatusNotFound, "IDENTITY_NOT_FOUND")
+	expectStatus(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": user.Username, "password": dbtest.Password}), http.StatusOK, nil)
+
+	// А единственный способ входа — нельзя
+	e.token = ""
+	code, state = m.authorize(e.oidcStart(false), linked)
+	var created loginResponse
+	expectStatus(t, e.oidcCallback(code, state), http.StatusOK, &created)
+	e.token = created.Token
+	expectError(t, e.do("DELETE", "/api/v1/me/identities/mock", nil), http.StatusConflict, "LAST_LOGIN_METHOD")
+}
+
+func TestOIDCLoginKeepsSecondFactorAndBan(t *testing.T) {
+	e, m := newOIDCEnv(t)
+	user := e.signIn(e.f.User())
+	linked := map[string]any{"sub": "2fa-1"}
+	code, state := m.authorize(e.oidcStart(true), linked)
+	expectStatus(t, e.oidcCallback(code, state), http.StatusOK, nil)
+	e.enableTwoFactor()
+
+	// Провайдер заменяет пароль, но не второй фактор
+	e.token = ""
+	code, state = m.authorize(e.oidcStart(false), linked)
+	var login loginResponse
+	expectStatus(t, e.oidcCallback(code, state), http.StatusOK, &login)
+	if !login.TwoFactorRequired || login.ChallengeToken == "" || login.Token != "" {
+		t.Fatalf("вход с 2FA: %+v", login)
+	}
+
+	e.db.Model(&user).Update("banned_at", time.Now())
+	code, state = m.authorize(e.oidcStart(false), linked)
+	expectError(t, e.oidcCallback(code, state), http.StatusForbidden, "USER_BANNED")
+}
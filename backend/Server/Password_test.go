@@ -0,0 +1,135 @@
+package Server
+
+import (
+	database "REVFORUM/database"
+	dbtest "REVFORUM/dbtest"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// storedHash возвращает текущий хэш пароля пользователя.
+func (e *testEnv) storedHash(userID uint) string {
+	e.t.Helper()
+	var user database.User
+	if err := e.db.First(&user, userID).Error; err != nil {
+		e.t.Fatal(err)
+	}
+	return user.PasswordHash
+}
+
+func expectRule(t *testing.T, body apiError, field, rule string) {
+	t.Helper()
+	for _, f := range body.Fields {
+		if f.Field == field && f.Rule == rule {
+			return
+		}
+	}
+	t.Fatalf("нет ошибки %s для поля %q: %+v", rule, field, body.Fields)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	e := newTestEnv(t, func(cfg *Config) {
+		cfg.Passwords.MinLength = 10
+		cfg.Passwords.MinClasses = 3
+	})
+	register := func(password string) apiError {
+		return expectError(t, e.do("POST", "/api/v1/auth/register", map[string]string{"username": "alice", "email": "a.white@example.com", "password": password}),
+			http.StatusBadRequest, "VALIDATION_FAILED")
+	}
+
+	expectRule(t, register("Short1"), "password", "min")
+	expectRule(t, register("onlylowercase"), "password", "password_classes")
+	expectRule(t, register("Alice-2024-pass"), "password", "password_personal")
+	expectRule(t, register("X-a.white-1"), "password", "password_personal")
+	expectRule(t, register("Password123"), "password", "password_common")
+
+	expectStatus(t, e.do("POST", "/api/v1/auth/register", map[string]string{"username": "alice", "email": "a.white@example.com", "password": "Tall-Oak-Rivers7"}),
+		http.StatusCreated, nil)
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	// Фикстуры хэшируют с bcrypt.MinCost; вход с более высокой стоимостью пересчитывает хэш
+	e := newTestEnv(t, func(cfg *Config) { cfg.Passwords.BcryptCost = bcrypt.MinCost + 1 })
+	user := e.f.User()
+	e.login(user, firefoxUA)
+	if cost, err := bcrypt.Cost([]byte(e.storedHash(user.ID))); err != nil || cost != bcrypt.MinCost+1 {
+		t.Fatalf("стоимость bcrypt = %d (%v), ожидалась %d", cost, err, bcrypt.MinCost+1)
+	}
+
+	// Переход на argon2id: хэш меняется при входе и дальше не пересчитывается
+	e = newTestEnv(t, func(cfg *Config) {
+		cfg.Passwords.Hash = database.PasswordHashArgon2id
+		cfg.Passwords.Argon2Time = 1
+		cfg.Passwords.Argon2Memory = 1024
+		cfg.Passwords.Argon2Threads = 1
+	})
+	user = e.f.User()
+	e.login(user, firefoxUA)
+	hash := e.storedHash(user.ID)
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("хэш не пересчитан в argon2id: %q", hash)
+	}
+	e.login(user, firefoxUA)
+	if e.storedHash(user.ID) != hash {
+		t.Fatal("актуальный хэш пересчитан повторно")
+	}
+	expectError(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": user.Username, "password": "wrong-password"}),
+		http.StatusUnauthorized, "INVALID_CREDENTIALS")
+}
+
+func TestChangePassword(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.f.User()
+	e.login(user, "other device")
+	e.login(user, firefoxUA)
+
+	body := expectError(t, e.do("PUT", "/api/v1/me/password", map[string]string{"current_password": "wrong-password", "new_password": "Tall-Oak-Rivers7"}),
+		http.StatusBadRequest, "INVALID_CURRENT_PASSWORD")
+	if body.Error == "" {
+		t.Fatal("нет сообщения об ошибке")
+	}
+	body = expectError(t, e.do("PUT", "/api/v1/me/password", map[string]string{"current_password": dbtest.Password, "new_password": "qwerty"}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectRule(t, body, "new_password", "min")
+
+	var resp struct {
+		RevokedSessions int64 `json:"revoked_sessions"`
+	}
+	expectStatus(t, e.do("PUT", "/api/v1/me/password", map[string]string{"current_password": dbtest.Password, "new_password": "Tall-Oak-Rivers7"}),
+		http.StatusOK, &resp)
+	if resp.RevokedSessions != 1 {
+		t.Fatalf("завершено сессий: %d, ожидалась 1", resp.RevokedSessions)
+	}
+	if sessions := e.sessions(); len(sessions) != 1 || !sessions[0].Current {
+		t.Fatalf("текущая сессия не сохранена: %+v", sessions)
+	}
+
+	e.token = ""
+	expectError(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": user.Username, "password": dbtest.Password}),
+		http.StatusUnauthorized, "INVALID_CREDENTIALS")
+	expectStatus(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": user.Username, "password": "Tall-Oak-Rivers7"}),
+		http.StatusOK, nil)
+}
+
+func TestChangePasswordWithoutCurrent(t *testing.T) {
+	// Учетная запись, созданная через провайдера входа, пароля не имеет
+	e := newTestEnv(t)
+	user := e.f.User()
+	if err := e.db.Model(&user).Update("password_hash", "!").Error; err != nil {
+		t.Fatal(err)
+	}
+	e.signIn(user)
+
+	expectStatus(t, e.do("PUT", "/api/v1/me/password", map[string]string{"new_password": "Tall-Oak-Rivers7"}), http.StatusOK, nil)
+	e.token = ""
+	expectStatus(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": user.Username, "password": "Tall-Oak-Rivers7"}),
+		http.StatusOK, nil)
+
+	// Теперь пароль есть, и без текущего его не сменить
+	e.signIn(user)
+	expectError(t, e.do("PUT", "/api/v1/me/password", map[string]string{"new_password": "Another-Tall-Oak8"}),
+		http.StatusBadRequest, "INVALID_CURRENT_PASSWORD")
+}
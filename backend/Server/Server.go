@@ -1,58 +1,374 @@
 package Server
 
 import (
+	apperr "REVFORUM/apperr"
+	cache "REVFORUM/cache"
 	database "REVFORUM/database"
+	logging "REVFORUM/logging"
+	metrics "REVFORUM/metrics"
+	realtime "REVFORUM/realtime"
+	storage "REVFORUM/storage"
+	tracing "REVFORUM/tracing"
+	"context"
+	"errors"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
-func Init_Server() {
+// Config — настройки приложения. Из окружения читается только в ConfigFromEnv,
+// поэтому тесты и утилиты собирают приложение без побочных эффектов.
+type Config struct {
+	Addr         string                    // Адрес HTTP-сервера, например ":8080"
+	CacheTTL     time.Duration             // Время жизни кэша списков
+	AllowOrigins []string                  // Разрешенные Origin для CORS и WebSocket
+	Attachments  database.AttachmentLimits // Ограничения на вложения
+	Metrics      metrics.Access            // Кому разрешено читать /metrics
+
+	// Фоновая чистка вложений, не привязанных к постам; 0 — чистка выключена
+	OrphanCleanupInterval time.Duration
+	OrphanMaxAge          time.Duration // Возраст, после которого непривязанный файл удаляется
+
+	// Удаление учетных записей (DELETE /api/v1/me): срок до удаления
+	// и период фоновой задачи, которая его выполняет; 0 — задача выключена
+	AccountDeletionGrace time.Duration
+	AccountPurgeInterval time.Duration
+
+	// Сессии входа: срок жизни и период удаления истекших; 0 — удаление выключено
+	SessionTTL             time.Duration
+	SessionCleanupInterval time.Duration
+
+	// Ключи идемпотентности создания ресурсов: сколько хранится ответ (0 — заголовок
+	// Idempotency-Key игнорируется) и период удаления истекших ключей
+	IdempotencyTTL             time.Duration
+	IdempotencyCleanupInterval time.Duration
+
+	OIDC      database.OIDCProviders       // Провайдеры входа OpenID Connect; пусто — вход через них выключен
+	Passwords database.PasswordPolicy      // Требования к паролям и хэширование; нулевое значение — по умолчанию
+	Usernames database.UsernameChangeRules // Смена имени: не чаще раза в Cooldown, старое имя удерживается Hold
+}
+
+// ConfigFromEnv собирает Config из переменных окружения (см. resource/.env).
+func ConfigFromEnv() Config {
+	return Config{
+		Addr:                       ":8080",
+		CacheTTL:                   cache.TTLFromEnv(),
+		AllowOrigins:               []string{"http://localhost"}, // Адрес твоего React-приложения
+		Attachments:                database.AttachmentLimitsFromEnv(),
+		Metrics:                    metrics.AccessFromEnv(),
+		OrphanCleanupInterval:      time.Hour,
+		OrphanMaxAge:               24 * time.Hour,
+		AccountDeletionGrace:       database.AccountDeletionGraceFromEnv(),
+		AccountPurgeInterval:       time.Hour,
+		SessionTTL:                 database.SessionTTLFromEnv(),
+		SessionCleanupInterval:     time.Hour,
+		IdempotencyTTL:             database.IdempotencyTTLFromEnv(),
+		IdempotencyCleanupInterval: time.Hour,
+		OIDC:                       database.OIDCProvidersFromEnv(),
+		Passwords:                  database.PasswordPolicyFromEnv(),
+		Usernames:                  database.UsernameChangeRulesFromEnv(),
+	}
+}
+
+// Deps — внешние сервисы приложения. Их жизненным циклом управляет вызывающий:
+// App только пользуется ими.
+type Deps struct {
+	Cache  cache.Store   // Кэш ответов списков; nil — кэширование выключено
+	Events *realtime.Hub // Хаб событий реального времени; должен быть запущен (Hub.Run)
+	Files  storage.Store // Хранилище аватаров и вложений
+}
+
+// App — собранное приложение: HTTP-обработчик и фоновые задачи.
+//
+//	app := server.New(cfg, db, deps)
+//	app.Start(ctx)                // фоновые задачи
+//	go app.ListenAndServe()       // или httptest.NewServer(app.Handler())
+//	defer app.Shutdown(ctx)
+type App struct {
+	cfg     Config
+	db      *gorm.DB
+	deps    Deps
+	handler http.Handler
+	srv     *http.Server
+	stop    context.CancelFunc // Останавливает фоновые задачи
+	done    sync.WaitGroup
+}
+
+// New собирает приложение. Ничего не запускает и не читает окружение.
+func New(cfg Config, db *gorm.DB, deps Deps) *App {
+	handler := newRouter(cfg, db, deps)
+	return &App{
+		cfg:     cfg,
+		db:      db,
+		deps:    deps,
+		handler: handler,
+		srv:     &http.Server{Addr: cfg.Addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second},
+		stop:    func() {},
+	}
+}
+
+// Handler возвращает HTTP-обработчик всех маршрутов.
+func (a *App) Handler() http.Handler { return a.handler }
+
+// Start запускает фоновые задачи; они работают до отмены ctx или Shutdown.
+func (a *App) Start(ctx context.Context) {
+	ctx, a.stop = context.WithCancel(ctx)
+	a.every(ctx, a.cfg.OrphanCleanupInterval, func() {
+		database.CleanupOrphanAttachments(ctx, a.db, a.deps.Files, a.cfg.OrphanMaxAge)
+	})
+	a.every(ctx, a.cfg.AccountPurgeInterval, func() {
+		database.PurgeDeletedAccounts(ctx, a.db, a.deps.Cache, a.deps.Files)
+	})
+	a.every(ctx, a.cfg.SessionCleanupInterval, func() {
+		database.CleanupExpiredSessions(ctx, a.db)
+	})
+	a.every(ctx, a.cfg.IdempotencyCleanupInterval, func() {
+		database.CleanupExpiredIdempotencyKeys(ctx, a.db)
+	})
+}
+
+// every запускает фоновую задачу fn с периодом interval; 0 — не запускает.
+func (a *App) every(ctx context.Context, interval time.Duration, fn func()) {
+	if interval <= 0 {
+		return
+	}
+	a.done.Add(1)
+	go func() {
+		defer a.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+}
+
+// ListenAndServe принимает соединения на cfg.Addr до вызова Shutdown.
+// После штатной остановки возвращает nil.
+func (a *App) ListenAndServe() error {
+	slog.Info("server started", "addr", a.cfg.Addr)
+	if err := a.srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown дожидается завершения текущих запросов (не дольше ctx)
+// и останавливает фоновые задачи.
+func (a *App) Shutdown(ctx context.Context) error {
+	err := a.srv.Shutdown(ctx)
+	a.stop()
+	a.done.Wait()
+	return err
+}
+
+// Init_Server собирает приложение из окружения, обслуживает запросы
+// и корректно останавливается по SIGINT/SIGTERM.
+func Init_Server(db *gorm.DB) {
 	gin.SetMode(gin.ReleaseMode)
-	router := gin.Default()
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// Трассировка: серверный спан на запрос; экспортер задается TRACING_EXPORTER
+	shutdownTracing := tracing.Init()
+	defer shutdownTracing(context.Background())
+
+	app := New(ConfigFromEnv(), db, Deps{
+		Cache:  cache.Init(),                  // Кэш ответов для списков
+		Events: realtime.Init(database.DSN()), // События реального времени
+		Files:  storage.Init(),                // Хранилище аватаров и вложений
+	})
+	app.Start(ctx)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := app.Shutdown(shutdownCtx); err != nil {
+			slog.Error("server shutdown failed", "error", err)
+		}
+	}()
+	if err := app.ListenAndServe(); err != nil {
+		log.Fatal("Server failed: ", err)
+	}
+	slog.Info("server stopped")
+}
+
+// newRouter регистрирует middleware и все маршруты API.
+// Каждый маршрут должен быть описан в openapi.json (см. Server_test.go).
+func newRouter(cfg Config, db *gorm.DB, deps Deps) *gin.Engine {
+	store, hub, files := deps.Cache, deps.Events, deps.Files
+	router := gin.New()
+	router.Use(tracing.Middleware())
+	router.Use(logging.Middleware(), apperr.Recovery()) // Журнал запросов с request_id вместо стандартного логгера gin
+	router.Use(metrics.Middleware())
+
+	// Метрики Prometheus; доступ ограничивается METRICS_TOKEN и METRICS_ALLOWED_NETS
+	router.GET("/metrics", metrics.Handler(cfg.Metrics))
+
+	// Все ошибки API, включая неизвестные маршруты, — в едином формате apperr
+	router.NoRoute(apperr.NoRoute)
 
 	router.GET("/hello", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "Hello, World!"})
 	})
 
 	config := cors.Config{
-		AllowOrigins:     []string{"http://localhost"}, // Адрес твоего React-приложения
+		AllowOrigins:     cfg.AllowOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization"}, // Добавь Authorization, если используешь JWT
-		AllowCredentials: false,                                                                 // Установи true, если используешь куки/credentials
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", logging.RequestIDHeader, database.IdempotencyKeyHeader}, // Authorization: Bearer <токен сессии>
+		ExposeHeaders:    []string{logging.RequestIDHeader, database.IdempotentReplayedHeader},
+		AllowCredentials: false, // Установи true, если используешь куки/credentials
 	}
 	router.Use(cors.New(config))
+	router.Use(database.Authenticate(db)) // Пользователь по токену сессии; без токена — анонимный запрос
 
-	// Определяем маршрут и его обработчик
-	router.GET("/api/users", func(c *gin.Context) { // Используем GET и более конкретный путь
-		var users []database.User          // Слайс для хранения результатов запроса
-		result := database.DB.Find(&users) // Выполняем запрос к БД внутри обработчика
-		if result.Error != nil {
-			// Обрабатываем ошибку, если запрос к БД не удался
-			// log.Println("Ошибка БД:", result.Error) // Логирование ошибки полезно
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка базы данных"}) // 500 Internal Server Error
-			return                                                                       // Важно: завершаем обработчик после ошибки
-		}
-		// Если успешно, возвращаем данные
-		c.JSON(http.StatusOK, users) // 200 OK со списком пользователей
-	})
+	cached := func(group, param string) gin.HandlerFunc {
+		return cache.Middleware(store, cfg.CacheTTL, cache.ByParam(group, param))
+	}
+	// Повтор создания с тем же Idempotency-Key отдает сохраненный ответ.
+	// Только для ресурсов форума: ответы входа и 2FA хранить нельзя
+	idempotent := database.Idempotency(db, cfg.IdempotencyTTL, database.IdempotencyMaxJSONBody)
+	idempotentUpload := database.Idempotency(db, cfg.IdempotencyTTL, cfg.Attachments.MaxRequestBody())
+
+	// Документация API
+	router.GET("/api/openapi.json", OpenAPIHandler)
+	router.GET("/api/docs", DocsHandler)
+
+	// Основной API: ресурсные маршруты, родитель задается ID в пути
+	v1 := router.Group("/api/v1")
+
+	v1.POST("/auth/register", database.RegisterHandler(db, cfg.Passwords))
+	v1.GET("/auth/username-available", database.UsernameAvailabilityHandler(db))
+	v1.POST("/auth/login", database.LoginHandler(db, cfg.SessionTTL, cfg.Passwords))
+	v1.POST("/auth/login/2fa", database.TwoFactorLoginHandler(db, cfg.SessionTTL)) // Второй шаг входа
+	v1.POST("/auth/logout", database.LogoutHandler(db))
+	v1.GET("/auth/oidc", database.OIDCProvidersHandler(cfg.OIDC))
+	v1.POST("/auth/oidc/:provider/start", database.OIDCStartHandler(db, cfg.OIDC))
+	v1.POST("/auth/oidc/:provider/callback", database.OIDCCallbackHandler(db, cfg.OIDC, cfg.SessionTTL))
+
+	v1.GET("/users", database.ListUsersHandler(db))
+	v1.GET("/users/:id", database.GetUserProfileHandler(db))
+	v1.GET("/users/:id/avatar", database.GetAvatarHandler(db, files))
+	v1.PATCH("/me/profile", database.UpdateProfileHandler(db, store))
+	v1.PUT("/me/password", database.ChangePasswordHandler(db, cfg.Passwords))
+	v1.PUT("/me/username", database.ChangeUsernameHandler(db, store, cfg.Usernames))
+	v1.POST("/me/avatar", database.UploadAvatarHandler(db, store, files))
+	v1.DELETE("/me/avatar", database.DeleteAvatarHandler(db, store, files))
+	v1.GET("/me/export", database.ExportPersonalDataHandler(db))
+	v1.DELETE("/me", database.DeleteAccountHandler(db, cfg.AccountDeletionGrace))
+	v1.DELETE("/me/deletion", database.CancelAccountDeletionHandler(db))
+	v1.GET("/me/sessions", database.ListSessionsHandler(db))
+	v1.DELETE("/me/sessions", database.RevokeAllSessionsHandler(db)) // Выйти везде
+	v1.DELETE("/me/sessions/:id", database.RevokeSessionHandler(db))
+	v1.GET("/me/2fa", database.TwoFactorStatusHandler(db))
+	v1.POST("/me/2fa/setup", database.SetupTwoFactorHandler(db))
+	v1.POST("/me/2fa/enable", database.EnableTwoFactorHandler(db))
+	v1.POST("/me/2fa/recovery-codes", database.RegenerateRecoveryCodesHandler(db))
+	v1.DELETE("/me/2fa", database.DisableTwoFactorHandler(db))
+	v1.GET("/me/identities", database.ListIdentitiesHandler(db))
+	v1.DELETE("/me/identities/:provider", database.UnlinkIdentityHandler(db))
+
+	v1.GET("/themes", cached(database.CacheThemes, ""), database.GetThemesHandler(db))
+	v1.POST("/themes", idempotent, database.CreateThemeHandler(db, store))
+	v1.GET("/themes/:id", database.GetThemeHandler(db))
+	v1.PATCH("/themes/:id", database.UpdateThemeHandler(db, store))
+	v1.DELETE("/themes/:id", database.DeleteThemeHandler(db, store))
+	v1.GET("/themes/:id/subthemes", cached(database.CacheSubThemes, "id"), database.GetSubThemesHandler(db))
+	v1.POST("/themes/:id/subthemes", idempotent, database.CreateSubThemeHandler(db, store))
 
-	router.POST("/api/register", database.RegisterHandler(database.DB))
-	router.POST("/api/login", database.LoginHandler(database.DB))
+	v1.GET("/subthemes/:id", database.GetSubThemeHandler(db))
+	v1.PATCH("/subthemes/:id", database.UpdateSubThemeHandler(db, store))
+	v1.DELETE("/subthemes/:id", database.DeleteSubThemeHandler(db, store))
+	v1.GET("/subthemes/:id/topics", cached(database.CacheTopics, "id"), database.GetTopicsBySubThemeHandler(db))
+	v1.POST("/subthemes/:id/topics", idempotent, database.CreateTopicHandler(db, store, hub))
 
-	router.GET("/api/themes", database.GetThemesHandler(database.DB))
-	router.POST("/api/themes/create", database.CreateThemeHandler(database.DB))
+	v1.GET("/topics/:id", database.GetTopicHandler(db))
+	v1.PATCH("/topics/:id", database.UpdateTopicHandler(db, store, hub))
+	v1.DELETE("/topics/:id", database.DeleteTopicHandler(db, store, hub, files))
+	v1.GET("/topics/:id/posts", cached(database.CachePosts, "id"), database.GetPostsByTopicHandler(db))
+	v1.POST("/topics/:id/posts", idempotent, database.CreatePostHandler(db, store, hub))
 
-	router.POST("/api/themes/subthemes", database.CreateSubThemeHandler(database.DB))
-	router.GET("/api/themes/:id/subthemes", database.GetSubThemesHandler(database.DB))
+	v1.GET("/posts/:id", database.GetPostHandler(db))
+	v1.PATCH("/posts/:id", database.UpdatePostHandler(db, store, hub))
+	v1.DELETE("/posts/:id", database.DeletePostHandler(db, store, hub, files))
 
-	router.POST("/api/themes/subthemes/topics", database.CreateTopicHandler)
-	router.GET("/api/themes/subthemes/:id/topics", database.GetTopicsBySubThemeHandler)
+	v1.POST("/attachments", idempotentUpload, database.UploadAttachmentHandler(db, files, cfg.Attachments))
+	v1.GET("/attachments/:id", database.GetAttachmentHandler(db, files, false))
+	v1.GET("/attachments/:id/thumbnail", database.GetAttachmentHandler(db, files, true))
 
-	router.POST("/api/themes/subthemes/topics/posts", database.CreatePostHandler)         // Создание поста
-	router.GET("/api/themes/subthemes/topics/:id/posts", database.GetPostsByTopicHandler) // Получение постов по ID топика
-	
-	log.Println("Сервер запущен на http://localhost:8080/hello")
-	router.Run(":8080")
+	v1.GET("/events", realtime.SSEHandler(hub))                        // Поток событий (SSE)
+	v1.POST("/events/typing", realtime.TypingHandler(hub))             // «Печатает…» для SSE-клиентов
+	v1.GET("/ws", realtime.WebSocketHandler(hub, config.AllowOrigins)) // Поток событий (WebSocket)
+
+	v1.GET("/admin/export", database.ExportArchiveHandler(db))         // Архив форума (JSON Lines)
+	v1.POST("/admin/import", database.ImportArchiveHandler(db, store)) // Загрузка архива
+	v1.GET("/admin/2fa-policy", database.GetTwoFactorPolicyHandler(db))
+	v1.PUT("/admin/2fa-policy", database.SetTwoFactorPolicyHandler(db)) // Для каких ролей 2FA обязательна
+
+	// Устаревшие маршруты: оставлены, пока фронтенд не перейдет на /api/v1.
+	// Отвечают так же, но с заголовками Deprecation и Link на замену.
+	legacy := router.Group("/api")
+
+	legacy.GET("/users", deprecated("/api/v1/users"), database.ListUsersHandler(db))
+
+	legacy.POST("/register", deprecated("/api/v1/auth/register"), database.RegisterHandler(db, cfg.Passwords))
+	legacy.POST("/login", deprecated("/api/v1/auth/login"), database.LoginHandler(db, cfg.SessionTTL, cfg.Passwords))
+
+	legacy.GET("/themes", deprecated("/api/v1/themes"), cached(database.CacheThemes, ""), database.GetThemesHandler(db))
+	legacy.POST("/themes/create", deprecated("/api/v1/themes"), idempotent, database.CreateThemeHandler(db, store))
+
+	legacy.POST("/themes/subthemes", deprecated("/api/v1/themes/{id}/subthemes"), idempotent, database.CreateSubThemeHandler(db, store))
+	legacy.GET("/themes/:id/subthemes", deprecated("/api/v1/themes/{id}/subthemes"), cached(database.CacheSubThemes, "id"), database.GetSubThemesHandler(db))
+
+	legacy.POST("/themes/subthemes/topics", deprecated("/api/v1/subthemes/{id}/topics"), idempotent, database.CreateTopicHandler(db, store, hub))
+	legacy.GET("/themes/subthemes/:id/topics", deprecated("/api/v1/subthemes/{id}/topics"), cached(database.CacheTopics, "id"), database.GetTopicsBySubThemeHandler(db))
+
+	legacy.POST("/themes/subthemes/topics/posts", deprecated("/api/v1/topics/{id}/posts"), idempotent, database.CreatePostHandler(db, store, hub))
+	legacy.GET("/themes/subthemes/topics/:id/posts", deprecated("/api/v1/topics/{id}/posts"), cached(database.CachePosts, "id"), database.GetPostsByTopicHandler(db))
+
+	legacy.GET("/users/:id", deprecated("/api/v1/users/{id}"), database.GetUserProfileHandler(db))
+	legacy.GET("/users/:id/avatar", deprecated("/api/v1/users/{id}/avatar"), database.GetAvatarHandler(db, files))
+	legacy.PATCH("/me/profile", deprecated("/api/v1/me/profile"), database.UpdateProfileHandler(db, store))
+	legacy.POST("/me/avatar", deprecated("/api/v1/me/avatar"), database.UploadAvatarHandler(db, store, files))
+	legacy.DELETE("/me/avatar", deprecated("/api/v1/me/avatar"), database.DeleteAvatarHandler(db, store, files))
+
+	legacy.POST("/attachments", deprecated("/api/v1/attachments"), idempotentUpload, database.UploadAttachmentHandler(db, files, cfg.Attachments))
+	legacy.GET("/attachments/:id", deprecated("/api/v1/attachments/{id}"), database.GetAttachmentHandler(db, files, false))
+	legacy.GET("/attachments/:id/thumbnail", deprecated("/api/v1/attachments/{id}/thumbnail"), database.GetAttachmentHandler(db, files, true))
+
+	legacy.GET("/events", deprecated("/api/v1/events"), realtime.SSEHandler(hub))
+	legacy.POST("/events/typing", deprecated("/api/v1/events/typing"), realtime.TypingHandler(hub))
+	legacy.GET("/ws", deprecated("/api/v1/ws"), realtime.WebSocketHandler(hub, config.AllowOrigins))
+
+	return router
+}
+
+// deprecated помечает устаревший маршрут заголовками Deprecation (RFC 9745)
+// и Link с rel="successor-version", указывающим на маршрут в /api/v1.
+// {id} в successor подставляется из параметра пути, если он есть.
+func deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		link := successor
+		if id := c.Param("id"); id != "" {
+			link = strings.ReplaceAll(link, "{id}", id)
+		}
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+link+`>; rel="successor-version"`)
+		c.Next()
+	}
 }
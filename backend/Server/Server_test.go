@@ -0,0 +1,192 @@
+package Server
+
+import (
+	cache "REVFORUM/cache"
+	database "REVFORUM/database"
+	realtime "REVFORUM/realtime"
+	storage "REVFORUM/storage"
+	"context"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gin-gonic/gin"
+)
+
+func loadSpec(t *testing.T) *openapi3.T {
+	t.Helper()
+	doc, err := openapi3.NewLoader().LoadFromData(openapiSpec)
+	if err != nil {
+		t.Fatalf("openapi.json не разбирается: %v", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatalf("openapi.json не проходит проверку OpenAPI 3: %v", err)
+	}
+	return doc
+}
+
+func testRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	files, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newRouter(Config{AllowOrigins: []string{"http://localhost"}}, nil, Deps{Cache: cache.NewLRU(1), Events: realtime.NewHub(realtime.NewMemoryBroker(1)), Files: files})
+}
+
+// pathParam — параметр пути gin (:id, *path) в записи OpenAPI ({id}).
+var pathParam = regexp.MustCompile(`[:*](\w+)`)
+
+// TestOpenAPIMatchesRoutes проверяет, что каждый маршрут описан в спецификации,
+// а в спецификации нет маршрутов, которых уже не существует.
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	doc := loadSpec(t)
+
+	routes := map[string]bool{}
+	for _, r := range testRouter(t).Routes() {
+		routes[r.Method+" "+pathParam.ReplaceAllString(r.Path, "{$1}")] = true
+	}
+	documented := map[string]bool{}
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			documented[method+" "+path] = true
+		}
+	}
+
+	for _, r := range sortedKeys(routes) {
+		if !documented[r] {
+			t.Errorf("маршрут %s не описан в openapi.json", r)
+		}
+	}
+	for _, op := range sortedKeys(documented) {
+		if !routes[op] {
+			t.Errorf("openapi.json описывает %s, но такого маршрута нет", op)
+		}
+	}
+}
+
+// TestOpenAPISchemasMatchTypes сверяет поля схем с JSON-тегами типов Go,
+// а для тел запросов — еще и обязательные поля с binding:"required".
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+	doc := loadSpec(t)
+
+	requests := map[string]any{
+		"RegisterRequest":       database.RegisterRequest{},
+		"CreateThemeRequest":    database.CreateThemeRequest{},
+		"CreateSubThemeRequest": database.CreateSubThemeRequest{},
+		"CreateTopicRequest":    database.CreateTopicRequest{},
+		"CreatePostRequest":     database.CreatePostRequest{},
+		"UpdateThemeRequest":    database.UpdateThemeRequest{},
+		"UpdateSubThemeRequest": database.UpdateSubThemeRequest{},
+		"UpdateTopicRequest":    database.UpdateTopicRequest{},
+		"UpdatePostRequest":     database.UpdatePostRequest{},
+		"UpdateProfileRequest":  database.UpdateProfileRequest{},
+		"TypingRequest":         realtime.TypingRequest{},
+		"TwoFactorCodeRequest":  database.TwoFactorCodeRequest{},
+		"TwoFactorLoginRequest": database.TwoFactorLoginRequest{},
+		"OIDCCallbackRequest":   database.OIDCCallbackRequest{},
+		"ChangePasswordRequest": database.ChangePasswordRequest{},
+		"DeleteAccountRequest":  database.DeleteAccountRequest{},
+		"ChangeUsernameRequest": database.ChangeUsernameRequest{},
+	}
+	models := map[string]any{
+		"Theme":                database.Themes_Collection{},
+		"SubTheme":             database.Sub_Themes{},
+		"Topic":                database.Topic{},
+		"Post":                 database.Post{},
+		"Attachment":           database.Attachment{},
+		"AuthorSummary":        database.AuthorSummary{},
+		"PublicProfile":        database.PublicProfile{},
+		"Event":                realtime.Event{},
+		"ImportReport":         database.ImportReport{},
+		"ArchiveCounts":        database.ArchiveCounts{},
+		"PersonalData":         database.PersonalData{},
+		"PersonalProfile":      database.PersonalProfile{},
+		"Session":              database.Session{},
+		"TwoFactorStatus":      database.TwoFactorStatus{},
+		"TwoFactorSetup":       database.TwoFactorSetup{},
+		"TwoFactorPolicy":      database.TwoFactorPolicy{},
+		"Identity":             database.Identity{},
+		"OIDCProviderInfo":     database.OIDCProviderInfo{},
+		"OIDCStart":            database.OIDCStart{},
+		"UsernameAvailability": database.UsernameAvailability{},
+		"UsernameChange":       database.UsernameChange{},
+		"Mention":              database.Mention{},
+	}
+
+	check := func(name string, v any, checkRequired bool) {
+		ref, ok := doc.Components.Schemas[name]
+		if !ok {
+			t.Errorf("в openapi.json нет схемы %s", name)
+			return
+		}
+		fields, required := jsonFields(reflect.TypeOf(v))
+
+		var props []string
+		for p := range ref.Value.Properties {
+			props = append(props, p)
+		}
+		sort.Strings(props)
+		if got, want := strings.Join(props, ","), strings.Join(fields, ","); got != want {
+			t.Errorf("схема %s: поля %s, у типа Go: %s", name, got, want)
+		}
+
+		if checkRequired {
+			specRequired := append([]string(nil), ref.Value.Required...)
+			sort.Strings(specRequired)
+			if got, want := strings.Join(specRequired, ","), strings.Join(required, ","); got != want {
+				t.Errorf("схема %s: обязательные поля %s, у типа Go: %s", name, got, want)
+			}
+		}
+	}
+	for name, v := range requests {
+		check(name, v, true)
+	}
+	for name, v := range models {
+		check(name, v, false)
+	}
+}
+
+// jsonFields возвращает отсортированные имена полей в JSON (со встроенными
+// структурами) и поля с binding:"required".
+func jsonFields(typ reflect.Type) (fields, required []string) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.Anonymous {
+			embedded, embeddedRequired := jsonFields(f.Type)
+			fields = append(fields, embedded...)
+			required = append(required, embeddedRequired...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, name)
+		if strings.Contains(","+f.Tag.Get("binding")+",", ",required,") {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(fields)
+	sort.Strings(required)
+	return fields, required
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,183 @@
+package Server
+
+import (
+	database "REVFORUM/database"
+	dbtest "REVFORUM/dbtest"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+const firefoxUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0"
+
+// login входит через API и делает полученный токен текущим.
+func (e *testEnv) login(user database.User, userAgent string) string {
+	e.t.Helper()
+	e.token = ""
+	var resp struct {
+		Token string `json:"token"`
+	}
+	expectStatus(e.t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": user.Username, "password": dbtest.Password},
+		"User-Agent", userAgent), http.StatusOK, &resp)
+	if resp.Token == "" {
+		e.t.Fatal("вход не вернул токен")
+	}
+	e.token = resp.Token
+	return resp.Token
+}
+
+// sessions возвращает сессии текущего пользователя.
+func (e *testEnv) sessions() []database.Session {
+	e.t.Helper()
+	var sessions []database.Session
+	expectStatus(e.t, e.do("GET", "/api/v1/me/sessions", nil), http.StatusOK, &sessions)
+	return sessions
+}
+
+func TestLoginOpensSession(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.f.User()
+	token := e.login(user, firefoxUA)
+
+	sessions := e.sessions()
+	if len(sessions) != 1 {
+		t.Fatalf("сессии: %+v", sessions)
+	}
+	s := sessions[0]
+	if !s.Current || s.Device != "Firefox, Windows" || s.UserAgent != firefoxUA || s.IP == "" || s.LastSeenAt.IsZero() || !s.ExpiresAt.After(s.CreatedAt) {
+		t.Fatalf("сессия записана неверно: %+v", s)
+	}
+	if e.count(&database.Session{}, "token_hash = ?", token) != 0 {
+		t.Fatal("токен хранится в базе открытым")
+	}
+
+	// Запись от имени вошедшего пользователя
+	var created struct {
+		Topic database.Topic `json:"topic"`
+	}
+	expectStatus(t, e.do("POST", fmt.Sprintf("/api/v1/subthemes/%d/topics", e.f.SubTheme(e.f.Theme()).ID), map[string]string{"title": "Мой топик", "content": "Текст"}),
+		http.StatusCreated, &created)
+	if created.Topic.AuthorID != user.ID {
+		t.Fatalf("автор топика %d, ожидался %d", created.Topic.AuthorID, user.ID)
+	}
+}
+
+func TestLoginLongUserAgent(t *testing.T) {
+	e := newTestEnv(t)
+	// 500-й байт приходится на середину двухбайтового символа
+	ua := "Agent/" + strings.Repeat("я", 300)
+	e.login(e.f.User(), ua)
+	s := e.sessions()[0]
+	if !utf8.ValidString(s.UserAgent) || len(s.UserAgent) > 500 || !strings.HasPrefix(ua, s.UserAgent) {
+		t.Fatalf("User-Agent сессии: %d байт, %q", len(s.UserAgent), s.UserAgent)
+	}
+}
+
+func TestSessionRequired(t *testing.T) {
+	e := newTestEnv(t)
+	subTheme := e.f.SubTheme(e.f.Theme())
+
+	// Анонимно читать можно, писать — нет
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/subthemes/%d/topics", subTheme.ID), nil), http.StatusOK, nil)
+	expectError(t, e.do("POST", fmt.Sprintf("/api/v1/subthemes/%d/topics", subTheme.ID), map[string]string{"title": "x"}),
+		http.StatusUnauthorized, "UNAUTHENTICATED")
+	expectError(t, e.do("GET", "/api/v1/me/sessions", nil), http.StatusUnauthorized, "UNAUTHENTICATED")
+
+	// Неизвестный токен отклоняется даже на открытых маршрутах
+	expectError(t, e.do("GET", "/api/v1/themes", nil, "Authorization", "Bearer unknown"), http.StatusUnauthorized, "UNAUTHENTICATED")
+}
+
+func TestRevokeSession(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.f.User()
+	phone := e.login(user, "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 Version/17.5 Mobile/15E148 Safari/604.1")
+	e.login(user, firefoxUA)
+
+	sessions := e.sessions()
+	if len(sessions) != 2 || !sessions[0].Current || sessions[1].Current || sessions[1].Device != "Safari, iOS" {
+		t.Fatalf("сессии: %+v", sessions)
+	}
+
+	// Чужую сессию не завершить: она неотличима от несуществующей
+	other := e.signIn(e.f.User())
+	expectError(t, e.do("DELETE", fmt.Sprintf("/api/v1/me/sessions/%d", sessions[1].ID), nil), http.StatusNotFound, "SESSION_NOT_FOUND")
+
+	e.login(user, firefoxUA)
+	expectStatus(t, e.do("DELETE", fmt.Sprintf("/api/v1/me/sessions/%d", sessions[1].ID), nil), http.StatusNoContent, nil)
+	expectError(t, e.do("GET", "/api/v1/me/sessions", nil, "Authorization", "Bearer "+phone), http.StatusUnauthorized, "UNAUTHENTICATED")
+	if n := len(e.sessions()); n != 2 {
+		t.Fatalf("осталось сессий %d, ожидалось 2", n)
+	}
+	if e.count(&database.Session{}, "user_id = ?", other.ID) != 1 {
+		t.Fatal("затронута сессия другого пользователя")
+	}
+	expectError(t, e.do("DELETE", "/api/v1/me/sessions/abc", nil), http.StatusBadRequest, "INVALID_PARAMETER")
+}
+
+func TestLogoutEverywhere(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.f.User()
+	first := e.login(user, firefoxUA)
+	e.login(user, firefoxUA)
+	third := e.login(user, firefoxUA)
+
+	// Кроме текущей
+	var resp struct {
+		Revoked int `json:"revoked"`
+	}
+	expectStatus(t, e.do("DELETE", "/api/v1/me/sessions?keep_current=true", nil), http.StatusOK, &resp)
+	if resp.Revoked != 2 || len(e.sessions()) != 1 {
+		t.Fatalf("завершено %d сессий", resp.Revoked)
+	}
+	expectError(t, e.do("GET", "/api/v1/themes", nil, "Authorization", "Bearer "+first), http.StatusUnauthorized, "UNAUTHENTICATED")
+
+	// Везде, включая текущую
+	e.login(user, firefoxUA)
+	expectStatus(t, e.do("DELETE", "/api/v1/me/sessions", nil), http.StatusOK, &resp)
+	if resp.Revoked != 2 || e.count(&database.Session{}, "user_id = ?", user.ID) != 0 {
+		t.Fatalf("завершено %d сессий", resp.Revoked)
+	}
+	expectError(t, e.do("GET", "/api/v1/me/sessions", nil, "Authorization", "Bearer "+third), http.StatusUnauthorized, "UNAUTHENTICATED")
+
+	// Выход из одной сессии
+	e.login(user, firefoxUA)
+	expectStatus(t, e.do("POST", "/api/v1/auth/logout", nil), http.StatusNoContent, nil)
+	expectError(t, e.do("GET", "/api/v1/me/sessions", nil), http.StatusUnauthorized, "UNAUTHENTICATED")
+}
+
+func TestPasswordResetAndBanRevokeSessions(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	user := e.f.User()
+	e.login(user, firefoxUA)
+
+	if err := database.ResetPassword(ctx, e.db, user.ID, database.ResetPasswordRequest{Password: "new-password"}, database.PasswordPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, e.do("GET", "/api/v1/me/sessions", nil), http.StatusUnauthorized, "UNAUTHENTICATED")
+
+	e.token = ""
+	expectStatus(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": user.Username, "password": "new-password"}), http.StatusOK, nil)
+	if _, err := database.BanUser(ctx, e.db, user.ID, "спам"); err != nil {
+		t.Fatal(err)
+	}
+	if e.count(&database.Session{}, "user_id = ?", user.ID) != 0 {
+		t.Fatal("сессии заблокированного пользователя остались")
+	}
+}
+
+func TestCleanupExpiredSessions(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.signIn(e.f.User())
+	e.db.Model(&database.Session{}).Where("user_id = ?", user.ID).Update("expires_at", time.Now().Add(-time.Second))
+	expectError(t, e.do("GET", "/api/v1/me/sessions", nil), http.StatusUnauthorized, "UNAUTHENTICATED")
+
+	database.CleanupExpiredSessions(context.Background(), e.db)
+	if e.count(&database.Session{}, "1 = 1") != 0 {
+		t.Fatal("истекшая сессия не удалена")
+	}
+}
@@ -0,0 +1,277 @@
+package Server
+
+import (
+	database "REVFORUM/database"
+	dbtest "REVFORUM/dbtest"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// totp возвращает код для секрета со сдвигом offset от текущего времени.
+// Код каждого шага принимается один раз, поэтому следующие входы берут
+// код следующего шага (сервер принимает ±1 шаг).
+func totp(t *testing.T, secret string, offset time.Duration) string {
+	t.Helper()
+	code, err := database.TOTPCode(secret, time.Now().Add(offset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// enableTwoFactor включает 2FA текущему пользователю и возвращает секрет и резервные коды.
+func (e *testEnv) enableTwoFactor() (string, []string) {
+	e.t.Helper()
+	var setup database.TwoFactorSetup
+	expectStatus(e.t, e.do("POST", "/api/v1/me/2fa/setup", nil), http.StatusOK, &setup)
+	var enabled struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	expectStatus(e.t, e.do("POST", "/api/v1/me/2fa/enable", map[string]string{"code": totp(e.t, setup.Secret, 0)}), http.StatusOK, &enabled)
+	return setup.Secret, enabled.RecoveryCodes
+}
+
+// loginResponse — ответ POST /api/v1/auth/login и /auth/login/2fa.
+type loginResponse struct {
+	Token                  string `json:"token"`
+	TwoFactorRequired      bool   `json:"two_factor_required"`
+	TwoFactorSetupRequired bool   `json:"two_factor_setup_required"`
+	ChallengeToken         string `json:"challenge_token"`
+}
+
+// startLogin выполняет первый шаг входа.
+func (e *testEnv) startLogin(user database.User) loginResponse {
+	e.t.Helper()
+	var resp loginResponse
+	expectStatus(e.t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": user.Username, "password": dbtest.Password}),
+		http.StatusOK, &resp)
+	return resp
+}
+
+func TestTwoFactorEnrollAndLogin(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.signIn(e.f.User())
+
+	var status database.TwoFactorStatus
+	expectStatus(t, e.do("GET", "/api/v1/me/2fa", nil), http.StatusOK, &status)
+	if status.Enabled {
+		t.Fatalf("2FA включена сразу: %+v", status)
+	}
+
+	// Подключение: секрет, затем проверка первого кода
+	expectError(t, e.do("POST", "/api/v1/me/2fa/enable", map[string]string{"code": "123456"}), http.StatusConflict, "TWO_FACTOR_NOT_ENABLED")
+	var setup database.TwoFactorSetup
+	expectStatus(t, e.do("POST", "/api/v1/me/2fa/setup", nil), http.StatusOK, &setup)
+	if setup.Secret == "" || !strings.HasPrefix(setup.OTPAuthURI, "otpauth://totp/RevForum:"+user.Username+"?") || !strings.Contains(setup.OTPAuthURI, "secret="+setup.Secret) {
+		t.Fatalf("секрет выдан неверно: %+v", setup)
+	}
+	expectError(t, e.do("POST", "/api/v1/me/2fa/enable", map[string]string{"code": "000000"}), http.StatusBadRequest, "INVALID_TWO_FACTOR_CODE")
+	var enabled struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	expectStatus(t, e.do("POST", "/api/v1/me/2fa/enable", map[string]string{"code": totp(t, setup.Secret, 0)}), http.StatusOK, &enabled)
+	if len(enabled.RecoveryCodes) != 10 {
+		t.Fatalf("резервные коды: %v", enabled.RecoveryCodes)
+	}
+	if e.count(&database.RecoveryCode{}, "code_hash = ?", strings.ReplaceAll(enabled.RecoveryCodes[0], "-", "")) != 0 {
+		t.Fatal("резервный код хранится открытым")
+	}
+	expectError(t, e.do("POST", "/api/v1/me/2fa/setup", nil), http.StatusConflict, "TWO_FACTOR_ALREADY_ENABLED")
+
+	// Вход в два шага: пароль дает только challenge_token
+	e.token = ""
+	first := e.startLogin(user)
+	if !first.TwoFactorRequired || first.ChallengeToken == "" || first.Token != "" {
+		t.Fatalf("первый шаг входа: %+v", first)
+	}
+	second := map[string]string{"challenge_token": first.ChallengeToken}
+
+	// Код, уже принятый при включении, повторно не действует
+	second["code"] = totp(t, setup.Secret, 0)
+	expectError(t, e.do("POST", "/api/v1/auth/login/2fa", second), http.StatusBadRequest, "INVALID_TWO_FACTOR_CODE")
+	second["code"] = totp(t, setup.Secret, 30*time.Second)
+	var done loginResponse
+	expectStatus(t, e.do("POST", "/api/v1/auth/login/2fa", second), http.StatusOK, &done)
+	if done.Token == "" {
+		t.Fatalf("второй шаг не выдал сессию: %+v", done)
+	}
+	// challenge_token одноразовый
+	expectError(t, e.do("POST", "/api/v1/auth/login/2fa", second), http.StatusUnauthorized, "TWO_FACTOR_CHALLENGE_EXPIRED")
+
+	// Резервный код тоже подходит, но один раз
+	recovery := map[string]string{"challenge_token": e.startLogin(user).ChallengeToken, "code": strings.ToUpper(enabled.RecoveryCodes[0])}
+	expectStatus(t, e.do("POST", "/api/v1/auth/login/2fa", recovery), http.StatusOK, &done)
+	e.token = done.Token
+	expectStatus(t, e.do("GET", "/api/v1/me/2fa", nil), http.StatusOK, &status)
+	if !status.Enabled || status.EnabledAt == nil || status.RecoveryCodesLeft != 9 {
+		t.Fatalf("состояние 2FA: %+v", status)
+	}
+	recovery["challenge_token"] = e.startLogin(user).ChallengeToken
+	expectError(t, e.do("POST", "/api/v1/auth/login/2fa", recovery), http.StatusBadRequest, "INVALID_TWO_FACTOR_CODE")
+}
+
+func TestTwoFactorChallengeAttempts(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.signIn(e.f.User())
+	secret, _ := e.enableTwoFactor()
+	e.token = ""
+
+	second := map[string]string{"challenge_token": e.startLogin(user).ChallengeToken, "code": "000000"}
+	for i := 0; i < 5; i++ {
+		expectError(t, e.do("POST", "/api/v1/auth/login/2fa", second), http.StatusBadRequest, "INVALID_TWO_FACTOR_CODE")
+	}
+	// После пяти ошибок вызов сгорел: верный код уже не поможет
+	second["code"] = totp(t, secret, 30*time.Second)
+	expectError(t, e.do("POST", "/api/v1/auth/login/2fa", second), http.StatusUnauthorized, "TWO_FACTOR_CHALLENGE_EXPIRED")
+	expectError(t, e.do("POST", "/api/v1/auth/login/2fa", map[string]string{"code": "123456"}), http.StatusBadRequest, "VALIDATION_FAILED")
+
+	// Параллельные запросы израсходовали попытки, пока этот читал вызов:
+	// лимит проверяется по базе, а не по прочитанной записи
+	second["challenge_token"] = e.startLogin(user).ChallengeToken
+	e.db.Model(&database.LoginChallenge{}).Where("user_id = ?", user.ID).Update("attempts", 5)
+	const name = "test:stale_challenge"
+	e.db.Callback().Query().After("gorm:query").Register(name, func(tx *gorm.DB) {
+		if challenge, ok := tx.Statement.Dest.(*database.LoginChallenge); ok {
+			challenge.Attempts = 0
+		}
+	})
+	w := e.do("POST", "/api/v1/auth/login/2fa", second)
+	e.db.Callback().Query().Remove(name)
+	expectError(t, w, http.StatusUnauthorized, "TWO_FACTOR_CHALLENGE_EXPIRED")
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.signIn(e.f.User())
+	secret, _ := e.enableTwoFactor()
+	e.token = ""
+
+	// Ошибки копятся по всем вызовам: новый вход по паролю их не обнуляет
+	for i := 0; i < 2; i++ {
+		wrong := map[string]string{"challenge_token": e.startLogin(user).ChallengeToken, "code": "000000"}
+		for j := 0; j < 5; j++ {
+			expectError(t, e.do("POST", "/api/v1/auth/login/2fa", wrong), http.StatusBadRequest, "INVALID_TWO_FACTOR_CODE")
+		}
+	}
+	second := map[string]string{"challenge_token": e.startLogin(user).ChallengeToken, "code": totp(t, secret, 30*time.Second)}
+	body := expectError(t, e.do("POST", "/api/v1/auth/login/2fa", second), http.StatusTooManyRequests, "TWO_FACTOR_LOCKED")
+	retryAt, err := time.Parse(time.RFC3339, body.Params["retry_at"])
+	if err != nil || time.Until(retryAt) < 14*time.Minute || time.Until(retryAt) > 15*time.Minute {
+		t.Fatalf("срок блокировки: %+v", body)
+	}
+
+	// После срока верный код пускает и обнуляет счетчик
+	e.db.Model(&database.User{}).Where("id = ?", user.ID).Update("two_factor_locked_until", time.Now().Add(-time.Second))
+	expectStatus(t, e.do("POST", "/api/v1/auth/login/2fa", second), http.StatusOK, nil)
+	var stored database.User
+	e.db.First(&stored, user.ID)
+	if stored.TwoFactorFailures != 0 || stored.TwoFactorLockedUntil != nil {
+		t.Fatalf("счетчик не сброшен: %d, %v", stored.TwoFactorFailures, stored.TwoFactorLockedUntil)
+	}
+
+	// Каждая следующая серия ошибок закрывает ввод вдвое дольше
+	e.db.Model(&database.User{}).Where("id = ?", user.ID).Update("two_factor_failures", 19)
+	wrong := map[string]string{"challenge_token": e.startLogin(user).ChallengeToken, "code": "000000"}
+	expectError(t, e.do("POST", "/api/v1/auth/login/2fa", wrong), http.StatusBadRequest, "INVALID_TWO_FACTOR_CODE")
+	e.db.First(&stored, user.ID)
+	if stored.TwoFactorLockedUntil == nil || time.Until(*stored.TwoFactorLockedUntil) < 29*time.Minute {
+		t.Fatalf("вторая серия: %v", stored.TwoFactorLockedUntil)
+	}
+}
+
+func TestTwoFactorLoginBanned(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	user := e.signIn(e.f.User())
+	secret, _ := e.enableTwoFactor()
+	e.token = ""
+
+	// Блокировка посреди входа: начатый вход сгорает
+	second := map[string]string{"challenge_token": e.startLogin(user).ChallengeToken, "code": totp(t, secret, 30*time.Second)}
+	if _, err := database.BanUser(ctx, e.db, user.ID, "спам"); err != nil {
+		t.Fatal(err)
+	}
+	if e.count(&database.LoginChallenge{}, "user_id = ?", user.ID) != 0 {
+		t.Fatal("вызовы заблокированного пользователя остались")
+	}
+	expectError(t, e.do("POST", "/api/v1/auth/login/2fa", second), http.StatusUnauthorized, "TWO_FACTOR_CHALLENGE_EXPIRED")
+
+	// Блокировка, которая не прошла через BanUser, останавливает второй шаг
+	e.db.Model(&user).Updates(map[string]any{"banned_at": nil})
+	second["challenge_token"] = e.startLogin(user).ChallengeToken
+	e.db.Model(&user).Update("banned_at", time.Now())
+	expectError(t, e.do("POST", "/api/v1/auth/login/2fa", second), http.StatusForbidden, "USER_BANNED")
+	if e.count(&database.Session{}, "user_id = ?", user.ID) != 0 {
+		t.Fatal("заблокированный пользователь получил сессию")
+	}
+}
+
+func TestTwoFactorRecoveryCodesAndDisable(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.signIn(e.f.User())
+	secret, codes := e.enableTwoFactor()
+
+	// Новые коды заменяют прежние
+	var regenerated struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	expectStatus(t, e.do("POST", "/api/v1/me/2fa/recovery-codes", map[string]string{"code": totp(t, secret, 30*time.Second)}), http.StatusOK, &regenerated)
+	if len(regenerated.RecoveryCodes) != 10 || regenerated.RecoveryCodes[0] == codes[0] {
+		t.Fatalf("коды не заменены: %v", regenerated.RecoveryCodes)
+	}
+	expectError(t, e.do("DELETE", "/api/v1/me/2fa", map[string]string{"code": codes[0]}), http.StatusBadRequest, "INVALID_TWO_FACTOR_CODE")
+
+	// Выключение по резервному коду; дальше вход снова в один шаг
+	expectStatus(t, e.do("DELETE", "/api/v1/me/2fa", map[string]string{"code": regenerated.RecoveryCodes[0]}), http.StatusOK, nil)
+	expectError(t, e.do("DELETE", "/api/v1/me/2fa", map[string]string{"code": regenerated.RecoveryCodes[1]}), http.StatusConflict, "TWO_FACTOR_NOT_ENABLED")
+	if e.count(&database.RecoveryCode{}, "user_id = ?", user.ID) != 0 {
+		t.Fatal("резервные коды не удалены")
+	}
+	if resp := e.startLogin(user); resp.TwoFactorRequired || resp.Token == "" {
+		t.Fatalf("вход без 2FA: %+v", resp)
+	}
+}
+
+func TestTwoFactorPolicy(t *testing.T) {
+	e := newTestEnv(t)
+	admin := e.signIn(e.f.User(dbtest.Admin))
+	mod := e.f.User(dbtest.Moderator)
+	theme := e.f.Theme()
+
+	var policy database.TwoFactorPolicy
+	expectStatus(t, e.do("GET", "/api/v1/admin/2fa-policy", nil), http.StatusOK, &policy)
+	if len(policy.RequiredRoles) != 0 {
+		t.Fatalf("по умолчанию 2FA необязательна: %+v", policy)
+	}
+	expectError(t, e.do("PUT", "/api/v1/admin/2fa-policy", map[string]any{"required_roles": []string{"user"}}), http.StatusBadRequest, "INVALID_ROLE")
+	expectStatus(t, e.do("PUT", "/api/v1/admin/2fa-policy", map[string]any{"required_roles": []string{"moderator"}}), http.StatusOK, &policy)
+	if strings.Join(policy.RequiredRoles, ",") != "moderator" {
+		t.Fatalf("политика: %+v", policy)
+	}
+
+	// Модератор без 2FA входит, но прав модератора у него нет
+	e.token = ""
+	resp := e.startLogin(mod)
+	if resp.Token == "" || !resp.TwoFactorSetupRequired {
+		t.Fatalf("вход модератора без 2FA: %+v", resp)
+	}
+	e.token = resp.Token
+	rename := map[string]string{"title": "Новое название"}
+	expectError(t, e.do("PATCH", fmt.Sprintf("/api/v1/themes/%d", theme.ID), rename), http.StatusForbidden, "TWO_FACTOR_REQUIRED")
+	expectError(t, e.do("GET", "/api/v1/admin/2fa-policy", nil), http.StatusForbidden, "FORBIDDEN")
+
+	// С 2FA права возвращаются, а выключить ее нельзя
+	_, codes := e.enableTwoFactor()
+	expectStatus(t, e.do("PATCH", fmt.Sprintf("/api/v1/themes/%d", theme.ID), rename), http.StatusOK, nil)
+	expectError(t, e.do("DELETE", "/api/v1/me/2fa", map[string]string{"code": codes[0]}), http.StatusForbidden, "TWO_FACTOR_REQUIRED")
+
+	// Администратору 2FA пока не обязательна
+	e.signIn(admin)
+	expectStatus(t, e.do("PUT", "/api/v1/admin/2fa-policy", map[string]any{"required_roles": []string{}}), http.StatusOK, &policy)
+}
@@ -0,0 +1,257 @@
+package Server
+
+import (
+	database "REVFORUM/database"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type usernameChanged struct {
+	Username     string     `json:"username"`
+	NextChangeAt *time.Time `json:"next_change_at"`
+}
+
+// changeUsername меняет имя текущего пользователя.
+func (e *testEnv) changeUsername(name string) usernameChanged {
+	e.t.Helper()
+	var resp usernameChanged
+	expectStatus(e.t, e.do("PUT", "/api/v1/me/username", map[string]string{"username": name}), http.StatusOK, &resp)
+	return resp
+}
+
+func TestChangeUsername(t *testing.T) {
+	e := newTestEnv(t, func(cfg *Config) {
+		cfg.Usernames = database.UsernameChangeRules{Cooldown: 24 * time.Hour, Hold: time.Hour}
+	})
+	alice := e.signIn(e.f.User(func(u *database.User) { u.Username = "alice" }))
+
+	resp := e.changeUsername("Alicia")
+	if resp.Username != "Alicia" || resp.NextChangeAt == nil || time.Until(*resp.NextChangeAt) < 23*time.Hour {
+		t.Fatalf("ответ: %+v", resp)
+	}
+
+	// Смена только регистра не считается сменой: ни ожидания, ни записи в истории
+	if resp := e.changeUsername("AliciA"); resp.Username != "AliciA" || resp.NextChangeAt != nil {
+		t.Fatalf("смена регистра: %+v", resp)
+	}
+	if resp := e.changeUsername("Alicia"); resp.Username != "Alicia" {
+		t.Fatalf("смена регистра: %+v", resp)
+	}
+
+	// Не чаще раза в Cooldown
+	body := expectError(t, e.do("PUT", "/api/v1/me/username", map[string]string{"username": "alice2"}),
+		http.StatusTooManyRequests, "USERNAME_CHANGE_TOO_SOON")
+	if body.Params["available_at"] == "" {
+		t.Fatalf("нет времени следующей смены: %+v", body)
+	}
+
+	// Профиль находится и по новому, и по прежнему имени
+	for _, ref := range []string{"Alicia", "alicia", "alice", "ALICE"} {
+		var profile database.PublicProfile
+		expectStatus(t, e.do("GET", "/api/v1/users/"+ref, nil), http.StatusOK, &profile)
+		if profile.ID != alice.ID || profile.Username != "Alicia" {
+			t.Fatalf("профиль по имени %q: %+v", ref, profile)
+		}
+	}
+
+	// Прежнее имя удерживается: ни регистрации, ни смены на него
+	e.token = ""
+	if body := e.register("alice", "new@example.com"); body.Code != "USER_ALREADY_EXISTS" {
+		t.Fatalf("регистрация с удерживаемым именем: %+v", body)
+	}
+	var availability database.UsernameAvailability
+	expectStatus(t, e.do("GET", "/api/v1/auth/username-available?username=alice", nil), http.StatusOK, &availability)
+	if availability.Available {
+		t.Fatal("удерживаемое имя доступно")
+	}
+	e.signIn(e.f.User())
+	expectError(t, e.do("PUT", "/api/v1/me/username", map[string]string{"username": "Alice"}), http.StatusConflict, "USER_ALREADY_EXISTS")
+	expectError(t, e.do("PUT", "/api/v1/me/username", map[string]string{"username": "ALICIA"}), http.StatusConflict, "USER_ALREADY_EXISTS")
+	expectError(t, e.do("PUT", "/api/v1/me/username", map[string]string{"username": "system"}), http.StatusConflict, "USERNAME_RESERVED")
+	expectField(t, expectError(t, e.do("PUT", "/api/v1/me/username", map[string]string{"username": "a b"}), http.StatusBadRequest, "VALIDATION_FAILED"), "username")
+
+	// История попадает в выгрузку данных
+	e.signIn(alice)
+	var data database.PersonalData
+	expectStatus(t, e.do("GET", "/api/v1/me/export", nil), http.StatusOK, &data)
+	if len(data.UsernameChanges) != 1 || data.UsernameChanges[0].OldUsername != "alice" || data.UsernameChanges[0].NewUsername != "Alicia" {
+		t.Fatalf("история имен: %+v", data.UsernameChanges)
+	}
+}
+
+func TestUsernameHoldAndReclaim(t *testing.T) {
+	e := newTestEnv(t, func(cfg *Config) {
+		cfg.Usernames = database.UsernameChangeRules{Hold: time.Hour}
+	})
+	alice := e.signIn(e.f.User(func(u *database.User) { u.Username = "alice" }))
+
+	// Смена только регистра и возврат своего прежнего имени
+	if resp := e.changeUsername("Alice"); resp.Username != "Alice" {
+		t.Fatalf("ответ: %+v", resp)
+	}
+	e.changeUsername("wonderland")
+	e.changeUsername("alice")
+	if resp := e.changeUsername("alice"); resp.NextChangeAt != nil {
+		t.Fatalf("то же имя записано как смена: %+v", resp)
+	}
+	if n := e.count(&database.UsernameChange{}, "user_id = ?", alice.ID); n != 2 {
+		t.Fatalf("записей в истории: %d, ожидалось 2", n)
+	}
+
+	// После удержания имя может занять другой, и поиск находит уже его
+	e.changeUsername("alice_old")
+	e.db.Model(&database.UsernameChange{}).Where("user_id = ?", alice.ID).Update("held_until", time.Now().Add(-time.Minute))
+	bob := e.signIn(e.f.User())
+	e.changeUsername("alice")
+
+	var profile database.PublicProfile
+	expectStatus(t, e.do("GET", "/api/v1/users/alice", nil), http.StatusOK, &profile)
+	if profile.ID != bob.ID {
+		t.Fatalf("имя alice ведет к пользователю %d, ожидался %d", profile.ID, bob.ID)
+	}
+	expectStatus(t, e.do("GET", "/api/v1/users/wonderland", nil), http.StatusOK, &profile)
+	if profile.ID != alice.ID {
+		t.Fatalf("прежнее имя wonderland ведет к пользователю %d, ожидался %d", profile.ID, alice.ID)
+	}
+}
+
+func TestUsernameHoldSurvivesAccountDeletion(t *testing.T) {
+	e := newTestEnv(t, func(cfg *Config) {
+		cfg.Usernames = database.UsernameChangeRules{Hold: time.Hour}
+	})
+	carol := e.f.User(func(u *database.User) { u.Username = "carol" })
+	alice := e.signIn(e.f.User(func(u *database.User) { u.Username = "alice" }))
+	e.changeUsername("alicia")
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), carol)
+	e.f.Post(topic, carol, func(p *database.Post) { p.Content = "спасибо, @alice" })
+
+	if err := database.DeleteAccount(context.Background(), e.db, e.store, e.files, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	var placeholder database.User
+	if err := e.db.Where("placeholder").First(&placeholder).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n := e.count(&database.UsernameChange{}, "user_id = ?", placeholder.ID); n != 1 {
+		t.Fatalf("записей истории у заглушки: %d, ожидалась 1", n)
+	}
+
+	// Удержанное имя по-прежнему никому не выдается
+	e.signIn(e.f.User())
+	expectError(t, e.do("PUT", "/api/v1/me/username", map[string]string{"username": "alice"}), http.StatusConflict, "USER_ALREADY_EXISTS")
+
+	// Старое упоминание ведет к заглушке, а не к новому владельцу имени
+	e.db.Model(&database.UsernameChange{}).Where("user_id = ?", placeholder.ID).Update("held_until", time.Now().Add(-time.Minute))
+	e.changeUsername("alice")
+	var posts []database.Post
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/topics/%d/posts?include=mentions", topic.ID), nil), http.StatusOK, &posts)
+	if len(posts) != 1 || len(posts[0].Mentions) != 1 || posts[0].Mentions[0].UserID != placeholder.ID {
+		t.Fatalf("упоминания: %+v", posts)
+	}
+}
+
+func TestMentionsResolveOldNames(t *testing.T) {
+	e := newTestEnv(t)
+	carol := e.f.User(func(u *database.User) { u.Username = "Carol" })
+	alice := e.signIn(e.f.User(func(u *database.User) { u.Username = "alice" }))
+	e.changeUsername("alicia")
+
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), carol)
+	e.f.Post(topic, carol, func(p *database.Post) {
+		p.Content = "@carol привет! Спасибо, @alice. Пишите на me@example.com, @nobody_here и @ab"
+	})
+	e.f.Post(topic, carol, func(p *database.Post) { p.Content = "без упоминаний" })
+
+	var posts []database.Post
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/topics/%d/posts?include=mentions", topic.ID), nil), http.StatusOK, &posts)
+	want := []database.Mention{
+		{Name: "carol", UserID: carol.ID, Username: "Carol"},
+		{Name: "alice", UserID: alice.ID, Username: "alicia"},
+	}
+	if len(posts) != 2 || fmt.Sprint(posts[0].Mentions) != fmt.Sprint(want) || posts[1].Mentions != nil {
+		t.Fatalf("упоминания: %+v", posts)
+	}
+
+	// Без include упоминания не загружаются
+	var plain []database.Post
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/topics/%d/posts", topic.ID), nil), http.StatusOK, &plain)
+	if plain[0].Mentions != nil {
+		t.Fatalf("упоминания без include: %+v", plain[0].Mentions)
+	}
+}
+
+func TestUsernameChangeRaceKeepsCooldown(t *testing.T) {
+	e := newTestEnv(t, func(cfg *Config) {
+		cfg.Usernames = database.UsernameChangeRules{Cooldown: 24 * time.Hour}
+	})
+	me := e.signIn(e.f.User())
+	const n = 8
+	codes := make([]int, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = e.do("PUT", "/api/v1/me/username", map[string]string{"username": fmt.Sprintf("racer%d", i)}).Code
+		}()
+	}
+	wg.Wait()
+
+	changed := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			changed++
+		case http.StatusTooManyRequests:
+		default:
+			t.Fatalf("ответы: %v", codes)
+		}
+	}
+	if changed != 1 || e.count(&database.UsernameChange{}, "user_id = ?", me.ID) != 1 {
+		t.Fatalf("смен имени: %d, ответы: %v", changed, codes)
+	}
+}
+
+func TestMentionsKeepOwnerAfterNameReuse(t *testing.T) {
+	e := newTestEnv(t, func(cfg *Config) {
+		cfg.Usernames = database.UsernameChangeRules{Hold: time.Hour}
+	})
+	carol := e.f.User()
+	alice := e.signIn(e.f.User(func(u *database.User) { u.Username = "alice" }))
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), carol)
+	start := time.Now()
+	mention := func(at time.Duration) {
+		e.f.Post(topic, carol, func(p *database.Post) { p.Content = "@alice, привет"; p.CreatedAt = start.Add(at) })
+	}
+
+	// alice меняет имя; после удержания «alice» занимает bob
+	mention(time.Minute)
+	e.changeUsername("alicia")
+	e.db.Model(&database.UsernameChange{}).Where("user_id = ?", alice.ID).
+		Updates(map[string]any{"created_at": start.Add(2 * time.Minute), "held_until": start.Add(-time.Minute)})
+	mention(3 * time.Minute)
+	bob := e.signIn(e.f.User())
+	e.changeUsername("alice")
+	e.db.Model(&database.UsernameChange{}).Where("user_id = ?", bob.ID).Update("created_at", start.Add(4*time.Minute))
+	mention(5 * time.Minute)
+
+	// Упоминания, написанные до того, как имя занял bob, по-прежнему ведут к alice
+	var posts []database.Post
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/topics/%d/posts?include=mentions", topic.ID), nil), http.StatusOK, &posts)
+	want := []uint{alice.ID, alice.ID, bob.ID}
+	if len(posts) != len(want) {
+		t.Fatalf("постов: %d", len(posts))
+	}
+	for i, p := range posts {
+		if len(p.Mentions) != 1 || p.Mentions[0].UserID != want[i] {
+			t.Fatalf("пост %d: упоминания %+v, ожидался пользователь %d", i, p.Mentions, want[i])
+		}
+	}
+	if posts[0].Mentions[0].Username != "alicia" || posts[2].Mentions[0].Username != "alice" {
+		t.Fatalf("текущие имена: %+v, %+v", posts[0].Mentions, posts[2].Mentions)
+	}
+}
@@ -0,0 +1,216 @@
+package Server
+
+import (
+	database "REVFORUM/database"
+	dbtest "REVFORUM/dbtest"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func (e *testEnv) register(username, email string) apiError {
+	e.t.Helper()
+	w := e.do("POST", "/api/v1/auth/register", map[string]string{"username": username, "email": email, "password": "Tall-Oak-Rivers7"})
+	var body apiError
+	if w.Code != http.StatusCreated {
+		expectStatus(e.t, w, w.Code, &body)
+	}
+	return body
+}
+
+func TestUsernameCaseInsensitive(t *testing.T) {
+	e := newTestEnv(t)
+	if body := e.register("Alice", "Alice@Example.COM"); body.Code != "" {
+		t.Fatalf("регистрация не прошла: %+v", body)
+	}
+	var stored database.User
+	if err := e.db.Where("username = ?", "Alice").First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Email != "alice@example.com" || stored.UsernameKey == nil || *stored.UsernameKey != "allce" {
+		t.Fatalf("имя и email не приведены: %q, %v", stored.Email, stored.UsernameKey)
+	}
+
+	for _, name := range []string{"alice", "ALICE", "Аlicе" /* кириллические «А» и «е» */, "ａｌｉｃｅ" /* полноширинные */} {
+		if body := e.register(name, "other@example.com"); body.Code != "USER_ALREADY_EXISTS" {
+			t.Errorf("имя %q: %+v", name, body)
+		}
+	}
+	if body := e.register("alice2", "ALICE@example.com"); body.Code != "USER_ALREADY_EXISTS" {
+		t.Errorf("email в другом регистре: %+v", body)
+	}
+
+	// Вход и поиск по имени тоже без учета регистра
+	expectStatus(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": "ALICE", "password": "Tall-Oak-Rivers7"}), http.StatusOK, nil)
+	if user, err := database.LookupUser(t.Context(), e.db, "aLiCe"); err != nil || user.ID != stored.ID {
+		t.Fatalf("LookupUser: %+v, %v", user, err)
+	}
+}
+
+func TestUsernameRules(t *testing.T) {
+	e := newTestEnv(t)
+	for _, name := range []string{"admin", "Moderator", "ѕуѕtеm" /* кириллица */, "r00t", "adm1n", "admIn", "admln"} {
+		if body := e.register(name, "x@example.com"); body.Code != "USERNAME_RESERVED" {
+			t.Errorf("имя %q: %+v", name, body)
+		}
+	}
+	expectField(t, e.register("ab", "x@example.com"), "username")
+
+	// i, I, l и 1 неразличимы: «Wlll» совпадает с «WIll»
+	if body := e.register("WIll", "will@example.com"); body.Code != "" {
+		t.Fatalf("регистрация не прошла: %+v", body)
+	}
+	for _, name := range []string{"Wlll", "wi1l", "will"} {
+		if body := e.register(name, "other@example.com"); body.Code != "USER_ALREADY_EXISTS" {
+			t.Errorf("имя %q: %+v", name, body)
+		}
+	}
+	body := e.register("bad name", "x@example.com")
+	if len(body.Fields) != 1 || body.Fields[0].Rule != "username_chars" {
+		t.Fatalf("имя с пробелом: %+v", body)
+	}
+	// Одни цифры: такая ссылка на пользователя читается как ID
+	for _, name := range []string{"12345", "１２３４５"} {
+		if body := e.register(name, "x@example.com"); len(body.Fields) != 1 || body.Fields[0].Rule != "username_digits" {
+			t.Errorf("имя %q: %+v", name, body)
+		}
+	}
+
+	// Полноширинные символы хранятся обычными
+	if body := e.register("ｂｏｂ", "bob@example.com"); body.Code != "" {
+		t.Fatalf("регистрация не прошла: %+v", body)
+	}
+	if e.count(&database.User{}, "username = ?", "bob") != 1 {
+		t.Fatal("имя не приведено к NFKC")
+	}
+}
+
+func TestUsernameAvailability(t *testing.T) {
+	e := newTestEnv(t)
+	e.f.User(func(u *database.User) { u.Username = "Alice" })
+
+	check := func(name string) database.UsernameAvailability {
+		t.Helper()
+		var result database.UsernameAvailability
+		expectStatus(t, e.do("GET", "/api/v1/auth/username-available?username="+name, nil), http.StatusOK, &result)
+		return result
+	}
+	if r := check("carol"); !r.Available || r.Reason != "" || r.Username != "carol" {
+		t.Fatalf("свободное имя: %+v", r)
+	}
+	if r := check("ALICE"); r.Available || r.Reason != database.UsernameTakenReason {
+		t.Fatalf("занятое имя: %+v", r)
+	}
+	if r := check("deleted"); r.Available || r.Reason != database.UsernameTakenReason {
+		t.Fatalf("имя заглушки: %+v", r)
+	}
+	if r := check("Admin"); r.Available || r.Reason != database.UsernameReservedReason {
+		t.Fatalf("служебное имя: %+v", r)
+	}
+	expectField(t, expectError(t, e.do("GET", "/api/v1/auth/username-available?username=a", nil), http.StatusBadRequest, "VALIDATION_FAILED"), "username")
+}
+
+func TestRegisterRaceReturnsConflict(t *testing.T) {
+	e := newTestEnv(t)
+	const n = 8
+	codes := make([]int, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = e.do("POST", "/api/v1/auth/register", map[string]string{"username": "racer", "email": "racer@example.com", "password": "Tall-Oak-Rivers7"}).Code
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Fatalf("ответы: %v", codes)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("создано %d пользователей, ответы: %v", created, codes)
+	}
+}
+
+func TestMigrateBackfillsUsernameKeys(t *testing.T) {
+	e := newTestEnv(t)
+	first := e.f.User(func(u *database.User) { u.Username = "Dave" })
+	second := e.f.User(func(u *database.User) { u.Username = "dave_2" })
+	clash := e.f.User(func(u *database.User) { u.Username = "DAVE_2x" })
+	// Пользователи, созданные до появления ключа; третий после приведения совпадет со вторым
+	e.db.Exec("UPDATE users SET username_key = NULL, email = UPPER(email) WHERE id IN (?, ?)", first.ID, second.ID)
+	e.db.Exec("UPDATE users SET username_key = NULL, username = ?, email = ? WHERE id = ?", "DAVE_2", "Dave_2@example.com", clash.ID)
+	e.db.Exec("UPDATE users SET email = ? WHERE id = ?", "dave_2@example.com", second.ID)
+
+	if err := database.Migrate(e.db); err != nil {
+		t.Fatal(err)
+	}
+	var users []database.User
+	e.db.Order("id").Find(&users)
+	if users[0].UsernameKey == nil || *users[0].UsernameKey != "dave" || users[0].Email != first.Email {
+		t.Fatalf("первый пользователь: %q %v", users[0].Email, users[0].UsernameKey)
+	}
+	if users[1].UsernameKey == nil || *users[1].UsernameKey != "dave_2" {
+		t.Fatalf("второй пользователь: %v", users[1].UsernameKey)
+	}
+	if users[2].UsernameKey != nil || users[2].Email != "Dave_2@example.com" {
+		t.Fatalf("совпавший пользователь изменен: %q %v", users[2].Email, users[2].UsernameKey)
+	}
+
+	// Точное имя важнее совпадения по ключу: пользователь без ключа по-прежнему входит
+	var login struct {
+		User struct {
+			ID uint `json:"id"`
+		} `json:"user"`
+	}
+	expectStatus(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": "DAVE_2", "password": dbtest.Password}), http.StatusOK, &login)
+	if login.User.ID != clash.ID {
+		t.Fatalf("вход под DAVE_2 открыл пользователя %d, ожидался %d", login.User.ID, clash.ID)
+	}
+}
+
+func TestMigrateRekeysUsernames(t *testing.T) {
+	e := newTestEnv(t)
+	will := e.f.User(func(u *database.User) { u.Username = "Will" })
+	blll := e.f.User(func(u *database.User) { u.Username = "Blll" })
+	bill := e.f.User()
+	// Ключи по прежним правилам, где «i» не сводилась к «l»
+	e.db.Exec("UPDATE users SET username_key = ? WHERE id = ?", "will", will.ID)
+	e.db.Exec("UPDATE users SET username = ?, username_key = ? WHERE id = ?", "Bill", "bill", bill.ID)
+	e.db.Create(&database.UsernameChange{UserID: will.ID, OldUsername: "Wilma", OldKey: "wilma", NewUsername: "Will",
+		CreatedAt: time.Now(), HeldUntil: time.Now().Add(time.Hour)})
+	e.db.Exec("DELETE FROM settings WHERE key = ?", "username_key_version") // База до смены правил
+
+	if err := database.Migrate(e.db); err != nil {
+		t.Fatal(err)
+	}
+	var users []database.User
+	e.db.Order("id").Find(&users)
+	if users[0].UsernameKey == nil || *users[0].UsernameKey != "wlll" || users[1].ID != blll.ID || *users[1].UsernameKey != "blll" {
+		t.Fatalf("ключи: %v, %v", users[0].UsernameKey, users[1].UsernameKey)
+	}
+	// Совпавший после пересчета пользователь остается без ключа
+	if users[2].ID != bill.ID || users[2].UsernameKey != nil {
+		t.Fatalf("совпавший пользователь: %v", users[2].UsernameKey)
+	}
+	if e.count(&database.UsernameChange{}, "old_key = ?", "wllma") != 1 {
+		t.Fatal("ключ в истории имен не пересчитан")
+	}
+
+	// Пересчет однократный: ключи, которые уже соответствуют версии, не трогаются
+	e.db.Exec("UPDATE username_changes SET old_key = ?", "wilma")
+	if err := database.Migrate(e.db); err != nil {
+		t.Fatal(err)
+	}
+	if e.count(&database.UsernameChange{}, "old_key = ?", "wilma") != 1 {
+		t.Fatal("история имен пересчитана повторно")
+	}
+}
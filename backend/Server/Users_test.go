@@ -0,0 +1,364 @@
+package Server
+
+import (
+	database "REVFORUM/database"
+	dbtest "REVFORUM/dbtest"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Регистрация и вход
+
+func TestRegister(t *testing.T) {
+	e := newTestEnv(t)
+
+	var created struct {
+		User struct {
+			ID       uint   `json:"id"`
+			Username string `json:"username"`
+		} `json:"user"`
+	}
+	w := e.do("POST", "/api/v1/auth/register", map[string]string{"username": "alice", "email": "alice@example.com", "password": "secret-word1"})
+	expectStatus(t, w, http.StatusCreated, &created)
+	if created.User.ID == 0 || created.User.Username != "alice" {
+		t.Fatalf("неожиданный пользователь: %+v", created.User)
+	}
+
+	var stored database.User
+	if err := e.db.First(&stored, created.User.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.PasswordHash), []byte("secret-word1")) != nil {
+		t.Fatal("пароль сохранен не как bcrypt-хэш")
+	}
+	if stored.Role != database.RoleUser {
+		t.Fatalf("роль = %q, ожидалась %q", stored.Role, database.RoleUser)
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	e := newTestEnv(t)
+	existing := e.f.User()
+
+	body := expectError(t, e.do("POST", "/api/v1/auth/register", map[string]string{"username": "bob", "email": "not-an-email", "password": "secret-word1"}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectField(t, body, "email")
+	body = expectError(t, e.do("POST", "/api/v1/auth/register", map[string]string{"username": "bob", "email": "bob@example.com", "password": "123"}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectField(t, body, "password")
+
+	expectError(t, e.do("POST", "/api/v1/auth/register", map[string]string{"username": existing.Username, "email": "new@example.com", "password": "secret-word1"}),
+		http.StatusConflict, "USER_ALREADY_EXISTS")
+	expectError(t, e.do("POST", "/api/v1/auth/register", map[string]string{"username": "new", "email": existing.Email, "password": "secret-word1"}),
+		http.StatusConflict, "USER_ALREADY_EXISTS")
+	expectError(t, e.do("POST", "/api/v1/auth/register", map[string]string{"username": database.DeletedUsername, "email": "d@example.com", "password": "secret-word1"}),
+		http.StatusConflict, "USER_ALREADY_EXISTS")
+}
+
+func TestLogin(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.f.User()
+
+	var resp struct {
+		User struct {
+			ID uint `json:"id"`
+		} `json:"user"`
+	}
+	expectStatus(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": user.Username, "password": dbtest.Password}),
+		http.StatusOK, &resp)
+	if resp.User.ID != user.ID {
+		t.Fatalf("вход выполнен за пользователя %d, ожидался %d", resp.User.ID, user.ID)
+	}
+
+	expectError(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": user.Username, "password": "wrong-password"}),
+		http.StatusUnauthorized, "INVALID_CREDENTIALS")
+	expectError(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": "nobody", "password": dbtest.Password}),
+		http.StatusUnauthorized, "INVALID_CREDENTIALS")
+	body := expectError(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": user.Username}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectField(t, body, "password")
+
+	banned := e.f.User(func(u *database.User) { now := time.Now(); u.BannedAt = &now })
+	expectError(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": banned.Username, "password": dbtest.Password}),
+		http.StatusForbidden, "USER_BANNED")
+	expectError(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": banned.Username, "password": "wrong-password"}),
+		http.StatusUnauthorized, "INVALID_CREDENTIALS")
+}
+
+// Пользователи и профиль
+
+func TestListUsers(t *testing.T) {
+	e := newTestEnv(t)
+	e.f.User()
+	e.f.User()
+
+	w := e.do("GET", "/api/v1/users", nil)
+	var users []database.User
+	expectStatus(t, w, http.StatusOK, &users)
+	if len(users) != 2 {
+		t.Fatalf("получено %d пользователей, ожидалось 2", len(users))
+	}
+	if strings.Contains(w.Body.String(), "PasswordHash") || strings.Contains(w.Body.String(), "$2a$") {
+		t.Fatal("хэш пароля попал в ответ")
+	}
+}
+
+func TestUserProfile(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.f.User(func(u *database.User) { u.Bio = "Люблю Go" })
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), user)
+	e.f.Post(topic, user)
+
+	var profile database.PublicProfile
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/users/%d", user.ID), nil), http.StatusOK, &profile)
+	if profile.Username != user.Username || profile.Bio != "Люблю Go" || profile.PostCount != 1 {
+		t.Fatalf("неожиданный профиль: %+v", profile)
+	}
+	if len(profile.RecentTopics) != 1 || profile.RecentTopics[0].ID != topic.ID {
+		t.Fatalf("неожиданные последние топики: %+v", profile.RecentTopics)
+	}
+
+	expectError(t, e.do("GET", "/api/v1/users/999", nil), http.StatusNotFound, "USER_NOT_FOUND")
+	expectError(t, e.do("GET", "/api/v1/users/nobody", nil), http.StatusNotFound, "USER_NOT_FOUND")
+}
+
+func TestUpdateProfile(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+
+	expectStatus(t, e.do("PATCH", "/api/v1/me/profile", map[string]string{"display_name": "  Алиса  ", "website": "https://example.com"}),
+		http.StatusOK, nil)
+	var stored database.User
+	if err := e.db.First(&stored, me.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.DisplayName != "Алиса" || stored.Website != "https://example.com" {
+		t.Fatalf("профиль не обновлен: %+v", stored)
+	}
+
+	expectError(t, e.do("PATCH", "/api/v1/me/profile", map[string]string{}), http.StatusBadRequest, "NO_FIELDS_TO_UPDATE")
+	body := expectError(t, e.do("PATCH", "/api/v1/me/profile", map[string]string{"website": "javascript:alert(1)"}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectField(t, body, "website")
+	body = expectError(t, e.do("PATCH", "/api/v1/me/profile", map[string]string{"display_name": strings.Repeat("я", 51)}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	expectField(t, body, "display_name")
+}
+
+func TestUpdateProfileWithoutSession(t *testing.T) {
+	e := newTestEnv(t)
+	e.f.User() // Пользователь есть, но запрос анонимный
+	expectError(t, e.do("PATCH", "/api/v1/me/profile", map[string]string{"bio": "x"}), http.StatusUnauthorized, "UNAUTHENTICATED")
+	expectError(t, e.do("DELETE", "/api/v1/me/avatar", nil), http.StatusUnauthorized, "UNAUTHENTICATED")
+}
+
+// Аватары
+
+// testPNG возвращает PNG-изображение w×h.
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAvatarLifecycle(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User())
+	path := fmt.Sprintf("/api/v1/users/%d/avatar", me.ID)
+
+	expectError(t, e.do("GET", path, nil), http.StatusNotFound, "AVATAR_NOT_FOUND")
+
+	var uploaded struct {
+		AvatarURL string `json:"avatar_url"`
+	}
+	expectStatus(t, e.upload("/api/v1/me/avatar", "avatar", "me.png", testPNG(t, 400, 300)), http.StatusOK, &uploaded)
+	if !strings.HasPrefix(uploaded.AvatarURL, path+"?v=") {
+		t.Fatalf("неожиданный avatar_url: %q", uploaded.AvatarURL)
+	}
+
+	w := e.do("GET", uploaded.AvatarURL, nil)
+	expectStatus(t, w, http.StatusOK, nil)
+	img, format, err := image.Decode(w.Body)
+	if err != nil {
+		t.Fatalf("аватар не декодируется: %v", err)
+	}
+	if format != "png" || img.Bounds().Dx() != 256 || img.Bounds().Dy() != 256 {
+		t.Fatalf("аватар %s %v, ожидался png 256×256", format, img.Bounds())
+	}
+	expectStatus(t, e.do("GET", path, nil, "If-None-Match", w.Header().Get("ETag")), http.StatusNotModified, nil)
+
+	expectStatus(t, e.do("DELETE", "/api/v1/me/avatar", nil), http.StatusNoContent, nil)
+	expectError(t, e.do("GET", path, nil), http.StatusNotFound, "AVATAR_NOT_FOUND")
+	expectStatus(t, e.do("DELETE", "/api/v1/me/avatar", nil), http.StatusNoContent, nil) // Повторное удаление не ошибка
+}
+
+func TestAvatarErrors(t *testing.T) {
+	e := newTestEnv(t)
+	e.signIn(e.f.User())
+
+	expectError(t, e.upload("/api/v1/me/avatar", "", "", nil), http.StatusBadRequest, "FILE_REQUIRED")
+	expectError(t, e.upload("/api/v1/me/avatar", "avatar", "notes.txt", []byte("просто текст")),
+		http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE")
+	broken := testPNG(t, 10, 10)[:40] // Сигнатура PNG есть, данные обрезаны
+	expectError(t, e.upload("/api/v1/me/avatar", "avatar", "broken.png", broken), http.StatusBadRequest, "INVALID_IMAGE")
+	expectError(t, e.upload("/api/v1/me/avatar", "avatar", "huge.png", make([]byte, 2<<20+1)),
+		http.StatusRequestEntityTooLarge, "FILE_TOO_LARGE")
+
+	expectError(t, e.do("GET", "/api/v1/users/999/avatar", nil), http.StatusNotFound, "USER_NOT_FOUND")
+	expectError(t, e.do("GET", "/api/v1/users/abc/avatar", nil), http.StatusBadRequest, "INVALID_PARAMETER")
+}
+
+func TestAvatarFileMissing(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.f.User(func(u *database.User) { u.AvatarKey = "avatars/gone.png" })
+
+	expectError(t, e.do("GET", fmt.Sprintf("/api/v1/users/%d/avatar", me.ID), nil), http.StatusNotFound, "FILE_NOT_FOUND")
+}
+
+// Личные данные и удаление учетной записи
+
+func TestPersonalDataExport(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User(func(u *database.User) { u.Bio = "о себе" }))
+	other := e.f.User()
+	topic := e.f.Topic(e.f.SubTheme(e.f.Theme()), me)
+	post := e.f.Post(topic, me)
+	e.f.Attachment(me, func(a *database.Attachment) { a.PostID = &post.ID })
+	e.f.Attachment(me) // Еще не прикреплено
+	e.f.Post(topic, other)
+
+	var data database.PersonalData
+	w := e.do("GET", "/api/v1/me/export", nil)
+	expectStatus(t, w, http.StatusOK, &data)
+	if !strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("ответ не отдается файлом: %q", w.Header().Get("Content-Disposition"))
+	}
+	if data.Profile.Email != me.Email || data.Profile.Bio != "о себе" {
+		t.Fatalf("профиль выгружен неверно: %+v", data.Profile)
+	}
+	if len(data.Topics) != 1 || len(data.Posts) != 1 || len(data.Posts[0].Attachments) != 1 || len(data.Attachments) != 2 {
+		t.Fatalf("выгружено топиков %d, сообщений %d, вложений %d", len(data.Topics), len(data.Posts), len(data.Attachments))
+	}
+	if strings.Contains(w.Body.String(), me.PasswordHash) {
+		t.Fatal("в выгрузку попал хэш пароля")
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	e := newTestEnv(t)
+	me := e.signIn(e.f.User(func(u *database.User) { u.AvatarKey = "avatars/me.png" }))
+	other := e.f.User()
+	if err := e.files.Put(context.Background(), me.AvatarKey, strings.NewReader("png"), 3, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	subTheme := e.f.SubTheme(e.f.Theme())
+	topic := e.f.Topic(subTheme, me)
+	e.f.Post(topic, me)
+	e.f.Post(topic, other)
+
+	// Удаление назначается и отменяется
+	var scheduled struct {
+		DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+	}
+	// Только с паролем: одной сессии мало
+	expectError(t, e.do("DELETE", "/api/v1/me", nil), http.StatusBadRequest, "INVALID_REQUEST_BODY")
+	expectError(t, e.do("DELETE", "/api/v1/me", map[string]string{"password": "wrong"}), http.StatusBadRequest, "INVALID_CURRENT_PASSWORD")
+	expectStatus(t, e.do("DELETE", "/api/v1/me", map[string]string{"password": dbtest.Password}), http.StatusAccepted, &scheduled)
+	if scheduled.DeletionScheduledAt == nil {
+		t.Fatal("срок удаления не назначен")
+	}
+	expectStatus(t, e.do("DELETE", "/api/v1/me/deletion", nil), http.StatusOK, nil)
+	database.PurgeDeletedAccounts(context.Background(), e.db, e.store, e.files)
+	if e.count(&database.User{}, "id = ?", me.ID) != 1 {
+		t.Fatal("отмененное удаление выполнено")
+	}
+
+	// Срок наступил (в тестах ACCOUNT_DELETION_GRACE = 0): материалы переходят заглушке
+	expectStatus(t, e.do("DELETE", "/api/v1/me", map[string]string{"password": dbtest.Password}), http.StatusAccepted, nil)
+	database.PurgeDeletedAccounts(context.Background(), e.db, e.store, e.files)
+	if e.count(&database.User{}, "id = ? OR email = ?", me.ID, me.Email) != 0 {
+		t.Fatal("учетная запись не удалена")
+	}
+	var placeholder database.User
+	if err := e.db.Where("username = ?", database.DeletedUsername).First(&placeholder).Error; err != nil || !placeholder.Placeholder {
+		t.Fatalf("заглушка: %+v (%v)", placeholder, err)
+	}
+	if e.count(&database.Topic{}, "author_id = ?", placeholder.ID) != 1 || e.count(&database.Post{}, "author_id = ?", placeholder.ID) != 1 {
+		t.Fatal("материалы не переданы заглушке")
+	}
+	if _, err := e.files.Get(context.Background(), me.AvatarKey); err == nil {
+		t.Fatal("файл аватара не удален")
+	}
+
+	// Сессии удаленного пользователя больше не действуют
+	if e.count(&database.Session{}, "user_id = ?", me.ID) != 0 {
+		t.Fatal("сессии удаленного пользователя остались")
+	}
+	expectError(t, e.do("GET", "/api/v1/me/sessions", nil), http.StatusUnauthorized, "UNAUTHENTICATED")
+	e.token = ""
+
+	// Обсуждение читается, автор — заглушка
+	var topics []database.Topic
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/subthemes/%d/topics?include=author", subTheme.ID), nil), http.StatusOK, &topics)
+	if len(topics) != 1 || topics[0].Author == nil || topics[0].Author.Username != database.DeletedUsername {
+		t.Fatalf("автор удаленного пользователя: %+v", topics)
+	}
+	expectStatus(t, e.do("GET", fmt.Sprintf("/api/v1/topics/%d/posts", topic.ID), nil), http.StatusOK, nil)
+
+	// Заглушка одна на всех удаленных, и войти под ней нельзя
+	if err := database.DeleteAccount(context.Background(), e.db, e.store, e.files, other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if e.count(&database.User{}, "username = ?", database.DeletedUsername) != 1 || e.count(&database.Post{}, "author_id = ?", placeholder.ID) != 2 {
+		t.Fatal("заглушка создана повторно или сообщения не переданы")
+	}
+	expectError(t, e.do("POST", "/api/v1/auth/login", map[string]string{"username": database.DeletedUsername, "password": "!"}),
+		http.StatusUnauthorized, "INVALID_CREDENTIALS")
+}
+
+func TestAccountDeletionPlaceholder(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	user := e.f.User()
+	post := e.f.Post(e.f.Topic(e.f.SubTheme(e.f.Theme()), user), user)
+
+	// Имя заглушки занято обычным пользователем: его сообщения не становятся
+	// сообщениями удаленных, а удаление ждет, пока имя освободят
+	squatter := e.f.User(func(u *database.User) { u.Username = database.DeletedUsername })
+	if err := database.DeleteAccount(ctx, e.db, e.store, e.files, user.ID); err == nil {
+		t.Fatal("материалы переданы обычному пользователю с именем заглушки")
+	}
+	if e.count(&database.User{}, "id = ?", user.ID) != 1 || e.count(&database.Post{}, "id = ? AND author_id = ?", post.ID, user.ID) != 1 {
+		t.Fatal("удаление выполнено частично")
+	}
+
+	// Заглушка прежних версий узнается при миграции
+	e.db.Delete(&squatter)
+	legacy := e.f.User(func(u *database.User) {
+		u.Username, u.Email, u.PasswordHash = database.DeletedUsername, database.DeletedUsername+"@revforum.invalid", "!"
+	})
+	if err := database.Migrate(e.db); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.DeleteAccount(ctx, e.db, e.store, e.files, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if e.count(&database.User{}, "placeholder") != 1 || e.count(&database.Post{}, "id = ? AND author_id = ?", post.ID, legacy.ID) != 1 {
+		t.Fatal("сообщения не переданы прежней заглушке")
+	}
+	if err := database.DeleteAccount(ctx, e.db, e.store, e.files, legacy.ID); err == nil {
+		t.Fatal("заглушка удалена")
+	}
+}
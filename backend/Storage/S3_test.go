@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 — минимальный S3-совместимый сервер в памяти: ровно те запросы,
+// которые делает клиент minio в S3 (HEAD/PUT бакета, PUT/HEAD/GET/DELETE объекта).
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]bool
+	objects map[string]fakeObject // Ключ — bucket/key
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, string) {
+	f := &fakeS3{buckets: map[string]bool{}, objects: map[string]fakeObject{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, strings.TrimPrefix(srv.URL, "http://")
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodHead:
+			if !f.buckets[bucket] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			f.buckets[bucket] = true
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+		return
+	}
+	if !f.buckets[bucket] {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := readPayload(r)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[bucket+"/"+key] = fakeObject{data: data, contentType: r.Header.Get("Content-Type")}
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodHead, http.MethodGet:
+		obj, ok := f.objects[bucket+"/"+key]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound) // Ответ на HEAD без тела: код ошибки клиент выводит сам
+				return
+			}
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, bucket+"/"+key) // Как в S3: удаление отсутствующего объекта — успех
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// readPayload читает тело PUT; по HTTP без TLS клиент шлет его
+// кусками aws-chunked с подписью каждого куска.
+func readPayload(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var data []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		chunk := make([]byte, size+2) // Кусок и \r\n после него
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func newTestS3(t *testing.T, endpoint string) *S3 {
+	t.Helper()
+	s, err := NewS3(S3Config{Endpoint: endpoint, AccessKey: "test", SecretKey: "test-secret", Bucket: "revforum", Region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestS3CreatesBucket(t *testing.T) {
+	fake, endpoint := newFakeS3(t)
+	newTestS3(t, endpoint)
+	if !fake.buckets["revforum"] {
+		t.Fatal("бакет не создан")
+	}
+
+	// Существующий бакет используется как есть
+	fake.objects["revforum/kept"] = fakeObject{data: []byte("x")}
+	newTestS3(t, endpoint)
+	if _, ok := fake.objects["revforum/kept"]; !ok {
+		t.Fatal("содержимое бакета потеряно")
+	}
+}
+
+func TestS3PutGetDelete(t *testing.T) {
+	fake, endpoint := newFakeS3(t)
+	s := newTestS3(t, endpoint)
+	ctx := context.Background()
+	const key = "attachments/2025/07/abc"
+	content := "содержимое файла"
+
+	if err := s.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if obj := fake.objects["revforum/"+key]; string(obj.data) != content || obj.contentType != "text/plain" {
+		t.Fatalf("сохранено: %q, %q", obj.data, obj.contentType)
+	}
+
+	r, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != content {
+		t.Fatalf("прочитано %q (%v)", data, err)
+	}
+
+	// Удаление; отсутствие объекта ошибкой не считается
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("повторное удаление: %v", err)
+	}
+	if _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("чтение удаленного объекта: %v, ожидалась ErrNotFound", err)
+	}
+}
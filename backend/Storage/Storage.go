@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+// ErrNotFound возвращается, если объекта с таким ключом нет.
+var ErrNotFound = errors.New("storage: object not found")
+
+// Store — хранилище файлов (вложений, миниатюр, аватаров).
+// Ключи — относительные пути вида attachments/2025/07/<hex>.
+type Store interface {
+	// Put сохраняет содержимое r размером size под ключом key.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get открывает объект на чтение. Вызывающий обязан закрыть reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete удаляет объект; отсутствие объекта ошибкой не считается.
+	Delete(ctx context.Context, key string) error
+}
+
+// Init создает хранилище по переменным окружения:
+//
+//	STORAGE_BACKEND — local (по умолчанию) или s3
+//	STORAGE_DIR     — каталог для local (по умолчанию ./uploads)
+//	S3_ENDPOINT, S3_ACCESS_KEY, S3_SECRET_KEY, S3_BUCKET, S3_REGION, S3_USE_SSL — для s3
+//	(подходит любой S3-совместимый сервер, например MinIO)
+func Init() Store {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "local":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "./uploads"
+		}
+		store, err := NewLocal(dir)
+		if err != nil {
+			log.Fatal("Failed to init local storage: ", err)
+		}
+		return store
+	case "s3":
+		store, err := NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    os.Getenv("S3_REGION"),
+			UseSSL:    os.Getenv("S3_USE_SSL") == "true",
+		})
+		if err != nil {
+			log.Fatal("Failed to init s3 storage: ", err)
+		}
+		return store
+	default:
+		log.Fatal("Unknown STORAGE_BACKEND: ", backend)
+		return nil
+	}
+}
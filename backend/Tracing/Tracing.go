@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"gorm.io/gorm"
+	gormtracing "gorm.io/plugin/opentelemetry/tracing"
+)
+
+// defaultServiceName — имя сервиса в трассах, если не задан OTEL_SERVICE_NAME.
+const defaultServiceName = "revforum"
+
+// Init настраивает глобальный TracerProvider по переменной TRACING_EXPORTER:
+//
+//	none (по умолчанию) — трассы не собираются
+//	stdout              — трассы печатаются в stdout, для локальной проверки
+//	otlp                — отправка по OTLP/HTTP; адрес берется из стандартной
+//	                      OTEL_EXPORTER_OTLP_ENDPOINT (по умолчанию localhost:4318)
+//
+// Возвращает функцию, которая досылает накопленные спаны при остановке.
+func Init() func(context.Context) error {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch kind := os.Getenv("TRACING_EXPORTER"); kind {
+	case "", "none":
+		return func(context.Context) error { return nil }
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background())
+	default:
+		log.Fatal("Unknown TRACING_EXPORTER: ", kind)
+	}
+	if err != nil {
+		log.Fatal("Failed to create trace exporter: ", err)
+	}
+
+	name := os.Getenv("OTEL_SERVICE_NAME")
+	if name == "" {
+		name = defaultServiceName
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(name))),
+	)
+	otel.SetTracerProvider(provider)
+	// W3C traceparent: входящий контекст трассы от прокси или фронтенда продолжается
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	return provider.Shutdown
+}
+
+// Middleware создает серверный спан на каждый запрос и кладет его в
+// c.Request.Context(). Обработчики передают этот контекст в GORM через
+// db.WithContext, и спаны SQL-запросов становятся дочерними.
+func Middleware() gin.HandlerFunc {
+	name := os.Getenv("OTEL_SERVICE_NAME")
+	if name == "" {
+		name = defaultServiceName
+	}
+	return otelgin.Middleware(name)
+}
+
+// GormPlugin создает спан на каждый SQL-запрос. Значения параметров в спаны
+// не попадают: среди них бывают хеши паролей и личные данные.
+func GormPlugin() gorm.Plugin {
+	return gormtracing.NewPlugin(gormtracing.WithoutQueryVariables(), gormtracing.WithoutMetrics())
+}
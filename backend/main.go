@@ -1,11 +1,21 @@
 package main
 
 import (
+	admin "REVFORUM/admin"
 	database "REVFORUM/database"
 	server "REVFORUM/server"
+	"context"
+	"os"
 )
 
 func main() {
-	database.Init()
-	server.Init_Server()
+	// revforum admin ... — консольные команды администратора (см. пакет admin)
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Setenv("LOG_OUTPUT", "stderr") // Журнал не должен смешиваться с выводом команд
+		db := database.Init()
+		os.Exit(admin.Main(context.Background(), admin.NewEnv(db), os.Args[2:]))
+	}
+
+	db := database.Init()
+	server.Init_Server(db)
 }
@@ -2,7 +2,7 @@
 import React, { useState, useEffect } from 'react';
 
 // Компонент для отображения конкретного топика и работы с сообщениями
-const TopicView = ({ topicId, topicTitle, topicContent, onBack }) => {
+const TopicView = ({ topicId, topicTitle, onBack }) => {
     // const [topicDetails, setTopicDetails] = useState(null); // Не используется, данные передаются пропсами
     const [posts, setPosts] = useState([]);
     const [loading, setLoading] = useState(true);
@@ -25,7 +25,7 @@ const TopicView = ({ topicId, topicTitle, topicContent, onBack }) => {
             setLoading(true);
             setError(null);
             // Замените URL на правильный путь к вашему API
-            const response = await fetch(`http://localhost:8080/api/themes/subthemes/topics/${topicId}/posts`);
+            const response = await fetch(`http://localhost:8080/api/themes/subthemes/topics/${topicId}/posts?include=author`);
             console.log(`Fetching posts for topic ID: ${topicId}`, response.status);
 
             if (!response.ok) {
@@ -53,6 +53,18 @@ const TopicView = ({ topicId, topicTitle, topicContent, onBack }) => {
         fetchPosts();
     }, [topicId]);
 
+    // Подписка на события топика (SSE): новые сообщения появляются без перезагрузки
+    useEffect(() => {
+        if (!topicId) return;
+        const source = new EventSource(`http://localhost:8080/api/events?topic=${topicId}`);
+        source.addEventListener('post.created', (e) => {
+            const post = JSON.parse(e.data).data;
+            // Свое сообщение может прийти и из события, и из fetchPosts — не дублируем
+            setPosts((prev) => (prev.some((p) => p.id === post.id) ? prev : [...prev, post]));
+        });
+        return () => source.close();
+    }, [topicId]);
+
     // Функция для обработки отправки формы нового сообщения
     const handleSendPost = async (e) => {
         e.preventDefault();
@@ -121,13 +133,6 @@ const TopicView = ({ topicId, topicTitle, topicContent, onBack }) => {
             <div className="topic-view-header">
                 {/* Заголовок топика (вопрос) */}
                 <h2>{topicTitle}</h2>
-
-                {/* Содержание топика (описание), переданное как пропс */}
-                {topicContent && (
-                    <div className="topic-description">
-                        {topicContent}
-                    </div>
-                )}
             </div>
 
             <div className="posts-section">
@@ -138,7 +143,9 @@ const TopicView = ({ topicId, topicTitle, topicContent, onBack }) => {
                         {posts.map((post, index) => (
                             <div key={post.id} className="post-item">
                                 <div className="post-header">
-                                    <span className="post-author">Пользователь #{post.author_id}</span>
+                                    <span className="post-author">
+                                        {post.author ? (post.author.display_name || post.author.username) : `Пользователь #${post.author_id}`}
+                                    </span>
                                     <span className="post-number">#{index + 1}</span>
                                     <span className="post-date">
                                         {new Date(post.created_at).toLocaleString()}
@@ -29,7 +29,7 @@ const TopicsList = ({ subThemeId, subThemeTitle, onBack }) => {
             setLoading(true);
             setError(null);
             // Замените URL на правильный путь к вашему API
-            const response = await fetch(`http://localhost:8080/api/themes/subthemes/${subThemeId}/topics`);
+            const response = await fetch(`http://localhost:8080/api/themes/subthemes/${subThemeId}/topics?include=author`);
             console.log(`Fetching topics for subtheme ID: ${subThemeId}`, response.status);
 
             if (!response.ok) {
@@ -61,6 +61,10 @@ const TopicsList = ({ subThemeId, subThemeTitle, onBack }) => {
             setCreateMessage({ type: 'error', text: 'Заголовок топика не может быть пустым.' });
             return;
         }
+        if (!newTopicContent.trim()) {
+            setCreateMessage({ type: 'error', text: 'Текст топика не может быть пустым.' });
+            return;
+        }
 
         setIsCreating(true);
         setCreateMessage({ type: '', text: '' }); // Сброс сообщений
@@ -170,13 +174,10 @@ const TopicsList = ({ subThemeId, subThemeTitle, onBack }) => {
                             >
                                 {topic.title}
                             </h3>
-                            {topic.content && (
-                                <p className="topic-content-preview">
-                                    {topic.content.substring(0, 100)}{topic.content.length > 100 ? '...' : ''}
-                                </p>
-                            )}
                             <div className="topic-meta">
-                                <span className="topic-author">Автор: User#{topic.author_id}</span>
+                                <span className="topic-author">
+                                    Автор: {topic.author ? (topic.author.display_name || topic.author.username) : `User#${topic.author_id}`}
+                                </span>
                                 <span className="topic-date">
                                     Создан: {new Date(topic.created_at).toLocaleString()}
                                 </span>
@@ -224,12 +225,13 @@ const TopicsList = ({ subThemeId, subThemeTitle, onBack }) => {
                                 />
                             </div>
                             <div className="form-group">
-                                <label htmlFor="modal-new-topic-content">Содержание (опционально):</label>
+                                <label htmlFor="modal-new-topic-content">Текст (станет первым сообщением):</label>
                                 <textarea
                                     id="modal-new-topic-content"
                                     value={newTopicContent}
                                     onChange={(e) => setNewTopicContent(e.target.value)}
                                     rows="4"
+                                    required
                                 />
                             </div>
                             <button